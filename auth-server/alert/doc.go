@@ -0,0 +1,6 @@
+// Package alert alerts users to suspicious logins through channels other than their websocket
+// connections, such as a service sending email or push notifications.
+//
+// The Notifier is chosen with config.SuspiciousLogin.Notifier. The webhook notifier posts each
+// alert as JSON to a URL, for services which know how to reach the user.
+package alert
@@ -0,0 +1,97 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
+)
+
+// Notifier alerts users to suspicious logins.
+type Notifier interface {
+	Notify(ctx context.Context, user *model.User, alert *model.LoginAlert) error
+	Close() error
+}
+
+// Message is the alert posted by the webhook notifier.
+type Message struct {
+	UserID   uuid.UUID         `json:"user_id"`
+	Username string            `json:"username"`
+	Login    *model.LoginAlert `json:"login"`
+}
+
+// NewNotifier returns the notifier named by config.Notifier.
+func NewNotifier(config config.SuspiciousLogin) (Notifier, error) {
+	switch config.Notifier {
+	case "log":
+		return NewLogNotifier(log.Default()), nil
+	case "webhook":
+		return NewWebhookNotifier(config.NotifierURL, &http.Client{Timeout: 10 * time.Second}), nil
+	default:
+		return nil, fmt.Errorf("unknown suspicious login notifier %q", config.Notifier)
+	}
+}
+
+type logNotifier struct {
+	logger *log.Logger
+}
+
+// NewLogNotifier returns a Notifier logging alerts to logger.
+func NewLogNotifier(logger *log.Logger) Notifier {
+	return &logNotifier{logger}
+}
+
+func (n *logNotifier) Notify(_ context.Context, user *model.User, alert *model.LoginAlert) error {
+	n.logger.Printf("suspicious login: user: %s, ip: %s, risks: %v", user.ID, alert.IP, alert.Risks)
+	return nil
+}
+
+func (n *logNotifier) Close() error {
+	return nil
+}
+
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier returns a Notifier posting each alert to url as a JSON Message.
+// Any response other than 2xx is treated as a failure.
+func NewWebhookNotifier(url string, client *http.Client) Notifier {
+	return &webhookNotifier{url, client}
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, user *model.User, alert *model.LoginAlert) error {
+	body, err := json.Marshal(&Message{UserID: user.ID, Username: user.Username, Login: alert})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("notifier responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (n *webhookNotifier) Close() error {
+	n.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,61 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func newAlert() (*model.User, *model.LoginAlert) {
+	return &model.User{ID: uuid.New(), Username: "alice"},
+		&model.LoginAlert{Risks: []model.LoginRisk{model.NewDevice}, IP: "203.0.113.7", UserAgent: "curl/8.0"}
+}
+
+func TestLogNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	notifier := NewLogNotifier(log.New(&buf, "", 0))
+	user, alert := newAlert()
+	require.NoError(t, notifier.Notify(context.Background(), user, alert))
+	require.Contains(t, buf.String(), user.ID.String())
+	require.Contains(t, buf.String(), "new_device")
+	require.NoError(t, notifier.Close())
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	status := http.StatusNoContent
+	var received Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	notifier := NewWebhookNotifier(server.URL, server.Client())
+	user, alert := newAlert()
+	require.NoError(t, notifier.Notify(context.Background(), user, alert))
+	require.Equal(t, user.ID, received.UserID)
+	require.Equal(t, alert, received.Login)
+
+	status = http.StatusBadGateway
+	require.Error(t, notifier.Notify(context.Background(), user, alert))
+	require.NoError(t, notifier.Close())
+}
+
+func TestNewNotifier(t *testing.T) {
+	_, err := NewNotifier(config.SuspiciousLogin{Notifier: "carrier-pigeon"})
+	require.Error(t, err)
+	notifier, err := NewNotifier(config.SuspiciousLogin{Notifier: "log"})
+	require.NoError(t, err)
+	require.NotNil(t, notifier)
+}
@@ -0,0 +1,261 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+)
+
+// Archiver periodically archives the events which have expired under the retention policy.
+type Archiver struct {
+	repository repository.ArchiveRepository
+	signer     *jwt.Signer
+	issuer     string
+	policy     *model.RetentionPolicy
+	dir        string
+	interval   time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewArchiver returns an Archiver enforcing policy, and signing archives with signer on behalf
+// of issuer. The directory archives are written to and the interval between runs are read from config.
+func NewArchiver(repository repository.ArchiveRepository, signer *jwt.Signer, issuer string, policy *model.RetentionPolicy, config config.Retention) *Archiver {
+	return &Archiver{
+		repository: repository,
+		signer:     signer,
+		issuer:     issuer,
+		policy:     policy,
+		dir:        config.ArchiveDir,
+		interval:   time.Duration(config.Interval) * time.Second,
+	}
+}
+
+// Start starts archiving in the background, until Stop is called.
+func (a *Archiver) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	a.done = make(chan struct{})
+	go a.run(ctx)
+}
+
+// Stop stops archiving, waiting for the partition being archived.
+func (a *Archiver) Stop() {
+	a.cancel()
+	<-a.done
+}
+
+// run archives every interval until ctx is done.
+func (a *Archiver) run(ctx context.Context) {
+	defer close(a.done)
+	for {
+		if _, err := a.Run(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("archive: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(a.interval):
+		}
+	}
+}
+
+// Run creates the partitions of the month of now and the next, then archives the events of every
+// partition which have expired by now, returning the archives written. Partitions being archived
+// by another replica are skipped.
+func (a *Archiver) Run(ctx context.Context, now time.Time) ([]*model.Archive, error) {
+	now = now.UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for _, m := range []time.Time{month, month.AddDate(0, 1, 0)} {
+		if err := a.repository.CreatePartition(ctx, m); err != nil {
+			return nil, fmt.Errorf("failed to create partition %s: %w", model.PartitionName(m), err)
+		}
+	}
+
+	archives := make([]*model.Archive, 0)
+	days := a.policy.Shortest()
+	if days == 0 {
+		return archives, nil
+	}
+	partitions, err := a.repository.Partitions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read partitions: %w", err)
+	}
+	for _, partition := range partitions {
+		// no event of a partition starting after the shortest retention has expired
+		if !partition.From.IsZero() && partition.From.After(now.AddDate(0, 0, -days)) {
+			continue
+		}
+		archive, err := a.archive(ctx, partition, !partition.To.IsZero() && !partition.To.After(month), now)
+		if err != nil {
+			return archives, fmt.Errorf("failed to archive %s: %w", partition.Name, err)
+		}
+		if archive != nil {
+			archives = append(archives, archive)
+		}
+	}
+	return archives, nil
+}
+
+// archive writes the expired events of partition to a file, and deletes them, or drops the partition
+// when droppable and every event has expired. Returns nil when no event has expired, or another
+// replica is archiving.
+func (a *Archiver) archive(ctx context.Context, partition *model.EventPartition, droppable bool, now time.Time) (*model.Archive, error) {
+	tx, err := a.repository.Begin(ctx, partition)
+	if err != nil || tx == nil {
+		return nil, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			if err := tx.Rollback(); err != nil {
+				log.Printf("archive: %s", err)
+			}
+		}
+	}()
+
+	archive := &model.Archive{ID: uuid.New(), Partition: partition.Name, Ranges: make([]*model.PurgedRange, 0)}
+	path, err := a.export(ctx, tx, archive, now)
+	if err != nil || path == "" {
+		return nil, err
+	}
+	// the file is only kept once the events are deleted
+	defer func() {
+		if !committed {
+			if err := os.Remove(path); err != nil {
+				log.Printf("archive: %s", err)
+			}
+		}
+	}()
+
+	if droppable {
+		count, err := tx.Count(ctx)
+		if err != nil {
+			return nil, err
+		}
+		archive.Dropped = count == archive.Events
+	}
+	if archive.Signature, err = a.sign(archive, now); err != nil {
+		return nil, fmt.Errorf("failed to sign archive: %w", err)
+	}
+	event, err := model.NewEvent(ctx, archive.ID, model.EventPurged, &model.EventPurgedPayload{
+		ID:        archive.ID,
+		Partition: archive.Partition,
+		File:      archive.File,
+		SHA256:    archive.SHA256,
+		Events:    archive.Events,
+		Dropped:   archive.Dropped,
+		FirstSeq:  archive.Ranges[0].First,
+		LastSeq:   archive.Ranges[len(archive.Ranges)-1].Last,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Purge(ctx, archive, event); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	committed = true
+	archive.CreatedAt = now
+	return archive, nil
+}
+
+// export writes the expired events of tx to a file of the directory, one JSON object per line, setting
+// the file, its hash, the number of events and the ranges of the chain of archive. Returns the path of
+// the file, or an empty path when no event has expired.
+func (a *Archiver) export(ctx context.Context, tx repository.ArchiveTx, archive *model.Archive, now time.Time) (string, error) {
+	if err := os.MkdirAll(a.dir, 0o750); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(a.dir, archive.Partition+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		// only reached with the temporary file when exporting failed, or no event has expired
+		if err := os.Remove(file.Name()); err != nil && !os.IsNotExist(err) {
+			log.Printf("archive: %s", err)
+		}
+	}()
+	defer file.Close()
+
+	digest := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(file, digest))
+	encoder := json.NewEncoder(zw)
+	var last *model.PurgedRange
+	err = tx.Expired(ctx, a.policy, now, func(event *model.ArchivedEvent) error {
+		if last == nil || event.ChainSeq != last.Last+1 {
+			prevHash, err := hex.DecodeString(event.PrevHash)
+			if err != nil {
+				return err
+			}
+			last = &model.PurgedRange{First: event.ChainSeq, PrevHash: prevHash}
+			archive.Ranges = append(archive.Ranges, last)
+		}
+		hash, err := hex.DecodeString(event.Hash)
+		if err != nil {
+			return err
+		}
+		last.Last, last.Hash = event.ChainSeq, hash
+		archive.Events++
+		return encoder.Encode(event)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to export events: %w", err)
+	}
+	if archive.Events == 0 {
+		return "", nil
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	if err := file.Sync(); err != nil {
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	// the chain entries deleted identify the archive
+	archive.File = fmt.Sprintf("%s-%d-%d.ndjson.gz", archive.Partition, archive.Ranges[0].First, last.Last)
+	archive.SHA256 = hex.EncodeToString(digest.Sum(nil))
+	path := filepath.Join(a.dir, archive.File)
+	if err := os.Rename(file.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// sign returns the signature of archive, covering its file and ranges.
+func (a *Archiver) sign(archive *model.Archive, now time.Time) (string, error) {
+	ranges, err := archive.RangesHash()
+	if err != nil {
+		return "", err
+	}
+	return a.signer.Sign(&model.ArchiveClaims{
+		Issuer:   a.issuer,
+		IssuedAt: now.Unix(),
+		ID:       archive.ID,
+		File:     archive.File,
+		SHA256:   archive.SHA256,
+		Events:   archive.Events,
+		Ranges:   ranges,
+	})
+}
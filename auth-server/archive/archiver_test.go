@@ -0,0 +1,172 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2023, 7, 15, 12, 0, 0, 0, time.UTC)
+
+// newRepository returns a repository holding an event of each type and creation time, chained in order
+func newRepository(events ...*model.Event) *repository.MockArchiveRepository {
+	repo := &repository.MockArchiveRepository{Partitioned: make(map[string][]*model.ArchivedEvent)}
+	for i, event := range events {
+		name := model.PartitionName(event.CreatedAt)
+		repo.Partitioned[name] = append(repo.Partitioned[name], &model.ArchivedEvent{
+			Event:    *event,
+			ChainSeq: int64(i + 1),
+			PrevHash: fmt.Sprintf("%064x", i),
+			Hash:     fmt.Sprintf("%064x", i+1),
+		})
+	}
+	return repo
+}
+
+func newEvent(eventType model.EventType, createdAt time.Time) *model.Event {
+	return &model.Event{UUID: uuid.New(), Type: eventType, Body: json.RawMessage(`{"version":1}`), CreatedAt: createdAt}
+}
+
+func newArchiver(t *testing.T, repo repository.ArchiveRepository, policy *model.RetentionPolicy) (*Archiver, *jwt.Signer) {
+	key, err := jwt.GenerateKey()
+	require.NoError(t, err)
+	signer, err := jwt.NewSigner(key)
+	require.NoError(t, err)
+	return NewArchiver(repo, signer, "auth-server", policy, config.Retention{ArchiveDir: t.TempDir(), Interval: 3600}), signer
+}
+
+// readArchive returns the events written to the file of archive
+func readArchive(t *testing.T, dir string, archive *model.Archive) []*model.ArchivedEvent {
+	data, err := os.ReadFile(filepath.Join(dir, archive.File))
+	require.NoError(t, err)
+	digest := sha256.Sum256(data)
+	require.Equal(t, hex.EncodeToString(digest[:]), archive.SHA256)
+
+	file, err := os.Open(filepath.Join(dir, archive.File))
+	require.NoError(t, err)
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	events := make([]*model.ArchivedEvent, 0)
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		var event model.ArchivedEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, &event)
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func TestRun(t *testing.T) {
+	repo := newRepository(
+		newEvent(model.LoggedIn, time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)),
+		newEvent(model.LoggedIn, time.Date(2023, 5, 2, 0, 0, 0, 0, time.UTC)),
+		newEvent(model.AccountCreated, time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)),
+		newEvent(model.LoggedIn, time.Date(2023, 6, 2, 0, 0, 0, 0, time.UTC)),
+		newEvent(model.LoggedIn, time.Date(2023, 6, 3, 0, 0, 0, 0, time.UTC)),
+		newEvent(model.LoggedIn, time.Date(2023, 7, 14, 0, 0, 0, 0, time.UTC)),
+	)
+	policy, err := model.ParseRetentionPolicy(0, "logged_in:30")
+	require.NoError(t, err)
+	archiver, signer := newArchiver(t, repo, policy)
+
+	archives, err := archiver.Run(context.Background(), now)
+	require.NoError(t, err)
+	require.Len(t, archives, 2)
+
+	// every event of May expired, so its partition is dropped
+	may := archives[0]
+	require.Equal(t, "event_2023_05", may.Partition)
+	require.True(t, may.Dropped)
+	require.Equal(t, 2, may.Events)
+	require.Len(t, may.Ranges, 1)
+	require.Equal(t, int64(1), may.Ranges[0].First)
+	require.Equal(t, int64(2), may.Ranges[0].Last)
+	require.Len(t, readArchive(t, archiver.dir, may), 2)
+	require.NotContains(t, repo.Partitioned, "event_2023_05")
+
+	// accounts created in June are kept forever
+	june := archives[1]
+	require.False(t, june.Dropped)
+	require.Equal(t, 2, june.Events)
+	require.Equal(t, "event_2023_06-4-5.ndjson.gz", june.File)
+	require.Equal(t, fmt.Sprintf("%064x", 3), hex.EncodeToString(june.Ranges[0].PrevHash))
+	require.Equal(t, fmt.Sprintf("%064x", 5), hex.EncodeToString(june.Ranges[0].Hash))
+	events := readArchive(t, archiver.dir, june)
+	require.Equal(t, int64(4), events[0].ChainSeq)
+	require.Equal(t, model.LoggedIn, events[0].Type)
+	require.Len(t, repo.Partitioned["event_2023_06"], 1)
+	require.Len(t, repo.Partitioned["event_2023_07"], 1)
+	require.Contains(t, repo.Partitioned, "event_2023_08")
+
+	// archives are signed, and recorded by an event
+	var claims model.ArchiveClaims
+	require.NoError(t, jwt.Parse(context.Background(), june.Signature, signer.KeySet(), &claims))
+	ranges, err := june.RangesHash()
+	require.NoError(t, err)
+	require.Equal(t, ranges, claims.Ranges)
+	require.Equal(t, june.SHA256, claims.SHA256)
+	require.Len(t, repo.Events, 2)
+	payload, err := repo.Events[1].Payload()
+	require.NoError(t, err)
+	require.Equal(t, int64(5), payload.(*model.EventPurgedPayload).LastSeq)
+
+	// expired events are only archived once
+	archives, err = archiver.Run(context.Background(), now)
+	require.NoError(t, err)
+	require.Empty(t, archives)
+	files, err := os.ReadDir(archiver.dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
+
+func TestRunKeepsAll(t *testing.T) {
+	repo := newRepository(newEvent(model.LoggedIn, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	archiver, _ := newArchiver(t, repo, &model.RetentionPolicy{})
+	archives, err := archiver.Run(context.Background(), now)
+	require.NoError(t, err)
+	require.Empty(t, archives)
+	require.Len(t, repo.Partitioned["event_2020_01"], 1)
+
+	// partitions are created nonetheless
+	require.Contains(t, repo.Partitioned, "event_2023_07")
+	require.Contains(t, repo.Partitioned, "event_2023_08")
+}
+
+func TestRunLocked(t *testing.T) {
+	repo := newRepository(newEvent(model.LoggedIn, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	repo.Locked = true
+	archiver, _ := newArchiver(t, repo, &model.RetentionPolicy{Default: 30})
+	archives, err := archiver.Run(context.Background(), now)
+	require.NoError(t, err)
+	require.Empty(t, archives)
+	require.Len(t, repo.Partitioned["event_2020_01"], 1)
+}
+
+func TestArchiverStartStop(t *testing.T) {
+	repo := newRepository(newEvent(model.LoggedIn, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	archiver, _ := newArchiver(t, repo, &model.RetentionPolicy{Default: 30})
+	archiver.Start()
+	require.Eventually(t, func() bool {
+		partitions, _ := repo.Partitions(context.Background())
+		return len(partitions) == 2
+	}, time.Second, 10*time.Millisecond)
+	archiver.Stop()
+	require.Len(t, repo.Archived, 1)
+}
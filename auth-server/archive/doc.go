@@ -0,0 +1,12 @@
+// Package archive enforces the retention policy of the events recorded in auth.event.
+//
+// The event table is partitioned by month. The Archiver creates the partitions of the current
+// and next month, and periodically exports the events which have outlived the retention policy
+// of their type to gzip compressed NDJSON files, before deleting them. A partition whose events
+// have all expired is dropped as a whole, rather than deleted from row by row.
+//
+// Deleting events leaves gaps in the hash chain of the audit package, so every archive records
+// the ranges of the chain it deleted, along with the hashes linking them to the entries around
+// them, and is signed with the assertion key. An event_purged event records the archive in the
+// chain itself.
+package archive
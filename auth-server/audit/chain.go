@@ -0,0 +1,292 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+)
+
+// Genesis is the hash the first entry of the chain is linked to.
+var Genesis = make([]byte, sha256.Size)
+
+// Hash returns the hash of entry, as computed by the chain_event trigger: the SHA-256 hash of
+// the hash of the previous entry, the hash of the body, and the hash of each other field in
+// their canonical text form, the fields of the request context as their stored digest. Fields
+// are hashed individually so that their boundaries cannot shift.
+func Hash(entry *model.ChainEntry) []byte {
+	h := sha256.New()
+	h.Write(entry.PrevHash)
+	h.Write(entry.BodyHash)
+	for _, field := range []string{
+		strconv.FormatInt(entry.Seq, 10),
+		strconv.FormatInt(entry.EventID, 10),
+		entry.UUID,
+		entry.Type,
+	} {
+		digest := sha256.Sum256([]byte(field))
+		h.Write(digest[:])
+	}
+	h.Write(entry.ContextDigest)
+	digest := sha256.Sum256([]byte(entry.CreatedAt))
+	h.Write(digest[:])
+	return h.Sum(nil)
+}
+
+// BodyHash returns the hash of the body of an event, in the text form of jsonb.
+func BodyHash(body []byte) []byte {
+	digest := sha256.Sum256(body)
+	return digest[:]
+}
+
+// ContextDigest returns the digest of the request context of entry, as stored in its context_digest
+// column: the hashes of its IP, user agent, request ID and session ID, concatenated.
+func ContextDigest(entry *model.ChainEntry) []byte {
+	digest := make([]byte, 0, 4*sha256.Size)
+	for _, field := range []string{entry.IP, entry.UserAgent, entry.RequestID, entry.SessionID} {
+		d := sha256.Sum256([]byte(field))
+		digest = append(digest, d[:]...)
+	}
+	return digest
+}
+
+// Break is a break in the chain found by Verify, at the entry Seq.
+type Break struct {
+	Seq    int64
+	Reason string
+}
+
+func (b *Break) String() string {
+	return fmt.Sprintf("seq %d: %s", b.Seq, b.Reason)
+}
+
+// Report is the result of verifying the chain.
+type Report struct {
+	Entries     int64 // entries verified
+	Erased      int64 // entries whose body or context has been erased, which are verified without them
+	Purged      int64 // entries deleted by archives
+	Checkpoints int   // checkpoints matching the chain
+	Signed      bool  // whether the signatures of checkpoints and archives were verified
+	Breaks      []*Break
+}
+
+// Verify walks the chain of repository in batches of batchSize, checking that every entry follows
+// the previous one, links to its hash, and matches its own hash and those of its body and context. Bodies and
+// contexts may only be erased from the events of a user whose account_deleted event is in the chain, as
+// only deleting an account erases them. Entries may only be missing when an archive deleted them, in which case the ranges of the archive must link
+// to the entries around them. Checkpoints must match the hash of their entry, unless it was archived,
+// and when keys is not nil, checkpoints and archives must be signed by one of its keys. The head of
+// the chain must be the last entry, so that deleting the latest events is detected too.
+//
+// Returns an error only when the chain cannot be read, breaks are reported.
+func Verify(ctx context.Context, repository repository.AuditRepository, keys jwt.KeySource, batchSize int) (*Report, error) {
+	report := &Report{Signed: keys != nil, Breaks: make([]*Break, 0)}
+	checkpoints, err := repository.Checkpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bySeq := make(map[int64]*model.Checkpoint, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		if keys != nil {
+			if err := verifySignature(ctx, checkpoint.Signature, keys, &model.CheckpointClaims{}, checkpointClaims(checkpoint)); err != nil {
+				report.Breaks = append(report.Breaks, &Break{checkpoint.Seq, "checkpoint signature is invalid: " + err.Error()})
+				continue
+			}
+		}
+		bySeq[checkpoint.Seq] = checkpoint
+	}
+	archives, err := repository.Archives(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w := &walk{report: report, checkpoints: bySeq, ranges: make(map[int64]*model.PurgedRange),
+		erased: make(map[string]*model.ChainEntry), deleted: make(map[string]bool), last: &model.ChainHead{Hash: Genesis}}
+	for _, archive := range archives {
+		if keys != nil {
+			if err := verifyArchive(ctx, archive, keys); err != nil {
+				report.Breaks = append(report.Breaks, &Break{0, fmt.Sprintf("archive %s signature is invalid: %s", archive.ID, err)})
+				continue
+			}
+		}
+		for _, r := range archive.Ranges {
+			w.ranges[r.First] = r
+		}
+	}
+
+	for {
+		entries, err := repository.ChainEntries(ctx, w.last.Seq, batchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			w.entry(entry)
+		}
+		if len(entries) < batchSize {
+			break
+		}
+	}
+
+	head, err := repository.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w.skipPurged(head.Seq + 1)
+	w.erasures()
+	if head.Seq != w.last.Seq || !bytes.Equal(head.Hash, w.last.Hash) {
+		report.Breaks = append(report.Breaks, &Break{head.Seq, fmt.Sprintf("head of the chain does not match the last entry %d", w.last.Seq)})
+	}
+	for _, checkpoint := range checkpoints {
+		if _, ok := bySeq[checkpoint.Seq]; ok {
+			report.Breaks = append(report.Breaks, &Break{checkpoint.Seq, "checkpointed entry is missing"})
+		}
+	}
+	return report, nil
+}
+
+// walk is the state of Verify walking the chain.
+type walk struct {
+	report      *Report
+	checkpoints map[int64]*model.Checkpoint  // checkpoints not yet reached, by entry
+	ranges      map[int64]*model.PurgedRange // ranges deleted by archives, by first entry
+	erased      map[string]*model.ChainEntry // first erased entry of each user
+	deleted     map[string]bool              // users whose account_deleted entry was walked
+	last        *model.ChainHead             // last entry walked, or purged
+}
+
+// entry verifies entry, which follows the last entry walked.
+func (w *walk) entry(entry *model.ChainEntry) {
+	w.skipPurged(entry.Seq)
+	if entry.Seq != w.last.Seq+1 {
+		w.fail(entry.Seq, fmt.Sprintf("entries %d to %d are missing", w.last.Seq+1, entry.Seq-1))
+	} else if !bytes.Equal(entry.PrevHash, w.last.Hash) {
+		w.fail(entry.Seq, "previous hash does not match the previous entry")
+	}
+	if entry.Body != nil && !bytes.Equal(BodyHash(entry.Body), entry.BodyHash) {
+		w.fail(entry.Seq, fmt.Sprintf("body of event %d has been altered", entry.EventID))
+	}
+	// an erased context reads as empty, whose digest differs from the one stored unless it was empty
+	contextErased := false
+	if !bytes.Equal(ContextDigest(entry), entry.ContextDigest) {
+		if entry.IP == "" && entry.UserAgent == "" && entry.RequestID == "" && entry.SessionID == "" {
+			contextErased = true
+		} else {
+			w.fail(entry.Seq, fmt.Sprintf("context of event %d has been altered", entry.EventID))
+		}
+	}
+	if !bytes.Equal(Hash(entry), entry.Hash) {
+		w.fail(entry.Seq, fmt.Sprintf("event %d has been altered", entry.EventID))
+	}
+	w.report.Entries++
+	if entry.Body == nil || contextErased {
+		w.report.Erased++
+		if _, ok := w.erased[entry.UUID]; !ok {
+			w.erased[entry.UUID] = entry
+		}
+	}
+	if entry.Type == string(model.AccountDeleted) {
+		w.deleted[entry.UUID] = true
+	}
+	w.checkpoint(entry.Seq, entry.Hash)
+	w.last = &model.ChainHead{Seq: entry.Seq, Hash: entry.Hash}
+}
+
+// erasures reports the erased entries of users without an account_deleted entry. The account_deleted
+// entry follows the entries erased along with it, so erasures are only checked once the chain is walked.
+func (w *walk) erasures() {
+	entries := make([]*model.ChainEntry, 0, len(w.erased))
+	for user, entry := range w.erased {
+		if !w.deleted[user] {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	for _, entry := range entries {
+		w.fail(entry.Seq, fmt.Sprintf("event %d has been erased, but the account of %s was not deleted", entry.EventID, entry.UUID))
+	}
+}
+
+// skipPurged skips the ranges deleted by archives which follow the last entry walked, up to the entry until.
+// Each range must link to the hash of the entry before it.
+func (w *walk) skipPurged(until int64) {
+	for w.last.Seq+1 < until {
+		r, ok := w.ranges[w.last.Seq+1]
+		if !ok {
+			return
+		}
+		if r.Last >= until {
+			w.fail(r.First, fmt.Sprintf("archived entries %d to %d overlap entry %d", r.First, r.Last, until))
+			return
+		}
+		if !bytes.Equal(r.PrevHash, w.last.Hash) {
+			w.fail(r.First, "previous hash of archived entries does not match the previous entry")
+		}
+		// the hashes of entries within the range are unknown, so only checkpoints of the last can be verified
+		for seq := range w.checkpoints {
+			if seq >= r.First && seq < r.Last {
+				delete(w.checkpoints, seq)
+			}
+		}
+		w.checkpoint(r.Last, r.Hash)
+		w.report.Purged += r.Last - r.First + 1
+		w.last = &model.ChainHead{Seq: r.Last, Hash: r.Hash}
+	}
+}
+
+// checkpoint verifies the checkpoint of the entry seq, if there is one, against its hash.
+func (w *walk) checkpoint(seq int64, hash []byte) {
+	checkpoint, ok := w.checkpoints[seq]
+	if !ok {
+		return
+	}
+	if bytes.Equal(checkpoint.Hash, hash) {
+		w.report.Checkpoints++
+	} else {
+		w.fail(seq, "hash does not match the checkpoint")
+	}
+	delete(w.checkpoints, seq)
+}
+
+func (w *walk) fail(seq int64, reason string) {
+	w.report.Breaks = append(w.report.Breaks, &Break{seq, reason})
+}
+
+// checkpointClaims returns a function checking that claims, of type model.CheckpointClaims, match checkpoint.
+func checkpointClaims(checkpoint *model.Checkpoint) func(claims interface{}) bool {
+	return func(claims interface{}) bool {
+		c := claims.(*model.CheckpointClaims)
+		return c.Seq == checkpoint.Seq && c.Hash == hex.EncodeToString(checkpoint.Hash)
+	}
+}
+
+// verifyArchive verifies that archive is signed by one of keys, and that its claims match.
+func verifyArchive(ctx context.Context, archive *model.Archive, keys jwt.KeySource) error {
+	ranges, err := archive.RangesHash()
+	if err != nil {
+		return err
+	}
+	return verifySignature(ctx, archive.Signature, keys, &model.ArchiveClaims{}, func(claims interface{}) bool {
+		c := claims.(*model.ArchiveClaims)
+		return c.ID == archive.ID && c.File == archive.File && c.SHA256 == archive.SHA256 &&
+			c.Events == archive.Events && c.Ranges == ranges
+	})
+}
+
+// verifySignature verifies that signature is signed by one of keys, decoding its claims into claims,
+// which must satisfy match.
+func verifySignature(ctx context.Context, signature string, keys jwt.KeySource, claims interface{}, match func(claims interface{}) bool) error {
+	if err := jwt.Parse(ctx, signature, keys, claims); err != nil {
+		return err
+	}
+	if !match(claims) {
+		return errors.New("claims do not match")
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// newChain returns a repository containing a chain of n entries, linked as by the chain_event trigger
+func newChain(n int) *repository.MockAuditRepository {
+	repo := &repository.MockAuditRepository{Head: model.ChainHead{Hash: Genesis}}
+	for i := 1; i <= n; i++ {
+		appendEntry(repo, fmt.Sprintf(`{"id": "%s", "version": 2}`, uuid.New()))
+	}
+	return repo
+}
+
+// appendEntry links an entry with body to the head of the chain of repo
+func appendEntry(repo *repository.MockAuditRepository, body string) {
+	entry := &model.ChainEntry{
+		Seq:       repo.Head.Seq + 1,
+		EventID:   repo.Head.Seq + 100,
+		UUID:      uuid.New().String(),
+		Type:      string(model.LoggedIn),
+		IP:        "192.0.2.1",
+		UserAgent: "Firefox",
+		CreatedAt: "2023-07-01T12:00:00.000000",
+		Body:      []byte(body),
+		BodyHash:  BodyHash([]byte(body)),
+		PrevHash:  repo.Head.Hash,
+	}
+	entry.ContextDigest = ContextDigest(entry)
+	entry.Hash = Hash(entry)
+	repo.Entries = append(repo.Entries, entry)
+	repo.Head = model.ChainHead{Seq: entry.Seq, Hash: entry.Hash}
+}
+
+// appendDeleted links the account_deleted entry of user to the head of the chain of repo
+func appendDeleted(repo *repository.MockAuditRepository, user string) {
+	appendEntry(repo, fmt.Sprintf(`{"id": "%s", "version": 1}`, user))
+	entry := repo.Entries[len(repo.Entries)-1]
+	entry.UUID = user
+	entry.Type = string(model.AccountDeleted)
+	entry.ContextDigest = ContextDigest(entry)
+	entry.Hash = Hash(entry)
+	repo.Head.Hash = entry.Hash
+}
+
+// signedChain returns a chain of n entries, checkpointed at every entry, and the keys of the signer
+func signedChain(t *testing.T, n int) (*repository.MockAuditRepository, *jwt.KeySet) {
+	signer := newSigner(t)
+	repo := newChain(n)
+	checkpointer := NewCheckpointer(repo, signer, "auth-server", config.Audit{})
+	head := repo.Head
+	for _, entry := range repo.Entries {
+		repo.Head = model.ChainHead{Seq: entry.Seq, Hash: entry.Hash}
+		_, err := checkpointer.Checkpoint(context.Background())
+		require.NoError(t, err)
+	}
+	repo.Head = head
+	return repo, signer.KeySet()
+}
+
+// purge deletes the entries first to last of repo, as an archive signed by signer
+func purge(t *testing.T, repo *repository.MockAuditRepository, signer *jwt.Signer, first, last int64) *model.Archive {
+	r := &model.PurgedRange{First: first, Last: last}
+	entries := make([]*model.ChainEntry, 0, len(repo.Entries))
+	for _, entry := range repo.Entries {
+		if entry.Seq < first || entry.Seq > last {
+			entries = append(entries, entry)
+			continue
+		}
+		if entry.Seq == first {
+			r.PrevHash = entry.PrevHash
+		}
+		if entry.Seq == last {
+			r.Hash = entry.Hash
+		}
+	}
+	repo.Entries = entries
+
+	archive := &model.Archive{ID: uuid.New(), File: "event_2023_07-3.ndjson.gz", SHA256: "00", Events: int(last - first + 1),
+		Ranges: []*model.PurgedRange{r}}
+	ranges, err := archive.RangesHash()
+	require.NoError(t, err)
+	archive.Signature, err = signer.Sign(&model.ArchiveClaims{ID: archive.ID, File: archive.File, SHA256: archive.SHA256,
+		Events: archive.Events, Ranges: ranges})
+	require.NoError(t, err)
+	repo.Archived = append(repo.Archived, archive)
+	return archive
+}
+
+func newSigner(t *testing.T) *jwt.Signer {
+	key, err := jwt.GenerateKey()
+	require.NoError(t, err)
+	signer, err := jwt.NewSigner(key)
+	require.NoError(t, err)
+	return signer
+}
+
+func TestVerify(t *testing.T) {
+	repo, keys := signedChain(t, 5)
+	report, err := Verify(context.Background(), repo, keys, 2)
+	require.NoError(t, err)
+	require.Empty(t, report.Breaks)
+	require.Equal(t, int64(5), report.Entries)
+	require.Equal(t, 5, report.Checkpoints)
+	require.True(t, report.Signed)
+
+	// an empty chain is intact
+	report, err = Verify(context.Background(), newChain(0), nil, 2)
+	require.NoError(t, err)
+	require.Empty(t, report.Breaks)
+	require.False(t, report.Signed)
+}
+
+func TestHash(t *testing.T) {
+	entry := newChain(1).Entries[0]
+
+	// the context is hashed through its digest, leaving the hash of events chained before it was stored unchanged
+	h := sha256.New()
+	h.Write(entry.PrevHash)
+	h.Write(entry.BodyHash)
+	for _, field := range []string{"1", "100", entry.UUID, entry.Type, entry.IP, entry.UserAgent, "", "", entry.CreatedAt} {
+		digest := sha256.Sum256([]byte(field))
+		h.Write(digest[:])
+	}
+	require.Equal(t, h.Sum(nil), Hash(entry))
+}
+
+func TestVerifyErased(t *testing.T) {
+	repo := newChain(3)
+	repo.Entries[1].Body = nil
+	repo.Entries[2].IP = ""
+	repo.Entries[2].UserAgent = ""
+
+	// only deleting their account erases the events of a user
+	report, err := Verify(context.Background(), repo, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, report.Breaks, 2)
+	require.Equal(t, int64(2), report.Breaks[0].Seq)
+	require.Contains(t, report.Breaks[0].Reason, "event 101 has been erased")
+	require.Equal(t, int64(3), report.Breaks[1].Seq)
+
+	appendDeleted(repo, repo.Entries[1].UUID)
+	report, err = Verify(context.Background(), repo, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, report.Breaks, 1)
+	require.Equal(t, int64(3), report.Breaks[0].Seq)
+
+	appendDeleted(repo, repo.Entries[2].UUID)
+	report, err = Verify(context.Background(), repo, nil, 10)
+	require.NoError(t, err)
+	require.Empty(t, report.Breaks)
+	require.Equal(t, int64(2), report.Erased)
+}
+
+func TestVerifyAltered(t *testing.T) {
+	repo := newChain(3)
+	repo.Entries[1].IP = "198.51.100.1"
+	report, err := Verify(context.Background(), repo, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, report.Breaks, 1)
+	require.Equal(t, int64(2), report.Breaks[0].Seq)
+	require.Contains(t, report.Breaks[0].Reason, "context")
+
+	// as is replacing the digest of the context along with it
+	repo.Entries[1].ContextDigest = ContextDigest(repo.Entries[1])
+	report, err = Verify(context.Background(), repo, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, report.Breaks, 1)
+	require.Contains(t, report.Breaks[0].Reason, "event 101 has been altered")
+
+	repo = newChain(3)
+	repo.Entries[1].Body = []byte(`{"id": "` + uuid.New().String() + `", "version": 2}`)
+	report, err = Verify(context.Background(), repo, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, report.Breaks, 1)
+	require.Contains(t, report.Breaks[0].Reason, "body")
+
+	// recomputing the hash of the altered entry breaks the link to the next
+	repo = newChain(3)
+	repo.Entries[1].Type = string(model.LoggedOut)
+	repo.Entries[1].Hash = Hash(repo.Entries[1])
+	report, err = Verify(context.Background(), repo, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, report.Breaks, 1)
+	require.Equal(t, int64(3), report.Breaks[0].Seq)
+}
+
+func TestVerifyDeleted(t *testing.T) {
+	repo := newChain(4)
+	repo.Entries = append(repo.Entries[:1], repo.Entries[2:]...)
+	report, err := Verify(context.Background(), repo, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, report.Breaks, 1)
+	require.Contains(t, report.Breaks[0].Reason, "entries 2 to 2 are missing")
+
+	// deleting the latest events is detected by the head
+	repo = newChain(4)
+	repo.Entries = repo.Entries[:3]
+	report, err = Verify(context.Background(), repo, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, report.Breaks, 1)
+	require.Equal(t, int64(4), report.Breaks[0].Seq)
+}
+
+func TestVerifyCheckpoints(t *testing.T) {
+	// rewriting the chain from an altered entry onwards is detected by the checkpoints
+	repo, keys := signedChain(t, 3)
+	repo.Entries[1].UserAgent = "Chrome"
+	repo.Entries[1].ContextDigest = ContextDigest(repo.Entries[1])
+	repo.Entries[1].Hash = Hash(repo.Entries[1])
+	repo.Entries[2].PrevHash = repo.Entries[1].Hash
+	repo.Entries[2].Hash = Hash(repo.Entries[2])
+	repo.Head.Hash = repo.Entries[2].Hash
+	report, err := Verify(context.Background(), repo, keys, 10)
+	require.NoError(t, err)
+	require.Len(t, report.Breaks, 2)
+	require.Equal(t, 1, report.Checkpoints)
+
+	// as are checkpoints which are forged, or of missing entries
+	repo, keys = signedChain(t, 3)
+	forged, _ := signedChain(t, 3)
+	repo.Signed[0] = forged.Signed[0]
+	repo.Entries = repo.Entries[:2]
+	repo.Head = model.ChainHead{Seq: 2, Hash: repo.Entries[1].Hash}
+	report, err = Verify(context.Background(), repo, keys, 10)
+	require.NoError(t, err)
+	require.Len(t, report.Breaks, 2)
+	require.Contains(t, report.Breaks[0].Reason, "signature")
+	require.Contains(t, report.Breaks[1].Reason, "missing")
+}
+
+func TestVerifyPurged(t *testing.T) {
+	signer := newSigner(t)
+	repo := newChain(6)
+	purge(t, repo, signer, 1, 2)
+	purge(t, repo, signer, 4, 4)
+	report, err := Verify(context.Background(), repo, signer.KeySet(), 2)
+	require.NoError(t, err)
+	require.Empty(t, report.Breaks)
+	require.Equal(t, int64(3), report.Entries)
+	require.Equal(t, int64(3), report.Purged)
+
+	// as are the latest entries, linked to the head
+	repo = newChain(3)
+	purge(t, repo, signer, 2, 3)
+	report, err = Verify(context.Background(), repo, signer.KeySet(), 10)
+	require.NoError(t, err)
+	require.Empty(t, report.Breaks)
+	require.Equal(t, int64(2), report.Purged)
+}
+
+func TestVerifyPurgedCheckpoints(t *testing.T) {
+	// checkpoints within purged ranges are accepted, and those of the last entry verified
+	repo, _ := signedChain(t, 4)
+	purge(t, repo, newSigner(t), 1, 2)
+	report, err := Verify(context.Background(), repo, nil, 10)
+	require.NoError(t, err)
+	require.Empty(t, report.Breaks)
+	require.Equal(t, 3, report.Checkpoints)
+
+	repo.Archived[0].Ranges[0].Hash = repo.Entries[1].Hash
+	report, err = Verify(context.Background(), repo, nil, 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, report.Breaks)
+	require.Contains(t, report.Breaks[0].Reason, "checkpoint")
+}
+
+func TestVerifyPurgedForged(t *testing.T) {
+	// ranges of archives which are not signed are not accepted
+	signer := newSigner(t)
+	repo := newChain(3)
+	purge(t, repo, newSigner(t), 2, 2)
+	report, err := Verify(context.Background(), repo, signer.KeySet(), 10)
+	require.NoError(t, err)
+	require.Len(t, report.Breaks, 2)
+	require.Contains(t, report.Breaks[0].Reason, "signature")
+	require.Contains(t, report.Breaks[1].Reason, "entries 2 to 2 are missing")
+
+	// nor are ranges which do not link to the entries around them
+	repo = newChain(4)
+	archive := purge(t, repo, signer, 2, 3)
+	archive.Ranges[0].PrevHash = Genesis
+	ranges, err := archive.RangesHash()
+	require.NoError(t, err)
+	archive.Signature, err = signer.Sign(&model.ArchiveClaims{ID: archive.ID, File: archive.File, SHA256: archive.SHA256,
+		Events: archive.Events, Ranges: ranges})
+	require.NoError(t, err)
+	report, err = Verify(context.Background(), repo, signer.KeySet(), 10)
+	require.NoError(t, err)
+	require.Len(t, report.Breaks, 1)
+	require.Contains(t, report.Breaks[0].Reason, "archived")
+
+	// and altering the ranges of an archive invalidates its signature
+	repo = newChain(4)
+	archive = purge(t, repo, signer, 2, 2)
+	archive.Ranges[0].Last = 3
+	report, err = Verify(context.Background(), repo, signer.KeySet(), 10)
+	require.NoError(t, err)
+	require.Contains(t, report.Breaks[0].Reason, "signature")
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+)
+
+// Checkpointer periodically records a signed checkpoint of the head of the chain.
+type Checkpointer struct {
+	repository repository.AuditRepository
+	signer     *jwt.Signer
+	issuer     string
+	interval   time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewCheckpointer returns a Checkpointer signing checkpoints with signer on behalf of issuer.
+// The interval between checkpoints is read from config.
+func NewCheckpointer(repository repository.AuditRepository, signer *jwt.Signer, issuer string, config config.Audit) *Checkpointer {
+	return &Checkpointer{
+		repository: repository,
+		signer:     signer,
+		issuer:     issuer,
+		interval:   time.Duration(config.CheckpointInterval) * time.Second,
+	}
+}
+
+// Start starts recording checkpoints in the background, until Stop is called.
+func (c *Checkpointer) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go c.run(ctx)
+}
+
+// Stop stops recording checkpoints, waiting for the checkpoint being recorded.
+func (c *Checkpointer) Stop() {
+	c.cancel()
+	<-c.done
+}
+
+// run records a checkpoint every interval until ctx is done.
+func (c *Checkpointer) run(ctx context.Context) {
+	defer close(c.done)
+	for {
+		if _, err := c.Checkpoint(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("audit: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.interval):
+		}
+	}
+}
+
+// Checkpoint records a signed checkpoint of the head of the chain, returning it. Every replica
+// runs a checkpointer, so no checkpoint is recorded, and nil is returned, when the chain is empty,
+// or the latest checkpoint is of the head or was recorded within the interval.
+func (c *Checkpointer) Checkpoint(ctx context.Context) (*model.Checkpoint, error) {
+	head, err := c.repository.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read head of the chain: %w", err)
+	}
+	latest, err := c.repository.LatestCheckpoint(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read latest checkpoint: %w", err)
+	}
+	if head.Seq == 0 || (latest != nil && (latest.Seq >= head.Seq || time.Since(latest.CreatedAt) < c.interval)) {
+		return nil, nil
+	}
+
+	now := time.Now()
+	signature, err := c.signer.Sign(&model.CheckpointClaims{
+		Issuer:   c.issuer,
+		IssuedAt: now.Unix(),
+		Seq:      head.Seq,
+		Hash:     hex.EncodeToString(head.Hash),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign checkpoint: %w", err)
+	}
+	checkpoint := &model.Checkpoint{Seq: head.Seq, Hash: head.Hash, Signature: signature, CreatedAt: now.UTC()}
+	if err := c.repository.CreateCheckpoint(ctx, checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to record checkpoint: %w", err)
+	}
+	return checkpoint, nil
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	signer := newSigner(t)
+	repo := newChain(0)
+	checkpointer := NewCheckpointer(repo, signer, "auth-server", config.Audit{CheckpointInterval: 3600})
+
+	// an empty chain is not checkpointed
+	checkpoint, err := checkpointer.Checkpoint(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, checkpoint)
+
+	appendEntry(repo, `{}`)
+	checkpoint, err = checkpointer.Checkpoint(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), checkpoint.Seq)
+	require.Equal(t, repo.Head.Hash, checkpoint.Hash)
+	require.Len(t, repo.Signed, 1)
+
+	var claims model.CheckpointClaims
+	require.NoError(t, jwt.Parse(context.Background(), checkpoint.Signature, signer.KeySet(), &claims))
+	require.Equal(t, int64(1), claims.Seq)
+	require.Equal(t, hex.EncodeToString(repo.Head.Hash), claims.Hash)
+	require.Equal(t, "auth-server", claims.Issuer)
+
+	// another is only recorded once the interval has passed
+	appendEntry(repo, `{}`)
+	checkpoint, err = checkpointer.Checkpoint(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, checkpoint)
+
+	repo.Signed[0].CreatedAt = time.Now().Add(-2 * time.Hour)
+	checkpoint, err = checkpointer.Checkpoint(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), checkpoint.Seq)
+
+	// and only when the chain has grown
+	repo.Signed[1].CreatedAt = time.Now().Add(-2 * time.Hour)
+	checkpoint, err = checkpointer.Checkpoint(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, checkpoint)
+}
+
+func TestCheckpointerStartStop(t *testing.T) {
+	repo := newChain(2)
+	checkpointer := NewCheckpointer(repo, newSigner(t), "auth-server", config.Audit{CheckpointInterval: 3600})
+	checkpointer.Start()
+	require.Eventually(t, func() bool {
+		checkpoints, _ := repo.Checkpoints(context.Background())
+		return len(checkpoints) == 1
+	}, time.Second, 10*time.Millisecond)
+	checkpointer.Stop()
+
+	report, err := Verify(context.Background(), repo, nil, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Checkpoints)
+}
@@ -0,0 +1,16 @@
+// Package audit makes the events recorded in auth.event tamper-evident.
+//
+// Every event is linked into a hash chain as it is inserted, by the chain_event trigger of the
+// database: its hash covers the hash of the event chained before it, the hash of its body, the
+// digest of its request context, and its other columns, see Hash. Altering, inserting or deleting
+// an event therefore breaks the chain, unless every hash following it is recomputed. The body and
+// context are hashed separately so that they can be erased, as for users deleting their own
+// account, without breaking the chain. Verify accepts them erased only for users whose
+// account_deleted event is in the chain.
+//
+// The Checkpointer periodically records the head of the chain signed with the assertion key, so
+// recomputing the chain is detected too. Verify walks the chain, checking every hash, link and
+// checkpoint, and is run by the verify-events command. Events deleted by the archiver leave gaps
+// in the chain, which are accepted when covered by the signed ranges of an archive linking to
+// the entries around them.
+package audit
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// PubSub is an interface for publishing messages to Redis channels, which are delivered
+// to the subscribers of every instance of the server.
+type PubSub interface {
+	Publish(ctx context.Context, channel string, message string) error
+	Subscribe(ctx context.Context, channel string) (<-chan string, func() error)
+}
+
+type pubSub struct {
+	c *redis.Client
+}
+
+// NewPubSub returns a new instance of PubSub.
+func NewPubSub(c *redis.Client) PubSub {
+	return &pubSub{
+		c: c,
+	}
+}
+
+func (p *pubSub) Publish(ctx context.Context, channel string, message string) error {
+	return p.c.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe returns the messages published to channel, and a function which unsubscribes.
+// The returned channel is closed once unsubscribed.
+func (p *pubSub) Subscribe(ctx context.Context, channel string) (<-chan string, func() error) {
+	sub := p.c.Subscribe(ctx, channel)
+	messages := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		defer close(messages)
+		in := sub.Channel()
+		for {
+			select {
+			case msg, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- msg.Payload:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return messages, func() error {
+		once.Do(func() { close(done) })
+		return sub.Close()
+	}
+}
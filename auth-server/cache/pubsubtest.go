@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+// MockPubSub is a mock implementation of PubSub delivering messages in memory.
+type MockPubSub struct {
+	mu          sync.Mutex
+	subscribers map[string][]chan string
+}
+
+// Publish delivers the message to every subscriber of the channel.
+// Messages are dropped for subscribers with more than 16 undelivered messages.
+func (p *MockPubSub) Publish(_ context.Context, channel string, message string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, sub := range p.subscribers[channel] {
+		select {
+		case sub <- message:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe returns the messages published to the channel, and a function which unsubscribes.
+func (p *MockPubSub) Subscribe(_ context.Context, channel string) (<-chan string, func() error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.subscribers == nil {
+		p.subscribers = make(map[string][]chan string)
+	}
+	sub := make(chan string, 16)
+	p.subscribers[channel] = append(p.subscribers[channel], sub)
+
+	var once sync.Once
+	return sub, func() error {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			subs := p.subscribers[channel]
+			for i := range subs {
+				if subs[i] == sub {
+					p.subscribers[channel] = append(subs[:i], subs[i+1:]...)
+					break
+				}
+			}
+			close(sub)
+		})
+		return nil
+	}
+}
@@ -10,7 +10,9 @@ import (
 // SessionCache is an interface for interacting with Redis.
 type SessionCache interface {
 	Set(ctx context.Context, key string, value string, expiration time.Duration) error
+	Expire(ctx context.Context, key string, expiration time.Duration) error
 	Get(ctx context.Context, key string) (string, error)
+	GetDel(ctx context.Context, key string) (string, error)
 	Del(ctx context.Context, key string) error
 	SAdd(ctx context.Context, key string, value string) error
 	SRem(ctx context.Context, key string, value string) error
@@ -39,10 +41,19 @@ func (s *sessionCache) Set(ctx context.Context, key string, value string, expira
 	return s.c.Set(ctx, key, value, expiration).Err()
 }
 
+func (s *sessionCache) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	return s.c.Expire(ctx, key, expiration).Err()
+}
+
 func (s *sessionCache) Get(ctx context.Context, key string) (string, error) {
 	return s.c.Get(ctx, key).Result()
 }
 
+// GetDel atomically gets and deletes a key, so at most one caller observes its value.
+func (s *sessionCache) GetDel(ctx context.Context, key string) (string, error) {
+	return s.c.GetDel(ctx, key).Result()
+}
+
 func (s *sessionCache) SAdd(ctx context.Context, key string, value string) error {
 	return s.c.SAdd(ctx, key, value).Err()
 }
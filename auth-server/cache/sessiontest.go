@@ -19,6 +19,11 @@ func (s *MockSessionCache) Set(_ context.Context, key string, value string, _ ti
 	return nil
 }
 
+// Expire is a no-op, keys of the mock never expire.
+func (s *MockSessionCache) Expire(_ context.Context, _ string, _ time.Duration) error {
+	return nil
+}
+
 // Get gets a session from the cache.
 func (s *MockSessionCache) Get(_ context.Context, key string) (string, error) {
 	value, ok := s.Sessions[key]
@@ -28,6 +33,16 @@ func (s *MockSessionCache) Get(_ context.Context, key string) (string, error) {
 	return value, nil
 }
 
+// GetDel gets and deletes a session from the cache.
+func (s *MockSessionCache) GetDel(_ context.Context, key string) (string, error) {
+	value, ok := s.Sessions[key]
+	if !ok {
+		return "", nil
+	}
+	delete(s.Sessions, key)
+	return value, nil
+}
+
 // Del deletes a session from the cache.
 func (s *MockSessionCache) Del(_ context.Context, key string) error {
 	delete(s.Sessions, key)
@@ -1,3 +1,10 @@
 // main package is the entry point of the application.
 // It is responsible for initializing the application and starting the HTTP server.
+//
+// Given a command, it runs the command instead:
+//
+//	rebuild-projections [projection...]  rebuilds the named projections, or every projection, from the first event
+//	verify-events [jwks-url]             verifies the hash chain of the event table and its signed checkpoints
+//	migrate [up | down [steps] | status] applies the pending migrations of the schema, reverts the latest,
+//	                                     or lists the migrations and whether they are applied
 package main
@@ -2,20 +2,32 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/dgyurics/auth/auth-server/audit"
 	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/migration"
+	"github.com/dgyurics/auth/auth-server/projection"
+	"github.com/dgyurics/auth/auth-server/repository"
 	"github.com/dgyurics/auth/auth-server/server"
 )
 
 func main() {
-	// Create new server
 	config := config.New()
+	if len(os.Args) > 1 {
+		runCommand(config, os.Args[1], os.Args[2:])
+		return
+	}
+
+	// Create new server
 	server := server.NewHTTPServer(":" + config.ServerConfig.Port)
 
 	// Setup graceful shutdown
@@ -29,6 +41,128 @@ func main() {
 	}
 }
 
+// runCommand runs the named command instead of the server, exiting with status 1 when it fails.
+func runCommand(config config.Config, name string, args []string) {
+	switch name {
+	case "rebuild-projections":
+		sqlClient := repository.NewDBClient()
+		sqlClient.Connect(config.PostgreSQL)
+		defer sqlClient.Close()
+		projections := repository.NewProjectionRepository(sqlClient)
+		defer projections.Close()
+		if err := projection.Rebuild(context.Background(), projections, args, config.Outbox.BatchSize); err != nil {
+			log.Fatal(err)
+		}
+	case "verify-events":
+		sqlClient := repository.NewDBClient()
+		sqlClient.Connect(config.PostgreSQL)
+		defer sqlClient.Close()
+		audits := repository.NewAuditRepository(sqlClient)
+		defer audits.Close()
+		if err := verifyEvents(audits, config, args); err != nil {
+			log.Fatal(err)
+		}
+	case "migrate":
+		sqlClient := repository.NewDBClient()
+		sqlClient.Connect(config.PostgreSQL)
+		defer sqlClient.Close()
+		if err := migrate(repository.NewMigrationRepository(sqlClient), args); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown command %q, expected rebuild-projections [projection...], verify-events [jwks-url] "+
+			"or migrate [up | down [steps] | status]", name)
+	}
+}
+
+// migrate applies the pending migrations of the schema, reverts the latest, one unless the number
+// of steps is given, or lists every migration and when it was applied, as directed by args.
+func migrate(repo repository.MigrationRepository, args []string) error {
+	migrations, err := migration.Embedded()
+	if err != nil {
+		return err
+	}
+	direction := "up"
+	if len(args) > 0 {
+		direction = args[0]
+	}
+	switch direction {
+	case "up":
+		applied, err := migration.Up(context.Background(), repo, migrations)
+		for _, m := range applied {
+			log.Printf("applied migration %d_%s", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			log.Println("no pending migrations")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		reverted, err := migration.Down(context.Background(), repo, migrations, steps)
+		for _, m := range reverted {
+			log.Printf("reverted migration %d_%s", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		status, err := migration.Status(context.Background(), repo, migrations)
+		if err != nil {
+			return err
+		}
+		for _, m := range status {
+			applied := "pending"
+			if !m.AppliedAt.IsZero() {
+				applied = "applied " + m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown direction %q, expected up, down [steps] or status", direction)
+	}
+}
+
+// verifyEvents verifies the hash chain of the event table, logging every break. The signatures of
+// checkpoints are verified with the keys published at the JWKS URL of args, or else the assertion key.
+// Returns an error when the chain is broken.
+func verifyEvents(audits repository.AuditRepository, config config.Config, args []string) error {
+	var keys jwt.KeySource
+	switch {
+	case len(args) > 0:
+		keys = jwt.NewRemoteKeySet(args[0], nil)
+	case config.Assertion.KeyFile != "":
+		key, err := jwt.LoadKey(config.Assertion.KeyFile)
+		if err != nil {
+			return err
+		}
+		signer, err := jwt.NewSigner(key)
+		if err != nil {
+			return err
+		}
+		keys = signer.KeySet()
+	default:
+		log.Println("no JWKS URL or assertion key file, signatures of checkpoints are not verified")
+	}
+
+	report, err := audit.Verify(context.Background(), audits, keys, config.Outbox.BatchSize)
+	if err != nil {
+		return err
+	}
+	for _, b := range report.Breaks {
+		log.Println(b)
+	}
+	log.Printf("verified %d events, %d erased, %d archived, and %d checkpoints", report.Entries, report.Erased,
+		report.Purged, report.Checkpoints)
+	if len(report.Breaks) > 0 {
+		return fmt.Errorf("event chain is broken in %d places", len(report.Breaks))
+	}
+	return nil
+}
+
 // FIXME refactor
 func gracefulShutdown(server *server.HTTPServer) {
 	sig := make(chan os.Signal, 1)
@@ -1,31 +1,117 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 
 	"github.com/joho/godotenv"
 )
 
-// ServerConfig contains configuration values for the server listening port.
+// ServerConfig contains configuration values for the server listening port, and the proxies
+// in front of the server, such as the api-gateway, trusted to report the client address.
 type ServerConfig struct {
-	Port string
+	Port           string
+	TrustedProxies string // comma separated addresses or CIDR ranges, whose X-Forwarded-For headers are honored
+}
+
+// Audit contains configuration values for the hash chain of the event table.
+// Signed checkpoints of the chain are not recorded when CheckpointInterval is 0.
+type Audit struct {
+	CheckpointInterval int // seconds
+}
+
+// Outbox contains configuration values for the relay publishing events to other systems.
+// The relay is disabled when Sink is empty.
+type Outbox struct {
+	Sink         string // stdout, webhook, redis or nats
+	URL          string // URL events are posted to by the webhook sink, or of the NATS server
+	Topic        string // Redis stream, or prefix of the NATS subjects, events are published to
+	BatchSize    int
+	PollInterval int // milliseconds
+	GapTimeout   int // milliseconds
 }
 
 // PostgreSQL contains configuration values for the PostgreSQL database.
+// Every object is created and queried in Schema, so several instances can
+// share a database. Pending migrations of the schema are applied on startup
+// unless Migrate is false. When URL is set, it replaces the settings of the
+// connection from Dbname to Sslrootcert, except Schema.
 type PostgreSQL struct {
-	Dbname   string
-	User     string
-	Password string
-	Host     string
-	Port     int
-	Sslmode  string
-	AppName  string
-	// TODO add support for below items
-	// ConnectTimeout       string
-	// Sslcert              string
-	// Sslkey               string
-	// Sslrootcert          string
+	URL            string // postgres:// or postgresql:// URL
+	Dbname         string
+	User           string
+	Password       string
+	Host           string
+	Port           int
+	Sslmode        string // disable, require, verify-ca or verify-full
+	Sslcert        string // client certificate file, along with Sslkey
+	Sslkey         string
+	Sslrootcert    string // certificate authorities file, verifying the server for verify-ca and verify-full
+	AppName        string
+	ConnectTimeout int // seconds, 0 waits indefinitely
+	Schema         string
+	Migrate        bool
+	// pool settings of the sql.DB, 0 being unlimited
+	MaxOpenConns    int
+	MaxIdleConns    int // 0 keeps no idle connections
+	ConnMaxLifetime int // seconds
+	ConnMaxIdleTime int // seconds
+}
+
+// schemaName matches the names of schemas which need no quoting, as the search_path is not quoted.
+var schemaName = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// Validate returns an error describing every invalid setting of config, or nil if it is valid.
+func (config PostgreSQL) Validate() error {
+	errs := make([]error, 0)
+	if config.URL != "" {
+		if u, err := url.Parse(config.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			errs = append(errs, errors.New("DATABASE_URL must be a postgres:// or postgresql:// URL"))
+		}
+	} else {
+		if config.Host == "" || config.Dbname == "" || config.User == "" {
+			errs = append(errs, errors.New("POSTGRES_HOST, POSTGRES_DB and POSTGRES_USER are required"))
+		}
+		if config.Port < 1 || config.Port > 65535 {
+			errs = append(errs, fmt.Errorf("invalid POSTGRES_PORT %d", config.Port))
+		}
+		switch config.Sslmode {
+		case "disable", "require", "verify-ca", "verify-full":
+		default:
+			errs = append(errs, fmt.Errorf("invalid POSTGRES_SSLMODE %q, expected disable, require, verify-ca or verify-full", config.Sslmode))
+		}
+		if (config.Sslcert == "") != (config.Sslkey == "") {
+			errs = append(errs, errors.New("POSTGRES_SSLCERT and POSTGRES_SSLKEY must be set together"))
+		}
+		if config.Sslmode == "disable" && (config.Sslcert != "" || config.Sslrootcert != "") {
+			errs = append(errs, errors.New("POSTGRES_SSLCERT and POSTGRES_SSLROOTCERT require POSTGRES_SSLMODE other than disable"))
+		}
+		for _, file := range []string{config.Sslcert, config.Sslkey, config.Sslrootcert} {
+			if file == "" {
+				continue
+			}
+			if _, err := os.Stat(file); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if config.ConnectTimeout < 0 {
+			errs = append(errs, fmt.Errorf("invalid POSTGRES_CONNECT_TIMEOUT %d", config.ConnectTimeout))
+		}
+	}
+	if !schemaName.MatchString(config.Schema) {
+		errs = append(errs, fmt.Errorf("invalid POSTGRES_SCHEMA %q, expected lowercase letters, digits and underscores", config.Schema))
+	}
+	if config.MaxOpenConns < 0 || config.MaxIdleConns < 0 || config.ConnMaxLifetime < 0 || config.ConnMaxIdleTime < 0 {
+		errs = append(errs, errors.New("pool settings of PostgreSQL cannot be negative"))
+	}
+	if config.MaxOpenConns > 0 && config.MaxIdleConns > config.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("POSTGRES_MAX_IDLE_CONNS %d exceeds POSTGRES_MAX_OPEN_CONNS %d", config.MaxIdleConns, config.MaxOpenConns))
+	}
+	return errors.Join(errs...)
 }
 
 // Cors contains configuration values for the CORS middleware.
@@ -44,6 +130,13 @@ type Redis struct {
 	DB       int
 }
 
+// Registration contains configuration values for the registration of users.
+// When Verification is true, new users are pending_verification, and cannot
+// sign in, until an admin verifies them.
+type Registration struct {
+	Verification bool
+}
+
 // Session contains configuration values for the session.
 type Session struct {
 	Name     string
@@ -55,17 +148,102 @@ type Session struct {
 	MaxAge   int
 }
 
+// Assertion contains configuration values for the identity assertions
+// minted for upstream services during session verification.
+type Assertion struct {
+	Issuer   string
+	Audience string
+	TTL      int    // seconds
+	KeyFile  string // PEM encoded RSA or Ed25519 private key, generated on startup when empty
+}
+
+// OAuth contains configuration values for the OAuth 2.0 authorization server.
+type OAuth struct {
+	Issuer          string // external URL of this service, as reached through the api-gateway
+	LoginURL        string // page users without a session are sent to by the authorization endpoint
+	CodeTTL         int    // seconds
+	AccessTokenTTL  int    // seconds
+	RefreshTokenTTL int    // seconds
+}
+
+// SocialLogin contains configuration values for signing in with an upstream OpenID Connect provider.
+// Social login is disabled when Issuer is empty.
+type SocialLogin struct {
+	Provider     string // name recorded with each linked identity, must not change once users have linked
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       string // space separated, requested in addition to openid
+	RedirectURL  string // callback URL registered with the provider
+	ReturnURL    string // page users are sent to once logged in
+}
+
+// SuspiciousLogin contains configuration values for the detection of suspicious logins, which
+// are compared against the last History logins of the user. Impossible travel is only detected
+// when GeoIPDatabase is set, and users are only alerted through their websocket connections
+// when Notifier is empty.
+type SuspiciousLogin struct {
+	Enabled       bool
+	History       int
+	GeoIPDatabase string // path of a MaxMind City database, such as GeoLite2-City.mmdb
+	MaxSpeed      int    // km/h, logins further apart are impossible travel
+	Notifier      string // log or webhook
+	NotifierURL   string // URL alerts are posted to by the webhook notifier
+}
+
+// Username contains configuration values for the policy usernames must satisfy
+// on registration and when changed.
+type Username struct {
+	MinLength int    // characters
+	MaxLength int    // characters, cannot exceed 50, the width of the username column
+	Unicode   bool   // allow letters and digits of every script, otherwise only ASCII
+	Reserved  string // comma separated names which cannot be registered
+}
+
+// Webhook contains configuration values for the delivery of events to webhook endpoints.
+// A failed delivery is attempted again after Backoff seconds, the delay doubling with every
+// further failure up to MaxBackoff, until MaxAttempts have failed.
+type Webhook struct {
+	MaxAttempts  int
+	Backoff      int // seconds
+	MaxBackoff   int // seconds
+	Timeout      int // seconds
+	BatchSize    int // deliveries attempted concurrently
+	PollInterval int // milliseconds
+}
+
+// Retention contains configuration values for the retention of events, and the archiver exporting
+// expired events to ArchiveDir before deleting them. Events are kept for Days, or the days of
+// Policies for their type, and forever for 0. The archiver, which also creates the monthly
+// partitions of the event table, is disabled when Interval is 0.
+type Retention struct {
+	Days       int
+	Policies   string // comma separated event types and days, e.g. "logged_in:90,logged_out:90"
+	ArchiveDir string
+	Interval   int // seconds
+}
+
 // RequestTimeout contains configuration value for http request timeout.
 type RequestTimeout int
 
 // Config is the container struct for all configuration values.
 type Config struct {
+	Assertion
+	Audit
 	Cors
+	OAuth
+	Outbox
 	PostgreSQL
 	Redis
+	Registration
 	RequestTimeout
+	Retention
 	ServerConfig
 	Session
+	SocialLogin
+	SuspiciousLogin
+	Username
+	Webhook
 }
 
 func init() {
@@ -76,20 +254,55 @@ func init() {
 // and environment variables overriding the defaults.
 func New() Config {
 	return Config{
+		Assertion: Assertion{
+			Issuer:   getEnv("ASSERTION_ISSUER", "auth-server"),
+			Audience: getEnv("ASSERTION_AUDIENCE", "secure-server"),
+			TTL:      getEnvAsInt("ASSERTION_TTL", 60),
+			KeyFile:  getEnv("ASSERTION_KEY_FILE", ""),
+		},
+		Audit: Audit{
+			CheckpointInterval: getEnvAsInt("AUDIT_CHECKPOINT_INTERVAL", 3600),
+		},
 		Cors: Cors{
 			AllowOrigin:      getEnv("CORS_ALLOW_ORIGIN", "*"),
 			AllowMethods:     getEnv("CORS_ALLOW_METHODS", "GET, POST, OPTIONS"),
 			AllowHeaders:     getEnv("CORS_ALLOW_HEADERS", "*"),
 			AllowCredentials: getEnv("CORS_ALLOW_CREDENTIALS", "true"),
 		},
+		OAuth: OAuth{
+			Issuer:          getEnv("OAUTH_ISSUER", "http://localhost:3001/auth"),
+			LoginURL:        getEnv("OAUTH_LOGIN_URL", "http://localhost:3000/"),
+			CodeTTL:         getEnvAsInt("OAUTH_CODE_TTL", 60),
+			AccessTokenTTL:  getEnvAsInt("OAUTH_ACCESS_TOKEN_TTL", 3600),
+			RefreshTokenTTL: getEnvAsInt("OAUTH_REFRESH_TOKEN_TTL", 2592000),
+		},
+		Outbox: Outbox{
+			Sink:         getEnv("OUTBOX_SINK", ""),
+			URL:          getEnv("OUTBOX_URL", ""),
+			Topic:        getEnv("OUTBOX_TOPIC", "auth.events"),
+			BatchSize:    getEnvAsInt("OUTBOX_BATCH_SIZE", 100),
+			PollInterval: getEnvAsInt("OUTBOX_POLL_INTERVAL", 1000),
+			GapTimeout:   getEnvAsInt("OUTBOX_GAP_TIMEOUT", 10000),
+		},
 		PostgreSQL: PostgreSQL{
-			Dbname:   getEnv("POSTGRES_DB", "auth"),
-			User:     getEnv("POSTGRES_USER", "postgres"),
-			Password: getEnv("POSTGRES_PASSWORD", "postgres"),
-			Host:     getEnv("POSTGRES_HOST", "localhost"),
-			Port:     getEnvAsInt("POSTGRES_PORT", 5432),
-			Sslmode:  getEnv("POSTGRES_SSLMODE", "disable"),
-			AppName:  getEnv("POSTGRES_APPLICATION_NAME", "golang_auth_service"),
+			URL:             getEnv("DATABASE_URL", ""),
+			Dbname:          getEnv("POSTGRES_DB", "auth"),
+			User:            getEnv("POSTGRES_USER", "postgres"),
+			Password:        getEnv("POSTGRES_PASSWORD", "postgres"),
+			Host:            getEnv("POSTGRES_HOST", "localhost"),
+			Port:            getEnvAsInt("POSTGRES_PORT", 5432),
+			Sslmode:         getEnv("POSTGRES_SSLMODE", "disable"),
+			Sslcert:         getEnv("POSTGRES_SSLCERT", ""),
+			Sslkey:          getEnv("POSTGRES_SSLKEY", ""),
+			Sslrootcert:     getEnv("POSTGRES_SSLROOTCERT", ""),
+			AppName:         getEnv("POSTGRES_APPLICATION_NAME", "golang_auth_service"),
+			ConnectTimeout:  getEnvAsInt("POSTGRES_CONNECT_TIMEOUT", 10),
+			Schema:          getEnv("POSTGRES_SCHEMA", "auth"),
+			Migrate:         getEnvAsBool("POSTGRES_MIGRATE", true),
+			MaxOpenConns:    getEnvAsInt("POSTGRES_MAX_OPEN_CONNS", 0),
+			MaxIdleConns:    getEnvAsInt("POSTGRES_MAX_IDLE_CONNS", 2),
+			ConnMaxLifetime: getEnvAsInt("POSTGRES_CONN_MAX_LIFETIME", 0),
+			ConnMaxIdleTime: getEnvAsInt("POSTGRES_CONN_MAX_IDLE_TIME", 0),
 		},
 		Redis: Redis{
 			Addr:     getEnv("REDIS_ADDR", "localhost:6379"),
@@ -97,9 +310,51 @@ func New() Config {
 			Password: getEnv("REDIS_PASSWORD", ""),
 			DB:       getEnvAsInt("REDIS_DB", 0),
 		},
+		Registration: Registration{
+			Verification: getEnvAsBool("REGISTRATION_VERIFICATION", false),
+		},
 		RequestTimeout: RequestTimeout(getEnvAsInt("REQUEST_TIMEOUT", 30)),
+		Retention: Retention{
+			Days:       getEnvAsInt("EVENT_RETENTION_DAYS", 0),
+			Policies:   getEnv("EVENT_RETENTION_POLICIES", ""),
+			ArchiveDir: getEnv("EVENT_ARCHIVE_DIR", "archive"),
+			Interval:   getEnvAsInt("EVENT_ARCHIVE_INTERVAL", 3600),
+		},
 		ServerConfig: ServerConfig{
-			Port: getEnv("PORT", "8080"),
+			Port:           getEnv("PORT", "8080"),
+			TrustedProxies: getEnv("TRUSTED_PROXIES", ""),
+		},
+		SocialLogin: SocialLogin{
+			Provider:     getEnv("SOCIAL_LOGIN_PROVIDER", "oidc"),
+			Issuer:       getEnv("SOCIAL_LOGIN_ISSUER", ""),
+			ClientID:     getEnv("SOCIAL_LOGIN_CLIENT_ID", ""),
+			ClientSecret: getEnv("SOCIAL_LOGIN_CLIENT_SECRET", ""),
+			Scopes:       getEnv("SOCIAL_LOGIN_SCOPES", "profile email"),
+			RedirectURL:  getEnv("SOCIAL_LOGIN_REDIRECT_URL", "http://localhost:3001/auth/login/oidc/callback"),
+			ReturnURL:    getEnv("SOCIAL_LOGIN_RETURN_URL", "http://localhost:3000/"),
+		},
+		SuspiciousLogin: SuspiciousLogin{
+			Enabled:       getEnvAsBool("SUSPICIOUS_LOGIN_ENABLED", true),
+			History:       getEnvAsInt("SUSPICIOUS_LOGIN_HISTORY", 100),
+			GeoIPDatabase: getEnv("SUSPICIOUS_LOGIN_GEOIP_DATABASE", ""),
+			MaxSpeed:      getEnvAsInt("SUSPICIOUS_LOGIN_MAX_SPEED", 1000),
+			Notifier:      getEnv("SUSPICIOUS_LOGIN_NOTIFIER", ""),
+			NotifierURL:   getEnv("SUSPICIOUS_LOGIN_NOTIFIER_URL", ""),
+		},
+		Username: Username{
+			MinLength: getEnvAsInt("USERNAME_MIN_LENGTH", 1),
+			MaxLength: getEnvAsInt("USERNAME_MAX_LENGTH", 50),
+			Unicode:   getEnvAsBool("USERNAME_UNICODE", true),
+			Reserved: getEnv("USERNAME_RESERVED", "admin,administrator,root,system,support,security,"+
+				"help,api,auth,oauth,login,logout,register,user,users,me,null,undefined"),
+		},
+		Webhook: Webhook{
+			MaxAttempts:  getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 10),
+			Backoff:      getEnvAsInt("WEBHOOK_BACKOFF", 30),
+			MaxBackoff:   getEnvAsInt("WEBHOOK_MAX_BACKOFF", 21600),
+			Timeout:      getEnvAsInt("WEBHOOK_TIMEOUT", 10),
+			BatchSize:    getEnvAsInt("WEBHOOK_BATCH_SIZE", 20),
+			PollInterval: getEnvAsInt("WEBHOOK_POLL_INTERVAL", 1000),
 		},
 		Session: Session{
 			Name:     getEnv("SESSION_NAME", "X-Session-ID"),
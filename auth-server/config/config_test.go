@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -11,18 +13,50 @@ func TestNewConfig(t *testing.T) {
 
 	c := New()
 
+	r.Equal("auth-server", c.Assertion.Issuer, "Default assertion issuer not set correctly")
+	r.Equal("secure-server", c.Assertion.Audience, "Default assertion audience not set correctly")
+	r.Equal(60, c.Assertion.TTL, "Default assertion TTL not set correctly")
+	r.Equal("", c.Assertion.KeyFile, "Default assertion key file not set correctly")
+
+	r.Equal(3600, c.Audit.CheckpointInterval, "Default audit checkpoint interval not set correctly")
+
 	r.Equal("*", c.Cors.AllowOrigin, "Default CORS allow origin not set correctly")
 	r.Equal("GET, POST, OPTIONS", c.Cors.AllowMethods, "Default CORS allow methods not set correctly")
 	r.Equal("*", c.Cors.AllowHeaders, "Default CORS allow headers not set correctly")
 	r.Equal("true", c.Cors.AllowCredentials, "Default CORS allow credentials not set correctly")
 
+	r.Equal("http://localhost:3001/auth", c.OAuth.Issuer, "Default OAuth issuer not set correctly")
+	r.Equal("http://localhost:3000/", c.OAuth.LoginURL, "Default OAuth login URL not set correctly")
+	r.Equal(60, c.OAuth.CodeTTL, "Default OAuth code TTL not set correctly")
+	r.Equal(3600, c.OAuth.AccessTokenTTL, "Default OAuth access token TTL not set correctly")
+	r.Equal(2592000, c.OAuth.RefreshTokenTTL, "Default OAuth refresh token TTL not set correctly")
+
+	r.Equal("", c.Outbox.Sink, "Default outbox sink not set correctly")
+	r.Equal("", c.Outbox.URL, "Default outbox URL not set correctly")
+	r.Equal("auth.events", c.Outbox.Topic, "Default outbox topic not set correctly")
+	r.Equal(100, c.Outbox.BatchSize, "Default outbox batch size not set correctly")
+	r.Equal(1000, c.Outbox.PollInterval, "Default outbox poll interval not set correctly")
+	r.Equal(10000, c.Outbox.GapTimeout, "Default outbox gap timeout not set correctly")
+
+	r.Equal("", c.PostgreSQL.URL, "Default database URL not set correctly")
 	r.Equal("auth", c.PostgreSQL.Dbname, "Default PostgreSQL dbname not set correctly")
 	r.Equal("postgres", c.PostgreSQL.User, "Default PostgreSQL user not set correctly")
 	r.Equal("postgres", c.PostgreSQL.Password, "Default PostgreSQL password not set correctly")
 	r.Equal("localhost", c.PostgreSQL.Host, "Default PostgreSQL host not set correctly")
 	r.Equal(5432, c.PostgreSQL.Port, "Default PostgreSQL port not set correctly")
 	r.Equal("disable", c.PostgreSQL.Sslmode, "Default PostgreSQL sslmode not set correctly")
+	r.Equal("", c.PostgreSQL.Sslcert, "Default PostgreSQL client certificate not set correctly")
+	r.Equal("", c.PostgreSQL.Sslkey, "Default PostgreSQL client key not set correctly")
+	r.Equal("", c.PostgreSQL.Sslrootcert, "Default PostgreSQL root certificate not set correctly")
 	r.Equal("golang_auth_service", c.PostgreSQL.AppName, "Default PostgreSQL fallback application not set correctly")
+	r.Equal(10, c.PostgreSQL.ConnectTimeout, "Default PostgreSQL connect timeout not set correctly")
+	r.Equal("auth", c.PostgreSQL.Schema, "Default PostgreSQL schema not set correctly")
+	r.True(c.PostgreSQL.Migrate, "Default PostgreSQL migrate flag not set correctly")
+	r.Equal(0, c.PostgreSQL.MaxOpenConns, "Default PostgreSQL max open connections not set correctly")
+	r.Equal(2, c.PostgreSQL.MaxIdleConns, "Default PostgreSQL max idle connections not set correctly")
+	r.Equal(0, c.PostgreSQL.ConnMaxLifetime, "Default PostgreSQL connection max lifetime not set correctly")
+	r.Equal(0, c.PostgreSQL.ConnMaxIdleTime, "Default PostgreSQL connection max idle time not set correctly")
+	r.NoError(c.PostgreSQL.Validate(), "Default PostgreSQL config is invalid")
 
 	r.Equal("localhost:6379", c.Redis.Addr, "Default Redis address not set correctly")
 	r.Equal("", c.Redis.Username, "Default Redis username not set correctly")
@@ -31,7 +65,13 @@ func TestNewConfig(t *testing.T) {
 
 	r.Equal(30, int(c.RequestTimeout), "Default request timeout not set correctly")
 
+	r.Equal(0, c.Retention.Days, "Default event retention not set correctly")
+	r.Equal("", c.Retention.Policies, "Default event retention policies not set correctly")
+	r.Equal("archive", c.Retention.ArchiveDir, "Default event archive directory not set correctly")
+	r.Equal(3600, c.Retention.Interval, "Default event archive interval not set correctly")
+
 	r.Equal("8080", c.ServerConfig.Port, "Default server port not set correctly")
+	r.Equal("", c.ServerConfig.TrustedProxies, "Default trusted proxies not set correctly")
 
 	r.Equal("X-Session-ID", c.Session.Name, "Default session name not set correctly")
 	r.Equal("localhost", c.Session.Domain, "Default session domain not set correctly")
@@ -40,4 +80,66 @@ func TestNewConfig(t *testing.T) {
 	r.True(c.Session.HTTPOnly, "Default session HTTPOnly flag not set correctly")
 	r.Equal("Strict", c.Session.SameSite, "Default session SameSite not set correctly")
 	r.Equal(86400, c.Session.MaxAge, "Default session max age not set correctly")
+
+	r.Equal("oidc", c.SocialLogin.Provider, "Default social login provider not set correctly")
+	r.Equal("", c.SocialLogin.Issuer, "Default social login issuer not set correctly")
+	r.Equal("", c.SocialLogin.ClientID, "Default social login client ID not set correctly")
+	r.Equal("", c.SocialLogin.ClientSecret, "Default social login client secret not set correctly")
+	r.Equal("profile email", c.SocialLogin.Scopes, "Default social login scopes not set correctly")
+	r.Equal("http://localhost:3001/auth/login/oidc/callback", c.SocialLogin.RedirectURL, "Default social login redirect URL not set correctly")
+	r.Equal("http://localhost:3000/", c.SocialLogin.ReturnURL, "Default social login return URL not set correctly")
+
+	r.True(c.SuspiciousLogin.Enabled, "Default suspicious login detection not set correctly")
+	r.Equal(100, c.SuspiciousLogin.History, "Default suspicious login history not set correctly")
+	r.Equal("", c.SuspiciousLogin.GeoIPDatabase, "Default GeoIP database not set correctly")
+	r.Equal(1000, c.SuspiciousLogin.MaxSpeed, "Default suspicious login max speed not set correctly")
+	r.Equal("", c.SuspiciousLogin.Notifier, "Default suspicious login notifier not set correctly")
+	r.Equal("", c.SuspiciousLogin.NotifierURL, "Default suspicious login notifier URL not set correctly")
+
+	r.Equal(1, c.Username.MinLength, "Default username min length not set correctly")
+	r.Equal(50, c.Username.MaxLength, "Default username max length not set correctly")
+	r.True(c.Username.Unicode, "Default username unicode flag not set correctly")
+	r.Contains(c.Username.Reserved, "admin", "Default reserved usernames not set correctly")
+
+	r.Equal(10, c.Webhook.MaxAttempts, "Default webhook max attempts not set correctly")
+	r.Equal(30, c.Webhook.Backoff, "Default webhook backoff not set correctly")
+	r.Equal(21600, c.Webhook.MaxBackoff, "Default webhook max backoff not set correctly")
+	r.Equal(10, c.Webhook.Timeout, "Default webhook timeout not set correctly")
+	r.Equal(20, c.Webhook.BatchSize, "Default webhook batch size not set correctly")
+	r.Equal(1000, c.Webhook.PollInterval, "Default webhook poll interval not set correctly")
+}
+
+func TestValidatePostgreSQL(t *testing.T) {
+	r := require.New(t)
+	file := filepath.Join(t.TempDir(), "client.pem")
+	r.NoError(os.WriteFile(file, []byte("pem"), 0o600))
+
+	valid := New().PostgreSQL
+	valid.Sslmode = "verify-full"
+	valid.Sslcert, valid.Sslkey, valid.Sslrootcert = file, file, file
+	r.NoError(valid.Validate())
+
+	for name, invalidate := range map[string]func(c *PostgreSQL){
+		"port":         func(c *PostgreSQL) { c.Port = 0 },
+		"host":         func(c *PostgreSQL) { c.Host = "" },
+		"sslmode":      func(c *PostgreSQL) { c.Sslmode = "prefer" },
+		"key":          func(c *PostgreSQL) { c.Sslkey = "" },
+		"missing file": func(c *PostgreSQL) { c.Sslrootcert = file + ".missing" },
+		"disabled":     func(c *PostgreSQL) { c.Sslmode = "disable" },
+		"timeout":      func(c *PostgreSQL) { c.ConnectTimeout = -1 },
+		"schema":       func(c *PostgreSQL) { c.Schema = "Auth; DROP" },
+		"pool":         func(c *PostgreSQL) { c.MaxOpenConns, c.MaxIdleConns = 2, 4 },
+		"lifetime":     func(c *PostgreSQL) { c.ConnMaxLifetime = -1 },
+		"url":          func(c *PostgreSQL) { c.URL = "mysql://localhost/auth" },
+	} {
+		c := valid
+		invalidate(&c)
+		r.Error(c.Validate(), name)
+	}
+
+	// the URL replaces the settings of the connection
+	c := valid
+	c.URL = "postgresql://user@db.example.com/auth?sslmode=require"
+	c.Host, c.Port, c.Sslmode = "", 0, ""
+	r.NoError(c.Validate())
 }
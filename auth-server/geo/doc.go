@@ -0,0 +1,5 @@
+// Package geo locates IP addresses, to detect logins too far apart to have travelled between.
+//
+// Addresses are located with a local database in the MaxMind DB format, such as GeoLite2 City,
+// which is read once on startup, so no address is sent to a third party.
+package geo
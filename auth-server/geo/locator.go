@@ -0,0 +1,77 @@
+package geo
+
+import (
+	"math"
+	"net"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// earthRadius is the mean radius of the earth in kilometers.
+const earthRadius = 6371.0
+
+// Locator locates IP addresses.
+type Locator interface {
+	// Locate returns the location of ip, or nil when it is unknown, such as for private addresses.
+	Locate(ip net.IP) (*model.Location, error)
+	Close() error
+}
+
+type maxMindLocator struct {
+	reader *maxminddb.Reader
+}
+
+// cityRecord is the subset of a record of a MaxMind City database which is decoded.
+type cityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// NewMaxMindLocator returns a Locator reading the MaxMind City database at path,
+// such as GeoLite2-City.mmdb.
+func NewMaxMindLocator(path string) (Locator, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &maxMindLocator{reader}, nil
+}
+
+func (l *maxMindLocator) Locate(ip net.IP) (*model.Location, error) {
+	var record cityRecord
+	_, ok, err := l.reader.LookupNetwork(ip, &record)
+	if err != nil || !ok || record.Location.Latitude == nil || record.Location.Longitude == nil {
+		return nil, err
+	}
+	return &model.Location{
+		Country:   record.Country.ISOCode,
+		City:      record.City.Names["en"],
+		Latitude:  *record.Location.Latitude,
+		Longitude: *record.Location.Longitude,
+	}, nil
+}
+
+func (l *maxMindLocator) Close() error {
+	return l.reader.Close()
+}
+
+// Distance returns the great-circle distance between a and b in kilometers.
+func Distance(a, b *model.Location) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dLat, dLon := lat2-lat1, radians(b.Longitude-a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
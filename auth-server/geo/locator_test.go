@@ -0,0 +1,22 @@
+package geo
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistance(t *testing.T) {
+	london := &model.Location{Latitude: 51.5074, Longitude: -0.1278}
+	newYork := &model.Location{Latitude: 40.7128, Longitude: -74.0060}
+	require.InDelta(t, 5570, Distance(london, newYork), 10)
+	require.InDelta(t, 5570, Distance(newYork, london), 10)
+	require.Zero(t, Distance(london, london))
+}
+
+func TestNewMaxMindLocatorMissing(t *testing.T) {
+	_, err := NewMaxMindLocator(filepath.Join(t.TempDir(), "missing.mmdb"))
+	require.Error(t, err)
+}
@@ -7,13 +7,18 @@ require (
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/websocket v1.5.0
 	github.com/lib/pq v1.10.7
-	github.com/stretchr/testify v1.8.2
+	github.com/nats-io/nats.go v1.11.0
+	github.com/oschwald/maxminddb-golang v1.12.0
+	github.com/stretchr/testify v1.8.4
 	golang.org/x/crypto v0.0.0-20220507011949-2cf3adece122
+	golang.org/x/text v0.14.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/kr/pretty v0.3.0 // indirect
+	github.com/nats-io/nkeys v0.3.0 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/go-internal v1.8.0 // indirect
 	golang.org/x/net v0.7.0 // indirect
@@ -26,5 +31,5 @@ require (
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/go-chi/chi v1.5.4
 	github.com/joho/godotenv v1.4.0
-	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
 )
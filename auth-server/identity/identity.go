@@ -0,0 +1,61 @@
+package identity
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/jwt"
+)
+
+// Header is the request header carrying the identity assertion.
+const Header = "X-Identity-Assertion"
+
+// Claims is the content of an identity assertion.
+// The subject of the assertion is the ID of the user.
+type Claims struct {
+	jwt.Claims
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+}
+
+// Verifier verifies identity assertions.
+type Verifier struct {
+	keys     jwt.KeySource
+	issuer   string
+	audience string
+}
+
+// NewVerifier returns a Verifier accepting assertions signed by a key from keys,
+// issued by issuer for audience.
+func NewVerifier(keys jwt.KeySource, issuer, audience string) *Verifier {
+	return &Verifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+// Verify verifies the signature and claims of the assertion and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, assertion string) (*Claims, error) {
+	var claims Claims
+	if err := jwt.Parse(ctx, assertion, v.keys, &claims); err != nil {
+		return nil, err
+	}
+	if err := claims.Validate(v.issuer, v.audience, time.Now()); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying claims.
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims of the verified user stored in ctx, if any.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok
+}
@@ -0,0 +1,69 @@
+package identity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	key, err := jwt.GenerateKey()
+	require.NoError(t, err)
+	signer, err := jwt.NewSigner(key)
+	require.NoError(t, err)
+	verifier := NewVerifier(signer.KeySet(), "auth-server", "secure-server")
+
+	handler := Middleware(verifier)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := FromContext(r.Context())
+		require.True(t, ok)
+		_, _ = w.Write([]byte(claims.Username))
+	}))
+
+	valid, err := signer.Sign(&Claims{
+		Claims: jwt.Claims{
+			Issuer:    "auth-server",
+			Subject:   "4bd8a4f8-6ee5-4c42-9dc5-8f1b2d1e8e7a",
+			Audience:  jwt.Audience{"secure-server"},
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+		Username: "newuser",
+	})
+	require.NoError(t, err)
+	expired, err := signer.Sign(&Claims{
+		Claims: jwt.Claims{
+			Issuer:    "auth-server",
+			Audience:  jwt.Audience{"secure-server"},
+			ExpiresAt: time.Now().Add(-time.Hour).Unix(),
+		},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		assertion string
+		status    int
+	}{
+		{"valid", valid, http.StatusOK},
+		{"missing", "", http.StatusUnauthorized},
+		{"expired", expired, http.StatusUnauthorized},
+		{"malformed", "not.a.token", http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.assertion != "" {
+				req.Header.Set(Header, tc.assertion)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			require.Equal(t, tc.status, rr.Code)
+			if tc.status == http.StatusOK {
+				require.Equal(t, "newuser", rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package identity
+
+import (
+	"log"
+	"net/http"
+)
+
+// Middleware rejects requests without a valid identity assertion with HTTP 401 Unauthorized.
+// The claims of valid assertions are stored in the request context, see FromContext.
+func Middleware(v *Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assertion := r.Header.Get(Header)
+			if assertion == "" {
+				http.Error(w, "missing identity assertion", http.StatusUnauthorized)
+				return
+			}
+			claims, err := v.Verify(r.Context(), assertion)
+			if err != nil {
+				log.Printf("invalid identity assertion: %s", err)
+				http.Error(w, "invalid identity assertion", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), claims)))
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Leeway is the clock skew tolerated when validating time based claims.
+const Leeway = 30 * time.Second
+
+// Claims contains the registered claim names defined by RFC 7519.
+// Tokens carrying additional claims embed Claims in their own struct.
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Validate verifies the token was issued by issuer for audience and is valid at the given time.
+// An empty issuer or audience skips the respective check.
+func (c *Claims) Validate(issuer, audience string, now time.Time) error {
+	if issuer != "" && c.Issuer != issuer {
+		return errors.New("jwt: unexpected issuer")
+	}
+	if audience != "" && !c.Audience.Contains(audience) {
+		return errors.New("jwt: unexpected audience")
+	}
+	if c.ExpiresAt == 0 {
+		return errors.New("jwt: missing expiration")
+	}
+	if now.Add(-Leeway).Unix() >= c.ExpiresAt {
+		return errors.New("jwt: token expired")
+	}
+	if c.NotBefore != 0 && now.Add(Leeway).Unix() < c.NotBefore {
+		return errors.New("jwt: token not yet valid")
+	}
+	return nil
+}
+
+// Audience is the "aud" claim, which may be encoded as a single string or an array of strings.
+type Audience []string
+
+// Contains reports whether aud is one of the intended audiences.
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON encodes a single audience as a string, as is conventional.
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON decodes an audience encoded as either a string or an array of strings.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
@@ -0,0 +1,8 @@
+// Package jwt provides a minimal implementation of signed JSON Web Tokens (RFC 7519)
+// and JSON Web Key Sets (RFC 7517).
+//
+// Tokens are signed with RS256 or EdDSA, depending on the type of private key, and the
+// corresponding public keys are published as a key set so other services can verify them.
+// The package depends on the standard library only, allowing it to be imported by services
+// outside of auth-server without pulling in unrelated dependencies.
+package jwt
@@ -0,0 +1,120 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testClaims struct {
+	Claims
+	Name string `json:"name"`
+}
+
+func TestSignParse(t *testing.T) {
+	rsaKey, err := GenerateKey()
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for _, key := range []crypto.Signer{rsaKey, edKey} {
+		signer, err := NewSigner(key)
+		require.NoError(t, err)
+
+		token, err := signer.Sign(&testClaims{
+			Claims: Claims{Issuer: "issuer", Audience: Audience{"aud"}, ExpiresAt: time.Now().Add(time.Minute).Unix()},
+			Name:   "name",
+		})
+		require.NoError(t, err)
+
+		var claims testClaims
+		require.NoError(t, Parse(context.Background(), token, signer.KeySet(), &claims))
+		require.Equal(t, "name", claims.Name)
+		require.NoError(t, claims.Validate("issuer", "aud", time.Now()))
+		require.Error(t, claims.Validate("other", "aud", time.Now()))
+		require.Error(t, claims.Validate("issuer", "other", time.Now()))
+		require.Error(t, claims.Validate("issuer", "aud", time.Now().Add(time.Hour)))
+	}
+}
+
+func TestParseTampered(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	signer, err := NewSigner(key)
+	require.NoError(t, err)
+
+	token, err := signer.Sign(&testClaims{Name: "name"})
+	require.NoError(t, err)
+	parts := strings.Split(token, ".")
+	forged, err := json.Marshal(&testClaims{Name: "admin"})
+	require.NoError(t, err)
+	tampered := parts[0] + "." + encodeSegment(forged) + "." + parts[2]
+
+	var claims testClaims
+	require.Error(t, Parse(context.Background(), tampered, signer.KeySet(), &claims))
+}
+
+func TestParseUnknownKey(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	signer, err := NewSigner(key)
+	require.NoError(t, err)
+	other, err := GenerateKey()
+	require.NoError(t, err)
+	otherSigner, err := NewSigner(other)
+	require.NoError(t, err)
+
+	token, err := otherSigner.Sign(&testClaims{Name: "name"})
+	require.NoError(t, err)
+
+	var claims testClaims
+	require.Error(t, Parse(context.Background(), token, signer.KeySet(), &claims))
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	signer, err := NewSigner(key)
+	require.NoError(t, err)
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		require.NoError(t, json.NewEncoder(w).Encode(signer.KeySet()))
+	}))
+	defer srv.Close()
+
+	token, err := signer.Sign(&testClaims{Name: "name"})
+	require.NoError(t, err)
+
+	keys := NewRemoteKeySet(srv.URL, srv.Client())
+	for i := 0; i < 2; i++ {
+		var claims testClaims
+		require.NoError(t, Parse(context.Background(), token, keys, &claims))
+	}
+	// keys are cached between requests
+	require.Equal(t, 1, requests)
+}
+
+func TestAudience(t *testing.T) {
+	var single Audience
+	require.NoError(t, json.Unmarshal([]byte(`"one"`), &single))
+	require.Equal(t, Audience{"one"}, single)
+
+	var multiple Audience
+	require.NoError(t, json.Unmarshal([]byte(`["one", "two"]`), &multiple))
+	require.True(t, multiple.Contains("two"))
+
+	encoded, err := json.Marshal(single)
+	require.NoError(t, err)
+	require.Equal(t, `"one"`, string(encoded))
+}
@@ -0,0 +1,184 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Values for JWK.Alg and the "alg" token header
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// JWK is a public JSON Web Key as described by RFC 7517.
+// Only RSA and Ed25519 (OKP) keys are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA public exponent
+	Crv string `json:"crv,omitempty"` // OKP curve
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+// KeySet is a JSON Web Key Set, the document served by a JWKS endpoint.
+type KeySet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySource provides the public key used to verify a token.
+type KeySource interface {
+	Key(ctx context.Context, kid string) (*JWK, error)
+}
+
+// Key returns the key identified by kid. When kid is empty and the set contains
+// a single key, that key is returned.
+func (s *KeySet) Key(_ context.Context, kid string) (*JWK, error) {
+	if kid == "" && len(s.Keys) == 1 {
+		return &s.Keys[0], nil
+	}
+	for i := range s.Keys {
+		if s.Keys[i].Kid == kid {
+			return &s.Keys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("jwt: unknown key %q", kid)
+}
+
+// NewJWK returns the JWK representation of a RSA or Ed25519 public key.
+// The key ID is the RFC 7638 thumbprint of the key.
+func NewJWK(pub crypto.PublicKey) (*JWK, error) {
+	var jwk JWK
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		jwk = JWK{
+			Kty: "RSA",
+			Alg: RS256,
+			N:   encodeSegment(key.N.Bytes()),
+			E:   encodeSegment(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		jwk = JWK{
+			Kty: "OKP",
+			Alg: EdDSA,
+			Crv: "Ed25519",
+			X:   encodeSegment(key),
+		}
+	default:
+		return nil, fmt.Errorf("jwt: unsupported key type %T", pub)
+	}
+	jwk.Use = "sig"
+	jwk.Kid = jwk.thumbprint()
+	return &jwk, nil
+}
+
+// PublicKey decodes the public key contained in the JWK.
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("jwt: unsupported curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("jwt: invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("jwt: unsupported key type %q", k.Kty)
+	}
+}
+
+// thumbprint computes the RFC 7638 thumbprint of the key,
+// the hash of its required members in lexicographic order.
+func (k *JWK) thumbprint() string {
+	var members []byte
+	switch k.Kty {
+	case "RSA":
+		members, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N})
+	case "OKP":
+		members, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X})
+	}
+	sum := sha256.Sum256(members)
+	return encodeSegment(sum[:])
+}
+
+// LoadKey reads a PEM encoded RSA or Ed25519 private key from file.
+// Both PKCS #8 and PKCS #1 (RSA only) encodings are accepted.
+func LoadKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt: no PEM data found in %s", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("jwt: unsupported key type %T", key)
+	}
+}
+
+// GenerateKey generates a RSA private key suitable for signing RS256 tokens.
+func GenerateKey() (crypto.Signer, error) {
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
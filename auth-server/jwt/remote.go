@@ -0,0 +1,82 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RemoteKeySet is a KeySource backed by a JWKS endpoint.
+// Keys are cached, and refetched when a token references an unknown key,
+// allowing the issuer to rotate keys without coordination.
+// Safe for concurrent use by multiple goroutines.
+type RemoteKeySet struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      *KeySet
+	fetchedAt time.Time
+}
+
+// minRefreshInterval limits how often unknown key IDs trigger a refetch,
+// preventing tokens with bogus key IDs from flooding the JWKS endpoint.
+const minRefreshInterval = 10 * time.Second
+
+// maxCacheAge is the maximum time keys are cached before being refetched.
+const maxCacheAge = time.Hour
+
+// NewRemoteKeySet returns a key set fetching keys from the JWKS endpoint at url.
+func NewRemoteKeySet(url string, client *http.Client) *RemoteKeySet {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	return &RemoteKeySet{
+		url:    url,
+		client: client,
+	}
+}
+
+// Key returns the key identified by kid, fetching the key set if necessary.
+func (r *RemoteKeySet) Key(ctx context.Context, kid string) (*JWK, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.keys != nil && time.Since(r.fetchedAt) < maxCacheAge {
+		if key, err := r.keys.Key(ctx, kid); err == nil {
+			return key, nil
+		}
+		if time.Since(r.fetchedAt) < minRefreshInterval {
+			return nil, fmt.Errorf("jwt: unknown key %q", kid)
+		}
+	}
+	if err := r.fetch(ctx); err != nil {
+		return nil, err
+	}
+	return r.keys.Key(ctx, kid)
+}
+
+func (r *RemoteKeySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwt: fetching key set: unexpected status %d", res.StatusCode)
+	}
+	var keys KeySet
+	if err := json.NewDecoder(res.Body).Decode(&keys); err != nil {
+		return fmt.Errorf("jwt: decoding key set: %w", err)
+	}
+	r.keys = &keys
+	r.fetchedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,142 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// header is the JOSE header of a signed token.
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Signer signs tokens with a private key.
+// Safe for concurrent use by multiple goroutines.
+type Signer struct {
+	key crypto.Signer
+	jwk *JWK
+}
+
+// NewSigner returns a Signer for the given RSA or Ed25519 private key.
+// RSA keys sign with RS256, Ed25519 keys with EdDSA.
+func NewSigner(key crypto.Signer) (*Signer, error) {
+	jwk, err := NewJWK(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		key: key,
+		jwk: jwk,
+	}, nil
+}
+
+// KeySet returns the key set containing the public key of the signer.
+func (s *Signer) KeySet() *KeySet {
+	return &KeySet{Keys: []JWK{*s.jwk}}
+}
+
+// Sign encodes claims as JSON and returns the compact serialization of the signed token.
+func (s *Signer) Sign(claims interface{}) (string, error) {
+	h, err := json.Marshal(header{Alg: s.jwk.Alg, Kid: s.jwk.Kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encodeSegment(h) + "." + encodeSegment(c)
+
+	var sig []byte
+	switch s.jwk.Alg {
+	case RS256:
+		digest := sha256.Sum256([]byte(signingInput))
+		sig, err = s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case EdDSA:
+		sig, err = s.key.Sign(rand.Reader, []byte(signingInput), crypto.Hash(0))
+	}
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + encodeSegment(sig), nil
+}
+
+// Parse verifies the signature of token using a key provided by keys,
+// and decodes its claims into claims. The time based and audience claims
+// are not validated, see Claims.Validate.
+func Parse(ctx context.Context, token string, keys KeySource, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("jwt: malformed token")
+	}
+	rawHeader, err := decodeSegment(parts[0])
+	if err != nil {
+		return fmt.Errorf("jwt: malformed header: %w", err)
+	}
+	var h header
+	if err := json.Unmarshal(rawHeader, &h); err != nil {
+		return fmt.Errorf("jwt: malformed header: %w", err)
+	}
+	sig, err := decodeSegment(parts[2])
+	if err != nil {
+		return fmt.Errorf("jwt: malformed signature: %w", err)
+	}
+
+	jwk, err := keys.Key(ctx, h.Kid)
+	if err != nil {
+		return err
+	}
+	// the algorithm is dictated by the key, never by the token alone
+	if jwk.Alg != "" && jwk.Alg != h.Alg {
+		return fmt.Errorf("jwt: algorithm %q does not match key", h.Alg)
+	}
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		return err
+	}
+	if err := verify(h.Alg, pub, parts[0]+"."+parts[1], sig); err != nil {
+		return err
+	}
+
+	rawClaims, err := decodeSegment(parts[1])
+	if err != nil {
+		return fmt.Errorf("jwt: malformed claims: %w", err)
+	}
+	return json.Unmarshal(rawClaims, claims)
+}
+
+func verify(alg string, pub crypto.PublicKey, signingInput string, sig []byte) error {
+	switch alg {
+	case RS256:
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("jwt: RS256 requires a RSA key")
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("jwt: invalid signature")
+		}
+		return nil
+	case EdDSA:
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("jwt: EdDSA requires an Ed25519 key")
+		}
+		if !ed25519.Verify(key, []byte(signingInput), sig) {
+			return errors.New("jwt: invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("jwt: unsupported algorithm %q", alg)
+	}
+}
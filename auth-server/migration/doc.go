@@ -0,0 +1,18 @@
+// Package migration applies the versioned migrations of the database schema.
+//
+// Migrations are embedded in the binary from the sql directory, as pairs of files named
+// NNNN_name.up.sql and NNNN_name.down.sql, where NNNN is the version of the migration. Up applies
+// every migration which has not been applied yet, in version order, each in its own transaction,
+// and is run by the server on startup and by the migrate command. Down reverts the latest.
+//
+// A single replica migrates at a time, holding an advisory lock, while the others wait. The
+// checksum of every migration applied is recorded in the schema_migrations table, and migrating
+// fails when an applied migration has been edited since, or is unknown to the binary.
+//
+// Schemas created by database/init.sql, before releases had migrations, are adopted by Up, which
+// records the first migration, creating the same schema, as applied when the schema already holds
+// its tables. The migrations following it upgrade the schema, copying the events into the
+// partitioned event table, which chains them.
+//
+// Migrations are never edited once released: the schema is changed by adding a migration.
+package migration
@@ -0,0 +1,225 @@
+package migration
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// baseline is the version of the migration creating the schema of the last release before migrations,
+// created by its database/init.sql. Such schemas are adopted by recording it as applied.
+const baseline = 1
+
+// legacyObjects are the tables created by the init.sql of the last release before migrations.
+var legacyObjects = []string{"user", "event", "session"}
+
+// filename matches the files of migrations, capturing their version, name and direction.
+var filename = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Embedded returns the migrations embedded in the binary, in version order.
+func Embedded() ([]*model.Migration, error) {
+	sub, err := fs.Sub(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+	return Load(sub)
+}
+
+// Load returns the migrations of the files at the root of fsys, in version order. Every migration
+// must have both an up and a down file, and versions must be unique.
+func Load(fsys fs.FS) ([]*model.Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*model.Migration)
+	for _, entry := range entries {
+		match := filename.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			return nil, fmt.Errorf("invalid migration file %s, expected NNNN_name.up.sql or NNNN_name.down.sql", entry.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		script, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &model.Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(script)
+			digest := sha256.Sum256(script)
+			migration.Checksum = hex.EncodeToString(digest[:])
+		} else {
+			migration.Down = string(script)
+		}
+	}
+
+	migrations := make([]*model.Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s requires both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every migration which has not been applied, in version order, returning those applied.
+// Nothing is applied when an applied migration has been edited or is unknown. When a migration
+// fails, those before it remain applied. A schema created by init.sql is adopted first.
+func Up(ctx context.Context, repo repository.MigrationRepository, migrations []*model.Migration) ([]*model.Migration, error) {
+	done := make([]*model.Migration, 0)
+	err := locked(ctx, repo, migrations, func(session repository.MigrationSession, applied map[int]*model.Migration) error {
+		if len(applied) == 0 {
+			adopted, err := adopt(ctx, session, migrations)
+			if err != nil {
+				return err
+			}
+			if adopted != nil {
+				applied[adopted.Version] = adopted
+			}
+		}
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := session.Apply(ctx, migration); err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the latest steps migrations applied, latest first, returning those reverted.
+func Down(ctx context.Context, repo repository.MigrationRepository, migrations []*model.Migration, steps int) ([]*model.Migration, error) {
+	done := make([]*model.Migration, 0, steps)
+	err := locked(ctx, repo, migrations, func(session repository.MigrationSession, applied map[int]*model.Migration) error {
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := session.Revert(ctx, migration); err != nil {
+				return fmt.Errorf("failed to revert migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status returns migrations, with the time each was applied, or zero when it has not been.
+func Status(ctx context.Context, repo repository.MigrationRepository, migrations []*model.Migration) ([]*model.Migration, error) {
+	status := make([]*model.Migration, 0, len(migrations))
+	err := locked(ctx, repo, migrations, func(_ repository.MigrationSession, applied map[int]*model.Migration) error {
+		for _, migration := range migrations {
+			m := *migration
+			if a, ok := applied[migration.Version]; ok {
+				m.AppliedAt = a.AppliedAt
+			}
+			status = append(status, &m)
+		}
+		return nil
+	})
+	return status, err
+}
+
+// adopt records the baseline migration as applied when the schema, without any migration applied,
+// was created by the init.sql of the last release before migrations, returning the baseline, so that
+// the migrations following it upgrade the schema. Nothing is adopted in a schema without the objects
+// of init.sql, and a schema holding only some of them is not migrated.
+func adopt(ctx context.Context, session repository.MigrationSession, migrations []*model.Migration) (*model.Migration, error) {
+	for i, name := range legacyObjects {
+		exists, err := session.Exists(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect schema: %w", err)
+		}
+		if exists {
+			continue
+		}
+		if i == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("schema holds %s but lacks %s, so was not created by init.sql and cannot be adopted",
+			legacyObjects[0], name)
+	}
+	for _, migration := range migrations {
+		if migration.Version == baseline {
+			if err := session.Record(ctx, migration); err != nil {
+				return nil, fmt.Errorf("failed to adopt schema created by init.sql: %w", err)
+			}
+			return migration, nil
+		}
+	}
+	return nil, fmt.Errorf("schema was created by init.sql, but migration %d is unknown", baseline)
+}
+
+// locked calls fn with a session holding the lock of the migrations, and the migrations applied by version,
+// once checked against migrations.
+func locked(ctx context.Context, repo repository.MigrationRepository, migrations []*model.Migration,
+	fn func(session repository.MigrationSession, applied map[int]*model.Migration) error) (err error) {
+	session, err := repo.Lock(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer func() {
+		if e := session.Release(); e != nil && err == nil {
+			err = fmt.Errorf("failed to release migrations: %w", e)
+		}
+	}()
+	applied, err := check(ctx, session, migrations)
+	if err != nil {
+		return err
+	}
+	return fn(session, applied)
+}
+
+// check returns the migrations applied, by version, or an error when one of them is not in
+// migrations, or its checksum does not match.
+func check(ctx context.Context, session repository.MigrationSession, migrations []*model.Migration) (map[int]*model.Migration, error) {
+	applied, err := session.Applied(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations applied: %w", err)
+	}
+	known := make(map[int]*model.Migration, len(migrations))
+	for _, migration := range migrations {
+		known[migration.Version] = migration
+	}
+	byVersion := make(map[int]*model.Migration, len(applied))
+	for _, a := range applied {
+		migration, ok := known[a.Version]
+		if !ok {
+			return nil, fmt.Errorf("migration %d_%s is applied but unknown, the schema is newer than this binary", a.Version, a.Name)
+		}
+		if migration.Checksum != a.Checksum {
+			return nil, fmt.Errorf("migration %d_%s has been edited since it was applied", a.Version, a.Name)
+		}
+		byVersion[a.Version] = a
+	}
+	return byVersion, nil
+}
@@ -0,0 +1,207 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/stretchr/testify/require"
+)
+
+func newFS() fstest.MapFS {
+	return fstest.MapFS{
+		"0002_add_column.up.sql":   {Data: []byte(`ALTER TABLE t ADD COLUMN c text;`)},
+		"0002_add_column.down.sql": {Data: []byte(`ALTER TABLE t DROP COLUMN c;`)},
+		"0001_init.up.sql":         {Data: []byte(`CREATE TABLE t ();`)},
+		"0001_init.down.sql":       {Data: []byte(`DROP TABLE t;`)},
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	migrations, err := Embedded()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	require.Equal(t, 1, migrations[0].Version)
+	require.Equal(t, "init", migrations[0].Name)
+	for i, migration := range migrations {
+		require.Equal(t, i+1, migration.Version, "versions must be consecutive")
+		// objects are created in the schema of the search_path
+		require.NotContains(t, migration.Up, `"auth".`, migration.Name)
+		require.NotContains(t, migration.Down, `"auth".`, migration.Name)
+	}
+}
+
+// released are the checksums of the released migrations, which must never change: a schema
+// recording a checksum no longer embedded refuses to migrate. Change the schema by adding a
+// migration, and pin its checksum here once released. Migrations are edited freely until then.
+var released = map[int]string{}
+
+func TestReleased(t *testing.T) {
+	migrations, err := Embedded()
+	require.NoError(t, err)
+	byVersion := make(map[int]string, len(migrations))
+	for _, migration := range migrations {
+		byVersion[migration.Version] = migration.Checksum
+	}
+	for version, checksum := range released {
+		require.Equal(t, checksum, byVersion[version], "released migration %d has been edited", version)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(newFS())
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	require.Equal(t, 1, migrations[0].Version)
+	require.Equal(t, "CREATE TABLE t ();", migrations[0].Up)
+	require.Equal(t, "DROP TABLE t;", migrations[0].Down)
+	require.Len(t, migrations[0].Checksum, 64)
+	require.Equal(t, "add_column", migrations[1].Name)
+
+	fsys := newFS()
+	delete(fsys, "0002_add_column.down.sql")
+	_, err = Load(fsys)
+	require.ErrorContains(t, err, "both an up and a down file")
+
+	fsys = newFS()
+	fsys["0002_other.up.sql"] = &fstest.MapFile{Data: []byte(`SELECT 1;`)}
+	_, err = Load(fsys)
+	require.ErrorContains(t, err, "same version")
+
+	fsys = newFS()
+	fsys["readme.md"] = &fstest.MapFile{}
+	_, err = Load(fsys)
+	require.ErrorContains(t, err, "invalid migration file")
+}
+
+func TestUpDown(t *testing.T) {
+	migrations, err := Load(newFS())
+	require.NoError(t, err)
+	repo := &repository.MockMigrationRepository{}
+
+	done, err := Up(context.Background(), repo, migrations[:1])
+	require.NoError(t, err)
+	require.Len(t, done, 1)
+
+	// only pending migrations are applied
+	done, err = Up(context.Background(), repo, migrations)
+	require.NoError(t, err)
+	require.Len(t, done, 1)
+	require.Equal(t, 2, done[0].Version)
+
+	done, err = Up(context.Background(), repo, migrations)
+	require.NoError(t, err)
+	require.Empty(t, done)
+
+	status, err := Status(context.Background(), repo, migrations)
+	require.NoError(t, err)
+	require.Len(t, status, 2)
+	require.False(t, status[1].AppliedAt.IsZero())
+
+	// migrations are reverted latest first
+	done, err = Down(context.Background(), repo, migrations, 1)
+	require.NoError(t, err)
+	require.Len(t, done, 1)
+	require.Equal(t, 2, done[0].Version)
+	require.Len(t, repo.Migrations, 1)
+
+	status, err = Status(context.Background(), repo, migrations)
+	require.NoError(t, err)
+	require.True(t, status[1].AppliedAt.IsZero())
+}
+
+func TestUpFailed(t *testing.T) {
+	migrations, err := Load(newFS())
+	require.NoError(t, err)
+	repo := &repository.MockMigrationRepository{Err: errors.New("syntax error")}
+	done, err := Up(context.Background(), repo, migrations)
+	require.ErrorContains(t, err, "failed to apply migration 1_init: syntax error")
+	require.Empty(t, done)
+
+	// the lock is released
+	repo.Err = nil
+	done, err = Up(context.Background(), repo, migrations)
+	require.NoError(t, err)
+	require.Len(t, done, 2)
+}
+
+func TestUpChecked(t *testing.T) {
+	migrations, err := Load(newFS())
+	require.NoError(t, err)
+	repo := &repository.MockMigrationRepository{}
+	_, err = Up(context.Background(), repo, migrations[:1])
+	require.NoError(t, err)
+
+	// editing a migration once applied is detected
+	fsys := newFS()
+	fsys["0001_init.up.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE t (id integer);`)}
+	edited, err := Load(fsys)
+	require.NoError(t, err)
+	done, err := Up(context.Background(), repo, edited)
+	require.ErrorContains(t, err, "edited")
+	require.Empty(t, done)
+	require.Len(t, repo.Migrations, 1)
+
+	// as is a schema migrated by a newer binary
+	_, err = Up(context.Background(), repo, migrations)
+	require.NoError(t, err)
+	_, err = Up(context.Background(), repo, migrations[:1])
+	require.ErrorContains(t, err, "unknown")
+}
+
+func TestUpAdopt(t *testing.T) {
+	migrations, err := Load(newFS())
+	require.NoError(t, err)
+
+	// a schema created by the init.sql of the last release is adopted, applying later migrations only
+	repo := &repository.MockMigrationRepository{Objects: legacyObjects}
+	done, err := Up(context.Background(), repo, migrations)
+	require.NoError(t, err)
+	require.Len(t, done, 1)
+	require.Equal(t, 2, done[0].Version)
+	require.Len(t, repo.Migrations, 2)
+	require.Equal(t, migrations[0].Checksum, repo.Migrations[0].Checksum)
+
+	// one holding only some of its tables is not
+	repo = &repository.MockMigrationRepository{Objects: []string{"user"}}
+	done, err = Up(context.Background(), repo, migrations)
+	require.ErrorContains(t, err, "lacks event")
+	require.Empty(t, done)
+	require.Empty(t, repo.Migrations)
+}
+
+// statements returns the statements of script, without comments and blank lines,
+// and unqualified by the schema auth, which init.sql created its tables in.
+func statements(script string) []string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "--") || line == `CREATE SCHEMA "auth";` {
+			continue
+		}
+		lines = append(lines, strings.ReplaceAll(line, `"auth".`, ""))
+	}
+	return lines
+}
+
+func TestUpBaseline(t *testing.T) {
+	migrations, err := Embedded()
+	require.NoError(t, err)
+
+	// the baseline creates the schema created by the init.sql of the last release, testdata/init.sql
+	init, err := os.ReadFile("testdata/init.sql")
+	require.NoError(t, err)
+	require.Equal(t, baseline, migrations[0].Version)
+	require.Equal(t, statements(string(init)), statements(migrations[0].Up))
+
+	// which is upgraded by every later migration
+	repo := &repository.MockMigrationRepository{Objects: legacyObjects}
+	done, err := Up(context.Background(), repo, migrations)
+	require.NoError(t, err)
+	require.Equal(t, migrations[1:], done)
+	require.Len(t, repo.Migrations, len(migrations))
+}
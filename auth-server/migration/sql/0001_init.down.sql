@@ -0,0 +1,5 @@
+-- drops every object created by 0001_init.up.sql, in reverse order of their dependencies.
+-- The schema itself is kept, as it holds the schema_migrations table
+DROP TABLE "session";
+DROP TABLE "user";
+DROP TABLE "event";
@@ -0,0 +1,28 @@
+-- the schema of the last release before migrations, as created by its database/init.sql, which schemas created
+-- by it are adopted as, see migration.Up. Objects are created in the schema of the search_path of the connection
+
+-- event table stores events that occur in the system.
+-- uuid column is tied to a unique object/row in the system.
+-- type column is used to identify the type of event that occurred
+-- body column is used to store the data associated with the event
+CREATE TABLE "event" (
+  "id"         serial PRIMARY KEY not NULL,
+  "uuid"       uuid NOT NULL,
+  "type"       text NOT NULL,
+  "body"       jsonb,
+  "created_at" timestamp without time zone DEFAULT (now() at time zone 'utc')
+);
+
+-- user table stores user data
+CREATE TABLE "user" (
+  "id"       uuid	PRIMARY KEY,
+  "username" varchar(50) UNIQUE NOT NULL,
+  "password" char(60) NOT NULL -- bcrypt hash
+);
+
+-- session table stores user session data
+CREATE TABLE "session" (
+  "id" char(44) NOT NULL,
+  "user_id"    uuid NOT NULL REFERENCES "user" ("id"),
+  "created_at" timestamp without time zone DEFAULT (now() at time zone 'utc')
+);
@@ -0,0 +1,3 @@
+DROP TABLE "oauth_refresh_token";
+DROP TABLE "oauth_consent";
+DROP TABLE "oauth_client";
@@ -0,0 +1,31 @@
+-- oauth_client table stores applications allowed to delegate login to this service
+-- secret column is NULL for public clients, which must use PKCE
+CREATE TABLE "oauth_client" (
+  "id"            varchar(64) PRIMARY KEY,
+  "secret"        char(60), -- bcrypt hash
+  "name"          varchar(100) NOT NULL,
+  "redirect_uris" text[] NOT NULL,
+  "scopes"        text[] NOT NULL DEFAULT '{}',
+  "created_at"    timestamp without time zone DEFAULT (now() at time zone 'utc')
+);
+
+-- oauth_consent table stores the scopes a user has granted to a client
+CREATE TABLE "oauth_consent" (
+  "user_id"    uuid NOT NULL REFERENCES "user" ("id") ON DELETE CASCADE,
+  "client_id"  varchar(64) NOT NULL REFERENCES "oauth_client" ("id"),
+  "scopes"     text[] NOT NULL,
+  "created_at" timestamp without time zone DEFAULT (now() at time zone 'utc'),
+  PRIMARY KEY ("user_id", "client_id")
+);
+
+-- oauth_refresh_token table stores issued refresh tokens
+-- token_hash column is the hex encoded SHA-256 hash of the token, the token itself is never stored
+CREATE TABLE "oauth_refresh_token" (
+  "token_hash" char(64) PRIMARY KEY,
+  "client_id"  varchar(64) NOT NULL REFERENCES "oauth_client" ("id"),
+  "user_id"    uuid NOT NULL REFERENCES "user" ("id") ON DELETE CASCADE,
+  "scopes"     text[] NOT NULL,
+  "issued_at"  timestamp without time zone NOT NULL,
+  "expires_at" timestamp without time zone NOT NULL,
+  "revoked_at" timestamp without time zone
+);
@@ -0,0 +1 @@
+DROP TABLE "external_identity";
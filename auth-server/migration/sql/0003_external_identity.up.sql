@@ -0,0 +1,11 @@
+-- external_identity table links users to accounts at upstream OpenID Connect providers
+-- subject column is the identifier of the account assigned by the provider
+CREATE TABLE "external_identity" (
+  "provider"   varchar(64) NOT NULL,
+  "subject"    varchar(255) NOT NULL,
+  "user_id"    uuid NOT NULL REFERENCES "user" ("id") ON DELETE CASCADE,
+  "email"      varchar(255),
+  "created_at" timestamp without time zone DEFAULT (now() at time zone 'utc'),
+  PRIMARY KEY ("provider", "subject")
+);
+CREATE INDEX ON "external_identity" ("user_id");
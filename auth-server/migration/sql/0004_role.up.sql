@@ -0,0 +1,37 @@
+-- role table stores named sets of permissions which can be assigned to users
+CREATE TABLE "role" (
+  "name"        varchar(50) PRIMARY KEY,
+  "description" text NOT NULL DEFAULT ''
+);
+
+-- permission table stores the permissions checked by the server
+CREATE TABLE "permission" (
+  "name"        varchar(100) PRIMARY KEY,
+  "description" text NOT NULL DEFAULT ''
+);
+
+-- role_permission table stores the permissions granted by each role
+CREATE TABLE "role_permission" (
+  "role"       varchar(50) NOT NULL REFERENCES "role" ("name") ON DELETE CASCADE,
+  "permission" varchar(100) NOT NULL REFERENCES "permission" ("name") ON DELETE CASCADE,
+  PRIMARY KEY ("role", "permission")
+);
+
+-- user_role table stores the roles assigned to each user
+CREATE TABLE "user_role" (
+  "user_id"    uuid NOT NULL REFERENCES "user" ("id") ON DELETE CASCADE,
+  "role"       varchar(50) NOT NULL REFERENCES "role" ("name") ON DELETE CASCADE,
+  "created_at" timestamp without time zone DEFAULT (now() at time zone 'utc'),
+  PRIMARY KEY ("user_id", "role")
+);
+
+INSERT INTO "permission" ("name", "description") VALUES
+  ('roles:read', 'View roles and the roles assigned to users'),
+  ('roles:write', 'Assign roles to and revoke roles from users');
+
+INSERT INTO "role" ("name", "description") VALUES
+  ('admin', 'Full access to user and role management');
+
+INSERT INTO "role_permission" ("role", "permission") VALUES
+  ('admin', 'roles:read'),
+  ('admin', 'roles:write');
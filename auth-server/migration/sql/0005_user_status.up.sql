@@ -0,0 +1,3 @@
+-- status column determines whether the user may sign in, only active users may
+ALTER TABLE "user" ADD COLUMN "status" varchar(20) NOT NULL DEFAULT 'active'
+  CHECK ("status" IN ('active', 'locked', 'disabled', 'pending_verification'));
@@ -0,0 +1,2 @@
+ALTER TABLE "session" DROP CONSTRAINT "session_user_id_fkey",
+  ADD CONSTRAINT "session_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "user" ("id");
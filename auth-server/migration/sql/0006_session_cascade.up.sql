@@ -0,0 +1,3 @@
+-- sessions are deleted along with their user, as by users deleting their own account
+ALTER TABLE "session" DROP CONSTRAINT "session_user_id_fkey",
+  ADD CONSTRAINT "session_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "user" ("id") ON DELETE CASCADE;
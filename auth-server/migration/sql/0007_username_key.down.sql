@@ -0,0 +1 @@
+ALTER TABLE "user" DROP COLUMN "username_key";
@@ -0,0 +1,10 @@
+-- username_key column is the case folded, confusable free form of the username (see model.UsernameKey),
+-- so that usernames which only differ in case or by lookalike characters cannot both be registered.
+-- SQL cannot compute it, so the keys of existing users are read from the temporary table username_keys,
+-- created by the server before running this script, see repository.MigrationSession
+ALTER TABLE "user" ADD COLUMN "username_key" text;
+UPDATE "user" SET "username_key" = "username_keys"."key"
+  FROM "username_keys"
+  WHERE "username_keys"."id" = "user"."id";
+ALTER TABLE "user" ALTER COLUMN "username_key" SET NOT NULL,
+  ADD UNIQUE ("username_key");
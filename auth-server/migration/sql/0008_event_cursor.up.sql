@@ -0,0 +1,7 @@
+-- event_cursor table stores the position of each consumer of the event table, such as the outbox relay
+-- event_id column is the ID of the last event processed by the consumer
+CREATE TABLE "event_cursor" (
+  "name"       varchar(64) PRIMARY KEY,
+  "event_id"   bigint NOT NULL,
+  "updated_at" timestamp without time zone DEFAULT (now() at time zone 'utc')
+);
@@ -0,0 +1,40 @@
+-- webhook_endpoint table stores the URLs events are delivered to
+-- event_types column selects the types of events delivered, every type when empty
+-- secret column is the key deliveries are signed with, using HMAC-SHA256
+CREATE TABLE "webhook_endpoint" (
+  "id"          uuid PRIMARY KEY,
+  "url"         text NOT NULL,
+  "secret"      varchar(64) NOT NULL,
+  "event_types" text[] NOT NULL DEFAULT '{}',
+  "description" varchar(200) NOT NULL DEFAULT '',
+  "active"      boolean NOT NULL DEFAULT true,
+  "created_at"  timestamp without time zone DEFAULT (now() at time zone 'utc')
+);
+
+-- webhook_delivery table stores the delivery of each event to each endpoint subscribed to it
+-- next_attempt_at column is when a pending delivery is attempted, pushed back while an attempt is in progress
+CREATE TABLE "webhook_delivery" (
+  "id"              bigserial PRIMARY KEY,
+  "endpoint_id"     uuid NOT NULL REFERENCES "webhook_endpoint" ("id") ON DELETE CASCADE,
+  "event_id"        bigint NOT NULL, -- deleted along with the event when it is archived
+  "event_type"      text NOT NULL,
+  "status"          varchar(20) NOT NULL DEFAULT 'pending'
+    CHECK ("status" IN ('pending', 'succeeded', 'dead')),
+  "attempts"        integer NOT NULL DEFAULT 0,
+  "next_attempt_at" timestamp without time zone NOT NULL DEFAULT (now() at time zone 'utc'),
+  "created_at"      timestamp without time zone DEFAULT (now() at time zone 'utc'),
+  "delivered_at"    timestamp without time zone,
+  UNIQUE ("endpoint_id", "event_id")
+);
+CREATE INDEX ON "webhook_delivery" ("next_attempt_at") WHERE "status" = 'pending';
+
+-- webhook_attempt table logs every attempt to deliver an event to an endpoint
+-- status_code column is the status of the response, NULL when none was received
+CREATE TABLE "webhook_attempt" (
+  "delivery_id"  bigint NOT NULL REFERENCES "webhook_delivery" ("id") ON DELETE CASCADE,
+  "attempted_at" timestamp without time zone NOT NULL,
+  "status_code"  integer,
+  "error"        text,
+  "duration_ms"  integer NOT NULL
+);
+CREATE INDEX ON "webhook_attempt" ("delivery_id");
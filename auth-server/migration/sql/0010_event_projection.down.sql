@@ -0,0 +1,2 @@
+DROP TABLE "login_history";
+DROP TABLE "user_activity";
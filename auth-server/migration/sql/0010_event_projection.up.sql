@@ -0,0 +1,19 @@
+-- user_activity table is a projection of the event table, recording the activity of each user
+-- active_sessions column counts sessions opened by a login and not yet ended by signing out
+CREATE TABLE "user_activity" (
+  "user_id"         uuid PRIMARY KEY,
+  "registered_at"   timestamp without time zone,
+  "last_login_at"   timestamp without time zone,
+  "login_count"     integer NOT NULL DEFAULT 0,
+  "active_sessions" integer NOT NULL DEFAULT 0
+);
+
+-- login_history table is a projection of the event table, recording every login
+-- provider column is the social login provider used, empty for a password
+CREATE TABLE "login_history" (
+  "event_id"   bigint PRIMARY KEY,
+  "user_id"    uuid NOT NULL,
+  "provider"   text NOT NULL DEFAULT '',
+  "created_at" timestamp without time zone NOT NULL
+);
+CREATE INDEX ON "login_history" ("user_id", "event_id" DESC);
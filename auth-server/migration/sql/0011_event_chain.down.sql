@@ -0,0 +1,22 @@
+-- restores the event table of 0001_init, copying the events without their request context and chain
+CREATE TEMPORARY TABLE "event_0011" ON COMMIT DROP AS
+  SELECT "id", "uuid", "type", "body", "created_at"
+  FROM "event";
+DROP TABLE "event_archive";
+DROP TABLE "event_checkpoint";
+DROP TABLE "event";
+DROP FUNCTION "create_event_partition"(timestamp);
+DROP FUNCTION "chain_event"();
+DROP FUNCTION "digest_text"(text);
+DROP TABLE "event_chain";
+
+CREATE TABLE "event" (
+  "id"         serial PRIMARY KEY not NULL,
+  "uuid"       uuid NOT NULL,
+  "type"       text NOT NULL,
+  "body"       jsonb,
+  "created_at" timestamp without time zone DEFAULT (now() at time zone 'utc')
+);
+INSERT INTO "event" ("id", "uuid", "type", "body", "created_at")
+  SELECT "id", "uuid", "type", "body", "created_at" FROM "event_0011" ORDER BY "id";
+SELECT setval(pg_get_serial_sequence('"event"', 'id'), max("id")) FROM "event";
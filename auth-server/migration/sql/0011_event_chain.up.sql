@@ -0,0 +1,137 @@
+-- events record the request which caused them, are linked into a hash chain and are partitioned by month.
+-- A table cannot be partitioned in place, so the event table of 0001_init is replaced: its events are copied,
+-- keeping their IDs and in their order, into the partitioned table, whose trigger chains them
+CREATE TEMPORARY TABLE "event_0001" ON COMMIT DROP AS
+  SELECT "id", "uuid", "type", "body", coalesce("created_at", now() at time zone 'utc') AS "created_at"
+  FROM "event";
+DROP TABLE "event";
+
+-- event table stores events that occur in the system, partitioned by month of created_at (see create_event_partition)
+-- uuid column is tied to a unique object/row in the system.
+-- type column is used to identify the type of event that occurred
+-- body column is used to store the data associated with the event
+-- ip, user_agent, request_id and session_id columns describe the request which caused the event,
+-- NULL for events not caused by a request. session_id is the SHA-256 hash of the session
+-- chain_seq, body_hash, context_digest, prev_hash and hash columns link the event into a hash chain, set by the
+-- chain_event trigger. context_digest is the digest of the request context, hashed into the chain in place of
+-- its columns, so erasing the context of a deleted account leaves the chain intact like erasing the body
+CREATE TABLE "event" (
+  "id"             bigserial NOT NULL,
+  "uuid"           uuid NOT NULL,
+  "type"           text NOT NULL,
+  "body"           jsonb,
+  "ip"             inet,
+  "user_agent"     text,
+  "request_id"     text,
+  "session_id"     varchar(64),
+  "created_at"     timestamp without time zone NOT NULL DEFAULT (now() at time zone 'utc'),
+  "chain_seq"      bigint NOT NULL,
+  "body_hash"      bytea NOT NULL,
+  "context_digest" bytea NOT NULL,
+  "prev_hash"      bytea NOT NULL,
+  "hash"           bytea NOT NULL,
+  PRIMARY KEY ("id", "created_at")
+) PARTITION BY RANGE ("created_at");
+CREATE INDEX ON "event" ("ip", "id" DESC) WHERE "ip" IS NOT NULL;
+CREATE INDEX ON "event" ("chain_seq");
+-- events are read by object, newest first: the events of a user, their export and erasure,
+-- and the logins compared by the login monitor
+CREATE INDEX "event_uuid_id_idx" ON "event" ("uuid", "id" DESC);
+
+-- event_chain table stores the head of the hash chain of the event table, a single row
+-- seq column is the chain_seq of the last event, and hash column its hash, 0 and 32 zero bytes before the first
+CREATE TABLE "event_chain" (
+  "id"   boolean PRIMARY KEY DEFAULT true CHECK ("id"),
+  "seq"  bigint NOT NULL,
+  "hash" bytea NOT NULL
+);
+INSERT INTO "event_chain" ("seq", "hash") VALUES (0, decode(repeat('00', 32), 'hex'));
+
+-- digest_text returns the SHA-256 hash of the UTF-8 encoding of value, hashing NULL as empty
+CREATE FUNCTION "digest_text"("value" text) RETURNS bytea AS $$
+  SELECT sha256(convert_to(coalesce("value", ''), 'UTF8'))
+$$ LANGUAGE sql IMMUTABLE;
+
+-- chain_event links each new event to the head of the hash chain, see audit.Hash. The body and context are
+-- hashed separately, so erasing them leaves the chain intact. The head is locked until the transaction ends,
+-- so events are chained one transaction at a time, in the order they commit
+CREATE FUNCTION "chain_event"() RETURNS trigger AS $$
+DECLARE
+  head "event_chain"%ROWTYPE;
+BEGIN
+  SELECT * INTO head FROM "event_chain" FOR UPDATE;
+  NEW.chain_seq := head.seq + 1;
+  NEW.prev_hash := head.hash;
+  NEW.body_hash := "digest_text"(NEW.body::text);
+  NEW.context_digest := "digest_text"(host(NEW.ip)) || "digest_text"(NEW.user_agent)
+    || "digest_text"(NEW.request_id) || "digest_text"(NEW.session_id);
+  NEW.hash := sha256(NEW.prev_hash || NEW.body_hash
+    || "digest_text"(NEW.chain_seq::text)
+    || "digest_text"(NEW.id::text)
+    || "digest_text"(NEW.uuid::text)
+    || "digest_text"(NEW.type)
+    || NEW.context_digest
+    || "digest_text"(to_char(NEW.created_at, 'YYYY-MM-DD"T"HH24:MI:SS.US')));
+  UPDATE "event_chain" SET "seq" = NEW.chain_seq, "hash" = NEW.hash;
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql SET search_path FROM CURRENT;
+
+-- create_event_partition creates the partition of the event table holding the events of the month of "month",
+-- named event_YYYY_MM, unless it exists. PostgreSQL 12 does not support BEFORE triggers on partitioned tables,
+-- so the chain_event trigger is created on every partition. Both functions keep the search_path they were
+-- created with, so they resolve the objects of their own schema whichever schema the caller uses
+CREATE FUNCTION "create_event_partition"("month" timestamp) RETURNS text AS $$
+DECLARE
+  "from" timestamp := date_trunc('month', "month");
+  "name" text := 'event_' || to_char(date_trunc('month', "month"), 'YYYY_MM');
+BEGIN
+  PERFORM pg_advisory_xact_lock(hashtext(current_schema() || '.create_event_partition'));
+  IF to_regclass(format('%I', "name")) IS NULL THEN
+    EXECUTE format('CREATE TABLE %I PARTITION OF "event" FOR VALUES FROM (%L) TO (%L)',
+      "name", "from", "from" + interval '1 month');
+    EXECUTE format('CREATE TRIGGER "chain_event" BEFORE INSERT ON %I FOR EACH ROW EXECUTE FUNCTION "chain_event"()',
+      "name");
+  END IF;
+  RETURN "name";
+END
+$$ LANGUAGE plpgsql SET search_path FROM CURRENT;
+
+-- event_default table is the partition of the event table holding events of months without a partition,
+-- which the archiver creates ahead of time
+CREATE TABLE "event_default" PARTITION OF "event" DEFAULT;
+CREATE TRIGGER "chain_event" BEFORE INSERT ON "event_default"
+  FOR EACH ROW EXECUTE FUNCTION "chain_event"();
+SELECT "create_event_partition"(now() at time zone 'utc');
+SELECT "create_event_partition"((now() at time zone 'utc') + interval '1 month');
+
+-- the copied events are partitioned by the month they were created in, so that they expire along with it
+SELECT "create_event_partition"("month")
+  FROM (SELECT DISTINCT date_trunc('month', "created_at") AS "month" FROM "event_0001") AS "months";
+INSERT INTO "event" ("id", "uuid", "type", "body", "created_at")
+  SELECT "id", "uuid", "type", "body", "created_at" FROM "event_0001" ORDER BY "id";
+SELECT setval(pg_get_serial_sequence('"event"', 'id'), max("id")) FROM "event";
+
+-- event_checkpoint table stores signed records of the head of the hash chain, see audit.Checkpointer
+-- signature column is a JWT signed with the assertion key, containing the seq and hex encoded hash
+CREATE TABLE "event_checkpoint" (
+  "chain_seq"  bigint PRIMARY KEY,
+  "hash"       bytea NOT NULL,
+  "signature"  text NOT NULL,
+  "created_at" timestamp without time zone DEFAULT (now() at time zone 'utc')
+);
+
+-- event_archive table stores the files expired events were archived to before being deleted, see archive.Archiver
+-- dropped column is whether the whole partition was dropped, ranges column the ranges of the hash chain deleted
+-- signature column is a JWT signed with the assertion key, containing the file, its hash and a hash of the ranges
+CREATE TABLE "event_archive" (
+  "id"         uuid PRIMARY KEY,
+  "partition"  text NOT NULL,
+  "file"       text NOT NULL,
+  "sha256"     char(64) NOT NULL,
+  "events"     integer NOT NULL,
+  "dropped"    boolean NOT NULL,
+  "ranges"     jsonb NOT NULL,
+  "signature"  text NOT NULL,
+  "created_at" timestamp without time zone DEFAULT (now() at time zone 'utc')
+);
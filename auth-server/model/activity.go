@@ -0,0 +1,54 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserActivity is the activity of a user, projected from their events. ActiveSessions counts
+// the sessions opened by a login and not yet ended by signing out, so sessions which expire
+// are still counted. Logins lists the most recent logins, newest first.
+type UserActivity struct {
+	UserID         uuid.UUID  `json:"user_id"`
+	RegisteredAt   *time.Time `json:"registered_at,omitempty"`
+	LastLoginAt    *time.Time `json:"last_login_at,omitempty"`
+	LoginCount     int        `json:"login_count"`
+	ActiveSessions int        `json:"active_sessions"`
+	Logins         []*Login   `json:"logins"`
+}
+
+// Login is a login of a user. Provider is the social login provider used, empty for a password.
+type Login struct {
+	EventID   int64     `json:"event_id"`
+	Provider  string    `json:"provider,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginRisk is a reason a login is suspicious.
+type LoginRisk string
+
+// Values for LoginRisk
+const (
+	NewDevice        LoginRisk = "new_device"        // the user agent has not signed in to the account before
+	NewNetwork       LoginRisk = "new_network"       // no login came from the same network before
+	ImpossibleTravel LoginRisk = "impossible_travel" // too far from the previous login to have travelled since
+)
+
+// Location is the approximate location of an IP address.
+type Location struct {
+	Country   string  `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	City      string  `json:"city,omitempty"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// LoginAlert describes a suspicious login, from the address IP by the user agent. Location is the
+// location of the address, and PreviousLocation that of the previous login, when known.
+type LoginAlert struct {
+	Risks            []LoginRisk `json:"risks"`
+	IP               string      `json:"ip"`
+	UserAgent        string      `json:"user_agent,omitempty"`
+	Location         *Location   `json:"location,omitempty"`
+	PreviousLocation *Location   `json:"previous_location,omitempty"`
+}
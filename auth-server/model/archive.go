@@ -0,0 +1,153 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventPartition is a partition of auth.event, holding the events created from From until To.
+// Both are zero for the default partition, holding the events of months without a partition.
+type EventPartition struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+// PartitionName returns the name of the partition holding the events of the month of t.
+func PartitionName(t time.Time) string {
+	return t.UTC().Format("event_2006_01")
+}
+
+// RetentionPolicy is the number of days events are kept before being archived, by type. Events of
+// types without a policy are kept for Default days. Events are kept forever for 0 days.
+type RetentionPolicy struct {
+	Default int
+	Types   map[EventType]int
+}
+
+// ParseRetentionPolicy returns the policy keeping events for def days, overridden by policies,
+// comma separated pairs of an event type and days, e.g. "logged_in:90,logged_out:90".
+//
+// account_deleted events are kept as long as the events of every other type, as they account for
+// the erased events of the deleted user when the hash chain is verified. A shorter policy for them
+// is an error.
+func ParseRetentionPolicy(def int, policies string) (*RetentionPolicy, error) {
+	if def < 0 {
+		return nil, fmt.Errorf("invalid retention of %d days", def)
+	}
+	policy := &RetentionPolicy{Default: def, Types: make(map[EventType]int)}
+	for _, pair := range strings.Split(policies, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, ":")
+		eventType := EventType(strings.TrimSpace(name))
+		if !eventType.Valid() {
+			return nil, fmt.Errorf("invalid retention policy %q: unknown event type", pair)
+		}
+		days, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("invalid retention policy %q: days must be a non-negative number", pair)
+		}
+		policy.Types[eventType] = days
+	}
+
+	longest := def
+	for eventType, days := range policy.Types {
+		if eventType != AccountDeleted && longest != 0 && (days == 0 || days > longest) {
+			longest = days
+		}
+	}
+	if days, ok := policy.Types[AccountDeleted]; ok && days != 0 && (longest == 0 || days < longest) {
+		return nil, fmt.Errorf("invalid retention policy %s:%d: account_deleted events must be kept as long as events of every other type",
+			AccountDeleted, days)
+	}
+	if _, ok := policy.Types[AccountDeleted]; !ok && longest != def {
+		policy.Types[AccountDeleted] = longest
+	}
+	return policy, nil
+}
+
+// Days returns the number of days events of eventType are kept, 0 when they are kept forever.
+func (p *RetentionPolicy) Days(eventType EventType) int {
+	if days, ok := p.Types[eventType]; ok {
+		return days
+	}
+	return p.Default
+}
+
+// Shortest returns the fewest days events of any type are kept, 0 when events of every type are kept forever.
+func (p *RetentionPolicy) Shortest() int {
+	days := p.Default
+	for _, d := range p.Types {
+		if d > 0 && (days == 0 || d < days) {
+			days = d
+		}
+	}
+	return days
+}
+
+// ArchivedEvent is an event as written to an archive, along with its entry in the hash chain.
+// The hashes are hex encoded.
+type ArchivedEvent struct {
+	Event
+	ChainSeq      int64  `json:"chain_seq"`
+	BodyHash      string `json:"body_hash"`
+	ContextDigest string `json:"context_digest"`
+	PrevHash      string `json:"prev_hash"`
+	Hash          string `json:"hash"`
+}
+
+// PurgedRange is a range of entries of the hash chain deleted by an archive, from First to Last.
+// PrevHash is the hash the first entry was linked to, and Hash the hash of the last.
+type PurgedRange struct {
+	First    int64  `json:"first"`
+	Last     int64  `json:"last"`
+	PrevHash []byte `json:"prev_hash"`
+	Hash     []byte `json:"hash"`
+}
+
+// Archive is a file the expired events of a partition were written to, as gzip compressed NDJSON
+// of ArchivedEvent, before being deleted. Dropped is whether the whole partition was dropped.
+// SHA256 is the hex encoded hash of the file. Signature is a JWT containing ArchiveClaims.
+type Archive struct {
+	ID        uuid.UUID      `json:"id"`
+	Partition string         `json:"partition"`
+	File      string         `json:"file"`
+	SHA256    string         `json:"sha256"`
+	Events    int            `json:"events"`
+	Dropped   bool           `json:"dropped"`
+	Ranges    []*PurgedRange `json:"ranges"`
+	Signature string         `json:"signature"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// ArchiveClaims are the claims of the signature of an Archive. Ranges is the hex encoded
+// SHA-256 hash of the JSON encoding of the ranges of the archive.
+type ArchiveClaims struct {
+	Issuer   string    `json:"iss"`
+	IssuedAt int64     `json:"iat"`
+	ID       uuid.UUID `json:"id"`
+	File     string    `json:"file"`
+	SHA256   string    `json:"sha256"`
+	Events   int       `json:"events"`
+	Ranges   string    `json:"ranges"`
+}
+
+// RangesHash returns the hex encoded SHA-256 hash of the JSON encoding of the ranges of the archive.
+func (a *Archive) RangesHash() (string, error) {
+	ranges, err := json.Marshal(a.Ranges)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(ranges)
+	return hex.EncodeToString(digest[:]), nil
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetentionPolicy(t *testing.T) {
+	policy, err := ParseRetentionPolicy(365, " logged_in:90, logged_out:0,")
+	require.NoError(t, err)
+	require.Equal(t, 90, policy.Days(LoggedIn))
+	require.Equal(t, 0, policy.Days(LoggedOut))
+	require.Equal(t, 365, policy.Days(AccountCreated))
+	require.Equal(t, 90, policy.Shortest())
+	// account_deleted events are kept as long as any
+	require.Equal(t, 0, policy.Days(AccountDeleted))
+
+	policy, err = ParseRetentionPolicy(30, "logged_in:90")
+	require.NoError(t, err)
+	require.Equal(t, 90, policy.Days(AccountDeleted))
+	_, err = ParseRetentionPolicy(30, "logged_in:90,account_deleted:60")
+	require.Error(t, err)
+	_, err = ParseRetentionPolicy(0, "account_deleted:3650")
+	require.Error(t, err)
+
+	policy, err = ParseRetentionPolicy(0, "logged_out:0")
+	require.NoError(t, err)
+	require.Equal(t, 0, policy.Shortest())
+
+	for _, policies := range []string{"unknown:30", "logged_in", "logged_in:-1", "logged_in:month"} {
+		_, err = ParseRetentionPolicy(0, policies)
+		require.Error(t, err, policies)
+	}
+	_, err = ParseRetentionPolicy(-1, "")
+	require.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package model
+
+import "time"
+
+// ChainEntry is an event as linked into the hash chain of auth.event. The string fields are in
+// the canonical text form they are hashed in, empty when NULL. Body is nil once erased, and the
+// fields of the request context, IP to SessionID, are empty once erased.
+type ChainEntry struct {
+	Seq           int64
+	EventID       int64
+	UUID          string
+	Type          string
+	IP            string
+	UserAgent     string
+	RequestID     string
+	SessionID     string
+	CreatedAt     string
+	Body          []byte
+	BodyHash      []byte
+	ContextDigest []byte
+	PrevHash      []byte
+	Hash          []byte
+}
+
+// ChainHead is the position and hash of the last event in the hash chain.
+type ChainHead struct {
+	Seq  int64
+	Hash []byte
+}
+
+// Checkpoint is a signed record of the head of the hash chain. Signature is a JWT containing
+// the claims of the checkpoint, see CheckpointClaims.
+type Checkpoint struct {
+	Seq       int64     `json:"seq"`
+	Hash      []byte    `json:"hash"`
+	Signature string    `json:"signature"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CheckpointClaims are the claims of the signature of a Checkpoint. Hash is hex encoded.
+type CheckpointClaims struct {
+	Issuer   string `json:"iss"`
+	IssuedAt int64  `json:"iat"`
+	Seq      int64  `json:"seq"`
+	Hash     string `json:"hash"`
+}
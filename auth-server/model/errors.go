@@ -1,6 +1,9 @@
 package model
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // Errors is a custom type created to enforce a specific set of values
 type Errors []error
@@ -8,7 +11,73 @@ type Errors []error
 func (errs Errors) Error() string {
 	var errStr string
 	for _, err := range errs {
+		if err == nil {
+			continue
+		}
 		errStr += fmt.Sprintf("%s\n", err.Error())
 	}
 	return errStr
 }
+
+// Unwrap exposes the wrapped errors to errors.Is and errors.As.
+func (errs Errors) Unwrap() []error {
+	return errs
+}
+
+// NotFoundError is returned when a requested resource does not exist.
+type NotFoundError struct {
+	Resource string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s not found", e.Resource)
+}
+
+// ConflictError is returned when a resource cannot be created or modified
+// because it collides with existing state, e.g. a duplicate username.
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// ValidationError describes why a single field of a request is invalid.
+// Multiple validation errors are returned together as Errors.
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// UnauthorizedError is returned when a request lacks valid credentials or a valid session.
+type UnauthorizedError struct {
+	Message string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return e.Message
+}
+
+// ForbiddenError is returned when an authenticated user lacks the permission required by a request.
+type ForbiddenError struct {
+	Message string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Message
+}
+
+// RateLimitedError is returned when a caller has exceeded the number of allowed requests.
+// RetryAfter, when non-zero, indicates how long the caller should wait before retrying.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return "too many requests"
+}
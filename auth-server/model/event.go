@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,17 +13,68 @@ type EventType string
 
 // Values for EventType
 const (
-	LoggedIn       EventType = "logged_in"
-	LoggedOut      EventType = "logged_out"
-	LoggedOutAll   EventType = "logged_out_all"
-	AccountCreated EventType = "account_created"
+	LoggedIn        EventType = "logged_in"
+	LoggedOut       EventType = "logged_out"
+	LoggedOutAll    EventType = "logged_out_all"
+	AccountCreated  EventType = "account_created"
+	ConsentGranted  EventType = "consent_granted"
+	IdentityLinked  EventType = "identity_linked"
+	RoleAssigned    EventType = "role_assigned"
+	RoleRevoked     EventType = "role_revoked"
+	AccountLocked   EventType = "account_locked"
+	AccountUnlocked EventType = "account_unlocked"
+	AccountDeleted  EventType = "account_deleted"
+	AccountDisabled EventType = "account_disabled"
+	AccountEnabled  EventType = "account_enabled"
+	AccountVerified EventType = "account_verified"
+	UsernameChanged EventType = "username_changed"
+	WebhookCreated  EventType = "webhook_created"
+	WebhookUpdated  EventType = "webhook_updated"
+	WebhookDeleted  EventType = "webhook_deleted"
+	SuspiciousLogin EventType = "suspicious_login"
+	EventPurged     EventType = "event_purged"
 )
 
+// EventTypes are the types of events recorded, which webhook endpoints may subscribe to.
+var EventTypes = []EventType{
+	LoggedIn, LoggedOut, LoggedOutAll, AccountCreated, ConsentGranted, IdentityLinked,
+	RoleAssigned, RoleRevoked, AccountLocked, AccountUnlocked, AccountDeleted,
+	AccountDisabled, AccountEnabled, AccountVerified, UsernameChanged, WebhookCreated, WebhookUpdated,
+	WebhookDeleted, SuspiciousLogin, EventPurged,
+}
+
+// Valid reports whether t is one of EventTypes.
+func (t EventType) Valid() bool {
+	for _, eventType := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
 // Event represents an immutable event that has occurred in the system.
+// Context describes the request which caused the event, when there was one.
 type Event struct {
-	ID        int64     `json:"id"`
-	UUID      uuid.UUID `json:"uuid"`
-	Type      EventType `json:"type"`
-	Body      []byte    `json:"body"` // TODO change to map[string]interface{}?
-	CreatedAt time.Time `json:"created_at"`
+	ID        int64           `json:"id"`
+	UUID      uuid.UUID       `json:"uuid"`
+	Type      EventType       `json:"type"`
+	Body      json.RawMessage `json:"body"`
+	Context   *EventContext   `json:"context,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// EventFilter selects events, newest first. Before, when non-zero, is the ID of the last
+// event of the previous page, so only older events are returned. The events of every object
+// are selected when UUID is uuid.Nil. When set, Types restricts the events to those of the
+// given types, IP to those caused by requests from the address or CIDR range, and Since and
+// Until to those created at or after Since and before Until.
+type EventFilter struct {
+	UUID   uuid.UUID
+	Types  []EventType
+	IP     string
+	Since  time.Time
+	Until  time.Time
+	Before int64
+	Limit  int
 }
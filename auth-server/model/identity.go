@@ -0,0 +1,48 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Identity is the subset of user data needed to identify the caller of a request.
+// It is cached alongside the user's sessions, allowing a session to be verified
+// without querying the database.
+type Identity struct {
+	UserID      uuid.UUID  `json:"user_id"`
+	Username    string     `json:"username"`
+	Status      UserStatus `json:"status,omitempty"`
+	Roles       []string   `json:"roles,omitempty"`
+	Permissions []string   `json:"permissions,omitempty"`
+}
+
+// HasPermission reports whether any of the user's roles grants permission.
+func (i *Identity) HasPermission(permission string) bool {
+	for _, p := range i.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether role is assigned to the user.
+func (i *Identity) HasRole(role string) bool {
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ExternalIdentity links a user to their account at an upstream identity provider.
+// Subject is the identifier of the account assigned by the provider.
+type ExternalIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
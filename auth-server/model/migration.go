@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// Migration is a versioned change of the database schema. Up applies the change and Down reverts it.
+// Checksum is the hex encoded SHA-256 hash of Up, recorded when the migration is applied, so that
+// editing a migration after it has been applied is detected. AppliedAt is zero until it is applied.
+type Migration struct {
+	Version   int
+	Name      string
+	Up        string
+	Down      string
+	Checksum  string
+	AppliedAt time.Time
+}
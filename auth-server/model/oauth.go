@@ -0,0 +1,190 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAuthClient is an application registered to delegate login to this service.
+// Public clients, such as single page or native applications, have no secret.
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	Secret       string    `json:"-"` // bcrypt hash
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Public reports whether the client is unable to keep a secret.
+func (c *OAuthClient) Public() bool {
+	return c.Secret == ""
+}
+
+// AllowsRedirect reports whether uri exactly matches a registered redirect URI.
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsScopes reports whether the client may request every one of scopes.
+func (c *OAuthClient) AllowsScopes(scopes []string) bool {
+	return ContainsScopes(c.Scopes, scopes)
+}
+
+// AuthorizationRequest is a request made by a client to the authorization endpoint.
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scopes              []string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	Prompt              []string
+	MaxAge              *int // seconds, nil when not requested
+}
+
+// Prompted reports whether the request asked for the given prompt, e.g. "login" or "none".
+func (r *AuthorizationRequest) Prompted(prompt string) bool {
+	return ContainsScopes(r.Prompt, []string{prompt})
+}
+
+// OpenID reports whether the request is an OpenID Connect authentication request.
+func (r *AuthorizationRequest) OpenID() bool {
+	return ContainsScopes(r.Scopes, []string{ScopeOpenID})
+}
+
+// AuthorizationCode is the grant issued to a client once the user has authorized it,
+// exchanged by the client for tokens at the token endpoint.
+type AuthorizationCode struct {
+	ClientID      string    `json:"client_id"`
+	UserID        uuid.UUID `json:"user_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scopes        []string  `json:"scopes"`
+	CodeChallenge string    `json:"code_challenge"`
+	Nonce         string    `json:"nonce,omitempty"`
+	AuthTime      time.Time `json:"auth_time"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// LoginRequest records that the authorization endpoint sent the user to log in again.
+// The request is satisfied by a login with a new session, at or after IssuedAt.
+type LoginRequest struct {
+	Session  string    `json:"session,omitempty"` // hash of the session in use when the login was requested
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+// AccessToken is the state of an issued access token.
+type AccessToken struct {
+	ClientID  string    `json:"client_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Scopes    []string  `json:"scopes"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RefreshToken is the state of an issued refresh token.
+// Only a hash of the token itself is stored.
+type RefreshToken struct {
+	Hash      string
+	ClientID  string
+	UserID    uuid.UUID
+	Scopes    []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// Active reports whether the refresh token may still be used.
+func (t *RefreshToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+// TokenResponse is the successful response of the token endpoint, see RFC 6749 section 5.1.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// UserInfo is the response of the OpenID Connect userinfo endpoint.
+// Claims other than sub are only included when allowed by the scope of the access token.
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// Introspection is the response of the introspection endpoint, see RFC 7662 section 2.2.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+}
+
+// OAuthError is an error response defined by RFC 6749, returned by the OAuth endpoints
+// instead of a problem document so standard client libraries can interpret it.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// Values for OAuthError.Code
+const (
+	InvalidRequest          = "invalid_request"
+	InvalidClient           = "invalid_client"
+	InvalidGrant            = "invalid_grant"
+	InvalidScope            = "invalid_scope"
+	UnauthorizedClient      = "unauthorized_client"
+	UnsupportedGrantType    = "unsupported_grant_type"
+	UnsupportedResponseType = "unsupported_response_type"
+	AccessDenied            = "access_denied"
+	LoginRequired           = "login_required"
+	ConsentRequired         = "consent_required"
+	InvalidToken            = "invalid_token"
+	InsufficientScope       = "insufficient_scope"
+)
+
+// Scopes with a meaning defined by OpenID Connect.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+)
+
+// ContainsScopes reports whether every one of scopes is in granted.
+func ContainsScopes(granted []string, scopes []string) bool {
+	for _, scope := range scopes {
+		found := false
+		for _, g := range granted {
+			if g == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
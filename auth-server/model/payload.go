@@ -0,0 +1,307 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/google/uuid"
+)
+
+// EventContext describes the request which caused an event.
+type EventContext struct {
+	SessionID string `json:"session_id,omitempty"` // SHA-256 hash of the session, as listed by GET /sessions
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type eventContextKey struct{}
+
+// WithEventContext returns a copy of ctx carrying ec, which is recorded by NewEvent.
+func WithEventContext(ctx context.Context, ec *EventContext) context.Context {
+	return context.WithValue(ctx, eventContextKey{}, ec)
+}
+
+// EventContextFrom returns the EventContext carried by ctx, or nil when there is none.
+func EventContextFrom(ctx context.Context) *EventContext {
+	ec, _ := ctx.Value(eventContextKey{}).(*EventContext)
+	return ec
+}
+
+// WithSession returns a copy of ctx whose EventContext records the session sessionID,
+// which is hashed so that events never contain a usable session.
+func WithSession(ctx context.Context, sessionID string) context.Context {
+	ec := EventContext{}
+	if current := EventContextFrom(ctx); current != nil {
+		ec = *current
+	}
+	sum := sha256.Sum256([]byte(sessionID))
+	ec.SessionID = hex.EncodeToString(sum[:])
+	return WithEventContext(ctx, &ec)
+}
+
+// EventMeta is embedded in every event payload. Version is the version of the schema of the
+// payload, which is incremented whenever its fields change incompatibly. The request context is
+// not part of the payload, but stored alongside it, see Event.Context.
+type EventMeta struct {
+	Version int `json:"version"`
+}
+
+// Meta returns the metadata of the payload.
+func (m *EventMeta) Meta() *EventMeta {
+	return m
+}
+
+// EventPayload is the typed body of an event, see EventSchemas for the payload of each EventType.
+type EventPayload interface {
+	Meta() *EventMeta
+}
+
+// UserPayload is the payload of account_created and logged_out events.
+type UserPayload struct {
+	EventMeta
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+}
+
+// LoginPayload is the payload of logged_in events. Provider is the social login provider
+// used, empty for a password.
+type LoginPayload struct {
+	EventMeta
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+	Provider string    `json:"provider,omitempty"`
+}
+
+// LogoutAllPayload is the payload of logged_out_all events. SessionIDs are the hashes of the
+// sessions signed out, and ActorID is set when an admin signed the user out.
+type LogoutAllPayload struct {
+	EventMeta
+	ID         uuid.UUID  `json:"id"`
+	Username   string     `json:"username"`
+	SessionIDs []string   `json:"session_ids,omitempty"`
+	ActorID    *uuid.UUID `json:"actor_id,omitempty"`
+}
+
+// ConsentPayload is the payload of consent_granted events.
+type ConsentPayload struct {
+	EventMeta
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes"`
+}
+
+// IdentityPayload is the payload of identity_linked events.
+type IdentityPayload struct {
+	EventMeta
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	UserID   uuid.UUID `json:"user_id"`
+	Email    string    `json:"email,omitempty"`
+}
+
+// RolePayload is the payload of role_assigned and role_revoked events.
+type RolePayload struct {
+	EventMeta
+	Role    string    `json:"role"`
+	ActorID uuid.UUID `json:"actor_id"`
+}
+
+// AccountActionPayload is the payload of the account_locked, account_unlocked, account_disabled,
+// account_enabled, account_verified and account_deleted events. The username is omitted when a user deletes their
+// own account, as it is personal data.
+type AccountActionPayload struct {
+	EventMeta
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username,omitempty"`
+	ActorID  uuid.UUID `json:"actor_id"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// UsernameChangedPayload is the payload of username_changed events.
+type UsernameChangedPayload struct {
+	EventMeta
+	ID          uuid.UUID `json:"id"`
+	OldUsername string    `json:"old_username"`
+	NewUsername string    `json:"new_username"`
+}
+
+// WebhookPayload is the payload of webhook_created, webhook_updated and webhook_deleted events.
+// The secret of the endpoint is never recorded.
+type WebhookPayload struct {
+	EventMeta
+	ID         uuid.UUID   `json:"id"`
+	URL        string      `json:"url"`
+	EventTypes []EventType `json:"event_types"`
+	Active     bool        `json:"active"`
+	ActorID    uuid.UUID   `json:"actor_id"`
+}
+
+// SuspiciousLoginPayload is the payload of suspicious_login events, recorded before the
+// logged_in event of the login.
+type SuspiciousLoginPayload struct {
+	EventMeta
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+	LoginAlert
+}
+
+// EventPurgedPayload is the payload of event_purged events, recorded when expired events are
+// archived to File and deleted, see Archive. FirstSeq and LastSeq are the first and last entries
+// of the hash chain deleted.
+type EventPurgedPayload struct {
+	EventMeta
+	ID        uuid.UUID `json:"id"`
+	Partition string    `json:"partition"`
+	File      string    `json:"file"`
+	SHA256    string    `json:"sha256"`
+	Events    int       `json:"events"`
+	Dropped   bool      `json:"dropped"`
+	FirstSeq  int64     `json:"first_seq"`
+	LastSeq   int64     `json:"last_seq"`
+}
+
+// Upcaster converts the body of an event from one version of its schema to the next.
+type Upcaster func(event *Event, body map[string]interface{}) error
+
+// EventSchema describes the payload of an EventType. Upcasters[v] converts a body of version v
+// to version v+1, for every version before the current one.
+type EventSchema struct {
+	Version   int
+	Payload   func() EventPayload
+	Upcasters map[int]Upcaster
+}
+
+// EventSchemas is the registry of the payload of every EventType. Bodies written before payloads
+// were versioned carry no version, and are version 1.
+var EventSchemas = map[EventType]*EventSchema{
+	AccountCreated:  {Version: 2, Payload: func() EventPayload { return &UserPayload{} }, Upcasters: userUpcasters},
+	LoggedIn:        {Version: 2, Payload: func() EventPayload { return &LoginPayload{} }, Upcasters: userUpcasters},
+	LoggedOut:       {Version: 2, Payload: func() EventPayload { return &UserPayload{} }, Upcasters: userUpcasters},
+	LoggedOutAll:    {Version: 2, Payload: func() EventPayload { return &LogoutAllPayload{} }, Upcasters: userUpcasters},
+	ConsentGranted:  {Version: 1, Payload: func() EventPayload { return &ConsentPayload{} }},
+	IdentityLinked:  {Version: 2, Payload: func() EventPayload { return &IdentityPayload{} }, Upcasters: identityUpcasters},
+	RoleAssigned:    {Version: 1, Payload: func() EventPayload { return &RolePayload{} }},
+	RoleRevoked:     {Version: 1, Payload: func() EventPayload { return &RolePayload{} }},
+	AccountLocked:   {Version: 1, Payload: func() EventPayload { return &AccountActionPayload{} }},
+	AccountUnlocked: {Version: 1, Payload: func() EventPayload { return &AccountActionPayload{} }},
+	AccountDeleted:  {Version: 1, Payload: func() EventPayload { return &AccountActionPayload{} }},
+	AccountDisabled: {Version: 1, Payload: func() EventPayload { return &AccountActionPayload{} }},
+	AccountEnabled:  {Version: 1, Payload: func() EventPayload { return &AccountActionPayload{} }},
+	AccountVerified: {Version: 1, Payload: func() EventPayload { return &AccountActionPayload{} }},
+	UsernameChanged: {Version: 1, Payload: func() EventPayload { return &UsernameChangedPayload{} }},
+	WebhookCreated:  {Version: 1, Payload: func() EventPayload { return &WebhookPayload{} }},
+	WebhookUpdated:  {Version: 1, Payload: func() EventPayload { return &WebhookPayload{} }},
+	WebhookDeleted:  {Version: 1, Payload: func() EventPayload { return &WebhookPayload{} }},
+	SuspiciousLogin: {Version: 1, Payload: func() EventPayload { return &SuspiciousLoginPayload{} }},
+	EventPurged:     {Version: 1, Payload: func() EventPayload { return &EventPurgedPayload{} }},
+}
+
+// userUpcasters upcast the bodies of events which recorded the whole user, including an empty password.
+var userUpcasters = map[int]Upcaster{
+	1: func(_ *Event, body map[string]interface{}) error {
+		delete(body, "password")
+		delete(body, "status")
+		delete(body, "roles")
+		return nil
+	},
+}
+
+// identityUpcasters upcast the bodies of identity_linked events, which recorded when the identity was stored.
+var identityUpcasters = map[int]Upcaster{
+	1: func(_ *Event, body map[string]interface{}) error {
+		delete(body, "created_at")
+		return nil
+	},
+}
+
+// NewEvent returns an event of eventType about the object uuid, whose body is payload, which
+// must be the payload registered for eventType in EventSchemas. The version of the payload is
+// set, and the context of the event to the EventContext carried by ctx.
+func NewEvent(ctx context.Context, uuid uuid.UUID, eventType EventType, payload EventPayload) (*Event, error) {
+	schema, ok := EventSchemas[eventType]
+	if !ok {
+		return nil, fmt.Errorf("no schema registered for event type %s", eventType)
+	}
+	if reflect.TypeOf(payload) != reflect.TypeOf(schema.Payload()) {
+		return nil, fmt.Errorf("event type %s requires payload %T, not %T", eventType, schema.Payload(), payload)
+	}
+	payload.Meta().Version = schema.Version
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{UUID: uuid, Type: eventType, Body: body, Context: EventContextFrom(ctx)}, nil
+}
+
+// Payload decodes the body of the event into the payload registered for its type, upcast to
+// the current version. Returns nil when the body has been erased.
+func (e *Event) Payload() (EventPayload, error) {
+	schema, ok := EventSchemas[e.Type]
+	if !ok {
+		return nil, fmt.Errorf("no schema registered for event type %s", e.Type)
+	}
+	body, err := e.upcast(schema)
+	if body == nil || err != nil {
+		return nil, err
+	}
+	payload := schema.Payload()
+	if err := json.Unmarshal(body, payload); err != nil {
+		return nil, fmt.Errorf("failed to decode event %d: %w", e.ID, err)
+	}
+	return payload, nil
+}
+
+// Upcast converts the body of the event to the current version of its schema, if it has one.
+// Events of types without a schema, and erased bodies, are left unchanged.
+func (e *Event) Upcast() error {
+	schema, ok := EventSchemas[e.Type]
+	if !ok {
+		return nil
+	}
+	body, err := e.upcast(schema)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		e.Body = body
+	}
+	return nil
+}
+
+// upcast returns the body of the event converted to the current version of schema,
+// or nil when the body has been erased.
+func (e *Event) upcast(schema *EventSchema) (json.RawMessage, error) {
+	if len(e.Body) == 0 || string(e.Body) == "null" {
+		return nil, nil
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(e.Body, &body); err != nil {
+		return nil, fmt.Errorf("failed to decode event %d: %w", e.ID, err)
+	}
+	version := 1
+	v, versioned := body["version"].(float64)
+	if versioned {
+		version = int(v)
+	}
+	if versioned && version == schema.Version {
+		return e.Body, nil
+	}
+	if version > schema.Version {
+		return nil, fmt.Errorf("event %d has version %d of %s, newer than the supported %d", e.ID, version, e.Type, schema.Version)
+	}
+	for ; version < schema.Version; version++ {
+		upcaster, ok := schema.Upcasters[version]
+		if !ok {
+			return nil, fmt.Errorf("no upcaster of %s from version %d", e.Type, version)
+		}
+		if err := upcaster(e, body); err != nil {
+			return nil, fmt.Errorf("failed to upcast event %d from version %d: %w", e.ID, version, err)
+		}
+	}
+	body["version"] = schema.Version
+	return json.Marshal(body)
+}
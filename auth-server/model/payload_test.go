@@ -0,0 +1,75 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEvent(t *testing.T) {
+	id := uuid.New()
+	ctx := WithSession(WithEventContext(context.Background(), &EventContext{IP: "192.0.2.1", RequestID: "req-1"}), "secret")
+
+	event, err := NewEvent(ctx, id, LoggedOut, &UserPayload{ID: id, Username: "alice"})
+	require.NoError(t, err)
+	require.Equal(t, id, event.UUID)
+	require.Equal(t, LoggedOut, event.Type)
+	require.NotContains(t, string(event.Body), "secret")
+	// the context is kept out of the body, so erasing it from the event erases it entirely
+	require.NotContains(t, string(event.Body), "192.0.2.1")
+	require.Equal(t, "192.0.2.1", event.Context.IP)
+	require.Equal(t, "req-1", event.Context.RequestID)
+	require.Len(t, event.Context.SessionID, 64)
+
+	payload, err := event.Payload()
+	require.NoError(t, err)
+	user := payload.(*UserPayload)
+	require.Equal(t, EventSchemas[LoggedOut].Version, user.Version)
+	require.Equal(t, "alice", user.Username)
+
+	// the payload must be the one registered for the type
+	_, err = NewEvent(ctx, id, LoggedIn, &UserPayload{ID: id})
+	require.Error(t, err)
+	_, err = NewEvent(ctx, id, EventType("unknown"), &UserPayload{ID: id})
+	require.Error(t, err)
+}
+
+func TestEventUpcast(t *testing.T) {
+	id := uuid.New()
+
+	// bodies written before payloads were versioned are version 1
+	event := &Event{ID: 1, UUID: id, Type: LoggedIn,
+		Body: json.RawMessage(`{"id":"` + id.String() + `","username":"alice","password":"","status":"active","roles":null}`)}
+	require.NoError(t, event.Upcast())
+	require.JSONEq(t, `{"version":2,"id":"`+id.String()+`","username":"alice"}`, string(event.Body))
+	payload, err := event.Payload()
+	require.NoError(t, err)
+	require.Equal(t, "alice", payload.(*LoginPayload).Username)
+
+	event = &Event{ID: 2, UUID: id, Type: IdentityLinked,
+		Body: json.RawMessage(`{"provider":"google","subject":"123","user_id":"` + id.String() + `","created_at":"2023-01-01T00:00:00Z"}`)}
+	require.NoError(t, event.Upcast())
+	require.NotContains(t, string(event.Body), "created_at")
+
+	// unchanged schemas only gain their version
+	event = &Event{ID: 3, UUID: id, Type: RoleAssigned, Body: json.RawMessage(`{"role":"admin","actor_id":"` + id.String() + `"}`)}
+	payload, err = event.Payload()
+	require.NoError(t, err)
+	require.Equal(t, "admin", payload.(*RolePayload).Role)
+	require.Equal(t, 1, payload.(*RolePayload).Version)
+
+	// erased bodies are left alone
+	event = &Event{ID: 4, UUID: id, Type: LoggedIn}
+	require.NoError(t, event.Upcast())
+	require.Nil(t, event.Body)
+	payload, err = event.Payload()
+	require.NoError(t, err)
+	require.Nil(t, payload)
+
+	// versions newer than supported are rejected
+	event = &Event{ID: 5, UUID: id, Type: LoggedIn, Body: json.RawMessage(`{"version":3}`)}
+	require.Error(t, event.Upcast())
+}
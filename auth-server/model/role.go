@@ -0,0 +1,17 @@
+package model
+
+// Role is a named set of permissions which can be assigned to users.
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// RoleAdmin is the role required by the admin API.
+const RoleAdmin = "admin"
+
+// Permissions checked by the server, see migration/sql for the roles granting them.
+const (
+	PermissionRolesRead  = "roles:read"
+	PermissionRolesWrite = "roles:write"
+)
@@ -1,12 +1,43 @@
 package model
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserStatus is the state of a user account, determining whether the user may sign in.
+type UserStatus string
+
+// Values for UserStatus
+const (
+	UserActive              UserStatus = "active"
+	UserLocked              UserStatus = "locked"   // temporarily, e.g. while a compromise is investigated
+	UserDisabled            UserStatus = "disabled" // indefinitely, until re-enabled by an admin
+	UserPendingVerification UserStatus = "pending_verification"
+)
+
+// Err returns a ForbiddenError describing why a user with the status may not sign in,
+// or nil if they may. The empty status, of identities cached before statuses were
+// introduced, is treated as active.
+func (s UserStatus) Err() error {
+	switch s {
+	case UserActive, "":
+		return nil
+	case UserPendingVerification:
+		return &ForbiddenError{Message: "account is pending verification"}
+	default:
+		return &ForbiddenError{Message: "account is " + string(s)}
+	}
+}
 
 // User represents a user account.
 type User struct {
-	ID       uuid.UUID `json:"id"`
-	Username string    `json:"username"`
-	Password string    `json:"password"`
+	ID       uuid.UUID  `json:"id"`
+	Username string     `json:"username"`
+	Password string     `json:"password"`
+	Status   UserStatus `json:"status,omitempty"`
+	Roles    []string   `json:"roles,omitempty"`
 }
 
 // OmitPassword creates a copy of the user with the password field set to ""
@@ -15,5 +46,29 @@ func OmitPassword(user *User) *User {
 		ID:       user.ID,
 		Username: user.Username,
 		Password: "",
+		Status:   user.Status,
+		Roles:    user.Roles,
 	}
 }
+
+// UserUpdate is a change to the profile of a user. Fields left nil are not changed.
+type UserUpdate struct {
+	Username *string `json:"username"`
+}
+
+// Notification is a message pushed to the websocket connections of a user,
+// informing them of a change to their account made elsewhere.
+type Notification struct {
+	Type     EventType   `json:"type"`
+	Username string      `json:"username,omitempty"`
+	Login    *LoginAlert `json:"login,omitempty"`
+}
+
+// AccountExport is the data stored about a user, as exported by the user.
+type AccountExport struct {
+	User       *User               `json:"user"`
+	Sessions   []*Session          `json:"sessions"`
+	Identities []*ExternalIdentity `json:"identities"`
+	Events     []*Event            `json:"events"`
+	ExportedAt time.Time           `json:"exported_at"`
+}
@@ -0,0 +1,169 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// UsernamePolicy determines which usernames may be registered.
+type UsernamePolicy struct {
+	MinLength int             // characters
+	MaxLength int             // characters
+	Unicode   bool            // allow letters and digits of every script, otherwise only ASCII
+	Reserved  map[string]bool // keys of the names which cannot be registered, see UsernameKey
+}
+
+// NewUsernamePolicy returns a UsernamePolicy reserving the given names,
+// along with every name confusable with one of them.
+func NewUsernamePolicy(minLength int, maxLength int, unicode bool, reserved []string) *UsernamePolicy {
+	policy := &UsernamePolicy{
+		MinLength: minLength,
+		MaxLength: maxLength,
+		Unicode:   unicode,
+		Reserved:  make(map[string]bool, len(reserved)),
+	}
+	for _, name := range reserved {
+		if name = strings.TrimSpace(name); name != "" {
+			policy.Reserved[UsernameKey(name)] = true
+		}
+	}
+	return policy
+}
+
+// Validate returns a ValidationError if username, which must already be normalized
+// by NormalizeUsername, violates the policy, or nil if it is valid.
+func (p *UsernamePolicy) Validate(username string) *ValidationError {
+	length := utf8.RuneCountInString(username)
+	switch {
+	case username == "":
+		return &ValidationError{Field: "username", Message: "username cannot be empty"}
+	case length < p.MinLength || length > p.MaxLength:
+		return &ValidationError{Field: "username", Message: "username must be between " +
+			strconv.Itoa(p.MinLength) + " and " + strconv.Itoa(p.MaxLength) + " characters"}
+	case !p.Unicode && !isASCIIAlphanumeric(username):
+		return &ValidationError{Field: "username", Message: "username must be alphanumeric"}
+	case !isAlphanumeric(username):
+		return &ValidationError{Field: "username", Message: "username must consist of letters and digits"}
+	case !isSingleScript(username):
+		return &ValidationError{Field: "username", Message: "username cannot mix letters of different scripts"}
+	case p.Reserved[UsernameKey(username)]:
+		return &ValidationError{Field: "username", Message: "username is reserved"}
+	}
+	return nil
+}
+
+// NormalizeUsername returns the NFKC normalization of username, so that usernames
+// which are rendered the same are also stored the same, e.g. fullwidth and ASCII letters.
+// Case is preserved.
+func NormalizeUsername(username string) string {
+	return norm.NFKC.String(strings.TrimSpace(username))
+}
+
+// UsernameKey returns the key under which username is unique. Usernames differing only
+// in case, normalization or by characters which look alike, e.g. Latin "o", Cyrillic "о"
+// and the digit "0", share a key.
+func UsernameKey(username string) string {
+	key := skeleton(NormalizeUsername(username))
+	key = cases.Fold().String(key)
+	return skeleton(key)
+}
+
+// skeleton replaces each character of s which is confusable with a Latin letter with that letter,
+// following the skeleton of Unicode Technical Standard #39 for the characters allowed in usernames.
+func skeleton(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if prototype, ok := confusables[r]; ok {
+			return prototype
+		}
+		return r
+	}, s)
+	return strings.ReplaceAll(s, "m", "rn")
+}
+
+// confusables maps characters to the Latin letter they are most likely mistaken for.
+var confusables = map[rune]rune{
+	// Digits and Latin
+	'0': 'o', '1': 'l', 'I': 'l', 'ı': 'i', 'ɑ': 'a', 'ɡ': 'g', 'ɩ': 'i',
+	// Cyrillic
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O', 'Р': 'P',
+	'С': 'C', 'Т': 'T', 'У': 'Y', 'Х': 'X', 'Ѕ': 'S', 'І': 'l', 'Ј': 'J', 'Ԁ': 'D',
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x', 'ѕ': 's',
+	'і': 'i', 'ј': 'j', 'ԁ': 'd', 'һ': 'h', 'ԛ': 'q', 'ԝ': 'w', 'ү': 'y',
+	// Greek
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'l', 'Κ': 'K', 'Μ': 'M',
+	'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+	'α': 'a', 'ι': 'i', 'ο': 'o', 'κ': 'k', 'ν': 'v', 'ρ': 'p', 'υ': 'u', 'χ': 'x',
+}
+
+// isASCIIAlphanumeric reports whether s consists of ASCII letters and digits.
+func isASCIIAlphanumeric(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isAlphanumeric reports whether s consists of letters and digits. Combining marks,
+// which scripts such as Devanagari require, are allowed after the first character.
+func isAlphanumeric(s string) bool {
+	for i, r := range s {
+		mark := unicode.In(r, unicode.Mn, unicode.Mc)
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || (mark && i > 0)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSingleScript reports whether the letters of s belong to a single script, the
+// mixing of which is the usual way of spoofing a name. Han may be mixed with the
+// Japanese kana and with Korean Hangul, as those languages are written.
+func isSingleScript(s string) bool {
+	scripts := make(map[string]bool)
+	for _, r := range s {
+		for name, table := range usernameScripts {
+			if unicode.Is(table, r) {
+				scripts[name] = true
+			}
+		}
+	}
+	switch {
+	case len(scripts) <= 1:
+		return true
+	case scripts["Hangul"]:
+		return len(scripts) == 2 && scripts["Han"]
+	default:
+		for name := range scripts {
+			if name != "Han" && name != "Hiragana" && name != "Katakana" {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// usernameScripts are the scripts distinguished by isSingleScript. Letters of other
+// scripts, which are unlikely to be confused with these, are not restricted.
+var usernameScripts = map[string]*unicode.RangeTable{
+	"Arabic":     unicode.Arabic,
+	"Armenian":   unicode.Armenian,
+	"Cherokee":   unicode.Cherokee,
+	"Cyrillic":   unicode.Cyrillic,
+	"Devanagari": unicode.Devanagari,
+	"Georgian":   unicode.Georgian,
+	"Greek":      unicode.Greek,
+	"Han":        unicode.Han,
+	"Hangul":     unicode.Hangul,
+	"Hebrew":     unicode.Hebrew,
+	"Hiragana":   unicode.Hiragana,
+	"Katakana":   unicode.Katakana,
+	"Latin":      unicode.Latin,
+	"Thai":       unicode.Thai,
+}
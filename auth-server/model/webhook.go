@@ -0,0 +1,99 @@
+package model
+
+import (
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// WebhookEndpoint is a URL events are delivered to. EventTypes selects the types of events
+// delivered, every type when empty. Deliveries are signed with Secret, which is only
+// returned when the endpoint is created.
+type WebhookEndpoint struct {
+	ID          uuid.UUID   `json:"id"`
+	URL         string      `json:"url"`
+	Secret      string      `json:"secret,omitempty"`
+	EventTypes  []EventType `json:"event_types"`
+	Description string      `json:"description"`
+	Active      bool        `json:"active"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// Validate returns a ValidationError if the URL of the endpoint is not an absolute http(s) URL,
+// an event type is unknown, or the description is longer than 200 characters, or nil if it is valid.
+func (e *WebhookEndpoint) Validate() *ValidationError {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &ValidationError{Field: "url", Message: "url must be an absolute http or https URL"}
+	}
+	for _, eventType := range e.EventTypes {
+		if !eventType.Valid() {
+			return &ValidationError{Field: "event_types", Message: "unknown event type " + string(eventType)}
+		}
+	}
+	if utf8.RuneCountInString(e.Description) > 200 {
+		return &ValidationError{Field: "description", Message: "description cannot exceed 200 characters"}
+	}
+	return nil
+}
+
+// WebhookEndpointUpdate contains the fields of a webhook endpoint to change.
+// Fields which are nil are left unchanged.
+type WebhookEndpointUpdate struct {
+	URL         *string      `json:"url"`
+	EventTypes  *[]EventType `json:"event_types"`
+	Description *string      `json:"description"`
+	Active      *bool        `json:"active"`
+}
+
+// WebhookDeliveryStatus is the state of the delivery of an event to a webhook endpoint.
+type WebhookDeliveryStatus string
+
+// Values for WebhookDeliveryStatus
+const (
+	WebhookPending   WebhookDeliveryStatus = "pending" // awaiting its first or a further attempt
+	WebhookSucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDead      WebhookDeliveryStatus = "dead" // every attempt failed, until retried by an admin
+)
+
+// WebhookDelivery is the delivery of an event to a webhook endpoint. Log, the attempts
+// made to deliver the event, is only set when a single delivery is requested.
+type WebhookDelivery struct {
+	ID            int64                 `json:"id"`
+	EndpointID    uuid.UUID             `json:"endpoint_id"`
+	EventID       int64                 `json:"event_id"`
+	EventType     EventType             `json:"event_type"`
+	Status        WebhookDeliveryStatus `json:"status"`
+	Attempts      int                   `json:"attempts"`
+	NextAttemptAt time.Time             `json:"next_attempt_at"`
+	CreatedAt     time.Time             `json:"created_at"`
+	DeliveredAt   *time.Time            `json:"delivered_at,omitempty"`
+	Log           []*WebhookAttempt     `json:"log,omitempty"`
+}
+
+// WebhookAttempt is an attempt to deliver an event to a webhook endpoint.
+type WebhookAttempt struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int       `json:"status_code,omitempty"` // of the response, 0 when none was received
+	Error       string    `json:"error,omitempty"`
+	Duration    int64     `json:"duration_ms"`
+}
+
+// WebhookDeliveryFilter selects the deliveries to an endpoint, newest first. Status, when set,
+// restricts the deliveries to those with the status. Before, when non-zero, is the ID of the
+// last delivery of the previous page, so only older deliveries are returned.
+type WebhookDeliveryFilter struct {
+	EndpointID uuid.UUID
+	Status     WebhookDeliveryStatus
+	Before     int64
+	Limit      int
+}
+
+// WebhookJob is a delivery claimed for an attempt, along with the endpoint and event delivered.
+type WebhookJob struct {
+	Delivery *WebhookDelivery
+	Endpoint *WebhookEndpoint
+	Event    *Event
+}
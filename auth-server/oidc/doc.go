@@ -0,0 +1,13 @@
+// Package oidc is a minimal OpenID Connect relying party, used to sign users in
+// with an upstream identity provider.
+//
+// The provider is located through OpenID Connect Discovery, users are sent to its
+// authorization endpoint using the authorization code flow with PKCE, and the ID token
+// returned by its token endpoint is verified against its published key set.
+//
+//	provider := oidc.NewProvider(oidc.Config{Issuer: issuer, ClientID: id, ClientSecret: secret, RedirectURL: callback}, nil)
+//	authURL, _ := provider.AuthCodeURL(ctx, state, nonce, codeChallenge)
+//	...
+//	token, _ := provider.Exchange(ctx, code, codeVerifier)
+//	claims, _ := provider.Verify(ctx, token.IDToken, nonce)
+package oidc
@@ -0,0 +1,106 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"testing"
+
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testRedirectURL  = "http://localhost:3001/auth/login/oidc/callback"
+)
+
+func TestProvider(t *testing.T) {
+	fake, err := NewFakeProvider("client", "secret")
+	require.NoError(t, err)
+	defer fake.Close()
+	fake.User = Claims{Claims: jwt.Claims{Subject: "upstream-user"}, Email: "user@example.com"}
+
+	provider := NewProvider(Config{
+		Issuer:       fake.Issuer(),
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"email"},
+	}, nil)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", codeChallenge(testCodeVerifier))
+	require.NoError(t, err)
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	require.Equal(t, "openid email", parsed.Query().Get("scope"))
+
+	code, state, err := fake.Authorize(authURL)
+	require.NoError(t, err)
+	require.Equal(t, "state", state)
+
+	token, err := provider.Exchange(ctx, code, testCodeVerifier)
+	require.NoError(t, err)
+	claims, err := provider.Verify(ctx, token.IDToken, "nonce")
+	require.NoError(t, err)
+	require.Equal(t, "upstream-user", claims.Subject)
+	require.Equal(t, "user@example.com", claims.Email)
+
+	// nonce binds the ID token to the authorization request
+	_, err = provider.Verify(ctx, token.IDToken, "other")
+	require.Error(t, err)
+
+	// codes are single-use
+	_, err = provider.Exchange(ctx, code, testCodeVerifier)
+	require.Error(t, err)
+}
+
+func TestProviderInvalidToken(t *testing.T) {
+	fake, err := NewFakeProvider("client", "secret")
+	require.NoError(t, err)
+	defer fake.Close()
+	fake.User = Claims{Claims: jwt.Claims{Subject: "upstream-user"}}
+	fake.Audience = "another-client"
+
+	provider := NewProvider(Config{
+		Issuer:       fake.Issuer(),
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  testRedirectURL,
+	}, nil)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", codeChallenge(testCodeVerifier))
+	require.NoError(t, err)
+	code, _, err := fake.Authorize(authURL)
+	require.NoError(t, err)
+
+	// wrong code verifier
+	_, err = provider.Exchange(ctx, code, "wrong-verifier-wrong-verifier-wrong-verifier")
+	require.Error(t, err)
+
+	// ID token issued to another client
+	code, _, err = fake.Authorize(authURL)
+	require.NoError(t, err)
+	token, err := provider.Exchange(ctx, code, testCodeVerifier)
+	require.NoError(t, err)
+	_, err = provider.Verify(ctx, token.IDToken, "nonce")
+	require.Error(t, err)
+}
+
+func TestProviderIssuerMismatch(t *testing.T) {
+	fake, err := NewFakeProvider("client", "secret")
+	require.NoError(t, err)
+	defer fake.Close()
+
+	provider := NewProvider(Config{Issuer: fake.Issuer() + "/", ClientID: "client"}, nil)
+	_, err = provider.AuthCodeURL(context.Background(), "state", "nonce", codeChallenge(testCodeVerifier))
+	require.ErrorContains(t, err, "does not match")
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
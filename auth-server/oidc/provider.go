@@ -0,0 +1,196 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/jwt"
+)
+
+// Config identifies this service as a client of the upstream provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // callback URL registered with the provider
+	Scopes       []string // requested in addition to openid
+}
+
+// Metadata is the subset of the provider configuration document used by the relying party.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Token is the response of the token endpoint of the provider.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Claims are the claims of an ID token used to identify the user.
+type Claims struct {
+	jwt.Claims
+	Nonce             string `json:"nonce,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     bool   `json:"email_verified,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Name              string `json:"name,omitempty"`
+}
+
+// Provider is an upstream OpenID Connect provider. Its configuration is discovered on
+// first use, so the provider being unavailable does not prevent this service from starting.
+// Safe for concurrent use by multiple goroutines.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *Metadata
+	keys     *jwt.RemoteKeySet
+}
+
+// NewProvider returns the provider identified by config.Issuer.
+func NewProvider(config Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{
+		config: config,
+		client: client,
+	}
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint the user is sent to.
+// codeChallenge is the S256 PKCE challenge derived from the verifier later passed to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: invalid authorization endpoint: %w", err)
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, p.config.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// Exchange redeems the authorization code returned to the redirect URL for tokens.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (*Token, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var oauthErr struct {
+			Code        string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&oauthErr)
+		return nil, fmt.Errorf("oidc: token endpoint returned %d: %s %s", res.StatusCode, oauthErr.Code, oauthErr.Description)
+	}
+	var token Token
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("oidc: decoding token response: %w", err)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc: token response is missing id_token")
+	}
+	return &token, nil
+}
+
+// Verify verifies the signature and claims of an ID token issued to this client,
+// and that it was issued in response to the authorization request identified by nonce.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (*Claims, error) {
+	if _, err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err := jwt.Parse(ctx, idToken, p.keys, &claims); err != nil {
+		return nil, err
+	}
+	if err := claims.Validate(p.config.Issuer, p.config.ClientID, time.Now()); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("oidc: id token is missing sub")
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("oidc: id token nonce does not match")
+	}
+	return &claims, nil
+}
+
+// discover fetches and caches the provider configuration document.
+func (p *Provider) discover(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: fetching provider configuration: unexpected status %d", res.StatusCode)
+	}
+	var metadata Metadata
+	if err := json.NewDecoder(res.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("oidc: decoding provider configuration: %w", err)
+	}
+	// the issuer must match exactly, see OpenID Connect Discovery 1.0 section 4.3
+	if metadata.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("oidc: issuer %q does not match configured issuer %q", metadata.Issuer, p.config.Issuer)
+	}
+	p.metadata = &metadata
+	p.keys = jwt.NewRemoteKeySet(metadata.JWKSURI, p.client)
+	return p.metadata, nil
+}
@@ -0,0 +1,193 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/jwt"
+)
+
+// FakeProvider is a local OpenID Connect provider for testing.
+// Every authorization request is approved for the user described by the User field.
+type FakeProvider struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+	User         Claims // claims of the user approving authorization requests
+	Audience     string // overrides the audience of issued ID tokens when not empty
+
+	signer *jwt.Signer
+	mu     sync.Mutex
+	grants map[string]fakeGrant
+}
+
+type fakeGrant struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// NewFakeProvider starts a FakeProvider accepting the given client credentials.
+// The caller must call Close when finished.
+func NewFakeProvider(clientID, clientSecret string) (*FakeProvider, error) {
+	key, err := jwt.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := jwt.NewSigner(key)
+	if err != nil {
+		return nil, err
+	}
+	p := &FakeProvider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		signer:       signer,
+		grants:       make(map[string]fakeGrant),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.configuration)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.Server = httptest.NewServer(mux)
+	return p, nil
+}
+
+// Issuer returns the issuer identifier of the provider.
+func (p *FakeProvider) Issuer() string {
+	return p.URL
+}
+
+// Authorize follows authURL as the user would, returning the code and state
+// the provider redirects back to the client with.
+func (p *FakeProvider) Authorize(authURL string) (code string, state string, err error) {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+func (p *FakeProvider) configuration(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&Metadata{
+		Issuer:                p.Issuer(),
+		AuthorizationEndpoint: p.URL + "/authorize",
+		TokenEndpoint:         p.URL + "/token",
+		JWKSURI:               p.URL + "/jwks",
+	})
+}
+
+func (p *FakeProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != p.ClientID || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	p.mu.Lock()
+	p.grants[code] = fakeGrant{
+		redirectURI:   query.Get("redirect_uri"),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+	}
+	p.mu.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *FakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, _ := r.BasicAuth()
+	clientID, _ = url.QueryUnescape(clientID)
+	secret, _ = url.QueryUnescape(secret)
+	if clientID != p.ClientID || secret != p.ClientSecret {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	p.mu.Lock()
+	grant, ok := p.grants[r.PostForm.Get("code")]
+	delete(p.grants, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	if !ok || grant.redirectURI != r.PostForm.Get("redirect_uri") || verifyChallenge(grant.codeChallenge, r.PostForm.Get("code_verifier")) != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	audience := p.ClientID
+	if p.Audience != "" {
+		audience = p.Audience
+	}
+	now := time.Now()
+	claims := p.User
+	claims.Issuer = p.Issuer()
+	claims.Audience = jwt.Audience{audience}
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(time.Hour).Unix()
+	claims.Nonce = grant.nonce
+	idToken, err := p.signer.Sign(&claims)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&Token{
+		AccessToken: "access-token",
+		TokenType:   "Bearer",
+		IDToken:     idToken,
+		ExpiresIn:   3600,
+	})
+}
+
+func (p *FakeProvider) jwks(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p.signer.KeySet())
+}
+
+func verifyChallenge(challenge, verifier string) error {
+	sum := sha256.Sum256([]byte(verifier))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+		return errors.New("code_verifier does not match code_challenge")
+	}
+	return nil
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
@@ -0,0 +1,11 @@
+// Package outbox publishes the events recorded in auth.event to other systems.
+//
+// Events are written to the event table in the same transaction as the change they record,
+// the table serving as a transactional outbox. A Relay reads new events in ID order and
+// publishes them to a Sink, such as a Redis stream or a webhook, recording the ID of the last
+// event published. Delivery is at-least-once: events published before a crash, but not yet
+// recorded, are published again on restart, so consumers should deduplicate events by ID.
+//
+// Every replica runs a relay, but only the replica holding the lease of the cursor publishes,
+// another taking over when it stops.
+package outbox
@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+)
+
+// maxRetryDelay caps the delay before publishing again after a failure.
+const maxRetryDelay = time.Minute
+
+// Relay publishes events to a sink in ID order.
+type Relay struct {
+	cursors      repository.CursorRepository
+	sink         Sink
+	name         string // of the cursor recording the position of the relay
+	batchSize    int
+	pollInterval time.Duration
+	gapTimeout   time.Duration
+
+	failures int       // consecutive failures to publish
+	gapID    int64     // ID of the first missing event, see contiguous
+	gapSince time.Time // when gapID was found missing
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewRelay returns a Relay publishing events to sink, recording its position under name.
+// The batch size, poll interval and gap timeout are read from config.
+func NewRelay(cursors repository.CursorRepository, name string, sink Sink, config config.Outbox) *Relay {
+	return &Relay{
+		cursors:      cursors,
+		sink:         sink,
+		name:         name,
+		batchSize:    config.BatchSize,
+		pollInterval: time.Duration(config.PollInterval) * time.Millisecond,
+		gapTimeout:   time.Duration(config.GapTimeout) * time.Millisecond,
+	}
+}
+
+// Start starts publishing in the background, until Stop is called.
+func (r *Relay) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go r.run(ctx)
+}
+
+// Stop stops publishing, waiting for the batch being published, and closes the sink.
+func (r *Relay) Stop() error {
+	r.cancel()
+	<-r.done
+	return r.sink.Close()
+}
+
+// run publishes events while this replica holds the lease of the cursor,
+// trying to acquire it every poll interval while another replica does.
+func (r *Relay) run(ctx context.Context) {
+	defer close(r.done)
+	for {
+		lease, err := r.cursors.Acquire(ctx, r.name)
+		if err != nil {
+			log.Printf("%s: failed to acquire cursor: %s", r.name, err)
+		}
+		if lease != nil {
+			if err := r.relay(ctx, lease); err != nil && ctx.Err() == nil {
+				r.failures++
+				log.Printf("%s: %s", r.name, err)
+			}
+			if err := lease.Release(); err != nil {
+				log.Printf("%s: failed to release cursor: %s", r.name, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.retryDelay()):
+		}
+	}
+}
+
+// relay publishes batches of events until ctx is done or publishing fails,
+// polling for new events once every event has been published.
+func (r *Relay) relay(ctx context.Context, lease repository.CursorLease) error {
+	for {
+		published, err := r.publish(ctx, lease, time.Now())
+		if err != nil {
+			return err
+		}
+		r.failures = 0
+		if published == r.batchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
+
+// publish publishes the next batch of events and records the last as the position
+// of the relay, returning the number of events published.
+func (r *Relay) publish(ctx context.Context, lease repository.CursorLease, now time.Time) (int, error) {
+	position, err := lease.Position(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read position: %w", err)
+	}
+	events, err := lease.Events(ctx, position, r.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read events: %w", err)
+	}
+	events = r.contiguous(position, events, now)
+	if len(events) == 0 {
+		return 0, nil
+	}
+	if err := r.sink.Publish(ctx, events); err != nil {
+		return 0, fmt.Errorf("failed to publish events: %w", err)
+	}
+	if err := lease.Advance(ctx, events[len(events)-1].ID); err != nil {
+		return 0, fmt.Errorf("failed to record position: %w", err)
+	}
+	return len(events), nil
+}
+
+// contiguous returns the events following position up to the first missing ID.
+// IDs are assigned when events are inserted, but the events are only read once the
+// inserting transaction commits, so an event with a lower ID may still appear. An ID
+// is skipped once it has been missing for the gap timeout, as rolled back transactions
+// leave IDs which are never used.
+func (r *Relay) contiguous(position int64, events []*model.Event, now time.Time) []*model.Event {
+	next := position + 1
+	for i, event := range events {
+		if event.ID != next {
+			if r.gapID != next {
+				r.gapID, r.gapSince = next, now
+			}
+			if now.Sub(r.gapSince) < r.gapTimeout {
+				return events[:i]
+			}
+		}
+		next = event.ID + 1
+	}
+	return events
+}
+
+// retryDelay returns the delay before acquiring the cursor again, which doubles
+// with every consecutive failure to publish, up to maxRetryDelay.
+func (r *Relay) retryDelay() time.Duration {
+	delay := r.pollInterval
+	for i := 0; i < r.failures && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
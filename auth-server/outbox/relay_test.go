@@ -0,0 +1,166 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var outboxConfig = config.Outbox{
+	Sink:         "test",
+	BatchSize:    2,
+	PollInterval: 10,
+	GapTimeout:   1000,
+}
+
+// recordingSink records the IDs of the events published, failing while err is set
+type recordingSink struct {
+	mu        sync.Mutex
+	published []int64
+	err       error
+}
+
+func (s *recordingSink) Publish(_ context.Context, events []*model.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	for _, event := range events {
+		s.published = append(s.published, event.ID)
+	}
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func (s *recordingSink) Published() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int64{}, s.published...)
+}
+
+func newEvents(ids ...int64) []*model.Event {
+	events := make([]*model.Event, 0, len(ids))
+	for _, id := range ids {
+		events = append(events, &model.Event{ID: id, UUID: uuid.New(), Type: model.LoggedIn})
+	}
+	return events
+}
+
+func TestRelayPublish(t *testing.T) {
+	cursors := &repository.MockCursorRepository{Events: newEvents(1, 2, 3, 4, 5)}
+	sink := &recordingSink{}
+	relay := NewRelay(cursors, "outbox:test", sink, outboxConfig)
+	lease, err := cursors.Acquire(context.Background(), relay.name)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lease.Release()) }()
+
+	for _, want := range []int{2, 2, 1, 0} {
+		published, err := relay.publish(context.Background(), lease, time.Now())
+		require.NoError(t, err)
+		require.Equal(t, want, published)
+	}
+	require.Equal(t, []int64{1, 2, 3, 4, 5}, sink.Published())
+	require.Equal(t, int64(5), cursors.Positions["outbox:test"])
+}
+
+func TestRelayPublishFailure(t *testing.T) {
+	cursors := &repository.MockCursorRepository{Events: newEvents(1, 2)}
+	sink := &recordingSink{err: errors.New("unavailable")}
+	relay := NewRelay(cursors, "outbox:test", sink, outboxConfig)
+	lease, err := cursors.Acquire(context.Background(), relay.name)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lease.Release()) }()
+
+	// the position is not advanced, so the events are published again
+	_, err = relay.publish(context.Background(), lease, time.Now())
+	require.ErrorIs(t, err, sink.err)
+	require.Zero(t, cursors.Positions["outbox:test"])
+
+	sink.err = nil
+	published, err := relay.publish(context.Background(), lease, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 2, published)
+	require.Equal(t, []int64{1, 2}, sink.Published())
+}
+
+func TestRelayGap(t *testing.T) {
+	cursors := &repository.MockCursorRepository{Events: newEvents(1, 3, 5)}
+	sink := &recordingSink{}
+	config := outboxConfig
+	config.BatchSize = 10
+	relay := NewRelay(cursors, "outbox:test", sink, config)
+	lease, err := cursors.Acquire(context.Background(), relay.name)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lease.Release()) }()
+	now := time.Now()
+
+	// events after a missing ID wait for it to commit
+	published, err := relay.publish(context.Background(), lease, now)
+	require.NoError(t, err)
+	require.Equal(t, 1, published)
+	published, err = relay.publish(context.Background(), lease, now.Add(time.Millisecond))
+	require.NoError(t, err)
+	require.Zero(t, published)
+
+	// event 2 commits, event 4 never does
+	cursors.Events = append(cursors.Events, newEvents(2)...)
+	published, err = relay.publish(context.Background(), lease, now.Add(time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, 2, published)
+	published, err = relay.publish(context.Background(), lease, now.Add(time.Second))
+	require.NoError(t, err)
+	require.Zero(t, published)
+	published, err = relay.publish(context.Background(), lease, now.Add(2*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 1, published)
+	require.Equal(t, []int64{1, 2, 3, 5}, sink.Published())
+}
+
+func TestRelayRetryDelay(t *testing.T) {
+	relay := NewRelay(&repository.MockCursorRepository{}, "outbox:test", &recordingSink{}, outboxConfig)
+	require.Equal(t, 10*time.Millisecond, relay.retryDelay())
+	relay.failures = 3
+	require.Equal(t, 80*time.Millisecond, relay.retryDelay())
+	relay.failures = 100
+	require.Equal(t, maxRetryDelay, relay.retryDelay())
+}
+
+func TestRelayStartStop(t *testing.T) {
+	cursors := &repository.MockCursorRepository{Events: newEvents(1, 2, 3)}
+	sink := &recordingSink{}
+	relay := NewRelay(cursors, "outbox:test", sink, outboxConfig)
+	standby := NewRelay(cursors, "outbox:test", &recordingSink{}, outboxConfig)
+
+	relay.Start()
+	require.Eventually(t, func() bool { return len(sink.Published()) == 3 }, time.Second, 10*time.Millisecond)
+
+	// the lease is held, so a second replica waits
+	lease, err := cursors.Acquire(context.Background(), relay.name)
+	require.NoError(t, err)
+	require.Nil(t, lease)
+	standby.Start()
+	require.NoError(t, relay.Stop())
+
+	// and takes over once the first replica stops
+	require.Eventually(t, func() bool {
+		lease, err := cursors.Acquire(context.Background(), relay.name)
+		if lease != nil {
+			require.NoError(t, lease.Release())
+		}
+		return err == nil && lease == nil
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, standby.Stop())
+	require.Equal(t, []int64{1, 2, 3}, sink.Published())
+}
@@ -0,0 +1,171 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/go-redis/redis/v8"
+	"github.com/nats-io/nats.go"
+)
+
+// Sink is a system events are published to.
+type Sink interface {
+	// Publish publishes events, in order. Events are not published again once Publish
+	// returns nil, so it must only do so once the sink has accepted every event.
+	Publish(ctx context.Context, events []*model.Event) error
+	Close() error
+}
+
+// NewSink returns the sink named by config.Sink. The redis sink publishes with redisClient.
+func NewSink(config config.Outbox, redisClient *redis.Client) (Sink, error) {
+	switch config.Sink {
+	case "stdout":
+		return NewWriterSink(os.Stdout), nil
+	case "webhook":
+		return NewWebhookSink(config.URL, &http.Client{Timeout: 10 * time.Second}), nil
+	case "redis":
+		return NewRedisStreamSink(redisClient, config.Topic), nil
+	case "nats":
+		conn, err := nats.Connect(config.URL, nats.Name("auth-server outbox"))
+		if err != nil {
+			return nil, err
+		}
+		return NewNATSSink(conn, config.Topic), nil
+	default:
+		return nil, fmt.Errorf("unknown outbox sink %q", config.Sink)
+	}
+}
+
+type writerSink struct {
+	encoder *json.Encoder
+}
+
+// NewWriterSink returns a Sink writing events to w as JSON, one event per line.
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{json.NewEncoder(w)}
+}
+
+func (s *writerSink) Publish(_ context.Context, events []*model.Event) error {
+	for _, event := range events {
+		if err := s.encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a Sink posting events to url as a JSON array. Any response
+// other than 2xx is treated as a failure, and the events are posted again.
+func NewWebhookSink(url string, client *http.Client) Sink {
+	return &webhookSink{url, client}
+}
+
+func (s *webhookSink) Publish(ctx context.Context, events []*model.Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+type redisStreamSink struct {
+	client *redis.Client
+	stream string
+}
+
+// NewRedisStreamSink returns a Sink adding events to the Redis stream, one entry per event,
+// with the fields of the event as the fields of the entry.
+func NewRedisStreamSink(client *redis.Client, stream string) Sink {
+	return &redisStreamSink{client, stream}
+}
+
+func (s *redisStreamSink) Publish(ctx context.Context, events []*model.Event) error {
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, event := range events {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: s.stream,
+				Values: map[string]interface{}{
+					"id":         strconv.FormatInt(event.ID, 10),
+					"uuid":       event.UUID.String(),
+					"type":       string(event.Type),
+					"body":       string(event.Body),
+					"created_at": event.CreatedAt.Format(time.RFC3339Nano),
+				},
+			})
+		}
+		return nil
+	})
+	return err
+}
+
+// Close does not close the client, which is shared.
+func (s *redisStreamSink) Close() error {
+	return nil
+}
+
+type natsSink struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+// NewNATSSink returns a Sink publishing each event as JSON to the subject prefix.<type>,
+// e.g. auth.events.logged_in, so subscribers can select events by type.
+func NewNATSSink(conn *nats.Conn, prefix string) Sink {
+	return &natsSink{conn, prefix}
+}
+
+func (s *natsSink) Publish(ctx context.Context, events []*model.Event) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := s.conn.Publish(s.prefix+"."+string(event.Type), data); err != nil {
+			return err
+		}
+	}
+	// the server has received every event once it answers the ping sent by flush
+	return s.conn.FlushWithContext(ctx)
+}
+
+func (s *natsSink) Close() error {
+	s.conn.Close()
+	return nil
+}
@@ -0,0 +1,48 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink(&buf)
+	require.NoError(t, sink.Publish(context.Background(), newEvents(1, 2)))
+
+	decoder := json.NewDecoder(&buf)
+	for _, id := range []int64{1, 2} {
+		var event model.Event
+		require.NoError(t, decoder.Decode(&event))
+		require.Equal(t, id, event.ID)
+	}
+	require.NoError(t, sink.Close())
+}
+
+func TestWebhookSink(t *testing.T) {
+	status := http.StatusNoContent
+	var received []*model.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, server.Client())
+	require.NoError(t, sink.Publish(context.Background(), newEvents(1, 2)))
+	require.Len(t, received, 2)
+	require.Equal(t, int64(2), received[1].ID)
+
+	status = http.StatusServiceUnavailable
+	require.Error(t, sink.Publish(context.Background(), newEvents(3)))
+	require.NoError(t, sink.Close())
+}
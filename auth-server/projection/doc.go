@@ -0,0 +1,11 @@
+// Package projection maintains read models derived from the events recorded in auth.event,
+// such as the activity and login history of each user.
+//
+// Each projection is fed by a Relay of the outbox package, publishing to a Sink which applies
+// events to the read model of the projection and records its position in the same transaction,
+// so every event is applied exactly once. As with the outbox, only the replica holding the lease
+// of the cursor of a projection applies events.
+//
+// A projection is rebuilt from scratch, e.g. after a change to how it applies events, with the
+// rebuild-projections command, see Rebuild.
+package projection
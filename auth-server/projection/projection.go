@@ -0,0 +1,57 @@
+package projection
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/outbox"
+	"github.com/dgyurics/auth/auth-server/repository"
+)
+
+type sink struct {
+	repository repository.ProjectionRepository
+	name       string
+}
+
+// NewSink returns an outbox.Sink applying events to the projection name.
+func NewSink(repository repository.ProjectionRepository, name string) outbox.Sink {
+	return &sink{repository, name}
+}
+
+func (s *sink) Publish(ctx context.Context, events []*model.Event) error {
+	return s.repository.Apply(ctx, s.name, events)
+}
+
+func (s *sink) Close() error {
+	return nil
+}
+
+// NewRelays returns a Relay for each projection, applying events to it. The batch size,
+// poll interval and gap timeout are read from config.
+func NewRelays(cursors repository.CursorRepository, projections repository.ProjectionRepository, config config.Outbox) []*outbox.Relay {
+	names := projections.Names()
+	relays := make([]*outbox.Relay, 0, len(names))
+	for _, name := range names {
+		relays = append(relays, outbox.NewRelay(cursors, "projection:"+name, NewSink(projections, name), config))
+	}
+	return relays
+}
+
+// Rebuild rebuilds the named projections from the first event, every projection when
+// names is empty, applying batchSize events at a time.
+func Rebuild(ctx context.Context, projections repository.ProjectionRepository, names []string, batchSize int) error {
+	if len(names) == 0 {
+		names = projections.Names()
+	}
+	for _, name := range names {
+		applied, err := projections.Rebuild(ctx, name, batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to rebuild projection %s: %w", name, err)
+		}
+		log.Printf("rebuilt projection %s from %d events", name, applied)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package projection
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func newEvents(ids ...int64) []*model.Event {
+	events := make([]*model.Event, 0, len(ids))
+	for _, id := range ids {
+		events = append(events, &model.Event{ID: id, UUID: uuid.New(), Type: model.LoggedIn})
+	}
+	return events
+}
+
+func appliedIDs(projections *repository.MockProjectionRepository, name string) []int64 {
+	ids := make([]int64, 0)
+	for _, event := range projections.AppliedEvents(name) {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestRelays(t *testing.T) {
+	cursors := &repository.MockCursorRepository{Events: newEvents(1, 2, 3)}
+	projections := &repository.MockProjectionRepository{}
+	relays := NewRelays(cursors, projections, config.Outbox{BatchSize: 2, PollInterval: 10, GapTimeout: 1000})
+	require.Len(t, relays, len(projections.Names()))
+	for _, relay := range relays {
+		relay.Start()
+	}
+
+	// every projection is fed every event under its own cursor
+	require.Eventually(t, func() bool {
+		for _, name := range projections.Names() {
+			if len(appliedIDs(projections, name)) != 3 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+	for _, relay := range relays {
+		require.NoError(t, relay.Stop())
+	}
+	for _, name := range projections.Names() {
+		require.Equal(t, []int64{1, 2, 3}, appliedIDs(projections, name))
+		require.Equal(t, int64(3), cursors.Positions["projection:"+name])
+	}
+}
+
+func TestSinkSkipsApplied(t *testing.T) {
+	ctx := context.Background()
+	projections := &repository.MockProjectionRepository{}
+	sink := NewSink(projections, "user_activity")
+
+	require.NoError(t, sink.Publish(ctx, newEvents(1, 2)))
+	// events published again after a crash are not applied twice
+	require.NoError(t, sink.Publish(ctx, newEvents(2, 3)))
+	require.Equal(t, []int64{1, 2, 3}, appliedIDs(projections, "user_activity"))
+	require.Empty(t, appliedIDs(projections, "login_history"))
+}
+
+func TestRebuild(t *testing.T) {
+	ctx := context.Background()
+	projections := &repository.MockProjectionRepository{}
+	for _, name := range projections.Names() {
+		require.NoError(t, projections.Apply(ctx, name, newEvents(1, 2)))
+	}
+
+	require.NoError(t, Rebuild(ctx, projections, []string{"login_history"}, 100))
+	require.Empty(t, appliedIDs(projections, "login_history"))
+	require.Equal(t, []int64{1, 2}, appliedIDs(projections, "user_activity"))
+
+	require.NoError(t, Rebuild(ctx, projections, nil, 100))
+	require.Empty(t, appliedIDs(projections, "user_activity"))
+}
@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/lib/pq"
+)
+
+// ArchiveRepository is an interface for the monthly partitions of the event table,
+// and archiving the events which have expired
+type ArchiveRepository interface {
+	// CreatePartition creates the partition holding the events of the month of month, unless it exists.
+	CreatePartition(ctx context.Context, month time.Time) error
+	// Partitions returns the partitions of the event table, oldest first and the default partition last.
+	Partitions(ctx context.Context) ([]*model.EventPartition, error)
+	// Begin begins archiving the events of partition, or returns nil when another replica is archiving.
+	Begin(ctx context.Context, partition *model.EventPartition) (ArchiveTx, error)
+	Close() error
+}
+
+// ArchiveTx is a transaction archiving the expired events of a partition, which cannot be changed
+// until the transaction ends. Only one replica archives at a time.
+type ArchiveTx interface {
+	// Expired calls fn with every event of the partition which has expired by now under policy, in chain order.
+	Expired(ctx context.Context, policy *model.RetentionPolicy, now time.Time, fn func(event *model.ArchivedEvent) error) error
+	// Count returns the number of events in the partition.
+	Count(ctx context.Context) (int, error)
+	// Purge deletes the ranges of archive from the partition, or drops the partition when archive.Dropped,
+	// along with the webhook deliveries of the events deleted, and records archive and event.
+	Purge(ctx context.Context, archive *model.Archive, event *model.Event) error
+	Commit() error
+	Rollback() error
+}
+
+type archiveRepository struct {
+	*DbClient
+	stmtCreatePartition *sql.Stmt // Prepared statement for creating the partition of a month
+	stmtSelectPartition *sql.Stmt // Prepared statement for selecting the names of the partitions
+	stmtInsertArchive   *sql.Stmt // Prepared statement for inserting into auth.event_archive
+	stmtInsertEvent     *sql.Stmt // Prepared statement for inserting into auth.event
+}
+
+// NewArchiveRepository creates a new archive repository
+func NewArchiveRepository(c *DbClient) ArchiveRepository {
+	repo := &archiveRepository{
+		DbClient: c,
+	}
+	repo.prepareStatements()
+	return repo
+}
+
+func (r *archiveRepository) CreatePartition(ctx context.Context, month time.Time) error {
+	_, err := r.stmtCreatePartition.ExecContext(ctx, month.UTC())
+	return err
+}
+
+func (r *archiveRepository) Partitions(ctx context.Context) ([]*model.EventPartition, error) {
+	rows, err := r.stmtSelectPartition.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partitions := make([]*model.EventPartition, 0)
+	for rows.Next() {
+		partition := &model.EventPartition{}
+		if err := rows.Scan(&partition.Name); err != nil {
+			return nil, err
+		}
+		// monthly partitions are named by create_event_partition
+		if from, err := time.Parse("event_2006_01", partition.Name); err == nil {
+			partition.From = from
+			partition.To = from.AddDate(0, 1, 0)
+		}
+		partitions = append(partitions, partition)
+	}
+	return partitions, rows.Err()
+}
+
+// Begin begins a transaction holding the advisory lock of the archiver, and locking partition
+// against changes, so that no events are written to it while it is archived.
+func (r *archiveRepository) Begin(ctx context.Context, partition *model.EventPartition) (ArchiveTx, error) {
+	tx, err := r.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtext(current_schema() || '.event_archive'))`).Scan(&locked); err != nil || !locked {
+		if errRollback := tx.Rollback(); errRollback != nil {
+			log.Println(errRollback)
+		}
+		return nil, err
+	}
+	table := pq.QuoteIdentifier(partition.Name)
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE `+table+` IN SHARE MODE`); err != nil {
+		if errRollback := tx.Rollback(); errRollback != nil {
+			log.Println(errRollback)
+		}
+		return nil, err
+	}
+	return &archiveTx{r, tx, table}, nil
+}
+
+type archiveTx struct {
+	repo  *archiveRepository
+	tx    *sql.Tx
+	table string // quoted name of the partition
+}
+
+func (t *archiveTx) Expired(ctx context.Context, policy *model.RetentionPolicy, now time.Time, fn func(event *model.ArchivedEvent) error) error {
+	types := make([]string, 0, len(policy.Types))
+	days := make([]int64, 0, len(policy.Types))
+	for eventType, d := range policy.Types {
+		types = append(types, string(eventType))
+		days = append(days, int64(d))
+	}
+	rows, err := t.tx.QueryContext(ctx, `
+		SELECT e.id, e.uuid, e.type, e.body, host(e.ip), e.user_agent, e.request_id, e.session_id, e.created_at,
+			e.chain_seq, e.body_hash, e.context_digest, e.prev_hash, e.hash
+		FROM `+t.table+` e
+		LEFT JOIN unnest($1::text[], $2::integer[]) AS r(type, days) ON r.type = e.type
+		WHERE coalesce(r.days, $3) > 0 AND e.created_at < $4::timestamp - make_interval(days => coalesce(r.days, $3))
+		ORDER BY e.chain_seq
+	`, pq.Array(types), pq.Array(days), policy.Default, now.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event model.ArchivedEvent
+		var body []byte
+		var ip, userAgent, requestID, sessionID sql.NullString
+		var bodyHash, contextDigest, prevHash, hash []byte
+		if err := rows.Scan(&event.ID, &event.UUID, &event.Type, &body, &ip, &userAgent, &requestID, &sessionID,
+			&event.CreatedAt, &event.ChainSeq, &bodyHash, &contextDigest, &prevHash, &hash); err != nil {
+			return err
+		}
+		// bodies are archived as written, rather than upcast
+		event.Body = body
+		if ip.Valid || userAgent.Valid || requestID.Valid || sessionID.Valid {
+			event.Context = &model.EventContext{
+				IP:        ip.String,
+				UserAgent: userAgent.String,
+				RequestID: requestID.String,
+				SessionID: sessionID.String,
+			}
+		}
+		event.BodyHash = hex.EncodeToString(bodyHash)
+		event.ContextDigest = hex.EncodeToString(contextDigest)
+		event.PrevHash = hex.EncodeToString(prevHash)
+		event.Hash = hex.EncodeToString(hash)
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (t *archiveTx) Count(ctx context.Context) (int, error) {
+	var count int
+	err := t.tx.QueryRowContext(ctx, `SELECT count(*) FROM `+t.table).Scan(&count)
+	return count, err
+}
+
+func (t *archiveTx) Purge(ctx context.Context, archive *model.Archive, event *model.Event) error {
+	if archive.Dropped {
+		if _, err := t.tx.ExecContext(ctx, `
+			DELETE FROM webhook_delivery
+			WHERE event_id IN (SELECT id FROM `+t.table+`)
+		`); err != nil {
+			return err
+		}
+		if _, err := t.tx.ExecContext(ctx, `DROP TABLE `+t.table); err != nil {
+			return err
+		}
+	} else {
+		firsts := make([]int64, 0, len(archive.Ranges))
+		lasts := make([]int64, 0, len(archive.Ranges))
+		for _, r := range archive.Ranges {
+			firsts = append(firsts, r.First)
+			lasts = append(lasts, r.Last)
+		}
+		if _, err := t.tx.ExecContext(ctx, `
+			WITH purged AS (
+				DELETE FROM `+t.table+`
+				WHERE EXISTS (
+					SELECT 1 FROM unnest($1::bigint[], $2::bigint[]) AS r(f, l)
+					WHERE chain_seq BETWEEN r.f AND r.l
+				)
+				RETURNING id
+			)
+			DELETE FROM webhook_delivery
+			WHERE event_id IN (SELECT id FROM purged)
+		`, pq.Array(firsts), pq.Array(lasts)); err != nil {
+			return err
+		}
+	}
+
+	ranges, err := json.Marshal(archive.Ranges)
+	if err != nil {
+		return err
+	}
+	if _, err := t.tx.StmtContext(ctx, t.repo.stmtInsertArchive).ExecContext(ctx, archive.ID, archive.Partition,
+		archive.File, archive.SHA256, archive.Events, archive.Dropped, ranges, archive.Signature); err != nil {
+		return fmt.Errorf("failed to record archive: %w", err)
+	}
+	_, err = t.tx.StmtContext(ctx, t.repo.stmtInsertEvent).ExecContext(ctx, eventArgs(event)...)
+	return err
+}
+
+func (t *archiveTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *archiveTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+func (r *archiveRepository) prepareStatements() {
+	var err error
+	r.stmtCreatePartition, err = r.connPool.Prepare(`
+		SELECT create_event_partition($1)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectPartition, err = r.connPool.Prepare(`
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'event'::regclass
+		ORDER BY c.relname
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtInsertArchive, err = r.connPool.Prepare(`
+		INSERT INTO event_archive (id, partition, file, sha256, events, dropped, ranges, signature)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtInsertEvent, err = r.connPool.Prepare(`
+		INSERT INTO event (uuid, type, body, ip, user_agent, request_id, session_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (r *archiveRepository) Close() error {
+	var err error
+	for _, stmt := range []*sql.Stmt{
+		r.stmtCreatePartition,
+		r.stmtSelectPartition,
+		r.stmtInsertArchive,
+		r.stmtInsertEvent,
+	} {
+		if e := stmt.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
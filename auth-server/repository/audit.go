@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	"github.com/dgyurics/auth/auth-server/model"
+)
+
+// AuditRepository is an interface for reading the hash chain of the event table and its checkpoints
+type AuditRepository interface {
+	// ChainEntries returns up to limit entries of the hash chain following the entry afterSeq, in chain order.
+	ChainEntries(ctx context.Context, afterSeq int64, limit int) ([]*model.ChainEntry, error)
+	// ChainHead returns the head of the hash chain.
+	ChainHead(ctx context.Context) (*model.ChainHead, error)
+	// CreateCheckpoint records checkpoint, unless one of the same entry exists.
+	CreateCheckpoint(ctx context.Context, checkpoint *model.Checkpoint) error
+	// LatestCheckpoint returns the checkpoint of the latest entry, or nil when there is none.
+	LatestCheckpoint(ctx context.Context) (*model.Checkpoint, error)
+	// Checkpoints returns every checkpoint, in chain order.
+	Checkpoints(ctx context.Context) ([]*model.Checkpoint, error)
+	// Archives returns every archive, whose ranges of the chain have been deleted, oldest first.
+	Archives(ctx context.Context) ([]*model.Archive, error)
+	Close() error
+}
+
+type auditRepository struct {
+	*DbClient
+	stmtSelectEntries          *sql.Stmt // Prepared statement for selecting a page of the hash chain
+	stmtSelectHead             *sql.Stmt // Prepared statement for selecting the head of the hash chain
+	stmtInsertCheckpoint       *sql.Stmt // Prepared statement for inserting into auth.event_checkpoint
+	stmtSelectLatestCheckpoint *sql.Stmt // Prepared statement for selecting the latest checkpoint
+	stmtSelectCheckpoints      *sql.Stmt // Prepared statement for selecting every checkpoint
+	stmtSelectArchives         *sql.Stmt // Prepared statement for selecting every archive
+}
+
+// NewAuditRepository creates a new audit repository
+func NewAuditRepository(c *DbClient) AuditRepository {
+	repo := &auditRepository{
+		DbClient: c,
+	}
+	repo.prepareStatements()
+	return repo
+}
+
+func (r *auditRepository) ChainEntries(ctx context.Context, afterSeq int64, limit int) ([]*model.ChainEntry, error) {
+	rows, err := r.stmtSelectEntries.QueryContext(ctx, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*model.ChainEntry, 0)
+	for rows.Next() {
+		var entry model.ChainEntry
+		var body sql.NullString
+		if err := rows.Scan(&entry.Seq, &entry.EventID, &entry.UUID, &entry.Type, &entry.IP, &entry.UserAgent,
+			&entry.RequestID, &entry.SessionID, &entry.CreatedAt, &body, &entry.BodyHash, &entry.ContextDigest, &entry.PrevHash, &entry.Hash); err != nil {
+			return nil, err
+		}
+		if body.Valid {
+			entry.Body = []byte(body.String)
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
+
+func (r *auditRepository) ChainHead(ctx context.Context) (*model.ChainHead, error) {
+	var head model.ChainHead
+	if err := r.stmtSelectHead.QueryRowContext(ctx).Scan(&head.Seq, &head.Hash); err != nil {
+		return nil, err
+	}
+	return &head, nil
+}
+
+func (r *auditRepository) CreateCheckpoint(ctx context.Context, checkpoint *model.Checkpoint) error {
+	_, err := r.stmtInsertCheckpoint.ExecContext(ctx, checkpoint.Seq, checkpoint.Hash, checkpoint.Signature)
+	return err
+}
+
+func (r *auditRepository) LatestCheckpoint(ctx context.Context) (*model.Checkpoint, error) {
+	var checkpoint model.Checkpoint
+	err := r.stmtSelectLatestCheckpoint.QueryRowContext(ctx).Scan(&checkpoint.Seq, &checkpoint.Hash,
+		&checkpoint.Signature, &checkpoint.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+func (r *auditRepository) Checkpoints(ctx context.Context) ([]*model.Checkpoint, error) {
+	rows, err := r.stmtSelectCheckpoints.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := make([]*model.Checkpoint, 0)
+	for rows.Next() {
+		var checkpoint model.Checkpoint
+		if err := rows.Scan(&checkpoint.Seq, &checkpoint.Hash, &checkpoint.Signature, &checkpoint.CreatedAt); err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, &checkpoint)
+	}
+	return checkpoints, rows.Err()
+}
+
+func (r *auditRepository) Archives(ctx context.Context) ([]*model.Archive, error) {
+	rows, err := r.stmtSelectArchives.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	archives := make([]*model.Archive, 0)
+	for rows.Next() {
+		var archive model.Archive
+		var ranges []byte
+		if err := rows.Scan(&archive.ID, &archive.Partition, &archive.File, &archive.SHA256, &archive.Events,
+			&archive.Dropped, &ranges, &archive.Signature, &archive.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(ranges, &archive.Ranges); err != nil {
+			return nil, err
+		}
+		archives = append(archives, &archive)
+	}
+	return archives, rows.Err()
+}
+
+func (r *auditRepository) prepareStatements() {
+	var err error
+	// the fields are selected in the canonical text form hashed by the chain_event trigger
+	r.stmtSelectEntries, err = r.connPool.Prepare(`
+		SELECT chain_seq, id, uuid::text, type, coalesce(host(ip), ''), coalesce(user_agent, ''),
+			coalesce(request_id, ''), coalesce(session_id, ''),
+			coalesce(to_char(created_at, 'YYYY-MM-DD"T"HH24:MI:SS.US'), ''),
+			body::text, body_hash, context_digest, prev_hash, hash
+		FROM event
+		WHERE chain_seq > $1
+		ORDER BY chain_seq
+		LIMIT $2
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectHead, err = r.connPool.Prepare(`
+		SELECT seq, hash
+		FROM event_chain
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtInsertCheckpoint, err = r.connPool.Prepare(`
+		INSERT INTO event_checkpoint (chain_seq, hash, signature)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (chain_seq) DO NOTHING
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectLatestCheckpoint, err = r.connPool.Prepare(`
+		SELECT chain_seq, hash, signature, created_at
+		FROM event_checkpoint
+		ORDER BY chain_seq DESC
+		LIMIT 1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectCheckpoints, err = r.connPool.Prepare(`
+		SELECT chain_seq, hash, signature, created_at
+		FROM event_checkpoint
+		ORDER BY chain_seq
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectArchives, err = r.connPool.Prepare(`
+		SELECT id, partition, file, sha256, events, dropped, ranges, signature, created_at
+		FROM event_archive
+		ORDER BY created_at
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (r *auditRepository) Close() error {
+	var err error
+	for _, stmt := range []*sql.Stmt{
+		r.stmtSelectEntries,
+		r.stmtSelectHead,
+		r.stmtInsertCheckpoint,
+		r.stmtSelectLatestCheckpoint,
+		r.stmtSelectCheckpoints,
+		r.stmtSelectArchives,
+	} {
+		if e := stmt.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
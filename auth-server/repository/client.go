@@ -2,18 +2,29 @@ package repository
 
 import (
 	"database/sql"
-	"fmt"
+	"errors"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dgyurics/auth/auth-server/config"
-	_ "github.com/lib/pq" // driver for PostgreSQL that provides an implementation of the database/sql package
+	"github.com/lib/pq" // driver for PostgreSQL that provides an implementation of the database/sql package
 )
 
+// uniqueViolation is the PostgreSQL error code raised when a unique constraint is violated.
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const uniqueViolation = "23505"
+
+// foreignKeyViolation is the PostgreSQL error code raised when a foreign key constraint is violated.
+const foreignKeyViolation = "23503"
+
 // DbClient is a wrapper around the sql.DB struct
 // It is used to connect to the database and execute queries
 // Safe for concurrent use by multiple goroutines
 type DbClient struct {
 	connPool *sql.DB
+	schema   string // schema of the search_path of every connection
 }
 
 // NewDBClient returns a new instance of DbClient
@@ -22,23 +33,72 @@ func NewDBClient() *DbClient {
 }
 
 // Connect establishes a connection to PostgreSQL database
-// when provided with a valid config
-// FIXME unable to specify schema name via search_path=auth
-// https://github.com/go-pg/pg/issues/351#issuecomment-474875596
+// when provided with a valid config. Queries do not qualify the objects
+// they refer to, which are resolved in config.Schema, the search_path of
+// every connection.
 func (c *DbClient) Connect(config config.PostgreSQL) {
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s application_name=%s",
-		config.Host, config.Port, config.User, config.Password, config.Dbname, config.Sslmode, config.AppName)
+	if err := config.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	connStr, err := connString(config)
+	if err != nil {
+		log.Fatal(err)
+	}
 	connection, err := sql.Open("postgres", connStr)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
+	connection.SetMaxOpenConns(config.MaxOpenConns)
+	connection.SetMaxIdleConns(config.MaxIdleConns)
+	connection.SetConnMaxLifetime(time.Duration(config.ConnMaxLifetime) * time.Second)
+	connection.SetConnMaxIdleTime(time.Duration(config.ConnMaxIdleTime) * time.Second)
 	err = connection.Ping()
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
 	c.connPool = connection
+	c.schema = config.Schema
+}
+
+// connString returns the connection string of config, from its URL when set.
+// Settings which are empty are left to the defaults of the driver.
+func connString(config config.PostgreSQL) (string, error) {
+	settings := make([]string, 0)
+	if config.URL != "" {
+		connStr, err := pq.ParseURL(config.URL)
+		if err != nil {
+			return "", err
+		}
+		settings = append(settings, connStr)
+	} else {
+		for _, setting := range [][2]string{
+			{"host", config.Host},
+			{"port", strconv.Itoa(config.Port)},
+			{"user", config.User},
+			{"password", config.Password},
+			{"dbname", config.Dbname},
+			{"sslmode", config.Sslmode},
+			{"sslcert", config.Sslcert},
+			{"sslkey", config.Sslkey},
+			{"sslrootcert", config.Sslrootcert},
+			{"application_name", config.AppName},
+			{"connect_timeout", strconv.Itoa(config.ConnectTimeout)},
+		} {
+			if setting[1] != "" {
+				settings = append(settings, setting[0]+"="+quoteSetting(setting[1]))
+			}
+		}
+	}
+	// the search_path of the URL, if any, is overridden by the schema
+	settings = append(settings, "search_path="+config.Schema)
+	return strings.Join(settings, " "), nil
+}
+
+// quoteSetting quotes value as a setting of a connection string, so it may contain spaces and quotes.
+func quoteSetting(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
 }
 
 // Close closes the database and prevents new queries from starting.
@@ -52,3 +112,15 @@ func (c *DbClient) Close() {
 		log.Fatal(err)
 	}
 }
+
+// isUniqueViolation reports whether err was caused by a unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// isForeignKeyViolation reports whether err was caused by a foreign key constraint violation.
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/dgyurics/auth/auth-server/model"
+)
+
+// CursorRepository is an interface for consumers of the event table, which read events
+// in ID order and record the position they have reached under a name.
+type CursorRepository interface {
+	Acquire(ctx context.Context, name string) (CursorLease, error)
+}
+
+// CursorLease grants a single replica the exclusive right to consume events under a name.
+// The lease is held until released, or until the database connection holding it is lost,
+// in which case every method returns an error.
+type CursorLease interface {
+	Position(ctx context.Context) (int64, error)
+	Events(ctx context.Context, after int64, limit int) ([]*model.Event, error)
+	Advance(ctx context.Context, eventID int64) error
+	Release() error
+}
+
+type cursorRepository struct {
+	*DbClient
+}
+
+// NewCursorRepository creates a new cursor repository
+func NewCursorRepository(c *DbClient) CursorRepository {
+	return &cursorRepository{
+		DbClient: c,
+	}
+}
+
+// Acquire returns the lease of the cursor name, or nil when it is held by another replica.
+// The lease is a session level advisory lock, so it is held by a dedicated connection,
+// on which every query of the lease is run.
+func (r *cursorRepository) Acquire(ctx context.Context, name string) (CursorLease, error) {
+	conn, err := r.connPool.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext(current_schema() || '.event_cursor:' || $1))`,
+		name).Scan(&acquired); err != nil || !acquired {
+		return nil, errors.Join(err, conn.Close())
+	}
+	return &cursorLease{conn, name}, nil
+}
+
+type cursorLease struct {
+	conn *sql.Conn
+	name string
+}
+
+// Position returns the ID of the last event consumed, or 0 when none have been.
+func (l *cursorLease) Position(ctx context.Context) (int64, error) {
+	var position int64
+	err := l.conn.QueryRowContext(ctx, `
+		SELECT event_id
+		FROM event_cursor
+		WHERE name = $1
+	`, l.name).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return position, err
+}
+
+// Events returns up to limit events with an ID greater than after, in ID order.
+func (l *cursorLease) Events(ctx context.Context, after int64, limit int) ([]*model.Event, error) {
+	rows, err := l.conn.QueryContext(ctx, `
+		SELECT id, uuid, type, body, ip, user_agent, request_id, session_id, created_at
+		FROM event
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+	`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*model.Event, 0, limit)
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Advance records eventID as the ID of the last event consumed. The position never moves
+// back, as consumers such as projections may have recorded a later position themselves.
+func (l *cursorLease) Advance(ctx context.Context, eventID int64) error {
+	_, err := l.conn.ExecContext(ctx, `
+		INSERT INTO event_cursor (name, event_id)
+		VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE
+		SET event_id = GREATEST(event_cursor.event_id, excluded.event_id),
+			updated_at = (now() at time zone 'utc')
+	`, l.name, eventID)
+	return err
+}
+
+// Release releases the lease and returns the connection holding it to the pool.
+func (l *cursorLease) Release() error {
+	_, err := l.conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext(current_schema() || '.event_cursor:' || $1))`, l.name)
+	return errors.Join(err, l.conn.Close())
+}
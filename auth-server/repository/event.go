@@ -4,19 +4,25 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"time"
 
 	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // EventRepository is an interface for interacting with the event table
 type EventRepository interface {
 	CreateEvent(ctx context.Context, event *model.Event) error
+	GetEvents(ctx context.Context, filter *model.EventFilter) ([]*model.Event, error)
 	Close() error
 }
 
 type eventRepository struct {
 	*DbClient
-	stmtInsertEvent *sql.Stmt // Prepared statement for inserting into auth.event
+	stmtInsertEvent       *sql.Stmt // Prepared statement for inserting into auth.event
+	stmtSelectEvents      *sql.Stmt // Prepared statement for selecting a page of events of an object
+	stmtSelectEventsOfAll *sql.Stmt // Prepared statement for selecting a page of events of every object
 }
 
 // NewEventRepository creates a new event repository
@@ -29,21 +35,134 @@ func NewEventRepository(c *DbClient) EventRepository {
 }
 
 func (r *eventRepository) CreateEvent(ctx context.Context, event *model.Event) error {
-	_, err := r.stmtInsertEvent.ExecContext(ctx, event.UUID, event.Type, event.Body)
+	_, err := r.stmtInsertEvent.ExecContext(ctx, eventArgs(event)...)
 	return err
 }
 
+// GetEvents returns the events matching filter, newest first. The events of a single object
+// are selected by a statement of their own, so they are read through the index on uuid.
+func (r *eventRepository) GetEvents(ctx context.Context, filter *model.EventFilter) ([]*model.Event, error) {
+	types := make([]string, 0, len(filter.Types))
+	for _, eventType := range filter.Types {
+		types = append(types, string(eventType))
+	}
+	stmt := r.stmtSelectEventsOfAll
+	args := []interface{}{filter.Before, filter.Limit,
+		pq.Array(types), nullTime(filter.Since), nullTime(filter.Until), nullString(filter.IP)}
+	if filter.UUID != uuid.Nil {
+		stmt = r.stmtSelectEvents
+		args = append(args, filter.UUID)
+	}
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*model.Event, 0)
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// eventArgs returns the arguments of a statement inserting event into the uuid, type, body, ip,
+// user_agent, request_id and session_id columns of auth.event, whose context columns are NULL
+// when the event was not caused by a request.
+func eventArgs(event *model.Event) []interface{} {
+	ec := event.Context
+	if ec == nil {
+		ec = &model.EventContext{}
+	}
+	return []interface{}{event.UUID, event.Type, event.Body,
+		nullString(ec.IP), nullString(ec.UserAgent), nullString(ec.RequestID), nullString(ec.SessionID)}
+}
+
+// scanEvent scans the id, uuid, type, body, ip, user_agent, request_id, session_id and created_at
+// columns of auth.event, following dest, from row. The body is upcast to the current version of its schema.
+func scanEvent(row scanner, dest ...interface{}) (*model.Event, error) {
+	var event model.Event
+	var body []byte
+	var ip, userAgent, requestID, sessionID sql.NullString
+	dest = append(dest, &event.ID, &event.UUID, &event.Type, &body, &ip, &userAgent, &requestID, &sessionID, &event.CreatedAt)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	event.Body = body
+	if ip.Valid || userAgent.Valid || requestID.Valid || sessionID.Valid {
+		event.Context = &model.EventContext{
+			IP:        ip.String,
+			UserAgent: userAgent.String,
+			RequestID: requestID.String,
+			SessionID: sessionID.String,
+		}
+	}
+	// older bodies are read in the current version of their schema
+	if err := event.Upcast(); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// nullString returns s, or NULL when s is empty.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullTime returns t in UTC, the time zone of the created_at column, or NULL when t is zero.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
 func (r *eventRepository) prepareStatements() {
 	var err error
 	r.stmtInsertEvent, err = r.connPool.Prepare(`
-		INSERT INTO auth.event (uuid, type, body)
-		VALUES ($1, $2, $3)
+		INSERT INTO event (uuid, type, body, ip, user_agent, request_id, session_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`)
 	if err != nil {
 		log.Fatal(err)
 	}
+	r.stmtSelectEvents, err = r.connPool.Prepare(selectEvents(`uuid = $7 AND`))
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectEventsOfAll, err = r.connPool.Prepare(selectEvents(``))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// selectEvents returns the query selecting a page of the events matching the filter of GetEvents,
+// restricted by predicate, which may refer to the parameters following those of the filter.
+func selectEvents(predicate string) string {
+	return `
+		SELECT id, uuid, type, body, ip, user_agent, request_id, session_id, created_at
+		FROM event
+		WHERE ` + predicate + ` ($1 = 0 OR id < $1)
+			AND (cardinality($3::text[]) = 0 OR type = ANY($3))
+			AND ($4::timestamp IS NULL OR created_at >= $4)
+			AND ($5::timestamp IS NULL OR created_at < $5)
+			AND ($6::inet IS NULL OR ip <<= $6)
+		ORDER BY id DESC
+		LIMIT $2
+	`
 }
 
 func (r *eventRepository) Close() error {
-	return r.stmtInsertEvent.Close()
+	var err error
+	for _, stmt := range []*sql.Stmt{
+		r.stmtInsertEvent,
+		r.stmtSelectEvents,
+		r.stmtSelectEventsOfAll,
+	} {
+		if e := stmt.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
 }
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
+)
+
+// IdentityRepository is an interface for interacting with the external_identity table
+type IdentityRepository interface {
+	CreateIdentity(ctx context.Context, identity *model.ExternalIdentity) error
+	GetIdentity(ctx context.Context, provider string, subject string) (*model.ExternalIdentity, error)
+	GetUserIdentities(ctx context.Context, userID uuid.UUID) ([]*model.ExternalIdentity, error)
+	Close() error
+}
+
+type identityRepository struct {
+	*DbClient
+	stmtInsertIdentity *sql.Stmt // Prepared statement for inserting into auth.external_identity
+	stmtSelectIdentity *sql.Stmt // Prepared statement for selecting an identity by provider and subject
+	stmtSelectByUser   *sql.Stmt // Prepared statement for selecting the identities linked to a user
+}
+
+// NewIdentityRepository creates a new external identity repository
+func NewIdentityRepository(c *DbClient) IdentityRepository {
+	repo := &identityRepository{
+		DbClient: c,
+	}
+	repo.prepareStatements()
+	return repo
+}
+
+func (r *identityRepository) CreateIdentity(ctx context.Context, identity *model.ExternalIdentity) error {
+	var email sql.NullString
+	if identity.Email != "" {
+		email = sql.NullString{String: identity.Email, Valid: true}
+	}
+	_, err := r.stmtInsertIdentity.ExecContext(ctx, identity.Provider, identity.Subject, identity.UserID, email)
+	if isUniqueViolation(err) {
+		return &model.ConflictError{Message: "identity already linked"}
+	}
+	return err
+}
+
+func (r *identityRepository) GetIdentity(ctx context.Context, provider string, subject string) (*model.ExternalIdentity, error) {
+	var (
+		identity model.ExternalIdentity
+		email    sql.NullString
+	)
+	err := r.stmtSelectIdentity.QueryRowContext(ctx, provider, subject).Scan(&identity.Provider, &identity.Subject,
+		&identity.UserID, &email, &identity.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &model.NotFoundError{Resource: "identity"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	identity.Email = email.String
+	return &identity, nil
+}
+
+// GetUserIdentities returns the identities linked to the user, oldest first.
+func (r *identityRepository) GetUserIdentities(ctx context.Context, userID uuid.UUID) ([]*model.ExternalIdentity, error) {
+	rows, err := r.stmtSelectByUser.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]*model.ExternalIdentity, 0)
+	for rows.Next() {
+		var (
+			identity model.ExternalIdentity
+			email    sql.NullString
+		)
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identity.Email = email.String
+		identities = append(identities, &identity)
+	}
+	return identities, rows.Err()
+}
+
+func (r *identityRepository) prepareStatements() {
+	var err error
+	r.stmtInsertIdentity, err = r.connPool.Prepare(`
+		INSERT INTO external_identity (provider, subject, user_id, email)
+		VALUES ($1, $2, $3, $4)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectIdentity, err = r.connPool.Prepare(`
+		SELECT provider, subject, user_id, email, created_at
+		FROM external_identity
+		WHERE provider = $1 AND subject = $2
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectByUser, err = r.connPool.Prepare(`
+		SELECT provider, subject, user_id, email, created_at
+		FROM external_identity
+		WHERE user_id = $1
+		ORDER BY created_at
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// https://go.dev/doc/database/prepared-statements
+func (r *identityRepository) Close() error {
+	var err error
+	for _, stmt := range []*sql.Stmt{
+		r.stmtInsertIdentity,
+		r.stmtSelectIdentity,
+		r.stmtSelectByUser,
+	} {
+		if e := stmt.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
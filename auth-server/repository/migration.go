@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/lib/pq"
+)
+
+// MigrationRepository is an interface for applying the migrations of the database schema,
+// recording those applied in the schema_migrations table.
+type MigrationRepository interface {
+	// Lock waits until no other replica is migrating, and returns the session holding the lock.
+	Lock(ctx context.Context) (MigrationSession, error)
+}
+
+// MigrationSession grants a single replica the exclusive right to migrate the schema. The lock
+// is held until released, or until the database connection holding it is lost.
+type MigrationSession interface {
+	// Applied returns the migrations applied, without their scripts, in version order.
+	Applied(ctx context.Context) ([]*model.Migration, error)
+	// Apply runs the up script of migration and records it, in a single transaction. Values the script
+	// requires which SQL cannot compute are prepared beforehand, in the same transaction.
+	Apply(ctx context.Context, migration *model.Migration) error
+	// Revert runs the down script of migration and deletes its record, in a single transaction.
+	Revert(ctx context.Context, migration *model.Migration) error
+	// Exists reports whether the schema contains a table, index or function named name.
+	Exists(ctx context.Context, name string) (bool, error)
+	// Record records migration as applied, without running its up script.
+	Record(ctx context.Context, migration *model.Migration) error
+	Release() error
+}
+
+type migrationRepository struct {
+	*DbClient
+}
+
+// NewMigrationRepository creates a new migration repository
+func NewMigrationRepository(c *DbClient) MigrationRepository {
+	return &migrationRepository{
+		DbClient: c,
+	}
+}
+
+// Lock takes a session level advisory lock, so it is held by a dedicated connection, on which every
+// query of the session is run. The schema of the connection and its schema_migrations table are created
+// once it is held, so that migrations create their objects in the schema.
+func (r *migrationRepository) Lock(ctx context.Context) (MigrationSession, error) {
+	conn, err := r.connPool.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the schema may not exist yet, so the lock is named after the configured schema rather than current_schema()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1 || '.schema_migrations'))`, r.schema); err != nil {
+		return nil, errors.Join(err, conn.Close())
+	}
+	session := &migrationSession{conn, r.schema}
+	if _, err := conn.ExecContext(ctx, `
+		CREATE SCHEMA IF NOT EXISTS `+pq.QuoteIdentifier(r.schema)+`;
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    integer PRIMARY KEY,
+			name       text NOT NULL,
+			checksum   char(64) NOT NULL,
+			applied_at timestamp without time zone NOT NULL DEFAULT (now() at time zone 'utc')
+		);
+	`); err != nil {
+		return nil, errors.Join(err, session.Release())
+	}
+	return session, nil
+}
+
+type migrationSession struct {
+	conn   *sql.Conn
+	schema string
+}
+
+func (s *migrationSession) Applied(ctx context.Context) ([]*model.Migration, error) {
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT version, name, checksum, applied_at
+		FROM schema_migrations
+		ORDER BY version
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	migrations := make([]*model.Migration, 0)
+	for rows.Next() {
+		var migration model.Migration
+		if err := rows.Scan(&migration.Version, &migration.Name, &migration.Checksum, &migration.AppliedAt); err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, &migration)
+	}
+	return migrations, rows.Err()
+}
+
+// prepared are run before the up script of the migration of the same name, in its transaction, creating
+// temporary tables of the values the script requires which SQL cannot compute.
+var prepared = map[string]func(ctx context.Context, tx *sql.Tx) error{
+	"username_key": prepareUsernameKeys,
+}
+
+// Apply runs the script with the simple query protocol, which allows several statements.
+func (s *migrationSession) Apply(ctx context.Context, migration *model.Migration) error {
+	return s.transaction(ctx, prepared[migration.Name], migration.Up, `
+		INSERT INTO schema_migrations (version, name, checksum)
+		VALUES ($1, $2, $3)
+	`, migration.Version, migration.Name, migration.Checksum)
+}
+
+func (s *migrationSession) Revert(ctx context.Context, migration *model.Migration) error {
+	return s.transaction(ctx, nil, migration.Down, `
+		DELETE FROM schema_migrations
+		WHERE version = $1
+	`, migration.Version)
+}
+
+func (s *migrationSession) Exists(ctx context.Context, name string) (bool, error) {
+	var exists bool
+	err := s.conn.QueryRowContext(ctx, `
+		WITH schema AS (SELECT oid FROM pg_namespace WHERE nspname = current_schema())
+		SELECT EXISTS (SELECT 1 FROM pg_class, schema WHERE relname = $1 AND relnamespace = schema.oid)
+			OR EXISTS (SELECT 1 FROM pg_proc, schema WHERE proname = $1 AND pronamespace = schema.oid)
+	`, name).Scan(&exists)
+	return exists, err
+}
+
+func (s *migrationSession) Record(ctx context.Context, migration *model.Migration) error {
+	_, err := s.conn.ExecContext(ctx, `
+		INSERT INTO schema_migrations (version, name, checksum)
+		VALUES ($1, $2, $3)
+	`, migration.Version, migration.Name, migration.Checksum)
+	return err
+}
+
+// transaction runs prepare, unless nil, script, then query with args, in a single transaction.
+func (s *migrationSession) transaction(ctx context.Context, prepare func(ctx context.Context, tx *sql.Tx) error,
+	script string, query string, args ...interface{}) error {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if prepare != nil {
+		if err := prepare(ctx, tx); err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+// prepareUsernameKeys creates the temporary table username_keys, holding the model.UsernameKey of every user,
+// for the username_key migration. Fails when users share a key, as only one of them could sign in.
+func prepareUsernameKeys(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TEMPORARY TABLE username_keys (
+			id  uuid PRIMARY KEY,
+			key text NOT NULL
+		) ON COMMIT DROP
+	`); err != nil {
+		return err
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id, username FROM "user"`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ids, keys := make([]string, 0), make([]string, 0)
+	usernames := make(map[string]string) // by key
+	for rows.Next() {
+		var id, username string
+		if err := rows.Scan(&id, &username); err != nil {
+			return err
+		}
+		key := model.UsernameKey(username)
+		if other, ok := usernames[key]; ok {
+			return fmt.Errorf("usernames %q and %q share the key %q, rename one of them before migrating", other, username, key)
+		}
+		usernames[key] = username
+		ids, keys = append(ids, id), append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO username_keys (id, key)
+		SELECT * FROM unnest($1::uuid[], $2::text[])
+	`, pq.Array(ids), pq.Array(keys))
+	return err
+}
+
+// Release releases the lock and returns the connection holding it to the pool.
+func (s *migrationSession) Release() error {
+	_, err := s.conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1 || '.schema_migrations'))`, s.schema)
+	return errors.Join(err, s.conn.Close())
+}
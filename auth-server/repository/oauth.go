@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// OAuthRepository is an interface for interacting with the oauth_client, oauth_consent
+// and oauth_refresh_token tables
+type OAuthRepository interface {
+	CreateClient(ctx context.Context, client *model.OAuthClient) error
+	GetClient(ctx context.Context, clientID string) (*model.OAuthClient, error)
+	GetConsent(ctx context.Context, userID uuid.UUID, clientID string) ([]string, error)
+	SaveConsent(ctx context.Context, userID uuid.UUID, clientID string, scopes []string) error
+	CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash string) (*model.RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, hash string) error
+	Close() error
+}
+
+type oauthRepository struct {
+	*DbClient
+	stmtInsertClient       *sql.Stmt // Prepared statement for inserting into auth.oauth_client
+	stmtSelectClient       *sql.Stmt // Prepared statement for selecting a client by ID
+	stmtSelectConsent      *sql.Stmt // Prepared statement for selecting the scopes granted to a client
+	stmtUpsertConsent      *sql.Stmt // Prepared statement for inserting or updating auth.oauth_consent
+	stmtInsertRefreshToken *sql.Stmt // Prepared statement for inserting into auth.oauth_refresh_token
+	stmtSelectRefreshToken *sql.Stmt // Prepared statement for selecting a refresh token by hash
+	stmtRevokeRefreshToken *sql.Stmt // Prepared statement for revoking a refresh token
+}
+
+// NewOAuthRepository creates a new OAuth repository
+func NewOAuthRepository(c *DbClient) OAuthRepository {
+	repo := &oauthRepository{
+		DbClient: c,
+	}
+	repo.prepareStatements()
+	return repo
+}
+
+func (r *oauthRepository) CreateClient(ctx context.Context, client *model.OAuthClient) error {
+	var secret sql.NullString
+	if client.Secret != "" {
+		secret = sql.NullString{String: client.Secret, Valid: true}
+	}
+	_, err := r.stmtInsertClient.ExecContext(ctx, client.ID, secret, client.Name,
+		pq.Array(client.RedirectURIs), pq.Array(client.Scopes))
+	if isUniqueViolation(err) {
+		return &model.ConflictError{Message: "client already exists"}
+	}
+	return err
+}
+
+func (r *oauthRepository) GetClient(ctx context.Context, clientID string) (*model.OAuthClient, error) {
+	var (
+		client model.OAuthClient
+		secret sql.NullString
+	)
+	err := r.stmtSelectClient.QueryRowContext(ctx, clientID).Scan(&client.ID, &secret, &client.Name,
+		pq.Array(&client.RedirectURIs), pq.Array(&client.Scopes), &client.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &model.NotFoundError{Resource: "client"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	client.Secret = secret.String
+	return &client, nil
+}
+
+// GetConsent returns the scopes the user has granted to the client, or nil if none.
+func (r *oauthRepository) GetConsent(ctx context.Context, userID uuid.UUID, clientID string) ([]string, error) {
+	var scopes []string
+	err := r.stmtSelectConsent.QueryRowContext(ctx, userID, clientID).Scan(pq.Array(&scopes))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return scopes, err
+}
+
+func (r *oauthRepository) SaveConsent(ctx context.Context, userID uuid.UUID, clientID string, scopes []string) error {
+	_, err := r.stmtUpsertConsent.ExecContext(ctx, userID, clientID, pq.Array(scopes))
+	return err
+}
+
+func (r *oauthRepository) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	_, err := r.stmtInsertRefreshToken.ExecContext(ctx, token.Hash, token.ClientID, token.UserID,
+		pq.Array(token.Scopes), token.IssuedAt.UTC(), token.ExpiresAt.UTC())
+	return err
+}
+
+func (r *oauthRepository) GetRefreshToken(ctx context.Context, hash string) (*model.RefreshToken, error) {
+	var (
+		token     model.RefreshToken
+		revokedAt sql.NullTime
+	)
+	err := r.stmtSelectRefreshToken.QueryRowContext(ctx, hash).Scan(&token.Hash, &token.ClientID, &token.UserID,
+		pq.Array(&token.Scopes), &token.IssuedAt, &token.ExpiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &model.NotFoundError{Resource: "refresh token"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return &token, nil
+}
+
+// RevokeRefreshToken revokes the refresh token.
+// Returns a model.NotFoundError when there is no such token, or it was already revoked.
+func (r *oauthRepository) RevokeRefreshToken(ctx context.Context, hash string) error {
+	res, err := r.stmtRevokeRefreshToken.ExecContext(ctx, hash, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return &model.NotFoundError{Resource: "active refresh token"}
+	}
+	return nil
+}
+
+func (r *oauthRepository) prepareStatements() {
+	var err error
+	r.stmtInsertClient, err = r.connPool.Prepare(`
+		INSERT INTO oauth_client (id, secret, name, redirect_uris, scopes)
+		VALUES ($1, $2, $3, $4, $5)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectClient, err = r.connPool.Prepare(`
+		SELECT id, secret, name, redirect_uris, scopes, created_at
+		FROM oauth_client
+		WHERE id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectConsent, err = r.connPool.Prepare(`
+		SELECT scopes
+		FROM oauth_consent
+		WHERE user_id = $1 AND client_id = $2
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtUpsertConsent, err = r.connPool.Prepare(`
+		INSERT INTO oauth_consent (user_id, client_id, scopes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = EXCLUDED.scopes
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtInsertRefreshToken, err = r.connPool.Prepare(`
+		INSERT INTO oauth_refresh_token (token_hash, client_id, user_id, scopes, issued_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectRefreshToken, err = r.connPool.Prepare(`
+		SELECT token_hash, client_id, user_id, scopes, issued_at, expires_at, revoked_at
+		FROM oauth_refresh_token
+		WHERE token_hash = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtRevokeRefreshToken, err = r.connPool.Prepare(`
+		UPDATE oauth_refresh_token
+		SET revoked_at = $2
+		WHERE token_hash = $1 AND revoked_at IS NULL
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// https://go.dev/doc/database/prepared-statements
+func (r *oauthRepository) Close() error {
+	var err error
+	for _, stmt := range []*sql.Stmt{
+		r.stmtInsertClient,
+		r.stmtSelectClient,
+		r.stmtSelectConsent,
+		r.stmtUpsertConsent,
+		r.stmtInsertRefreshToken,
+		r.stmtSelectRefreshToken,
+		r.stmtRevokeRefreshToken,
+	} {
+		if e := stmt.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,433 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
+)
+
+// ProjectionRepository is an interface for the projections of the event table into read models,
+// such as the user_activity and login_history tables. The position of each projection is
+// recorded in the event_cursor table, under the name "projection:" followed by its name,
+// in the same transaction as the changes to its read model, so every event is applied once.
+type ProjectionRepository interface {
+	Names() []string
+	Apply(ctx context.Context, name string, events []*model.Event) error
+	Rebuild(ctx context.Context, name string, batchSize int) (int, error)
+	GetUserActivity(ctx context.Context, userID uuid.UUID, logins int) (*model.UserActivity, error)
+	Close() error
+}
+
+// projection maintains a read model from events
+type projection struct {
+	reset []*sql.Stmt // statements clearing the read model
+	apply func(ctx context.Context, tx *sql.Tx, event *model.Event) error
+}
+
+type projectionRepository struct {
+	*DbClient
+	projections             map[string]*projection
+	stmtLockCursor          *sql.Stmt // Prepared statement for locking the cursor of a projection
+	stmtInsertCursor        *sql.Stmt // Prepared statement for inserting the cursor of a projection
+	stmtUpdateCursor        *sql.Stmt // Prepared statement for recording the position of a projection
+	stmtSelectEvents        *sql.Stmt // Prepared statement for selecting the events following a position
+	stmtRegister            *sql.Stmt // Prepared statement for recording the registration of a user
+	stmtLogin               *sql.Stmt // Prepared statement for recording a login in auth.user_activity
+	stmtLogout              *sql.Stmt // Prepared statement for recording signing out of a session
+	stmtLogoutAll           *sql.Stmt // Prepared statement for recording signing out of every session
+	stmtDeleteActivity      *sql.Stmt // Prepared statement for deleting the activity of a user
+	stmtResetActivity       *sql.Stmt // Prepared statement for deleting every row of auth.user_activity
+	stmtInsertLogin         *sql.Stmt // Prepared statement for inserting into auth.login_history
+	stmtDeleteLogins        *sql.Stmt // Prepared statement for deleting the logins of a user
+	stmtResetLogins         *sql.Stmt // Prepared statement for deleting every row of auth.login_history
+	stmtSelectUserActivity  *sql.Stmt // Prepared statement for selecting the activity of a user
+	stmtSelectLoginsForUser *sql.Stmt // Prepared statement for selecting the most recent logins of a user
+}
+
+// NewProjectionRepository creates a new projection repository
+func NewProjectionRepository(c *DbClient) ProjectionRepository {
+	repo := &projectionRepository{
+		DbClient: c,
+	}
+	repo.prepareStatements()
+	repo.projections = map[string]*projection{
+		"user_activity": {reset: []*sql.Stmt{repo.stmtResetActivity}, apply: repo.applyUserActivity},
+		"login_history": {reset: []*sql.Stmt{repo.stmtResetLogins}, apply: repo.applyLoginHistory},
+	}
+	return repo
+}
+
+// Names returns the names of the projections, in alphabetical order.
+func (r *projectionRepository) Names() []string {
+	return []string{"login_history", "user_activity"}
+}
+
+// Apply applies the events following the position of the projection to its read model,
+// and records the last as its position, in a single transaction. Events at or before the
+// position have already been applied, and are skipped.
+func (r *projectionRepository) Apply(ctx context.Context, name string, events []*model.Event) (err error) {
+	p, err := r.projection(name)
+	if err != nil {
+		return err
+	}
+	tx, err := r.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			return
+		}
+		if errRollback := tx.Rollback(); errRollback != nil {
+			log.Println(errRollback)
+		}
+	}()
+
+	position, err := r.lockCursor(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	last := position
+	for _, event := range events {
+		if event.ID <= position {
+			continue
+		}
+		if err = p.apply(ctx, tx, event); err != nil {
+			return fmt.Errorf("failed to apply event %d: %w", event.ID, err)
+		}
+		last = event.ID
+	}
+	_, err = tx.StmtContext(ctx, r.stmtUpdateCursor).ExecContext(ctx, cursorName(name), last)
+	return err
+}
+
+// Rebuild clears the read model of the projection and applies every event to it again in a
+// single transaction, returning the number of events applied. Projecting new events waits
+// until the rebuild commits, then continues from the last event it applied. Events committed
+// during the rebuild with an ID below the last it applied are not projected.
+func (r *projectionRepository) Rebuild(ctx context.Context, name string, batchSize int) (applied int, err error) {
+	p, err := r.projection(name)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := r.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			return
+		}
+		if errRollback := tx.Rollback(); errRollback != nil {
+			log.Println(errRollback)
+		}
+	}()
+
+	if _, err = r.lockCursor(ctx, tx, name); err != nil {
+		return 0, err
+	}
+	for _, stmt := range p.reset {
+		if _, err = tx.StmtContext(ctx, stmt).ExecContext(ctx); err != nil {
+			return 0, err
+		}
+	}
+	var position int64
+	for {
+		var events []*model.Event
+		events, err = r.events(ctx, tx, position, batchSize)
+		if err != nil {
+			return 0, err
+		}
+		for _, event := range events {
+			if err = p.apply(ctx, tx, event); err != nil {
+				return 0, fmt.Errorf("failed to apply event %d: %w", event.ID, err)
+			}
+			position = event.ID
+		}
+		applied += len(events)
+		if len(events) < batchSize {
+			break
+		}
+	}
+	_, err = tx.StmtContext(ctx, r.stmtUpdateCursor).ExecContext(ctx, cursorName(name), position)
+	return applied, err
+}
+
+// GetUserActivity returns the activity of the user, along with their most recent logins.
+// The activity of a user without any is empty.
+func (r *projectionRepository) GetUserActivity(ctx context.Context, userID uuid.UUID, logins int) (*model.UserActivity, error) {
+	activity := &model.UserActivity{UserID: userID}
+	var registeredAt, lastLoginAt sql.NullTime
+	err := r.stmtSelectUserActivity.QueryRowContext(ctx, userID).Scan(&registeredAt, &lastLoginAt,
+		&activity.LoginCount, &activity.ActiveSessions)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if registeredAt.Valid {
+		activity.RegisteredAt = &registeredAt.Time
+	}
+	if lastLoginAt.Valid {
+		activity.LastLoginAt = &lastLoginAt.Time
+	}
+
+	rows, err := r.stmtSelectLoginsForUser.QueryContext(ctx, userID, logins)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activity.Logins = make([]*model.Login, 0, logins)
+	for rows.Next() {
+		var login model.Login
+		if err := rows.Scan(&login.EventID, &login.Provider, &login.CreatedAt); err != nil {
+			return nil, err
+		}
+		activity.Logins = append(activity.Logins, &login)
+	}
+	return activity, rows.Err()
+}
+
+// applyUserActivity applies event to auth.user_activity
+func (r *projectionRepository) applyUserActivity(ctx context.Context, tx *sql.Tx, event *model.Event) error {
+	var err error
+	switch event.Type {
+	case model.AccountCreated:
+		_, err = tx.StmtContext(ctx, r.stmtRegister).ExecContext(ctx, event.UUID, event.CreatedAt)
+	case model.LoggedIn:
+		_, err = tx.StmtContext(ctx, r.stmtLogin).ExecContext(ctx, event.UUID, event.CreatedAt)
+	case model.LoggedOut:
+		_, err = tx.StmtContext(ctx, r.stmtLogout).ExecContext(ctx, event.UUID)
+	case model.LoggedOutAll, model.AccountLocked, model.AccountDisabled:
+		// locking and disabling an account signs the user out of every session
+		_, err = tx.StmtContext(ctx, r.stmtLogoutAll).ExecContext(ctx, event.UUID)
+	case model.AccountDeleted:
+		_, err = tx.StmtContext(ctx, r.stmtDeleteActivity).ExecContext(ctx, event.UUID)
+	}
+	return err
+}
+
+// applyLoginHistory applies event to auth.login_history
+func (r *projectionRepository) applyLoginHistory(ctx context.Context, tx *sql.Tx, event *model.Event) error {
+	switch event.Type {
+	case model.LoggedIn:
+		payload, err := event.Payload()
+		if err != nil {
+			return err
+		}
+		// the body of an erased account is empty
+		var provider string
+		if login, ok := payload.(*model.LoginPayload); ok {
+			provider = login.Provider
+		}
+		_, err = tx.StmtContext(ctx, r.stmtInsertLogin).ExecContext(ctx, event.ID, event.UUID, provider, event.CreatedAt)
+		return err
+	case model.AccountDeleted:
+		_, err := tx.StmtContext(ctx, r.stmtDeleteLogins).ExecContext(ctx, event.UUID)
+		return err
+	}
+	return nil
+}
+
+func (r *projectionRepository) projection(name string) (*projection, error) {
+	p, ok := r.projections[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown projection %q", name)
+	}
+	return p, nil
+}
+
+// lockCursor locks the cursor of the projection until the end of tx, creating it when
+// missing, and returns the position of the projection.
+func (r *projectionRepository) lockCursor(ctx context.Context, tx *sql.Tx, name string) (int64, error) {
+	if _, err := tx.StmtContext(ctx, r.stmtInsertCursor).ExecContext(ctx, cursorName(name)); err != nil {
+		return 0, err
+	}
+	var position int64
+	err := tx.StmtContext(ctx, r.stmtLockCursor).QueryRowContext(ctx, cursorName(name)).Scan(&position)
+	return position, err
+}
+
+// events returns up to limit events with an ID greater than after, in ID order.
+func (r *projectionRepository) events(ctx context.Context, tx *sql.Tx, after int64, limit int) ([]*model.Event, error) {
+	rows, err := tx.StmtContext(ctx, r.stmtSelectEvents).QueryContext(ctx, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*model.Event, 0, limit)
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// cursorName returns the name of the cursor recording the position of the projection.
+func cursorName(projection string) string {
+	return "projection:" + projection
+}
+
+// Prepare the necessary SQL statements
+func (r *projectionRepository) prepareStatements() {
+	var err error
+	r.stmtInsertCursor, err = r.connPool.Prepare(`
+		INSERT INTO event_cursor (name, event_id)
+		VALUES ($1, 0)
+		ON CONFLICT (name) DO NOTHING
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtLockCursor, err = r.connPool.Prepare(`
+		SELECT event_id
+		FROM event_cursor
+		WHERE name = $1
+		FOR UPDATE
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtUpdateCursor, err = r.connPool.Prepare(`
+		UPDATE event_cursor
+		SET event_id = $2, updated_at = (now() at time zone 'utc')
+		WHERE name = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectEvents, err = r.connPool.Prepare(`
+		SELECT id, uuid, type, body, ip, user_agent, request_id, session_id, created_at
+		FROM event
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtRegister, err = r.connPool.Prepare(`
+		INSERT INTO user_activity (user_id, registered_at)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET registered_at = excluded.registered_at
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtLogin, err = r.connPool.Prepare(`
+		INSERT INTO user_activity (user_id, last_login_at, login_count, active_sessions)
+		VALUES ($1, $2, 1, 1)
+		ON CONFLICT (user_id) DO UPDATE
+		SET last_login_at = excluded.last_login_at,
+			login_count = user_activity.login_count + 1,
+			active_sessions = user_activity.active_sessions + 1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtLogout, err = r.connPool.Prepare(`
+		UPDATE user_activity
+		SET active_sessions = GREATEST(active_sessions - 1, 0)
+		WHERE user_id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtLogoutAll, err = r.connPool.Prepare(`
+		UPDATE user_activity
+		SET active_sessions = 0
+		WHERE user_id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtDeleteActivity, err = r.connPool.Prepare(`
+		DELETE FROM user_activity
+		WHERE user_id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtResetActivity, err = r.connPool.Prepare(`
+		DELETE FROM user_activity
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtInsertLogin, err = r.connPool.Prepare(`
+		INSERT INTO login_history (event_id, user_id, provider, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (event_id) DO NOTHING
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtDeleteLogins, err = r.connPool.Prepare(`
+		DELETE FROM login_history
+		WHERE user_id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtResetLogins, err = r.connPool.Prepare(`
+		DELETE FROM login_history
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectUserActivity, err = r.connPool.Prepare(`
+		SELECT registered_at, last_login_at, login_count, active_sessions
+		FROM user_activity
+		WHERE user_id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectLoginsForUser, err = r.connPool.Prepare(`
+		SELECT event_id, provider, created_at
+		FROM login_history
+		WHERE user_id = $1
+		ORDER BY event_id DESC
+		LIMIT $2
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (r *projectionRepository) Close() error {
+	var err error
+	for _, stmt := range []*sql.Stmt{
+		r.stmtLockCursor,
+		r.stmtInsertCursor,
+		r.stmtUpdateCursor,
+		r.stmtSelectEvents,
+		r.stmtRegister,
+		r.stmtLogin,
+		r.stmtLogout,
+		r.stmtLogoutAll,
+		r.stmtDeleteActivity,
+		r.stmtResetActivity,
+		r.stmtInsertLogin,
+		r.stmtDeleteLogins,
+		r.stmtResetLogins,
+		r.stmtSelectUserActivity,
+		r.stmtSelectLoginsForUser,
+	} {
+		if e := stmt.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
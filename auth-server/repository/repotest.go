@@ -5,6 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dgyurics/auth/auth-server/model"
@@ -13,14 +17,15 @@ import (
 
 // MockUserRepository is a mock implementation of the UserRepository interface
 type MockUserRepository struct {
-	Users []*model.User
+	Users  []*model.User
+	Events []*model.Event
 }
 
 // CreateUser creates a new user
 func (r *MockUserRepository) CreateUser(ctx context.Context, user *model.User) error {
 	err := r.GetUser(ctx, user)
 	if err == nil {
-		return errors.New("user already exists")
+		return &model.ConflictError{Message: "username already exists"}
 	}
 	r.Users = append(r.Users, user)
 	return nil
@@ -29,7 +34,7 @@ func (r *MockUserRepository) CreateUser(ctx context.Context, user *model.User) e
 // ExistsUser checks if a user exists
 func (r *MockUserRepository) ExistsUser(_ context.Context, username string) bool {
 	for _, u := range r.Users {
-		if u.Username == username {
+		if model.UsernameKey(u.Username) == model.UsernameKey(username) {
 			return true
 		}
 	}
@@ -39,18 +44,66 @@ func (r *MockUserRepository) ExistsUser(_ context.Context, username string) bool
 // GetUser gets a user by username or ID
 func (r *MockUserRepository) GetUser(_ context.Context, user *model.User) error {
 	for _, u := range r.Users {
-		if u.Username == user.Username {
+		if model.UsernameKey(u.Username) == model.UsernameKey(user.Username) || u.ID == user.ID {
 			user.ID = u.ID
+			user.Username = u.Username
 			user.Password = u.Password
+			user.Status = u.Status
+			if user.Status == "" {
+				user.Status = model.UserActive
+			}
 			return nil
 		}
-		if u.ID == user.ID {
-			user.Username = u.Username
-			user.Password = u.Password
+	}
+	return &model.NotFoundError{Resource: "user"}
+}
+
+// ListUsers gets a page of users ordered by username
+func (r *MockUserRepository) ListUsers(ctx context.Context, search string, limit int, offset int) ([]*model.User, int, error) {
+	matches := make([]*model.User, 0)
+	for _, u := range r.Users {
+		if strings.Contains(strings.ToLower(u.Username), strings.ToLower(search)) {
+			user := &model.User{ID: u.ID}
+			if err := r.GetUser(ctx, user); err != nil {
+				return nil, 0, err
+			}
+			matches = append(matches, model.OmitPassword(user))
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Username < matches[j].Username })
+	total := len(matches)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return matches[offset:end], total, nil
+}
+
+// UpdateStatus sets the status of a user
+func (r *MockUserRepository) UpdateStatus(_ context.Context, userID uuid.UUID, status model.UserStatus, event *model.Event) error {
+	for _, u := range r.Users {
+		if u.ID == userID {
+			u.Status = status
+			r.Events = append(r.Events, event)
+			return nil
+		}
+	}
+	return &model.NotFoundError{Resource: "user"}
+}
+
+// DeleteUser deletes a user
+func (r *MockUserRepository) DeleteUser(_ context.Context, userID uuid.UUID, event *model.Event) error {
+	for i, u := range r.Users {
+		if u.ID == userID {
+			r.Users = append(r.Users[:i], r.Users[i+1:]...)
+			r.Events = append(r.Events, event)
 			return nil
 		}
 	}
-	return errors.New("user not found")
+	return &model.NotFoundError{Resource: "user"}
 }
 
 // Close closes the repository prepared statements
@@ -58,6 +111,30 @@ func (r *MockUserRepository) Close() error {
 	return nil
 }
 
+// UpdateUsername changes the username of a user
+func (r *MockUserRepository) UpdateUsername(_ context.Context, userID uuid.UUID, username string, event *model.Event) error {
+	var user *model.User
+	for _, u := range r.Users {
+		if model.UsernameKey(u.Username) == model.UsernameKey(username) && u.ID != userID {
+			return &model.ConflictError{Message: "username already exists"}
+		}
+		if u.ID == userID {
+			user = u
+		}
+	}
+	if user == nil {
+		return &model.NotFoundError{Resource: "user"}
+	}
+	user.Username = username
+	r.Events = append(r.Events, event)
+	return nil
+}
+
+// EraseUser deletes a user, the mock does not store events to tombstone
+func (r *MockUserRepository) EraseUser(ctx context.Context, userID uuid.UUID, event *model.Event) error {
+	return r.DeleteUser(ctx, userID, event)
+}
+
 // MockEventRepository is a mock implementation of the EventRepository interface
 type MockEventRepository struct {
 	Events []*model.Event
@@ -65,10 +142,53 @@ type MockEventRepository struct {
 
 // CreateEvent creates a new event
 func (r *MockEventRepository) CreateEvent(_ context.Context, event *model.Event) error {
+	event.ID = int64(len(r.Events) + 1)
+	event.CreatedAt = time.Now().UTC()
 	r.Events = append(r.Events, event)
 	return nil
 }
 
+// GetEvents gets the events matching the filter, newest first
+func (r *MockEventRepository) GetEvents(_ context.Context, filter *model.EventFilter) ([]*model.Event, error) {
+	events := make([]*model.Event, 0)
+	for i := len(r.Events) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		event := r.Events[i]
+		if (filter.UUID == uuid.Nil || event.UUID == filter.UUID) && (filter.Before == 0 || event.ID < filter.Before) &&
+			matchesEventFilter(event, filter) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// matchesEventFilter reports whether event has one of the types, was created within the time range,
+// and was caused by a request from the address or CIDR range of filter
+func matchesEventFilter(event *model.Event, filter *model.EventFilter) bool {
+	if (!filter.Since.IsZero() && event.CreatedAt.Before(filter.Since)) ||
+		(!filter.Until.IsZero() && !event.CreatedAt.Before(filter.Until)) {
+		return false
+	}
+	if filter.IP != "" {
+		if event.Context == nil {
+			return false
+		}
+		ip := net.ParseIP(event.Context.IP)
+		if _, network, err := net.ParseCIDR(filter.IP); err == nil {
+			if !network.Contains(ip) {
+				return false
+			}
+		} else if !ip.Equal(net.ParseIP(filter.IP)) {
+			return false
+		}
+	}
+	for _, eventType := range filter.Types {
+		if event.Type == eventType {
+			return true
+		}
+	}
+	return len(filter.Types) == 0
+}
+
 // GenerateUniqueUsername generates a unique username for testing
 func GenerateUniqueUsername() string {
 	rand.Seed(time.Now().UnixNano()) // nolint:staticcheck
@@ -133,3 +253,811 @@ func (r *MockSessionRepository) RemoveSessions(_ context.Context, userID uuid.UU
 func (r *MockSessionRepository) Close() error {
 	return nil
 }
+
+// MockOAuthRepository is a mock implementation of the OAuthRepository interface
+type MockOAuthRepository struct {
+	Clients       map[string]*model.OAuthClient
+	Consents      map[string][]string
+	RefreshTokens map[string]*model.RefreshToken
+}
+
+// NewMockOAuthRepository returns an empty MockOAuthRepository
+func NewMockOAuthRepository() *MockOAuthRepository {
+	return &MockOAuthRepository{
+		Clients:       make(map[string]*model.OAuthClient),
+		Consents:      make(map[string][]string),
+		RefreshTokens: make(map[string]*model.RefreshToken),
+	}
+}
+
+// CreateClient registers a client
+func (r *MockOAuthRepository) CreateClient(_ context.Context, client *model.OAuthClient) error {
+	if _, ok := r.Clients[client.ID]; ok {
+		return &model.ConflictError{Message: "client already exists"}
+	}
+	r.Clients[client.ID] = client
+	return nil
+}
+
+// GetClient gets a client by ID
+func (r *MockOAuthRepository) GetClient(_ context.Context, clientID string) (*model.OAuthClient, error) {
+	client, ok := r.Clients[clientID]
+	if !ok {
+		return nil, &model.NotFoundError{Resource: "client"}
+	}
+	return client, nil
+}
+
+// GetConsent gets the scopes granted by a user to a client
+func (r *MockOAuthRepository) GetConsent(_ context.Context, userID uuid.UUID, clientID string) ([]string, error) {
+	return r.Consents[userID.String()+clientID], nil
+}
+
+// SaveConsent saves the scopes granted by a user to a client
+func (r *MockOAuthRepository) SaveConsent(_ context.Context, userID uuid.UUID, clientID string, scopes []string) error {
+	r.Consents[userID.String()+clientID] = scopes
+	return nil
+}
+
+// CreateRefreshToken stores a refresh token
+func (r *MockOAuthRepository) CreateRefreshToken(_ context.Context, token *model.RefreshToken) error {
+	r.RefreshTokens[token.Hash] = token
+	return nil
+}
+
+// GetRefreshToken gets a copy of a refresh token by hash
+func (r *MockOAuthRepository) GetRefreshToken(_ context.Context, hash string) (*model.RefreshToken, error) {
+	token, ok := r.RefreshTokens[hash]
+	if !ok {
+		return nil, &model.NotFoundError{Resource: "refresh token"}
+	}
+	copied := *token
+	return &copied, nil
+}
+
+// RevokeRefreshToken revokes a refresh token, unless already revoked
+func (r *MockOAuthRepository) RevokeRefreshToken(_ context.Context, hash string) error {
+	token, ok := r.RefreshTokens[hash]
+	if !ok || token.RevokedAt != nil {
+		return &model.NotFoundError{Resource: "active refresh token"}
+	}
+	now := time.Now()
+	token.RevokedAt = &now
+	return nil
+}
+
+// Close no-op
+func (r *MockOAuthRepository) Close() error {
+	return nil
+}
+
+// MockIdentityRepository is a mock implementation of the IdentityRepository interface
+type MockIdentityRepository struct {
+	Identities []*model.ExternalIdentity
+}
+
+// CreateIdentity links an external identity to a user
+func (r *MockIdentityRepository) CreateIdentity(ctx context.Context, identity *model.ExternalIdentity) error {
+	if _, err := r.GetIdentity(ctx, identity.Provider, identity.Subject); err == nil {
+		return &model.ConflictError{Message: "identity already linked"}
+	}
+	identity.CreatedAt = time.Now()
+	r.Identities = append(r.Identities, identity)
+	return nil
+}
+
+// GetIdentity gets an external identity by provider and subject
+func (r *MockIdentityRepository) GetIdentity(_ context.Context, provider string, subject string) (*model.ExternalIdentity, error) {
+	for _, identity := range r.Identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, &model.NotFoundError{Resource: "identity"}
+}
+
+// GetUserIdentities gets the external identities linked to a user
+func (r *MockIdentityRepository) GetUserIdentities(_ context.Context, userID uuid.UUID) ([]*model.ExternalIdentity, error) {
+	identities := make([]*model.ExternalIdentity, 0)
+	for _, identity := range r.Identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+// Close no-op
+func (r *MockIdentityRepository) Close() error {
+	return nil
+}
+
+// MockRoleRepository is a mock implementation of the RoleRepository interface
+type MockRoleRepository struct {
+	Roles     map[string]*model.Role
+	UserRoles map[uuid.UUID][]string
+	Events    []*model.Event
+}
+
+// NewMockRoleRepository returns a MockRoleRepository containing the given roles
+func NewMockRoleRepository(roles ...*model.Role) *MockRoleRepository {
+	repo := &MockRoleRepository{
+		Roles:     make(map[string]*model.Role),
+		UserRoles: make(map[uuid.UUID][]string),
+	}
+	for _, role := range roles {
+		repo.Roles[role.Name] = role
+	}
+	return repo
+}
+
+// GetRoles gets all roles
+func (r *MockRoleRepository) GetRoles(_ context.Context) ([]*model.Role, error) {
+	roles := make([]*model.Role, 0, len(r.Roles))
+	for _, role := range r.Roles {
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// GetUserRoles gets the roles assigned to a user
+func (r *MockRoleRepository) GetUserRoles(_ context.Context, userID uuid.UUID) ([]*model.Role, error) {
+	roles := make([]*model.Role, 0)
+	for _, name := range r.UserRoles[userID] {
+		roles = append(roles, r.Roles[name])
+	}
+	return roles, nil
+}
+
+// AssignRole assigns a role to a user
+func (r *MockRoleRepository) AssignRole(_ context.Context, userID uuid.UUID, role string, _ uuid.UUID) error {
+	if _, ok := r.Roles[role]; !ok {
+		return &model.NotFoundError{Resource: "role"}
+	}
+	for _, name := range r.UserRoles[userID] {
+		if name == role {
+			return &model.ConflictError{Message: "role already assigned"}
+		}
+	}
+	r.UserRoles[userID] = append(r.UserRoles[userID], role)
+	r.Events = append(r.Events, &model.Event{UUID: userID, Type: model.RoleAssigned})
+	return nil
+}
+
+// RevokeRole revokes a role from a user
+func (r *MockRoleRepository) RevokeRole(_ context.Context, userID uuid.UUID, role string, _ uuid.UUID) error {
+	for i, name := range r.UserRoles[userID] {
+		if name == role {
+			r.UserRoles[userID] = append(r.UserRoles[userID][:i], r.UserRoles[userID][i+1:]...)
+			r.Events = append(r.Events, &model.Event{UUID: userID, Type: model.RoleRevoked})
+			return nil
+		}
+	}
+	return &model.NotFoundError{Resource: "role assignment"}
+}
+
+// Close no-op
+func (r *MockRoleRepository) Close() error {
+	return nil
+}
+
+// MockCursorRepository is a mock implementation of the CursorRepository interface,
+// consuming Events under the names of Positions
+type MockCursorRepository struct {
+	Events    []*model.Event
+	Positions map[string]int64
+	mu        sync.Mutex
+	leased    map[string]bool
+}
+
+// Acquire returns the lease of the cursor, or nil when it is leased
+func (r *MockCursorRepository) Acquire(_ context.Context, name string) (CursorLease, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.leased == nil {
+		r.leased = make(map[string]bool)
+	}
+	if r.Positions == nil {
+		r.Positions = make(map[string]int64)
+	}
+	if r.leased[name] {
+		return nil, nil
+	}
+	r.leased[name] = true
+	return &mockCursorLease{r, name}, nil
+}
+
+type mockCursorLease struct {
+	repository *MockCursorRepository
+	name       string
+}
+
+// Position returns the ID of the last event consumed
+func (l *mockCursorLease) Position(_ context.Context) (int64, error) {
+	l.repository.mu.Lock()
+	defer l.repository.mu.Unlock()
+	return l.repository.Positions[l.name], nil
+}
+
+// Events returns the events following after in ID order
+func (l *mockCursorLease) Events(_ context.Context, after int64, limit int) ([]*model.Event, error) {
+	l.repository.mu.Lock()
+	defer l.repository.mu.Unlock()
+	events := make([]*model.Event, 0)
+	for _, event := range l.repository.Events {
+		if event.ID > after {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// Advance records the ID of the last event consumed, unless a later one was recorded
+func (l *mockCursorLease) Advance(_ context.Context, eventID int64) error {
+	l.repository.mu.Lock()
+	defer l.repository.mu.Unlock()
+	if eventID > l.repository.Positions[l.name] {
+		l.repository.Positions[l.name] = eventID
+	}
+	return nil
+}
+
+// Release releases the lease
+func (l *mockCursorLease) Release() error {
+	l.repository.mu.Lock()
+	defer l.repository.mu.Unlock()
+	delete(l.repository.leased, l.name)
+	return nil
+}
+
+// MockWebhookRepository is a mock implementation of the WebhookRepository interface
+type MockWebhookRepository struct {
+	Endpoints  []*model.WebhookEndpoint
+	Deliveries []*model.WebhookDelivery
+	Attempts   map[int64][]*model.WebhookAttempt
+	Events     []*model.Event // recorded by endpoint changes
+	mu         sync.Mutex
+	delivered  map[int64]*model.Event // events delivered, by ID
+}
+
+// CreateEndpoint creates a new endpoint
+func (r *MockWebhookRepository) CreateEndpoint(_ context.Context, endpoint *model.WebhookEndpoint, event *model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := *endpoint
+	stored.CreatedAt = time.Now().UTC()
+	endpoint.CreatedAt = stored.CreatedAt
+	r.Endpoints = append(r.Endpoints, &stored)
+	r.Events = append(r.Events, event)
+	return nil
+}
+
+// GetEndpoint gets an endpoint by ID, without its secret
+func (r *MockWebhookRepository) GetEndpoint(_ context.Context, id uuid.UUID) (*model.WebhookEndpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, endpoint := range r.Endpoints {
+		if endpoint.ID == id {
+			e := *endpoint
+			e.Secret = ""
+			return &e, nil
+		}
+	}
+	return nil, &model.NotFoundError{Resource: "webhook endpoint"}
+}
+
+// GetEndpoints gets every endpoint, without their secrets
+func (r *MockWebhookRepository) GetEndpoints(_ context.Context) ([]*model.WebhookEndpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	endpoints := make([]*model.WebhookEndpoint, 0, len(r.Endpoints))
+	for _, endpoint := range r.Endpoints {
+		e := *endpoint
+		e.Secret = ""
+		endpoints = append(endpoints, &e)
+	}
+	return endpoints, nil
+}
+
+// UpdateEndpoint updates an endpoint, leaving its secret unchanged
+func (r *MockWebhookRepository) UpdateEndpoint(_ context.Context, endpoint *model.WebhookEndpoint, event *model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.Endpoints {
+		if e.ID == endpoint.ID {
+			e.URL, e.EventTypes, e.Description, e.Active = endpoint.URL, endpoint.EventTypes, endpoint.Description, endpoint.Active
+			r.Events = append(r.Events, event)
+			return nil
+		}
+	}
+	return &model.NotFoundError{Resource: "webhook endpoint"}
+}
+
+// DeleteEndpoint deletes an endpoint along with its deliveries
+func (r *MockWebhookRepository) DeleteEndpoint(_ context.Context, id uuid.UUID, event *model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, endpoint := range r.Endpoints {
+		if endpoint.ID == id {
+			r.Endpoints = append(r.Endpoints[:i], r.Endpoints[i+1:]...)
+			deliveries := r.Deliveries[:0]
+			for _, delivery := range r.Deliveries {
+				if delivery.EndpointID != id {
+					deliveries = append(deliveries, delivery)
+				}
+			}
+			r.Deliveries = deliveries
+			r.Events = append(r.Events, event)
+			return nil
+		}
+	}
+	return &model.NotFoundError{Resource: "webhook endpoint"}
+}
+
+// CreateDeliveries creates a pending delivery of each event to every active endpoint subscribed to its type
+func (r *MockWebhookRepository) CreateDeliveries(_ context.Context, events []*model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.delivered == nil {
+		r.delivered = make(map[int64]*model.Event)
+	}
+	now := time.Now().UTC()
+	for _, event := range events {
+		r.delivered[event.ID] = event
+		for _, endpoint := range r.Endpoints {
+			if !endpoint.Active || !subscribed(endpoint, event.Type) || r.delivery(endpoint.ID, event.ID) != nil {
+				continue
+			}
+			r.Deliveries = append(r.Deliveries, &model.WebhookDelivery{
+				ID:            int64(len(r.Deliveries) + 1),
+				EndpointID:    endpoint.ID,
+				EventID:       event.ID,
+				EventType:     event.Type,
+				Status:        model.WebhookPending,
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			})
+		}
+	}
+	return nil
+}
+
+// subscribed reports whether endpoint subscribes to events of eventType
+func subscribed(endpoint *model.WebhookEndpoint, eventType model.EventType) bool {
+	for _, t := range endpoint.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return len(endpoint.EventTypes) == 0
+}
+
+// delivery returns the delivery of the event to the endpoint, or nil when there is none
+func (r *MockWebhookRepository) delivery(endpointID uuid.UUID, eventID int64) *model.WebhookDelivery {
+	for _, delivery := range r.Deliveries {
+		if delivery.EndpointID == endpointID && delivery.EventID == eventID {
+			return delivery
+		}
+	}
+	return nil
+}
+
+// ClaimDeliveries returns the pending deliveries which are due, postponing their next attempt by timeout
+func (r *MockWebhookRepository) ClaimDeliveries(_ context.Context, limit int, timeout time.Duration) ([]*model.WebhookJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	jobs := make([]*model.WebhookJob, 0, limit)
+	for _, delivery := range r.Deliveries {
+		if len(jobs) == limit {
+			break
+		}
+		if delivery.Status != model.WebhookPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		delivery.NextAttemptAt = now.Add(timeout)
+		d := *delivery
+		job := &model.WebhookJob{Delivery: &d, Event: r.delivered[delivery.EventID]}
+		for _, endpoint := range r.Endpoints {
+			if endpoint.ID == delivery.EndpointID {
+				e := *endpoint
+				job.Endpoint = &e
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// RecordAttempt records an attempt along with the state of the delivery
+func (r *MockWebhookRepository) RecordAttempt(_ context.Context, delivery *model.WebhookDelivery, attempt *model.WebhookAttempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Attempts == nil {
+		r.Attempts = make(map[int64][]*model.WebhookAttempt)
+	}
+	for _, d := range r.Deliveries {
+		if d.ID == delivery.ID {
+			d.Status, d.Attempts, d.NextAttemptAt, d.DeliveredAt = delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.DeliveredAt
+			r.Attempts[d.ID] = append(r.Attempts[d.ID], attempt)
+			return nil
+		}
+	}
+	return &model.NotFoundError{Resource: "webhook delivery"}
+}
+
+// GetDeliveries gets the deliveries matching the filter, newest first
+func (r *MockWebhookRepository) GetDeliveries(_ context.Context, filter *model.WebhookDeliveryFilter) ([]*model.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deliveries := make([]*model.WebhookDelivery, 0)
+	for i := len(r.Deliveries) - 1; i >= 0 && len(deliveries) < filter.Limit; i-- {
+		delivery := r.Deliveries[i]
+		if delivery.EndpointID == filter.EndpointID && (filter.Status == "" || delivery.Status == filter.Status) &&
+			(filter.Before == 0 || delivery.ID < filter.Before) {
+			d := *delivery
+			deliveries = append(deliveries, &d)
+		}
+	}
+	return deliveries, nil
+}
+
+// GetDelivery gets a delivery along with its attempts
+func (r *MockWebhookRepository) GetDelivery(_ context.Context, endpointID uuid.UUID, id int64) (*model.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, delivery := range r.Deliveries {
+		if delivery.EndpointID == endpointID && delivery.ID == id {
+			d := *delivery
+			d.Log = append(make([]*model.WebhookAttempt, 0), r.Attempts[id]...)
+			return &d, nil
+		}
+	}
+	return nil, &model.NotFoundError{Resource: "webhook delivery"}
+}
+
+// RetryDelivery returns a dead delivery to pending
+func (r *MockWebhookRepository) RetryDelivery(_ context.Context, endpointID uuid.UUID, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, delivery := range r.Deliveries {
+		if delivery.EndpointID == endpointID && delivery.ID == id && delivery.Status == model.WebhookDead {
+			delivery.Status, delivery.Attempts, delivery.NextAttemptAt = model.WebhookPending, 0, time.Now().UTC()
+			return nil
+		}
+	}
+	return &model.NotFoundError{Resource: "dead webhook delivery"}
+}
+
+// Close no-op
+func (r *MockWebhookRepository) Close() error {
+	return nil
+}
+
+// MockProjectionRepository is a mock implementation of the ProjectionRepository interface,
+// recording the events applied to each projection and returning Activity
+type MockProjectionRepository struct {
+	Applied  map[string][]*model.Event
+	Activity map[uuid.UUID]*model.UserActivity
+	mu       sync.Mutex
+}
+
+// Names returns the names of the projections
+func (r *MockProjectionRepository) Names() []string {
+	return []string{"login_history", "user_activity"}
+}
+
+// Apply records the events following the last applied to the projection
+func (r *MockProjectionRepository) Apply(_ context.Context, name string, events []*model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Applied == nil {
+		r.Applied = make(map[string][]*model.Event)
+	}
+	applied := r.Applied[name]
+	for _, event := range events {
+		if len(applied) == 0 || event.ID > applied[len(applied)-1].ID {
+			applied = append(applied, event)
+		}
+	}
+	r.Applied[name] = applied
+	return nil
+}
+
+// AppliedEvents returns the events applied to the projection
+func (r *MockProjectionRepository) AppliedEvents(name string) []*model.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*model.Event{}, r.Applied[name]...)
+}
+
+// Rebuild forgets the events applied to the projection, the mock does not store events to apply again
+func (r *MockProjectionRepository) Rebuild(_ context.Context, name string, _ int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.Applied, name)
+	return 0, nil
+}
+
+// GetUserActivity returns the activity of the user with at most logins logins, empty when unknown
+func (r *MockProjectionRepository) GetUserActivity(_ context.Context, userID uuid.UUID, logins int) (*model.UserActivity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	activity, ok := r.Activity[userID]
+	if !ok {
+		return &model.UserActivity{UserID: userID, Logins: []*model.Login{}}, nil
+	}
+	a := *activity
+	if len(a.Logins) > logins {
+		a.Logins = a.Logins[:logins]
+	}
+	return &a, nil
+}
+
+// Close no-op
+func (r *MockProjectionRepository) Close() error {
+	return nil
+}
+
+// MockAuditRepository is a mock implementation of the AuditRepository interface,
+// reading the hash chain from Entries and Head, recording checkpoints in Signed and reading Archived
+type MockAuditRepository struct {
+	Entries  []*model.ChainEntry
+	Head     model.ChainHead
+	Signed   []*model.Checkpoint
+	Archived []*model.Archive
+	mu       sync.Mutex
+}
+
+// ChainEntries returns a page of Entries following afterSeq
+func (r *MockAuditRepository) ChainEntries(_ context.Context, afterSeq int64, limit int) ([]*model.ChainEntry, error) {
+	entries := make([]*model.ChainEntry, 0)
+	for _, entry := range r.Entries {
+		if entry.Seq > afterSeq && len(entries) < limit {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// ChainHead returns Head
+func (r *MockAuditRepository) ChainHead(_ context.Context) (*model.ChainHead, error) {
+	head := r.Head
+	return &head, nil
+}
+
+// CreateCheckpoint records the checkpoint, unless one of the same entry exists
+func (r *MockAuditRepository) CreateCheckpoint(_ context.Context, checkpoint *model.Checkpoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.Signed {
+		if c.Seq == checkpoint.Seq {
+			return nil
+		}
+	}
+	checkpoint.CreatedAt = time.Now().UTC()
+	r.Signed = append(r.Signed, checkpoint)
+	sort.Slice(r.Signed, func(i, j int) bool { return r.Signed[i].Seq < r.Signed[j].Seq })
+	return nil
+}
+
+// LatestCheckpoint returns the checkpoint of the latest entry, or nil when there is none
+func (r *MockAuditRepository) LatestCheckpoint(_ context.Context) (*model.Checkpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Signed) == 0 {
+		return nil, nil
+	}
+	return r.Signed[len(r.Signed)-1], nil
+}
+
+// Checkpoints returns the checkpoints recorded
+func (r *MockAuditRepository) Checkpoints(_ context.Context) ([]*model.Checkpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*model.Checkpoint{}, r.Signed...), nil
+}
+
+// Archives returns Archived
+func (r *MockAuditRepository) Archives(_ context.Context) ([]*model.Archive, error) {
+	return r.Archived, nil
+}
+
+// Close closes the repository prepared statements
+func (r *MockAuditRepository) Close() error {
+	return nil
+}
+
+// MockArchiveRepository is a mock implementation of the ArchiveRepository interface,
+// holding the events of each partition in Partitioned, by name, and recording archives
+// in Archived and the events recorded with them in Events. Begin returns nil when Locked.
+type MockArchiveRepository struct {
+	Partitioned map[string][]*model.ArchivedEvent
+	Archived    []*model.Archive
+	Events      []*model.Event
+	Locked      bool
+	mu          sync.Mutex
+}
+
+// CreatePartition creates the partition of the month of month, unless it exists
+func (r *MockArchiveRepository) CreatePartition(_ context.Context, month time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Partitioned == nil {
+		r.Partitioned = make(map[string][]*model.ArchivedEvent)
+	}
+	name := model.PartitionName(month)
+	if _, ok := r.Partitioned[name]; !ok {
+		r.Partitioned[name] = make([]*model.ArchivedEvent, 0)
+	}
+	return nil
+}
+
+// Partitions returns the partitions, oldest first and the default partition last
+func (r *MockArchiveRepository) Partitions(_ context.Context) ([]*model.EventPartition, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	partitions := make([]*model.EventPartition, 0, len(r.Partitioned))
+	for name := range r.Partitioned {
+		partition := &model.EventPartition{Name: name}
+		if from, err := time.Parse("event_2006_01", name); err == nil {
+			partition.From = from
+			partition.To = from.AddDate(0, 1, 0)
+		}
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Name < partitions[j].Name })
+	return partitions, nil
+}
+
+// Begin begins archiving partition, or returns nil when Locked
+func (r *MockArchiveRepository) Begin(_ context.Context, partition *model.EventPartition) (ArchiveTx, error) {
+	if r.Locked {
+		return nil, nil
+	}
+	r.mu.Lock()
+	return &mockArchiveTx{repo: r, name: partition.Name}, nil
+}
+
+// Close no-op
+func (r *MockArchiveRepository) Close() error {
+	return nil
+}
+
+// mockArchiveTx holds the lock of the repository until it ends, applying the purge on Commit
+type mockArchiveTx struct {
+	repo    *MockArchiveRepository
+	name    string
+	archive *model.Archive
+	event   *model.Event
+}
+
+func (t *mockArchiveTx) Expired(_ context.Context, policy *model.RetentionPolicy, now time.Time, fn func(event *model.ArchivedEvent) error) error {
+	for _, event := range t.repo.Partitioned[t.name] {
+		days := policy.Days(event.Type)
+		if days > 0 && event.CreatedAt.Before(now.AddDate(0, 0, -days)) {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *mockArchiveTx) Count(_ context.Context) (int, error) {
+	return len(t.repo.Partitioned[t.name]), nil
+}
+
+func (t *mockArchiveTx) Purge(_ context.Context, archive *model.Archive, event *model.Event) error {
+	t.archive = archive
+	t.event = event
+	return nil
+}
+
+func (t *mockArchiveTx) Commit() error {
+	defer t.repo.mu.Unlock()
+	if t.archive == nil {
+		return nil
+	}
+	if t.archive.Dropped {
+		delete(t.repo.Partitioned, t.name)
+	} else {
+		events := make([]*model.ArchivedEvent, 0)
+		for _, event := range t.repo.Partitioned[t.name] {
+			purged := false
+			for _, r := range t.archive.Ranges {
+				purged = purged || (event.ChainSeq >= r.First && event.ChainSeq <= r.Last)
+			}
+			if !purged {
+				events = append(events, event)
+			}
+		}
+		t.repo.Partitioned[t.name] = events
+	}
+	t.repo.Archived = append(t.repo.Archived, t.archive)
+	t.repo.Events = append(t.repo.Events, t.event)
+	return nil
+}
+
+func (t *mockArchiveTx) Rollback() error {
+	t.repo.mu.Unlock()
+	return nil
+}
+
+// MockMigrationRepository is a mock implementation of the MigrationRepository interface,
+// recording the migrations applied in Migrations. Apply and Revert fail with Err when set.
+// Objects are the names of the objects which exist in the schema.
+type MockMigrationRepository struct {
+	Migrations []*model.Migration
+	Objects    []string
+	Err        error
+	mu         sync.Mutex
+}
+
+// Lock locks the repository until the session is released
+func (r *MockMigrationRepository) Lock(_ context.Context) (MigrationSession, error) {
+	r.mu.Lock()
+	return &mockMigrationSession{r}, nil
+}
+
+type mockMigrationSession struct {
+	repo *MockMigrationRepository
+}
+
+func (s *mockMigrationSession) Applied(_ context.Context) ([]*model.Migration, error) {
+	applied := make([]*model.Migration, 0, len(s.repo.Migrations))
+	for _, migration := range s.repo.Migrations {
+		applied = append(applied, &model.Migration{Version: migration.Version, Name: migration.Name,
+			Checksum: migration.Checksum, AppliedAt: migration.AppliedAt})
+	}
+	sort.Slice(applied, func(i, j int) bool { return applied[i].Version < applied[j].Version })
+	return applied, nil
+}
+
+func (s *mockMigrationSession) Apply(_ context.Context, migration *model.Migration) error {
+	if s.repo.Err != nil {
+		return s.repo.Err
+	}
+	applied := *migration
+	applied.AppliedAt = time.Now().UTC()
+	s.repo.Migrations = append(s.repo.Migrations, &applied)
+	return nil
+}
+
+func (s *mockMigrationSession) Revert(_ context.Context, migration *model.Migration) error {
+	if s.repo.Err != nil {
+		return s.repo.Err
+	}
+	for i, m := range s.repo.Migrations {
+		if m.Version == migration.Version {
+			s.repo.Migrations = append(s.repo.Migrations[:i], s.repo.Migrations[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("migration %d is not applied", migration.Version)
+}
+
+func (s *mockMigrationSession) Exists(_ context.Context, name string) (bool, error) {
+	for _, object := range s.repo.Objects {
+		if object == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *mockMigrationSession) Record(_ context.Context, migration *model.Migration) error {
+	recorded := *migration
+	recorded.AppliedAt = time.Now().UTC()
+	s.repo.Migrations = append(s.repo.Migrations, &recorded)
+	return nil
+}
+
+func (s *mockMigrationSession) Release() error {
+	s.repo.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// RoleRepository is an interface for interacting with the role, role_permission and user_role tables
+type RoleRepository interface {
+	GetRoles(ctx context.Context) ([]*model.Role, error)
+	GetUserRoles(ctx context.Context, userID uuid.UUID) ([]*model.Role, error)
+	AssignRole(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) error
+	RevokeRole(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) error
+	Close() error
+}
+
+type roleRepository struct {
+	*DbClient
+	stmtInsertEvent     *sql.Stmt // Prepared statement for inserting into auth.event
+	stmtSelectRoles     *sql.Stmt // Prepared statement for selecting all roles and their permissions
+	stmtSelectUserRoles *sql.Stmt // Prepared statement for selecting the roles assigned to a user
+	stmtInsertUserRole  *sql.Stmt // Prepared statement for inserting into auth.user_role
+	stmtDeleteUserRole  *sql.Stmt // Prepared statement for deleting from auth.user_role
+}
+
+// NewRoleRepository creates a new role repository
+func NewRoleRepository(c *DbClient) RoleRepository {
+	repo := &roleRepository{
+		DbClient: c,
+	}
+	repo.prepareStatements()
+	return repo
+}
+
+func (r *roleRepository) GetRoles(ctx context.Context) ([]*model.Role, error) {
+	return r.queryRoles(ctx, r.stmtSelectRoles)
+}
+
+func (r *roleRepository) GetUserRoles(ctx context.Context, userID uuid.UUID) ([]*model.Role, error) {
+	return r.queryRoles(ctx, r.stmtSelectUserRoles, userID)
+}
+
+// AssignRole assigns the role to the user, recording the change as an event.
+// Returns a model.NotFoundError when the role does not exist, and a model.ConflictError
+// when the role is already assigned.
+func (r *roleRepository) AssignRole(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) error {
+	return r.changeRole(ctx, r.stmtInsertUserRole, model.RoleAssigned, userID, role, actorID)
+}
+
+// RevokeRole revokes the role from the user, recording the change as an event.
+// Returns a model.NotFoundError when the role is not assigned to the user.
+func (r *roleRepository) RevokeRole(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) error {
+	return r.changeRole(ctx, r.stmtDeleteUserRole, model.RoleRevoked, userID, role, actorID)
+}
+
+// changeRole executes stmt and records the event in a single transaction.
+func (r *roleRepository) changeRole(ctx context.Context, stmt *sql.Stmt, eventType model.EventType,
+	userID uuid.UUID, role string, actorID uuid.UUID) (err error) {
+	event, err := model.NewEvent(ctx, userID, eventType, &model.RolePayload{
+		Role:    role,
+		ActorID: actorID,
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			return
+		}
+		if errRollback := tx.Rollback(); errRollback != nil {
+			log.Println(errRollback)
+		}
+	}()
+
+	res, err := tx.StmtContext(ctx, stmt).ExecContext(ctx, userID, role)
+	switch {
+	case isUniqueViolation(err):
+		return &model.ConflictError{Message: "role already assigned"}
+	case isForeignKeyViolation(err):
+		return &model.NotFoundError{Resource: "role"}
+	case err != nil:
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return &model.NotFoundError{Resource: "role assignment"}
+	}
+
+	_, err = tx.StmtContext(ctx, r.stmtInsertEvent).ExecContext(ctx, eventArgs(event)...)
+	return err
+}
+
+func (r *roleRepository) queryRoles(ctx context.Context, stmt *sql.Stmt, args ...interface{}) ([]*model.Role, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]*model.Role, 0)
+	for rows.Next() {
+		var role model.Role
+		if err := rows.Scan(&role.Name, &role.Description, pq.Array(&role.Permissions)); err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+	return roles, rows.Err()
+}
+
+func (r *roleRepository) prepareStatements() {
+	var err error
+	r.stmtInsertEvent, err = r.connPool.Prepare(`
+		INSERT INTO event (uuid, type, body, ip, user_agent, request_id, session_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectRoles, err = r.connPool.Prepare(`
+		SELECT r.name, r.description, array_remove(array_agg(rp.permission ORDER BY rp.permission), NULL)
+		FROM role r
+		LEFT JOIN role_permission rp ON rp.role = r.name
+		GROUP BY r.name, r.description
+		ORDER BY r.name
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectUserRoles, err = r.connPool.Prepare(`
+		SELECT r.name, r.description, array_remove(array_agg(rp.permission ORDER BY rp.permission), NULL)
+		FROM user_role ur
+		JOIN role r ON r.name = ur.role
+		LEFT JOIN role_permission rp ON rp.role = r.name
+		WHERE ur.user_id = $1
+		GROUP BY r.name, r.description
+		ORDER BY r.name
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtInsertUserRole, err = r.connPool.Prepare(`
+		INSERT INTO user_role (user_id, role)
+		VALUES ($1, $2)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtDeleteUserRole, err = r.connPool.Prepare(`
+		DELETE FROM user_role
+		WHERE user_id = $1 AND role = $2
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// https://go.dev/doc/database/prepared-statements
+func (r *roleRepository) Close() error {
+	var err error
+	for _, stmt := range []*sql.Stmt{
+		r.stmtInsertEvent,
+		r.stmtSelectRoles,
+		r.stmtSelectUserRoles,
+		r.stmtInsertUserRole,
+		r.stmtDeleteUserRole,
+	} {
+		if e := stmt.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -52,7 +52,7 @@ func (r *sessionRepository) RemoveSessions(ctx context.Context, userID uuid.UUID
 func (r *sessionRepository) GetSessions(ctx context.Context, userID uuid.UUID) ([]*model.Session, error) {
 	rows, err := r.connPool.QueryContext(ctx, `
 		SELECT id, user_id, created_at
-		FROM auth.session
+		FROM session
 		WHERE user_id = $1
 	`, userID)
 	if err != nil {
@@ -77,21 +77,21 @@ func (r *sessionRepository) GetSessions(ctx context.Context, userID uuid.UUID) (
 func (r *sessionRepository) prepareStatements() {
 	var err error
 	r.stmtInsertSession, err = r.connPool.Prepare(`
-		INSERT INTO auth.session (id, user_id)
+		INSERT INTO session (id, user_id)
 		VALUES ($1, $2)
 	`)
 	if err != nil {
 		log.Fatal(err)
 	}
 	r.stmtDeleteSession, err = r.connPool.Prepare(`
-		DELETE FROM auth.session
+		DELETE FROM session
 		WHERE id = $1
 	`)
 	if err != nil {
 		log.Fatal(err)
 	}
 	r.stmtDeleteSessions, err = r.connPool.Prepare(`
-		DELETE FROM auth.session
+		DELETE FROM session
 		WHERE user_id = $1
 	`)
 	if err != nil {
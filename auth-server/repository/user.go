@@ -3,10 +3,11 @@ package repository
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"log"
 
 	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
 )
 
 // UserRepository is an interface for interacting with the user table
@@ -14,6 +15,11 @@ type UserRepository interface {
 	CreateUser(ctx context.Context, user *model.User) error
 	ExistsUser(ctx context.Context, username string) bool
 	GetUser(ctx context.Context, user *model.User) error
+	ListUsers(ctx context.Context, search string, limit int, offset int) ([]*model.User, int, error)
+	UpdateStatus(ctx context.Context, userID uuid.UUID, status model.UserStatus, event *model.Event) error
+	DeleteUser(ctx context.Context, userID uuid.UUID, event *model.Event) error
+	EraseUser(ctx context.Context, userID uuid.UUID, event *model.Event) error
+	UpdateUsername(ctx context.Context, userID uuid.UUID, username string, event *model.Event) error
 	Close() error
 }
 
@@ -23,6 +29,11 @@ type userRepository struct {
 	stmtInsertUser           *sql.Stmt // Prepared statement for inserting into auth.user
 	stmtSelectUserByUsername *sql.Stmt // Prepared statement for selecting a user by username
 	stmtSelectUserByID       *sql.Stmt // Prepared statement for selecting a user by ID
+	stmtSelectUsers          *sql.Stmt // Prepared statement for selecting a page of users
+	stmtUpdateStatus         *sql.Stmt // Prepared statement for updating the status of a user
+	stmtDeleteUser           *sql.Stmt // Prepared statement for deleting from auth.user
+	stmtTombstoneEvents      *sql.Stmt // Prepared statement for clearing the bodies and contexts of a user's events
+	stmtUpdateUsername       *sql.Stmt // Prepared statement for updating the username of a user
 }
 
 // NewUserRepository creates a new user repository
@@ -44,11 +55,130 @@ func (r *userRepository) ExistsUser(ctx context.Context, username string) bool {
 func (r *userRepository) GetUser(ctx context.Context, user *model.User) error {
 	var row *sql.Row
 	if user.Username != "" {
-		row = r.stmtSelectUserByUsername.QueryRowContext(ctx, user.Username)
+		row = r.stmtSelectUserByUsername.QueryRowContext(ctx, model.UsernameKey(user.Username))
 	} else {
 		row = r.stmtSelectUserByID.QueryRowContext(ctx, user.ID.String())
 	}
-	return row.Scan(&user.ID, &user.Username, &user.Password)
+	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &model.NotFoundError{Resource: "user"}
+	}
+	return err
+}
+
+// ListUsers returns a page of users ordered by username, and the total number of users.
+// When search is not empty only users whose username contains search, ignoring case, are returned.
+// Passwords are not selected.
+func (r *userRepository) ListUsers(ctx context.Context, search string, limit int, offset int) ([]*model.User, int, error) {
+	rows, err := r.stmtSelectUsers.QueryContext(ctx, search, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := make([]*model.User, 0)
+	total := 0
+	for rows.Next() {
+		var user model.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Status, &total); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, &user)
+	}
+	return users, total, rows.Err()
+}
+
+// UpdateStatus sets the status of the user and records event in a single transaction.
+// Returns a model.NotFoundError when the user does not exist.
+func (r *userRepository) UpdateStatus(ctx context.Context, userID uuid.UUID, status model.UserStatus, event *model.Event) error {
+	return r.execWithEvent(ctx, r.stmtUpdateStatus, event, userID, status)
+}
+
+// DeleteUser deletes the user, along with their sessions, roles, consents and linked identities,
+// and records event in a single transaction. Events of the user are kept.
+// Returns a model.NotFoundError when the user does not exist.
+func (r *userRepository) DeleteUser(ctx context.Context, userID uuid.UUID, event *model.Event) error {
+	return r.execWithEvent(ctx, r.stmtDeleteUser, event, userID)
+}
+
+// UpdateUsername changes the username of the user and records event in a single transaction.
+// Returns a model.NotFoundError when the user does not exist, and a model.ConflictError
+// when the username, or one sharing its model.UsernameKey, is taken.
+func (r *userRepository) UpdateUsername(ctx context.Context, userID uuid.UUID, username string, event *model.Event) error {
+	err := r.execWithEvent(ctx, r.stmtUpdateUsername, event, userID, username, model.UsernameKey(username))
+	if isUniqueViolation(err) {
+		return &model.ConflictError{Message: "username already exists"}
+	}
+	return err
+}
+
+// EraseUser deletes the user like DeleteUser, and tombstones their events by clearing the body
+// and request context of each, keeping only the type and time of the event. Unlike DeleteUser, it is intended for users
+// deleting their own account, whose personal data must not be kept.
+func (r *userRepository) EraseUser(ctx context.Context, userID uuid.UUID, event *model.Event) (err error) {
+	tx, err := r.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			return
+		}
+		if errRollback := tx.Rollback(); errRollback != nil {
+			log.Println(errRollback)
+		}
+	}()
+
+	if _, err = tx.StmtContext(ctx, r.stmtTombstoneEvents).ExecContext(ctx, userID); err != nil {
+		return err
+	}
+	res, err := tx.StmtContext(ctx, r.stmtDeleteUser).ExecContext(ctx, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return &model.NotFoundError{Resource: "user"}
+	}
+
+	_, err = tx.StmtContext(ctx, r.stmtInsertEvent).ExecContext(ctx, eventArgs(event)...)
+	return err
+}
+
+// execWithEvent executes stmt, which must affect a single user, and records event in a single transaction.
+func (r *userRepository) execWithEvent(ctx context.Context, stmt *sql.Stmt, event *model.Event, args ...interface{}) (err error) {
+	tx, err := r.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			return
+		}
+		if errRollback := tx.Rollback(); errRollback != nil {
+			log.Println(errRollback)
+		}
+	}()
+
+	res, err := tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return &model.NotFoundError{Resource: "user"}
+	}
+
+	_, err = tx.StmtContext(ctx, r.stmtInsertEvent).ExecContext(ctx, eventArgs(event)...)
+	return err
 }
 
 func (r *userRepository) CreateUser(ctx context.Context, user *model.User) error {
@@ -66,18 +196,23 @@ func (r *userRepository) CreateUser(ctx context.Context, user *model.User) error
 		}
 	}()
 
-	// stringify user for event body
-	userEncoded, err := json.Marshal(model.OmitPassword(user))
+	event, err := model.NewEvent(ctx, user.ID, model.AccountCreated, &model.UserPayload{
+		ID:       user.ID,
+		Username: user.Username,
+	})
 	if err != nil {
 		return err
 	}
 
-	_, err = r.stmtInsertEvent.Exec(user.ID, model.AccountCreated, userEncoded)
+	_, err = r.stmtInsertEvent.Exec(eventArgs(event)...)
 	if err != nil {
 		return err
 	}
 
-	if _, err = r.stmtInsertUser.Exec(user.ID, user.Username, user.Password); err != nil {
+	if _, err = r.stmtInsertUser.Exec(user.ID, user.Username, model.UsernameKey(user.Username), user.Password, user.Status); err != nil {
+		if isUniqueViolation(err) {
+			err = &model.ConflictError{Message: "username already exists"}
+		}
 		return err
 	}
 
@@ -88,35 +223,76 @@ func (r *userRepository) CreateUser(ctx context.Context, user *model.User) error
 func (r *userRepository) prepareStatements() {
 	var err error
 	r.stmtInsertEvent, err = r.connPool.Prepare(`
-		INSERT INTO auth.event (uuid, type, body)
-		VALUES ($1, $2, $3)
+		INSERT INTO event (uuid, type, body, ip, user_agent, request_id, session_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`)
 	if err != nil {
 		log.Fatal(err)
 	}
 	r.stmtInsertUser, err = r.connPool.Prepare(`
-		INSERT INTO auth.user (id, username, password)
-		VALUES ($1, $2, $3)
+		INSERT INTO "user" (id, username, username_key, password, status)
+		VALUES ($1, $2, $3, $4, $5)
 	`)
 	if err != nil {
 		log.Fatal(err)
 	}
 	r.stmtSelectUserByUsername, err = r.connPool.Prepare(`
-		SELECT id, username, password
-		FROM auth.user
-		WHERE username = $1
+		SELECT id, username, password, status
+		FROM "user"
+		WHERE username_key = $1
 	`)
 	if err != nil {
 		log.Fatal(err)
 	}
 	r.stmtSelectUserByID, err = r.connPool.Prepare(`
-		SELECT id, username, password
-		FROM auth.user
+		SELECT id, username, password, status
+		FROM "user"
 		WHERE id = $1
 	`)
 	if err != nil {
 		log.Fatal(err)
 	}
+	r.stmtSelectUsers, err = r.connPool.Prepare(`
+		SELECT id, username, status, count(*) OVER ()
+		FROM "user"
+		WHERE $1 = '' OR strpos(lower(username), lower($1)) > 0
+		ORDER BY username
+		LIMIT $2 OFFSET $3
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtUpdateStatus, err = r.connPool.Prepare(`
+		UPDATE "user"
+		SET status = $2
+		WHERE id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtDeleteUser, err = r.connPool.Prepare(`
+		DELETE FROM "user"
+		WHERE id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtUpdateUsername, err = r.connPool.Prepare(`
+		UPDATE "user"
+		SET username = $2, username_key = $3
+		WHERE id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtTombstoneEvents, err = r.connPool.Prepare(`
+		UPDATE event
+		SET body = NULL, ip = NULL, user_agent = NULL, request_id = NULL, session_id = NULL
+		WHERE uuid = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 // https://go.dev/doc/database/prepared-statements
@@ -134,5 +310,20 @@ func (r *userRepository) Close() error {
 	if e := r.stmtSelectUserByID.Close(); err != nil {
 		err = e
 	}
+	if e := r.stmtSelectUsers.Close(); e != nil {
+		err = e
+	}
+	if e := r.stmtUpdateStatus.Close(); e != nil {
+		err = e
+	}
+	if e := r.stmtDeleteUser.Close(); e != nil {
+		err = e
+	}
+	if e := r.stmtTombstoneEvents.Close(); e != nil {
+		err = e
+	}
+	if e := r.stmtUpdateUsername.Close(); e != nil {
+		err = e
+	}
 	return err
 }
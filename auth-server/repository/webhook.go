@@ -0,0 +1,492 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// WebhookRepository is an interface for interacting with the webhook_endpoint,
+// webhook_delivery and webhook_attempt tables
+type WebhookRepository interface {
+	CreateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint, event *model.Event) error
+	GetEndpoint(ctx context.Context, id uuid.UUID) (*model.WebhookEndpoint, error)
+	GetEndpoints(ctx context.Context) ([]*model.WebhookEndpoint, error)
+	UpdateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint, event *model.Event) error
+	DeleteEndpoint(ctx context.Context, id uuid.UUID, event *model.Event) error
+	CreateDeliveries(ctx context.Context, events []*model.Event) error
+	ClaimDeliveries(ctx context.Context, limit int, timeout time.Duration) ([]*model.WebhookJob, error)
+	RecordAttempt(ctx context.Context, delivery *model.WebhookDelivery, attempt *model.WebhookAttempt) error
+	GetDeliveries(ctx context.Context, filter *model.WebhookDeliveryFilter) ([]*model.WebhookDelivery, error)
+	GetDelivery(ctx context.Context, endpointID uuid.UUID, id int64) (*model.WebhookDelivery, error)
+	RetryDelivery(ctx context.Context, endpointID uuid.UUID, id int64) error
+	Close() error
+}
+
+type webhookRepository struct {
+	*DbClient
+	stmtInsertEvent      *sql.Stmt // Prepared statement for inserting into auth.event
+	stmtInsertEndpoint   *sql.Stmt // Prepared statement for inserting into auth.webhook_endpoint
+	stmtSelectEndpoint   *sql.Stmt // Prepared statement for selecting an endpoint by ID
+	stmtSelectEndpoints  *sql.Stmt // Prepared statement for selecting every endpoint
+	stmtUpdateEndpoint   *sql.Stmt // Prepared statement for updating an endpoint
+	stmtDeleteEndpoint   *sql.Stmt // Prepared statement for deleting an endpoint
+	stmtInsertDeliveries *sql.Stmt // Prepared statement for inserting the deliveries of an event to the endpoints subscribed to it
+	stmtClaimDeliveries  *sql.Stmt // Prepared statement for claiming the pending deliveries which are due
+	stmtUpdateDelivery   *sql.Stmt // Prepared statement for updating the status of a delivery
+	stmtInsertAttempt    *sql.Stmt // Prepared statement for inserting into auth.webhook_attempt
+	stmtSelectDeliveries *sql.Stmt // Prepared statement for selecting a page of the deliveries to an endpoint
+	stmtSelectDelivery   *sql.Stmt // Prepared statement for selecting a delivery by ID
+	stmtSelectAttempts   *sql.Stmt // Prepared statement for selecting the attempts of a delivery
+	stmtRetryDelivery    *sql.Stmt // Prepared statement for returning a dead delivery to pending
+}
+
+// NewWebhookRepository creates a new webhook repository
+func NewWebhookRepository(c *DbClient) WebhookRepository {
+	repo := &webhookRepository{
+		DbClient: c,
+	}
+	repo.prepareStatements()
+	return repo
+}
+
+// CreateEndpoint creates the endpoint and records event in a single transaction.
+func (r *webhookRepository) CreateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint, event *model.Event) error {
+	return r.execWithEvent(ctx, r.stmtInsertEndpoint, event, endpoint.ID, endpoint.URL, endpoint.Secret,
+		pq.Array(eventTypeStrings(endpoint.EventTypes)), endpoint.Description, endpoint.Active)
+}
+
+// GetEndpoint returns the endpoint, without its secret.
+// Returns a model.NotFoundError when the endpoint does not exist.
+func (r *webhookRepository) GetEndpoint(ctx context.Context, id uuid.UUID) (*model.WebhookEndpoint, error) {
+	endpoint, err := scanEndpoint(r.stmtSelectEndpoint.QueryRowContext(ctx, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &model.NotFoundError{Resource: "webhook endpoint"}
+	}
+	return endpoint, err
+}
+
+// GetEndpoints returns every endpoint, oldest first, without their secrets.
+func (r *webhookRepository) GetEndpoints(ctx context.Context) ([]*model.WebhookEndpoint, error) {
+	rows, err := r.stmtSelectEndpoints.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := make([]*model.WebhookEndpoint, 0)
+	for rows.Next() {
+		endpoint, err := scanEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, rows.Err()
+}
+
+// UpdateEndpoint updates the URL, event types, description and active flag of the endpoint,
+// and records event in a single transaction.
+// Returns a model.NotFoundError when the endpoint does not exist.
+func (r *webhookRepository) UpdateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint, event *model.Event) error {
+	return r.execWithEvent(ctx, r.stmtUpdateEndpoint, event, endpoint.ID, endpoint.URL,
+		pq.Array(eventTypeStrings(endpoint.EventTypes)), endpoint.Description, endpoint.Active)
+}
+
+// DeleteEndpoint deletes the endpoint along with its deliveries, and records event in a single transaction.
+// Returns a model.NotFoundError when the endpoint does not exist.
+func (r *webhookRepository) DeleteEndpoint(ctx context.Context, id uuid.UUID, event *model.Event) error {
+	return r.execWithEvent(ctx, r.stmtDeleteEndpoint, event, id)
+}
+
+// CreateDeliveries creates a pending delivery of each event to every active endpoint subscribed
+// to its type. Deliveries which already exist are left unchanged, so events may be passed again.
+func (r *webhookRepository) CreateDeliveries(ctx context.Context, events []*model.Event) (err error) {
+	tx, err := r.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			return
+		}
+		if errRollback := tx.Rollback(); errRollback != nil {
+			log.Println(errRollback)
+		}
+	}()
+
+	stmt := tx.StmtContext(ctx, r.stmtInsertDeliveries)
+	for _, event := range events {
+		if _, err = stmt.ExecContext(ctx, event.ID, event.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimDeliveries returns up to limit pending deliveries which are due, oldest first,
+// postponing their next attempt by timeout, so they are not claimed by another replica
+// while being attempted, but are claimed again should the attempt never be recorded.
+func (r *webhookRepository) ClaimDeliveries(ctx context.Context, limit int, timeout time.Duration) ([]*model.WebhookJob, error) {
+	now := time.Now().UTC()
+	rows, err := r.stmtClaimDeliveries.QueryContext(ctx, limit, now, now.Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]*model.WebhookJob, 0, limit)
+	for rows.Next() {
+		job := &model.WebhookJob{
+			Delivery: &model.WebhookDelivery{Status: model.WebhookPending},
+			Endpoint: &model.WebhookEndpoint{},
+		}
+		job.Event, err = scanEvent(rows, &job.Delivery.ID, &job.Delivery.EndpointID, &job.Delivery.EventType,
+			&job.Delivery.Attempts, &job.Delivery.NextAttemptAt, &job.Delivery.CreatedAt,
+			&job.Endpoint.URL, &job.Endpoint.Secret)
+		if err != nil {
+			return nil, err
+		}
+		job.Endpoint.ID = job.Delivery.EndpointID
+		job.Delivery.EventID = job.Event.ID
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// RecordAttempt records attempt, along with the status, number of attempts, next attempt
+// and delivery time of delivery, in a single transaction.
+func (r *webhookRepository) RecordAttempt(ctx context.Context, delivery *model.WebhookDelivery, attempt *model.WebhookAttempt) (err error) {
+	tx, err := r.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			return
+		}
+		if errRollback := tx.Rollback(); errRollback != nil {
+			log.Println(errRollback)
+		}
+	}()
+
+	if _, err = tx.StmtContext(ctx, r.stmtInsertAttempt).ExecContext(ctx, delivery.ID, attempt.AttemptedAt.UTC(),
+		sql.NullInt64{Int64: int64(attempt.StatusCode), Valid: attempt.StatusCode != 0},
+		sql.NullString{String: attempt.Error, Valid: attempt.Error != ""}, attempt.Duration); err != nil {
+		return err
+	}
+	var deliveredAt sql.NullTime
+	if delivery.DeliveredAt != nil {
+		deliveredAt = sql.NullTime{Time: delivery.DeliveredAt.UTC(), Valid: true}
+	}
+	_, err = tx.StmtContext(ctx, r.stmtUpdateDelivery).ExecContext(ctx, delivery.ID, delivery.Status,
+		delivery.Attempts, delivery.NextAttemptAt.UTC(), deliveredAt)
+	return err
+}
+
+// GetDeliveries returns the deliveries matching filter, newest first.
+func (r *webhookRepository) GetDeliveries(ctx context.Context, filter *model.WebhookDeliveryFilter) ([]*model.WebhookDelivery, error) {
+	rows, err := r.stmtSelectDeliveries.QueryContext(ctx, filter.EndpointID, filter.Status, filter.Before, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*model.WebhookDelivery, 0)
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// GetDelivery returns the delivery to the endpoint, along with the attempts made, oldest first.
+// Returns a model.NotFoundError when the delivery does not exist.
+func (r *webhookRepository) GetDelivery(ctx context.Context, endpointID uuid.UUID, id int64) (*model.WebhookDelivery, error) {
+	delivery, err := scanDelivery(r.stmtSelectDelivery.QueryRowContext(ctx, endpointID, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &model.NotFoundError{Resource: "webhook delivery"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.stmtSelectAttempts.QueryContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	delivery.Log = make([]*model.WebhookAttempt, 0, delivery.Attempts)
+	for rows.Next() {
+		var attempt model.WebhookAttempt
+		var statusCode sql.NullInt64
+		var message sql.NullString
+		if err := rows.Scan(&attempt.AttemptedAt, &statusCode, &message, &attempt.Duration); err != nil {
+			return nil, err
+		}
+		attempt.StatusCode = int(statusCode.Int64)
+		attempt.Error = message.String
+		delivery.Log = append(delivery.Log, &attempt)
+	}
+	return delivery, rows.Err()
+}
+
+// RetryDelivery returns the dead delivery to pending, to be attempted again with a fresh number of attempts.
+// Returns a model.NotFoundError when the endpoint has no such dead delivery.
+func (r *webhookRepository) RetryDelivery(ctx context.Context, endpointID uuid.UUID, id int64) error {
+	res, err := r.stmtRetryDelivery.ExecContext(ctx, endpointID, id)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return &model.NotFoundError{Resource: "dead webhook delivery"}
+	}
+	return nil
+}
+
+// execWithEvent executes stmt, which must affect a single endpoint, and records event in a single transaction.
+func (r *webhookRepository) execWithEvent(ctx context.Context, stmt *sql.Stmt, event *model.Event, args ...interface{}) (err error) {
+	tx, err := r.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			return
+		}
+		if errRollback := tx.Rollback(); errRollback != nil {
+			log.Println(errRollback)
+		}
+	}()
+
+	res, err := tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return &model.NotFoundError{Resource: "webhook endpoint"}
+	}
+
+	_, err = tx.StmtContext(ctx, r.stmtInsertEvent).ExecContext(ctx, eventArgs(event)...)
+	return err
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEndpoint(row scanner) (*model.WebhookEndpoint, error) {
+	var endpoint model.WebhookEndpoint
+	var eventTypes []string
+	if err := row.Scan(&endpoint.ID, &endpoint.URL, pq.Array(&eventTypes), &endpoint.Description,
+		&endpoint.Active, &endpoint.CreatedAt); err != nil {
+		return nil, err
+	}
+	endpoint.EventTypes = make([]model.EventType, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		endpoint.EventTypes = append(endpoint.EventTypes, model.EventType(eventType))
+	}
+	return &endpoint, nil
+}
+
+func scanDelivery(row scanner) (*model.WebhookDelivery, error) {
+	var delivery model.WebhookDelivery
+	var deliveredAt sql.NullTime
+	if err := row.Scan(&delivery.ID, &delivery.EndpointID, &delivery.EventID, &delivery.EventType, &delivery.Status,
+		&delivery.Attempts, &delivery.NextAttemptAt, &delivery.CreatedAt, &deliveredAt); err != nil {
+		return nil, err
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+	return &delivery, nil
+}
+
+func eventTypeStrings(eventTypes []model.EventType) []string {
+	s := make([]string, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		s = append(s, string(eventType))
+	}
+	return s
+}
+
+// Prepare the necessary SQL statements
+func (r *webhookRepository) prepareStatements() {
+	var err error
+	r.stmtInsertEvent, err = r.connPool.Prepare(`
+		INSERT INTO event (uuid, type, body, ip, user_agent, request_id, session_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtInsertEndpoint, err = r.connPool.Prepare(`
+		INSERT INTO webhook_endpoint (id, url, secret, event_types, description, active)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectEndpoint, err = r.connPool.Prepare(`
+		SELECT id, url, event_types, description, active, created_at
+		FROM webhook_endpoint
+		WHERE id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectEndpoints, err = r.connPool.Prepare(`
+		SELECT id, url, event_types, description, active, created_at
+		FROM webhook_endpoint
+		ORDER BY created_at
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtUpdateEndpoint, err = r.connPool.Prepare(`
+		UPDATE webhook_endpoint
+		SET url = $2, event_types = $3, description = $4, active = $5
+		WHERE id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtDeleteEndpoint, err = r.connPool.Prepare(`
+		DELETE FROM webhook_endpoint
+		WHERE id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtInsertDeliveries, err = r.connPool.Prepare(`
+		INSERT INTO webhook_delivery (endpoint_id, event_id, event_type)
+		SELECT id, $1, $2
+		FROM webhook_endpoint
+		WHERE active AND (cardinality(event_types) = 0 OR $2 = ANY(event_types))
+		ON CONFLICT (endpoint_id, event_id) DO NOTHING
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtClaimDeliveries, err = r.connPool.Prepare(`
+		WITH claimed AS (
+			UPDATE webhook_delivery
+			SET next_attempt_at = $3
+			WHERE id IN (
+				SELECT id
+				FROM webhook_delivery
+				WHERE status = 'pending' AND next_attempt_at <= $2
+				ORDER BY next_attempt_at
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, endpoint_id, event_id, event_type, attempts, next_attempt_at, created_at
+		)
+		SELECT c.id, c.endpoint_id, c.event_type, c.attempts, c.next_attempt_at, c.created_at, w.url, w.secret,
+			e.id, e.uuid, e.type, e.body, e.ip, e.user_agent, e.request_id, e.session_id, e.created_at
+		FROM claimed c
+		JOIN webhook_endpoint w ON w.id = c.endpoint_id
+		JOIN event e ON e.id = c.event_id
+		ORDER BY c.id
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtUpdateDelivery, err = r.connPool.Prepare(`
+		UPDATE webhook_delivery
+		SET status = $2, attempts = $3, next_attempt_at = $4, delivered_at = $5
+		WHERE id = $1
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtInsertAttempt, err = r.connPool.Prepare(`
+		INSERT INTO webhook_attempt (delivery_id, attempted_at, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectDeliveries, err = r.connPool.Prepare(`
+		SELECT id, endpoint_id, event_id, event_type, status, attempts, next_attempt_at, created_at, delivered_at
+		FROM webhook_delivery
+		WHERE endpoint_id = $1 AND ($2 = '' OR status = $2) AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectDelivery, err = r.connPool.Prepare(`
+		SELECT id, endpoint_id, event_id, event_type, status, attempts, next_attempt_at, created_at, delivered_at
+		FROM webhook_delivery
+		WHERE endpoint_id = $1 AND id = $2
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtSelectAttempts, err = r.connPool.Prepare(`
+		SELECT attempted_at, status_code, error, duration_ms
+		FROM webhook_attempt
+		WHERE delivery_id = $1
+		ORDER BY attempted_at
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.stmtRetryDelivery, err = r.connPool.Prepare(`
+		UPDATE webhook_delivery
+		SET status = 'pending', attempts = 0, next_attempt_at = (now() at time zone 'utc')
+		WHERE endpoint_id = $1 AND id = $2 AND status = 'dead'
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (r *webhookRepository) Close() error {
+	var err error
+	for _, stmt := range []*sql.Stmt{
+		r.stmtInsertEvent,
+		r.stmtInsertEndpoint,
+		r.stmtSelectEndpoint,
+		r.stmtSelectEndpoints,
+		r.stmtUpdateEndpoint,
+		r.stmtDeleteEndpoint,
+		r.stmtInsertDeliveries,
+		r.stmtClaimDeliveries,
+		r.stmtUpdateDelivery,
+		r.stmtInsertAttempt,
+		r.stmtSelectDeliveries,
+		r.stmtSelectDelivery,
+		r.stmtSelectAttempts,
+		r.stmtRetryDelivery,
+	} {
+		if e := stmt.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/model"
+)
+
+// updateUser changes the profile of the user making the request. The request body is a JSON object
+// containing the fields to change, currently only username (string), and the updated user is returned.
+func (s *RequestHandler) updateUser(w http.ResponseWriter, r *http.Request) {
+	sessionID, userID, err := s.sessionUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var update *model.UserUpdate
+	if err := parseRequestBody(r, &update); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if update == nil || update.Username == nil {
+		writeError(w, r, &model.ValidationError{Field: "body", Message: "request body must contain a field to update"})
+		return
+	}
+	username := model.NormalizeUsername(*update.Username)
+	update.Username = &username
+	if err := s.usernamePolicy.Validate(username); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	user, err := s.accountService.Update(model.WithSession(r.Context(), sessionID), userID, update)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, user)
+}
+
+// deleteUser erases the account of the user making the request and expires their session cookie.
+// The optional request body is a JSON object containing the user's password (string), which is
+// required unless the user signed in within service.ReauthWindow.
+func (s *RequestHandler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	sessionID, userID, err := s.sessionUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, &model.ValidationError{Field: "body", Message: "request body must be valid JSON"})
+		return
+	}
+	authTime, err := s.sessionService.AuthTime(r.Context(), sessionID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.accountService.Delete(model.WithSession(r.Context(), sessionID), userID, body.Password, authTime); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	http.SetCookie(w, s.expiredSessionCookie())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exportUser returns the data stored about the user making the request as a JSON attachment.
+func (s *RequestHandler) exportUser(w http.ResponseWriter, r *http.Request) {
+	_, userID, err := s.sessionUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	export, err := s.accountService.Export(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="account-export.json"`)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusOK, export)
+}
+
+// expiredSessionCookie returns a session cookie instructing the browser to remove its session.
+func (s *RequestHandler) expiredSessionCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     s.sessionConfig.Name,
+		Domain:   s.sessionConfig.Domain,
+		Path:     s.sessionConfig.Path,
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		Secure:   s.sessionConfig.Secure,
+		HttpOnly: s.sessionConfig.HTTPOnly,
+	}
+}
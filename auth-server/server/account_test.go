@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	repo "github.com/dgyurics/auth/auth-server/repository"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *HandlerTestSuite) TestExportUser(t *testing.T) {
+	user, session := suite.userWithRoles(t, "auditor")
+	require.Equal(t, http.StatusOK, suite.loginRequest(user.Username, "test").Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/user/export", nil)
+	req.AddCookie(session)
+	rr := httptest.NewRecorder()
+	suite.handler.exportUser(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Header().Get("Content-Disposition"), "attachment")
+
+	var export model.AccountExport
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&export))
+	require.Equal(t, user.ID, export.User.ID)
+	require.Empty(t, export.User.Password)
+	require.Equal(t, []string{"auditor"}, export.User.Roles)
+	require.Len(t, export.Sessions, 2)
+	require.NotEmpty(t, export.Events)
+	for _, event := range export.Events {
+		require.Equal(t, user.ID, event.UUID)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/user/export", nil)
+	rr = httptest.NewRecorder()
+	suite.handler.exportUser(rr, req)
+	decodeProblem(t, rr, http.StatusUnauthorized)
+}
+
+func (suite *HandlerTestSuite) TestDeleteUser(t *testing.T) {
+	user, session := suite.userWithRoles(t)
+
+	// signed in moments ago, no password required
+	req := httptest.NewRequest(http.MethodDelete, "/user", nil)
+	req.AddCookie(session)
+	rr := httptest.NewRecorder()
+	suite.handler.deleteUser(rr, req)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	cookie := rr.Result().Cookies()[0]
+	require.Equal(t, env.Session.Name, cookie.Name)
+	require.Less(t, cookie.MaxAge, 0)
+
+	require.Error(t, suite.authService.Fetch(context.Background(), &model.User{ID: user.ID}))
+	req = httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	suite.handler.verify(rr, req)
+	decodeProblem(t, rr, http.StatusUnauthorized)
+}
+
+func (suite *HandlerTestSuite) TestDeleteUserReauthenticate(t *testing.T) {
+	user, session := suite.userWithRoles(t)
+	signedIn := time.Now().Add(-time.Hour).Unix()
+	require.NoError(t, suite.sessionCache.Set(context.Background(), "auth_time:"+session.Value, strconv.FormatInt(signedIn, 10), 0))
+
+	for _, attempt := range []struct {
+		body   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{`{"password":"wrong"}`, http.StatusUnauthorized},
+		{`{"password":`, http.StatusBadRequest},
+		{`{"password":"test"}`, http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(http.MethodDelete, "/user", strings.NewReader(attempt.body))
+		req.AddCookie(session)
+		rr := httptest.NewRecorder()
+		suite.handler.deleteUser(rr, req)
+		require.Equal(t, attempt.status, rr.Code, attempt.body)
+	}
+	require.Error(t, suite.authService.Fetch(context.Background(), &model.User{ID: user.ID}))
+}
+
+func (suite *HandlerTestSuite) TestUpdateUser(t *testing.T) {
+	user, session := suite.userWithRoles(t)
+	other, _ := suite.userWithRoles(t)
+	notifications, unsubscribe := suite.notifications.Subscribe(context.Background(), user.ID)
+	defer func() { require.NoError(t, unsubscribe()) }()
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/user", strings.NewReader(body))
+		req.AddCookie(session)
+		rr := httptest.NewRecorder()
+		suite.handler.updateUser(rr, req)
+		return rr
+	}
+
+	oldUsername := user.Username
+	username := oldUsername + "x"
+	rr := update(`{"username":"` + username + `"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var updated model.User
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&updated))
+	require.Equal(t, username, updated.Username)
+	require.Empty(t, updated.Password)
+
+	events := suite.userRepo.(*repo.MockUserRepository).Events
+	event := events[len(events)-1]
+	require.Equal(t, model.UsernameChanged, event.Type)
+	payload, err := event.Payload()
+	require.NoError(t, err)
+	require.Equal(t, oldUsername, payload.(*model.UsernameChangedPayload).OldUsername)
+	require.Equal(t, username, payload.(*model.UsernameChangedPayload).NewUsername)
+
+	select {
+	case notification := <-notifications:
+		require.JSONEq(t, `{"type":"username_changed","username":"`+username+`"}`, notification)
+	case <-time.After(time.Second):
+		t.Fatal("notification not delivered")
+	}
+
+	// the gateway is sent the new username
+	req := httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	suite.handler.verify(rr, req)
+	require.Equal(t, username, rr.Header().Get(headerUsername))
+
+	for body, status := range map[string]int{
+		`{"username":"` + other.Username + `"}`: http.StatusConflict,
+		`{"username":"not valid"}`:              http.StatusBadRequest,
+		`{}`:                                    http.StatusBadRequest,
+		`not json`:                              http.StatusBadRequest,
+	} {
+		decodeProblem(t, update(body), status)
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// Page sizes of the admin API
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// userPage is a page of the users matching a search.
+type userPage struct {
+	Users  []*model.User `json:"users"`
+	Total  int           `json:"total"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+}
+
+// adminRoutes registers the admin API, which is restricted to users assigned the admin role.
+func (s *RequestHandler) adminRoutes(r chi.Router) {
+	r.Use(s.requireRole(model.RoleAdmin))
+	r.Get("/events", s.adminSearchEvents)
+	r.Get("/users", s.adminUsers)
+	r.Get("/users/{id}", s.adminUser)
+	r.Delete("/users/{id}", s.adminDelete)
+	r.Get("/users/{id}/sessions", s.adminSessions)
+	r.Get("/users/{id}/events", s.adminEvents)
+	r.Get("/users/{id}/activity", s.adminActivity)
+	r.Post("/users/{id}/logout", s.adminLogout)
+	r.Post("/users/{id}/lock", s.adminLock)
+	r.Post("/users/{id}/unlock", s.adminUnlock)
+	r.Post("/users/{id}/disable", s.adminDisable)
+	r.Post("/users/{id}/enable", s.adminEnable)
+	r.Post("/users/{id}/verify", s.adminVerify)
+	r.Route("/webhooks", s.webhookRoutes)
+}
+
+// adminUsers lists users ordered by username. The optional q query parameter
+// restricts the list to users whose username contains it, and limit and offset page through the list.
+func (s *RequestHandler) adminUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := intParam(r, "limit", defaultPageSize, 1, maxPageSize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	offset, err := intParam(r, "offset", 0, 0, -1)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	users, total, err := s.adminService.Users(r.Context(), query.Get("q"), limit, offset)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &userPage{users, total, limit, offset})
+}
+
+// adminUser returns the user identified by the id URL parameter, including their status and roles.
+func (s *RequestHandler) adminUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	user, err := s.adminService.User(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, user)
+}
+
+// adminSessions lists the active sessions of the user identified by the id URL parameter.
+func (s *RequestHandler) adminSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	sessions, err := s.adminService.Sessions(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, sessions)
+}
+
+// adminEvents lists the events of the user identified by the id URL parameter, newest first.
+// See eventFilter for the query parameters selecting the events.
+func (s *RequestHandler) adminEvents(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	filter, err := eventFilter(r, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	events, err := s.adminService.Events(r.Context(), filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newEventPage(events, filter.Limit))
+}
+
+// adminSearchEvents lists the events of every user, newest first, such as those caused by
+// requests from an address. See eventFilter for the query parameters selecting the events.
+func (s *RequestHandler) adminSearchEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := eventFilter(r, uuid.Nil)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	events, err := s.adminService.Events(r.Context(), filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newEventPage(events, filter.Limit))
+}
+
+// adminActivity returns the activity of the user identified by the id URL parameter, projected
+// from their events. The optional logins query parameter is the number of recent logins listed.
+func (s *RequestHandler) adminActivity(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	logins, err := intParam(r, "logins", 10, 0, maxPageSize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	activity, err := s.adminService.Activity(r.Context(), userID, logins)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, activity)
+}
+
+// adminLogout signs the user identified by the id URL parameter out of every session.
+func (s *RequestHandler) adminLogout(w http.ResponseWriter, r *http.Request) {
+	s.adminAction(w, r, s.adminService.Logout)
+}
+
+// adminLock temporarily prevents the user identified by the id URL parameter from signing in,
+// and signs them out of every session. The optional request body is a JSON object with
+// a reason (string) recorded in the account_locked event.
+func (s *RequestHandler) adminLock(w http.ResponseWriter, r *http.Request) {
+	reason, err := parseReason(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.adminAction(w, r, func(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error {
+		return s.adminService.Lock(ctx, userID, actorID, reason)
+	})
+}
+
+// adminUnlock allows the locked user identified by the id URL parameter to sign in again.
+func (s *RequestHandler) adminUnlock(w http.ResponseWriter, r *http.Request) {
+	s.adminAction(w, r, s.adminService.Unlock)
+}
+
+// adminDisable indefinitely prevents the user identified by the id URL parameter from signing in,
+// and signs them out of every session. The optional request body is a JSON object with
+// a reason (string) recorded in the account_disabled event.
+func (s *RequestHandler) adminDisable(w http.ResponseWriter, r *http.Request) {
+	reason, err := parseReason(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.adminAction(w, r, func(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error {
+		return s.adminService.Disable(ctx, userID, actorID, reason)
+	})
+}
+
+// adminEnable allows the disabled user identified by the id URL parameter to sign in again.
+func (s *RequestHandler) adminEnable(w http.ResponseWriter, r *http.Request) {
+	s.adminAction(w, r, s.adminService.Enable)
+}
+
+// adminVerify allows the user pending verification identified by the id URL parameter to sign in.
+func (s *RequestHandler) adminVerify(w http.ResponseWriter, r *http.Request) {
+	s.adminAction(w, r, s.adminService.Verify)
+}
+
+// adminDelete deletes the user identified by the id URL parameter.
+func (s *RequestHandler) adminDelete(w http.ResponseWriter, r *http.Request) {
+	s.adminAction(w, r, s.adminService.Delete)
+}
+
+// adminAction applies action to the user identified by the id URL parameter
+// on behalf of the admin making the request.
+func (s *RequestHandler) adminAction(w http.ResponseWriter, r *http.Request,
+	action func(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error) {
+	userID, err := userIDParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := action(r.Context(), userID, requestIdentity(r).UserID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseReason returns the reason given in the optional JSON request body of an admin action.
+func parseReason(r *http.Request) (string, error) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		return "", &model.ValidationError{Field: "body", Message: "request body must be valid JSON"}
+	}
+	if len(body.Reason) > 500 {
+		return "", &model.ValidationError{Field: "reason", Message: "reason cannot exceed 500 characters"}
+	}
+	return body.Reason, nil
+}
+
+// intParam returns the integer query parameter name, or def when it is absent.
+// A model.ValidationError is returned when the value is below min, or above max when max is not negative.
+func intParam(r *http.Request, name string, def int, min int, max int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || (max >= 0 && n > max) {
+		message := name + " must be an integer of at least " + strconv.Itoa(min)
+		if max >= 0 {
+			message = name + " must be an integer between " + strconv.Itoa(min) + " and " + strconv.Itoa(max)
+		}
+		return 0, &model.ValidationError{Field: name, Message: message}
+	}
+	return n, nil
+}
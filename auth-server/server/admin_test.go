@@ -0,0 +1,307 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	repo "github.com/dgyurics/auth/auth-server/repository"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *HandlerTestSuite) TestAdminRequiresRole(t *testing.T) {
+	router := suite.adminRouter()
+
+	rr := suite.adminRequest(router, http.MethodGet, "/admin/users", nil)
+	decodeProblem(t, rr, http.StatusUnauthorized)
+
+	// permissions granted by other roles are not sufficient
+	_, session := suite.userWithRoles(t, "auditor")
+	rr = suite.adminRequest(router, http.MethodGet, "/admin/users", session)
+	problem := decodeProblem(t, rr, http.StatusForbidden)
+	require.Equal(t, problemForbidden, problem.Type)
+
+	_, session = suite.userWithRoles(t, "admin")
+	rr = suite.adminRequest(router, http.MethodGet, "/admin/users", session)
+	require.Equal(t, http.StatusOK, rr.Code)
+}
+
+func (suite *HandlerTestSuite) TestAdminUsers(t *testing.T) {
+	router := suite.adminRouter()
+	_, session := suite.userWithRoles(t, "admin")
+	user, _ := suite.userWithRoles(t)
+
+	rr := suite.adminRequest(router, http.MethodGet, "/admin/users?q="+user.Username, session)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var page userPage
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+	require.Equal(t, 1, page.Total)
+	require.Equal(t, user.ID, page.Users[0].ID)
+	require.Empty(t, page.Users[0].Password)
+
+	rr = suite.adminRequest(router, http.MethodGet, "/admin/users?limit=1&offset=1", session)
+	require.Equal(t, http.StatusOK, rr.Code)
+	page = userPage{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+	require.Len(t, page.Users, 1)
+	require.Greater(t, page.Total, 1)
+	require.Equal(t, 1, page.Offset)
+
+	rr = suite.adminRequest(router, http.MethodGet, "/admin/users?limit=1000", session)
+	decodeProblem(t, rr, http.StatusBadRequest)
+
+	rr = suite.adminRequest(router, http.MethodGet, "/admin/users/"+user.ID.String(), session)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var fetched model.User
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&fetched))
+	require.Equal(t, model.UserActive, fetched.Status)
+	require.Empty(t, fetched.Password)
+}
+
+func (suite *HandlerTestSuite) TestAdminLock(t *testing.T) {
+	router := suite.adminRouter()
+	admin, adminSession := suite.userWithRoles(t, "admin")
+	user, userSession := suite.userWithRoles(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/users/"+user.ID.String()+"/lock", strings.NewReader(`{"reason":"suspicious activity"}`))
+	req.AddCookie(adminSession)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	events := suite.userRepo.(*repo.MockUserRepository).Events
+	require.Equal(t, model.AccountLocked, events[len(events)-1].Type)
+	require.Contains(t, string(events[len(events)-1].Body), "suspicious activity")
+
+	// existing sessions are signed out
+	req = httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.AddCookie(userSession)
+	rr = httptest.NewRecorder()
+	suite.handler.verify(rr, req)
+	decodeProblem(t, rr, http.StatusUnauthorized)
+
+	// new logins are rejected
+	rr = suite.loginRequest(user.Username, "test")
+	problem := decodeProblem(t, rr, http.StatusForbidden)
+	require.Equal(t, "account is locked", problem.Detail)
+
+	// locked accounts are enabled by unlocking them
+	rr = suite.adminRequest(router, http.MethodPost, "/admin/users/"+user.ID.String()+"/enable", adminSession)
+	decodeProblem(t, rr, http.StatusConflict)
+
+	// locking twice, and locking yourself, conflicts
+	rr = suite.adminRequest(router, http.MethodPost, "/admin/users/"+user.ID.String()+"/lock", adminSession)
+	decodeProblem(t, rr, http.StatusConflict)
+	rr = suite.adminRequest(router, http.MethodPost, "/admin/users/"+admin.ID.String()+"/lock", adminSession)
+	decodeProblem(t, rr, http.StatusConflict)
+
+	rr = suite.adminRequest(router, http.MethodPost, "/admin/users/"+user.ID.String()+"/unlock", adminSession)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	rr = suite.loginRequest(user.Username, "test")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	events = suite.userRepo.(*repo.MockUserRepository).Events
+	require.Equal(t, model.AccountUnlocked, events[len(events)-1].Type)
+	payload, err := events[len(events)-1].Payload()
+	require.NoError(t, err)
+	require.Equal(t, admin.ID, payload.(*model.AccountActionPayload).ActorID)
+}
+
+func (suite *HandlerTestSuite) TestAdminDisable(t *testing.T) {
+	router := suite.adminRouter()
+	_, adminSession := suite.userWithRoles(t, "admin")
+	user, userSession := suite.userWithRoles(t)
+
+	rr := suite.adminRequest(router, http.MethodPost, "/admin/users/"+user.ID.String()+"/disable", adminSession)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	events := suite.userRepo.(*repo.MockUserRepository).Events
+	require.Equal(t, model.AccountDisabled, events[len(events)-1].Type)
+
+	req := httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.AddCookie(userSession)
+	rr = httptest.NewRecorder()
+	suite.handler.verify(rr, req)
+	decodeProblem(t, rr, http.StatusUnauthorized)
+
+	rr = suite.loginRequest(user.Username, "test")
+	problem := decodeProblem(t, rr, http.StatusForbidden)
+	require.Equal(t, "account is disabled", problem.Detail)
+
+	// disabled accounts cannot be locked or unlocked
+	rr = suite.adminRequest(router, http.MethodPost, "/admin/users/"+user.ID.String()+"/lock", adminSession)
+	decodeProblem(t, rr, http.StatusConflict)
+	rr = suite.adminRequest(router, http.MethodPost, "/admin/users/"+user.ID.String()+"/unlock", adminSession)
+	decodeProblem(t, rr, http.StatusConflict)
+
+	rr = suite.adminRequest(router, http.MethodPost, "/admin/users/"+user.ID.String()+"/enable", adminSession)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	rr = suite.loginRequest(user.Username, "test")
+	require.Equal(t, http.StatusOK, rr.Code)
+}
+
+func (suite *HandlerTestSuite) TestAdminVerify(t *testing.T) {
+	router := suite.adminRouter()
+	_, adminSession := suite.userWithRoles(t, "admin")
+	user, _ := suite.userWithRoles(t)
+	require.NoError(t, suite.userRepo.UpdateStatus(context.Background(), user.ID, model.UserPendingVerification, &model.Event{}))
+
+	rr := suite.loginRequest(user.Username, "test")
+	problem := decodeProblem(t, rr, http.StatusForbidden)
+	require.Equal(t, "account is pending verification", problem.Detail)
+
+	rr = suite.adminRequest(router, http.MethodPost, "/admin/users/"+user.ID.String()+"/verify", adminSession)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	events := suite.userRepo.(*repo.MockUserRepository).Events
+	require.Equal(t, model.AccountVerified, events[len(events)-1].Type)
+
+	rr = suite.loginRequest(user.Username, "test")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// only users pending verification can be verified
+	rr = suite.adminRequest(router, http.MethodPost, "/admin/users/"+user.ID.String()+"/verify", adminSession)
+	decodeProblem(t, rr, http.StatusConflict)
+}
+
+func (suite *HandlerTestSuite) TestVerifyInactiveUser(t *testing.T) {
+	user, session := suite.userWithRoles(t)
+
+	// status changed without signing the user out, e.g. directly in the database
+	require.NoError(t, suite.userRepo.UpdateStatus(context.Background(), user.ID, model.UserDisabled, &model.Event{}))
+	require.NoError(t, suite.sessionService.RemoveIdentity(context.Background(), user.ID))
+
+	req := httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.AddCookie(session)
+	rr := httptest.NewRecorder()
+	suite.handler.verify(rr, req)
+	decodeProblem(t, rr, http.StatusForbidden)
+
+	// the sessions of the user are removed
+	sessions, err := suite.sessionService.UserSessions(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+}
+
+func (suite *HandlerTestSuite) TestAdminLogout(t *testing.T) {
+	router := suite.adminRouter()
+	admin, adminSession := suite.userWithRoles(t, "admin")
+	user, userSession := suite.userWithRoles(t)
+
+	rr := suite.adminRequest(router, http.MethodGet, "/admin/users/"+user.ID.String()+"/sessions", adminSession)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var sessions []*model.Session
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&sessions))
+	require.Len(t, sessions, 1)
+	require.NotEqual(t, userSession.Value, sessions[0].ID)
+
+	rr = suite.adminRequest(router, http.MethodPost, "/admin/users/"+user.ID.String()+"/logout", adminSession)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = suite.adminRequest(router, http.MethodGet, "/admin/users/"+user.ID.String()+"/sessions", adminSession)
+	require.Equal(t, http.StatusOK, rr.Code)
+	sessions = nil
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&sessions))
+	require.Empty(t, sessions)
+
+	rr = suite.adminRequest(router, http.MethodGet, "/admin/users/"+user.ID.String()+"/events?limit=1", adminSession)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var page eventPage
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+	require.Len(t, page.Events, 1)
+	require.Equal(t, model.LoggedOutAll, page.Events[0].Type)
+	require.Contains(t, string(page.Events[0].Body), admin.ID.String())
+	require.Equal(t, page.Events[0].ID, page.NextBefore)
+}
+
+func (suite *HandlerTestSuite) TestAdminDelete(t *testing.T) {
+	router := suite.adminRouter()
+	_, adminSession := suite.userWithRoles(t, "admin")
+	user, userSession := suite.userWithRoles(t)
+
+	rr := suite.adminRequest(router, http.MethodDelete, "/admin/users/"+user.ID.String(), adminSession)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.AddCookie(userSession)
+	rr = httptest.NewRecorder()
+	suite.handler.verify(rr, req)
+	decodeProblem(t, rr, http.StatusUnauthorized)
+
+	rr = suite.adminRequest(router, http.MethodGet, "/admin/users/"+user.ID.String(), adminSession)
+	decodeProblem(t, rr, http.StatusNotFound)
+	rr = suite.adminRequest(router, http.MethodDelete, "/admin/users/"+user.ID.String(), adminSession)
+	decodeProblem(t, rr, http.StatusNotFound)
+}
+
+func (suite *HandlerTestSuite) TestAdminActivity(t *testing.T) {
+	router := suite.adminRouter()
+	_, adminSession := suite.userWithRoles(t, "admin")
+	user, _ := suite.userWithRoles(t)
+	target := "/admin/users/" + user.ID.String() + "/activity"
+
+	// users without projected activity have none
+	rr := suite.adminRequest(router, http.MethodGet, target, adminSession)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var activity model.UserActivity
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&activity))
+	require.Equal(t, user.ID, activity.UserID)
+	require.Nil(t, activity.LastLoginAt)
+	require.Empty(t, activity.Logins)
+
+	lastLogin := time.Now().UTC().Truncate(time.Second)
+	suite.projectionRepo.Activity[user.ID] = &model.UserActivity{
+		UserID:         user.ID,
+		LastLoginAt:    &lastLogin,
+		LoginCount:     2,
+		ActiveSessions: 1,
+		Logins: []*model.Login{
+			{EventID: 2, Provider: "oidc", CreatedAt: lastLogin},
+			{EventID: 1, CreatedAt: lastLogin.Add(-time.Hour)},
+		},
+	}
+	rr = suite.adminRequest(router, http.MethodGet, target+"?logins=1", adminSession)
+	require.Equal(t, http.StatusOK, rr.Code)
+	activity = model.UserActivity{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&activity))
+	require.Equal(t, 2, activity.LoginCount)
+	require.Equal(t, 1, activity.ActiveSessions)
+	require.True(t, lastLogin.Equal(*activity.LastLoginAt))
+	require.Len(t, activity.Logins, 1)
+	require.Equal(t, "oidc", activity.Logins[0].Provider)
+
+	rr = suite.adminRequest(router, http.MethodGet, target+"?logins=-1", adminSession)
+	decodeProblem(t, rr, http.StatusBadRequest)
+	rr = suite.adminRequest(router, http.MethodGet, "/admin/users/"+uuid.New().String()+"/activity", adminSession)
+	decodeProblem(t, rr, http.StatusNotFound)
+}
+
+// adminRouter routes the admin API as registered by NewHTTPServer
+func (suite *HandlerTestSuite) adminRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Route("/admin", suite.handler.adminRoutes)
+	return r
+}
+
+func (suite *HandlerTestSuite) adminRequest(router http.Handler, method string, target string, session *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if session != nil {
+		req.AddCookie(session)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func (suite *HandlerTestSuite) loginRequest(username string, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(&model.User{Username: username, Password: password})
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	suite.handler.login(rr, req)
+	return rr
+}
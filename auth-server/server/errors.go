@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/go-chi/chi/middleware"
+)
+
+// problem is a problem details document as described by RFC 7807.
+// It is the body of every error response returned by the server.
+type problem struct {
+	Type      string                   `json:"type"`
+	Title     string                   `json:"title"`
+	Status    int                      `json:"status"`
+	Detail    string                   `json:"detail,omitempty"`
+	Instance  string                   `json:"instance,omitempty"`
+	RequestID string                   `json:"request_id,omitempty"`
+	Errors    []*model.ValidationError `json:"errors,omitempty"`
+}
+
+// Values for problem.Type
+const (
+	problemValidation   = "urn:auth:problem:validation"
+	problemUnauthorized = "urn:auth:problem:unauthorized"
+	problemForbidden    = "urn:auth:problem:forbidden"
+	problemNotFound     = "urn:auth:problem:not-found"
+	problemConflict     = "urn:auth:problem:conflict"
+	problemRateLimited  = "urn:auth:problem:rate-limited"
+	problemInternal     = "urn:auth:problem:internal"
+)
+
+// writeError maps err onto a problem document and writes it to w.
+// Errors not defined in the model package are logged and reported as an internal
+// server error, so driver or cache errors are never leaked to the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	p := newProblem(err)
+	p.Instance = r.URL.Path
+	p.RequestID = middleware.GetReqID(r.Context())
+	if p.Status == http.StatusInternalServerError {
+		log.Printf("request %s failed: %s", p.RequestID, err)
+	}
+
+	var rateLimited *model.RateLimitedError
+	if errors.As(err, &rateLimited) && rateLimited.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateLimited.RetryAfter.Seconds()))))
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Printf("failed to write error response: %s", err)
+	}
+}
+
+func newProblem(err error) *problem {
+	if fields := validationErrors(err); len(fields) > 0 {
+		return &problem{
+			Type:   problemValidation,
+			Title:  "Validation Failed",
+			Status: http.StatusBadRequest,
+			Detail: fields[0].Message,
+			Errors: fields,
+		}
+	}
+
+	var (
+		unauthorized *model.UnauthorizedError
+		forbidden    *model.ForbiddenError
+		notFound     *model.NotFoundError
+		conflict     *model.ConflictError
+		rateLimited  *model.RateLimitedError
+	)
+	switch {
+	case errors.As(err, &unauthorized):
+		return &problem{
+			Type:   problemUnauthorized,
+			Title:  http.StatusText(http.StatusUnauthorized),
+			Status: http.StatusUnauthorized,
+			Detail: unauthorized.Error(),
+		}
+	case errors.As(err, &forbidden):
+		return &problem{
+			Type:   problemForbidden,
+			Title:  http.StatusText(http.StatusForbidden),
+			Status: http.StatusForbidden,
+			Detail: forbidden.Error(),
+		}
+	case errors.As(err, &notFound):
+		return &problem{
+			Type:   problemNotFound,
+			Title:  http.StatusText(http.StatusNotFound),
+			Status: http.StatusNotFound,
+			Detail: notFound.Error(),
+		}
+	case errors.As(err, &conflict):
+		return &problem{
+			Type:   problemConflict,
+			Title:  http.StatusText(http.StatusConflict),
+			Status: http.StatusConflict,
+			Detail: conflict.Error(),
+		}
+	case errors.As(err, &rateLimited):
+		return &problem{
+			Type:   problemRateLimited,
+			Title:  http.StatusText(http.StatusTooManyRequests),
+			Status: http.StatusTooManyRequests,
+			Detail: rateLimited.Error(),
+		}
+	default:
+		return &problem{
+			Type:   problemInternal,
+			Title:  http.StatusText(http.StatusInternalServerError),
+			Status: http.StatusInternalServerError,
+		}
+	}
+}
+
+// validationErrors collects every ValidationError contained in err.
+func validationErrors(err error) []*model.ValidationError {
+	var errs model.Errors
+	if !errors.As(err, &errs) {
+		var field *model.ValidationError
+		if errors.As(err, &field) {
+			return []*model.ValidationError{field}
+		}
+		return nil
+	}
+	var fields []*model.ValidationError
+	for _, e := range errs {
+		var field *model.ValidationError
+		if errors.As(e, &field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
+)
+
+// eventPage is a page of events, newest first. NextBefore, when set, is the
+// before query parameter requesting the next page.
+type eventPage struct {
+	Events     []*model.Event `json:"events"`
+	NextBefore int64          `json:"next_before,omitempty"`
+}
+
+// newEventPage returns the page of events, which is followed by another
+// when it is full, i.e. there are limit events.
+func newEventPage(events []*model.Event, limit int) *eventPage {
+	page := &eventPage{Events: events}
+	if len(events) == limit {
+		page.NextBefore = events[len(events)-1].ID
+	}
+	return page
+}
+
+// events lists the events of the user making the request, their security history, newest first.
+// See eventFilter for the query parameters selecting the events.
+func (s *RequestHandler) events(w http.ResponseWriter, r *http.Request) {
+	_, userID, err := s.sessionUser(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	filter, err := eventFilter(r, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	events, err := s.accountService.Events(r.Context(), filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newEventPage(events, filter.Limit))
+}
+
+// eventFilter returns the filter selecting the events of the user identified by userID,
+// or of every user when uuid.Nil, from the query parameters of r:
+//   - type: comma separated event types, may be repeated
+//   - ip: an address or CIDR range, events caused by requests from it
+//   - since and until: RFC 3339 timestamps, events created at or after since and before until
+//   - before: the next_before of the previous page
+//   - limit: the size of the page
+func eventFilter(r *http.Request, userID uuid.UUID) (*model.EventFilter, error) {
+	limit, err := intParam(r, "limit", defaultPageSize, 1, maxPageSize)
+	if err != nil {
+		return nil, err
+	}
+	before, err := intParam(r, "before", 0, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	filter := &model.EventFilter{
+		UUID:   userID,
+		Before: int64(before),
+		Limit:  limit,
+	}
+	for _, types := range r.URL.Query()["type"] {
+		for _, eventType := range strings.Split(types, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				filter.Types = append(filter.Types, model.EventType(eventType))
+			}
+		}
+	}
+	if filter.IP = r.URL.Query().Get("ip"); filter.IP != "" {
+		_, _, cidrErr := net.ParseCIDR(filter.IP)
+		if net.ParseIP(filter.IP) == nil && cidrErr != nil {
+			return nil, &model.ValidationError{Field: "ip", Message: "ip must be an IP address or CIDR range"}
+		}
+	}
+	if filter.Since, err = timeParam(r, "since"); err != nil {
+		return nil, err
+	}
+	if filter.Until, err = timeParam(r, "until"); err != nil {
+		return nil, err
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		return nil, &model.ValidationError{Field: "until", Message: "until must be after since"}
+	}
+	return filter, nil
+}
+
+// timeParam returns the RFC 3339 timestamp query parameter name, or the zero time when it is absent.
+func timeParam(r *http.Request, name string) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, &model.ValidationError{Field: name, Message: name + " must be an RFC 3339 timestamp"}
+	}
+	return t, nil
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *HandlerTestSuite) TestEvents(t *testing.T) {
+	user, session := suite.userWithRoles(t)
+	other, _ := suite.userWithRoles(t)
+	start := time.Now().Add(-time.Second)
+	for _, event := range []*model.Event{
+		{UUID: user.ID, Type: model.LoggedIn, Body: json.RawMessage(`{"id":"` + user.ID.String() + `"}`)},
+		{UUID: other.ID, Type: model.LoggedIn},
+		{UUID: user.ID, Type: model.UsernameChanged},
+		{UUID: user.ID, Type: model.LoggedOut},
+		{UUID: user.ID, Type: model.LoggedIn},
+	} {
+		require.NoError(t, suite.eventRepo.CreateEvent(context.Background(), event))
+	}
+
+	events := func(query url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/events?"+query.Encode(), nil)
+		req.AddCookie(session)
+		rr := httptest.NewRecorder()
+		suite.handler.events(rr, req)
+		return rr
+	}
+	page := func(query url.Values) *eventPage {
+		rr := events(query)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var page eventPage
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+		return &page
+	}
+
+	// only the events of the user, newest first, with the body as JSON
+	all := page(url.Values{"since": {start.Format(time.RFC3339)}})
+	require.Len(t, all.Events, 4)
+	for _, event := range all.Events {
+		require.Equal(t, user.ID, event.UUID)
+	}
+	require.Equal(t, model.LoggedIn, all.Events[0].Type)
+	require.JSONEq(t, `{"id":"`+user.ID.String()+`"}`, string(all.Events[3].Body))
+	require.Zero(t, all.NextBefore)
+
+	// filtered by type, comma separated or repeated
+	for _, query := range []url.Values{
+		{"type": {"logged_in,logged_out"}},
+		{"type": {"logged_in", "logged_out"}},
+	} {
+		filtered := page(query)
+		require.Len(t, filtered.Events, 3)
+		for _, event := range filtered.Events {
+			require.NotEqual(t, model.UsernameChanged, event.Type)
+		}
+	}
+
+	// filtered by time range
+	require.Empty(t, page(url.Values{"until": {start.Format(time.RFC3339)}}).Events)
+	require.Empty(t, page(url.Values{"since": {time.Now().Add(time.Minute).Format(time.RFC3339)}}).Events)
+
+	// paged with next_before
+	first := page(url.Values{"limit": {"3"}})
+	require.Len(t, first.Events, 3)
+	require.Equal(t, first.Events[2].ID, first.NextBefore)
+	second := page(url.Values{"limit": {"3"}, "before": {strconv.FormatInt(first.NextBefore, 10)}})
+	require.Len(t, second.Events, 1)
+	require.Equal(t, model.LoggedIn, second.Events[0].Type)
+	require.Zero(t, second.NextBefore)
+	last := page(url.Values{"limit": {"3"}, "type": {"logged_in"}})
+	require.Len(t, last.Events, 2)
+	require.Zero(t, last.NextBefore)
+
+	for _, query := range []url.Values{
+		{"since": {"yesterday"}},
+		{"until": {"2023-01-01"}},
+		{"since": {"2023-01-02T00:00:00Z"}, "until": {"2023-01-01T00:00:00Z"}},
+		{"limit": {"0"}},
+		{"before": {"-1"}},
+		{"ip": {"not an address"}},
+	} {
+		p := decodeProblem(t, events(query), http.StatusBadRequest)
+		require.Equal(t, problemValidation, p.Type)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	rr := httptest.NewRecorder()
+	suite.handler.events(rr, req)
+	decodeProblem(t, rr, http.StatusUnauthorized)
+}
+
+func (suite *HandlerTestSuite) TestAdminSearchEvents(t *testing.T) {
+	router := suite.adminRouter()
+	_, session := suite.userWithRoles(t, "admin")
+	user, _ := suite.userWithRoles(t)
+	other, _ := suite.userWithRoles(t)
+	for _, event := range []*model.Event{
+		{UUID: user.ID, Type: model.LoggedIn, Context: &model.EventContext{IP: "203.0.113.7"}},
+		{UUID: other.ID, Type: model.LoggedIn, Context: &model.EventContext{IP: "203.0.113.8"}},
+		{UUID: other.ID, Type: model.LoggedOut, Context: &model.EventContext{IP: "198.51.100.1"}},
+	} {
+		require.NoError(t, suite.eventRepo.CreateEvent(context.Background(), event))
+	}
+	search := func(query url.Values) *eventPage {
+		rr := suite.adminRequest(router, http.MethodGet, "/admin/events?"+query.Encode(), session)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var page eventPage
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+		return &page
+	}
+
+	// the events of every user caused by requests from an address or range
+	page := search(url.Values{"ip": {"203.0.113.7"}})
+	require.Len(t, page.Events, 1)
+	require.Equal(t, user.ID, page.Events[0].UUID)
+	require.Equal(t, "203.0.113.7", page.Events[0].Context.IP)
+	page = search(url.Values{"ip": {"203.0.113.0/24"}})
+	require.Len(t, page.Events, 2)
+	require.Equal(t, other.ID, page.Events[0].UUID)
+	require.Len(t, search(url.Values{"ip": {"203.0.113.0/24"}, "type": {"logged_out"}}).Events, 0)
+
+	rr := suite.adminRequest(router, http.MethodGet, "/admin/events?ip=203.0.113", session)
+	decodeProblem(t, rr, http.StatusBadRequest)
+}
@@ -6,27 +6,74 @@ import (
 	"errors"
 	"log"
 	"net/http"
-	"regexp"
+	"strings"
 	"time"
 
+	"github.com/dgyurics/auth/auth-server/alert"
+	"github.com/dgyurics/auth/auth-server/archive"
+	"github.com/dgyurics/auth/auth-server/audit"
 	"github.com/dgyurics/auth/auth-server/cache"
 	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/geo"
+	"github.com/dgyurics/auth/auth-server/identity"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/migration"
 	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/oidc"
+	"github.com/dgyurics/auth/auth-server/outbox"
+	"github.com/dgyurics/auth/auth-server/projection"
 	"github.com/dgyurics/auth/auth-server/repository"
 	"github.com/dgyurics/auth/auth-server/service"
+	"github.com/dgyurics/auth/auth-server/webhook"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 // TODO Prevent user from creating too many sessions
 
+// Headers used to pass the identity of a verified user to the api-gateway.
+const (
+	headerUserID    = "X-User-ID"
+	headerUsername  = "X-Username"
+	headerRoles     = "X-User-Roles"
+	headerAssertion = identity.Header
+)
+
 // RequestHandler contains necessary dependents to handle HTTP requests.
 type RequestHandler struct {
-	sessionConfig   config.Session
-	authService     service.AuthService
-	sessionService  service.SessionService
-	userRepository  repository.UserRepository
-	eventRepository repository.EventRepository
-	upgrader        websocket.Upgrader
+	sessionConfig        config.Session
+	oauthConfig          config.OAuth
+	socialConfig         config.SocialLogin
+	usernamePolicy       *model.UsernamePolicy
+	authService          service.AuthService
+	sessionService       service.SessionService
+	assertionService     service.AssertionService
+	oauthService         service.OAuthService
+	socialService        service.SocialService
+	roleService          service.RoleService
+	adminService         service.AdminService
+	accountService       service.AccountService
+	notificationService  service.NotificationService
+	webhookService       service.WebhookService
+	userRepository       repository.UserRepository
+	eventRepository      repository.EventRepository
+	oauthRepository      repository.OAuthRepository
+	identityRepository   repository.IdentityRepository
+	roleRepository       repository.RoleRepository
+	webhookRepository    repository.WebhookRepository
+	projectionRepository repository.ProjectionRepository
+	upgrader             websocket.Upgrader
+	relay                *outbox.Relay // nil when the outbox is disabled
+	projectionRelays     []*outbox.Relay
+	webhookRelay         *outbox.Relay
+	dispatcher           *webhook.Dispatcher
+	auditRepository      repository.AuditRepository
+	checkpointer         *audit.Checkpointer // nil when checkpoints are disabled
+	archiveRepository    repository.ArchiveRepository
+	archiver             *archive.Archiver    // nil when archiving is disabled
+	locator              geo.Locator          // nil when logins are not geolocated
+	notifier             alert.Notifier       // nil when suspicious logins are only notified through the websocket
+	loginMonitor         service.LoginMonitor // nil when suspicious logins are not detected
 }
 
 // NewHTTPHandler returns an instance of HTTPHandler
@@ -35,15 +82,136 @@ func NewHTTPHandler(config config.Config) *RequestHandler {
 	sqlClient := repository.NewDBClient()
 	sqlClient.Connect(config.PostgreSQL)
 
+	// apply pending migrations, before the statements of repositories are prepared against the schema
+	if config.PostgreSQL.Migrate {
+		migrations, err := migration.Embedded()
+		if err != nil {
+			log.Fatal(err)
+		}
+		applied, err := migration.Up(context.Background(), repository.NewMigrationRepository(sqlClient), migrations)
+		for _, m := range applied {
+			log.Printf("applied migration %d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// create session service
 	redisClient := cache.NewClient(config.Redis)
 	sessionCache := cache.NewSessionCache(redisClient)
 	sessionService := service.NewSessionService(sessionCache)
 
-	// create auth service
+	// create username policy
+	usernamePolicy := model.NewUsernamePolicy(config.Username.MinLength, config.Username.MaxLength,
+		config.Username.Unicode, strings.Split(config.Username.Reserved, ","))
+
+	// create auth service, checking logins for suspicious activity
 	userRepo := repository.NewUserRepository(sqlClient)
 	eventRepo := repository.NewEventRepository(sqlClient)
-	authService := service.NewAuthService(userRepo, eventRepo)
+	notificationService := service.NewNotificationService(cache.NewPubSub(redisClient))
+	var locator geo.Locator
+	var notifier alert.Notifier
+	var loginMonitor service.LoginMonitor
+	if config.SuspiciousLogin.Enabled {
+		if config.SuspiciousLogin.GeoIPDatabase != "" {
+			maxMind, err := geo.NewMaxMindLocator(config.SuspiciousLogin.GeoIPDatabase)
+			if err != nil {
+				log.Fatal(err)
+			}
+			locator = maxMind
+		}
+		if config.SuspiciousLogin.Notifier != "" {
+			n, err := alert.NewNotifier(config.SuspiciousLogin)
+			if err != nil {
+				log.Fatal(err)
+			}
+			notifier = n
+		}
+		loginMonitor = service.NewLoginMonitor(eventRepo, notificationService, notifier, locator, config.SuspiciousLogin)
+	}
+	authService := service.NewAuthService(userRepo, eventRepo, loginMonitor, config.Registration)
+
+	// create role service
+	roleRepo := repository.NewRoleRepository(sqlClient)
+	roleService := service.NewRoleService(roleRepo, userRepo)
+
+	// create admin service
+	projectionRepo := repository.NewProjectionRepository(sqlClient)
+	adminService := service.NewAdminService(userRepo, eventRepo, roleRepo, projectionRepo, sessionService)
+
+	// create assertion service
+	signer, err := newSigner(config.Assertion)
+	if err != nil {
+		log.Fatal(err)
+	}
+	assertionService := service.NewAssertionService(signer, config.Assertion)
+
+	// create audit checkpointer, signing the head of the event hash chain with the assertion key
+	auditRepo := repository.NewAuditRepository(sqlClient)
+	var checkpointer *audit.Checkpointer
+	if config.Audit.CheckpointInterval > 0 {
+		checkpointer = audit.NewCheckpointer(auditRepo, signer, config.Assertion.Issuer, config.Audit)
+		checkpointer.Start()
+	}
+
+	// create event archiver, creating the monthly partitions of the event table and archiving expired events
+	policy, err := model.ParseRetentionPolicy(config.Retention.Days, config.Retention.Policies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	archiveRepo := repository.NewArchiveRepository(sqlClient)
+	var archiver *archive.Archiver
+	if config.Retention.Interval > 0 {
+		archiver = archive.NewArchiver(archiveRepo, signer, config.Assertion.Issuer, policy, config.Retention)
+		archiver.Start()
+	}
+
+	// create oauth service
+	oauthRepo := repository.NewOAuthRepository(sqlClient)
+	oauthService := service.NewOAuthService(oauthRepo, userRepo, eventRepo, sessionCache, signer, config.OAuth)
+
+	// create social login service
+	identityRepo := repository.NewIdentityRepository(sqlClient)
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:       config.SocialLogin.Issuer,
+		ClientID:     config.SocialLogin.ClientID,
+		ClientSecret: config.SocialLogin.ClientSecret,
+		RedirectURL:  config.SocialLogin.RedirectURL,
+		Scopes:       strings.Fields(config.SocialLogin.Scopes),
+	}, nil)
+	socialService := service.NewSocialService(provider, authService, userRepo, identityRepo, eventRepo, sessionCache,
+		usernamePolicy, config.SocialLogin)
+
+	// create account service
+	accountService := service.NewAccountService(userRepo, eventRepo, roleRepo, identityRepo, sessionService, notificationService)
+
+	// create outbox relay
+	cursorRepo := repository.NewCursorRepository(sqlClient)
+	var relay *outbox.Relay
+	if config.Outbox.Sink != "" {
+		sink, err := outbox.NewSink(config.Outbox, redisClient)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// switching sinks starts from the first event
+		relay = outbox.NewRelay(cursorRepo, "outbox:"+config.Outbox.Sink, sink, config.Outbox)
+		relay.Start()
+	}
+
+	// create projection relays, keeping the read models of the admin service up to date
+	projectionRelays := projection.NewRelays(cursorRepo, projectionRepo, config.Outbox)
+	for _, relay := range projectionRelays {
+		relay.Start()
+	}
+
+	// create webhook service, fanning events out to the endpoints subscribed to them
+	webhookRepo := repository.NewWebhookRepository(sqlClient)
+	webhookService := service.NewWebhookService(webhookRepo)
+	webhookRelay := outbox.NewRelay(cursorRepo, "webhooks", webhook.NewFanout(webhookRepo), config.Outbox)
+	webhookRelay.Start()
+	dispatcher := webhook.NewDispatcher(webhookRepo, &http.Client{}, config.Webhook)
+	dispatcher.Start()
 
 	// create websocket upgrader
 	upgrader := websocket.Upgrader{
@@ -57,11 +225,38 @@ func NewHTTPHandler(config config.Config) *RequestHandler {
 	sessionConfig := config.Session
 	return &RequestHandler{
 		sessionConfig,
+		config.OAuth,
+		config.SocialLogin,
+		usernamePolicy,
 		authService,
 		sessionService,
+		assertionService,
+		oauthService,
+		socialService,
+		roleService,
+		adminService,
+		accountService,
+		notificationService,
+		webhookService,
 		userRepo,
 		eventRepo,
+		oauthRepo,
+		identityRepo,
+		roleRepo,
+		webhookRepo,
+		projectionRepo,
 		upgrader,
+		relay,
+		projectionRelays,
+		webhookRelay,
+		dispatcher,
+		auditRepo,
+		checkpointer,
+		archiveRepo,
+		archiver,
+		locator,
+		notifier,
+		loginMonitor,
 	}
 }
 
@@ -73,32 +268,37 @@ func (s *RequestHandler) registration(w http.ResponseWriter, r *http.Request) {
 	// Parse request body
 	var user *model.User
 	if err := parseRequestBody(r, &user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
 	// Validate request body
-	if err := validateUser(user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if user != nil {
+		user.Username = model.NormalizeUsername(user.Username)
+	}
+	if err := validateUser(user, s.usernamePolicy); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Verify username unique
 	if s.authService.Exists(r.Context(), user) {
-		http.Error(w, "username already exists", http.StatusConflict)
+		writeError(w, r, &model.ConflictError{Message: "username already exists"})
 		return
 	}
 
 	// Create user
 	if err := s.authService.Create(r.Context(), user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	// Create session
-	if err := s.createSession(r.Context(), w, user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	// Create session, unless the user must be verified first
+	if user.Status.Err() == nil {
+		if err := s.createSession(r.Context(), w, user); err != nil {
+			writeError(w, r, err)
+			return
+		}
 	}
 
 	w.WriteHeader(http.StatusCreated)
@@ -108,26 +308,32 @@ func (s *RequestHandler) login(w http.ResponseWriter, r *http.Request) {
 	// Parse request body
 	var user *model.User
 	if err := parseRequestBody(r, &user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
 	// Validate request body
-	if err := validateUser(user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := validateCredentials(user); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Authenticate user
 	if err := s.authService.Authenticate(r.Context(), user); err != nil {
 		log.Printf("login failed: username: %s, err: %s", user.Username, err)
-		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		// only reveal why the login failed to users who know the password
+		var forbidden *model.ForbiddenError
+		if errors.As(err, &forbidden) {
+			writeError(w, r, err)
+			return
+		}
+		writeError(w, r, &model.UnauthorizedError{Message: "invalid credentials"})
 		return
 	}
 
 	// Create session
 	if err := s.createSession(r.Context(), w, user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -137,24 +343,20 @@ func (s *RequestHandler) login(w http.ResponseWriter, r *http.Request) {
 func (s *RequestHandler) logout(w http.ResponseWriter, r *http.Request) {
 	// Return error if user has no session
 	cookie, err := s.extractSession(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if cookie.Value == "" {
-		http.Error(w, "missing session cookie", http.StatusBadRequest)
+	if err != nil || cookie.Value == "" {
+		writeError(w, r, s.missingSession())
 		return
 	}
 
 	// Generate logout event (requires userID)
 	if err := s.logoutUser(r.Context(), cookie); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	// Invalidate session
 	if err := s.invalidateSession(r.Context(), w, cookie); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -164,24 +366,20 @@ func (s *RequestHandler) logout(w http.ResponseWriter, r *http.Request) {
 func (s *RequestHandler) logoutAll(w http.ResponseWriter, r *http.Request) {
 	// Return error if user has no session
 	cookie, err := s.extractSession(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if cookie.Value == "" {
-		http.Error(w, "missing session cookie", http.StatusBadRequest)
+	if err != nil || cookie.Value == "" {
+		writeError(w, r, s.missingSession())
 		return
 	}
 
 	// Generate logout all event (requires userID)
 	if err := s.logoutUsers(r.Context(), cookie); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	// TODO Invalidate all sessions
 	if err := s.invalidateSessions(r.Context(), w, cookie); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -190,12 +388,8 @@ func (s *RequestHandler) logoutAll(w http.ResponseWriter, r *http.Request) {
 
 func (s *RequestHandler) user(w http.ResponseWriter, r *http.Request) {
 	cookie, err := s.extractSession(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
-	}
-	if cookie.Value == "" {
-		http.Error(w, "missing session cookie", http.StatusUnauthorized)
+	if err != nil || cookie.Value == "" {
+		writeError(w, r, &model.UnauthorizedError{Message: "missing session cookie"})
 		return
 	}
 
@@ -203,7 +397,7 @@ func (s *RequestHandler) user(w http.ResponseWriter, r *http.Request) {
 	userID, err := s.sessionService.Fetch(r.Context(), cookie.Value)
 	if err != nil {
 		log.Printf("invalid session: %s", err)
-		w.WriteHeader(http.StatusUnauthorized)
+		writeError(w, r, &model.UnauthorizedError{Message: "invalid session"})
 		return
 	}
 
@@ -211,7 +405,7 @@ func (s *RequestHandler) user(w http.ResponseWriter, r *http.Request) {
 	cookie, err = s.sessionService.Extend(r.Context(), userID.String(), cookie)
 	if err != nil {
 		log.Printf("failed to extend session: %s", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	http.SetCookie(w, cookie)
@@ -219,27 +413,83 @@ func (s *RequestHandler) user(w http.ResponseWriter, r *http.Request) {
 	// fetch user from database
 	user := &model.User{ID: userID}
 	if err = s.authService.Fetch(r.Context(), user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	identity, err := s.identity(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	user.Roles = identity.Roles
 
 	// encode user as json and write to response
 	if err := json.NewEncoder(w).Encode(model.OmitPassword(user)); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s *RequestHandler) sessions(w http.ResponseWriter, r *http.Request) {
+// verify is called by the api-gateway, via the auth request module, before proxying a request
+// to an upstream service. Unlike user, it only consults the session cache, and identifies the user
+// through response headers which the gateway forwards upstream.
+func (s *RequestHandler) verify(w http.ResponseWriter, r *http.Request) {
 	cookie, err := s.extractSession(r)
+	if err != nil || cookie.Value == "" {
+		writeError(w, r, &model.UnauthorizedError{Message: "missing session cookie"})
+		return
+	}
+
+	// verify session valid
+	userID, err := s.sessionService.Fetch(r.Context(), cookie.Value)
+	if err != nil {
+		log.Printf("invalid session: %s", err)
+		writeError(w, r, &model.UnauthorizedError{Message: "invalid session"})
+		return
+	}
+
+	// extend session in cache and update cookie max age
+	cookie, err = s.sessionService.Extend(r.Context(), userID.String(), cookie)
+	if err != nil {
+		log.Printf("failed to extend session: %s", err)
+		writeError(w, r, err)
+		return
+	}
+	http.SetCookie(w, cookie)
+
+	identity, err := s.identity(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	assertion, err := s.assertionService.Issue(identity)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
-	if cookie.Value == "" {
-		http.Error(w, "missing session cookie", http.StatusUnauthorized)
+	w.Header().Set(headerUserID, identity.UserID.String())
+	w.Header().Set(headerUsername, identity.Username)
+	w.Header().Set(headerRoles, strings.Join(identity.Roles, ","))
+	w.Header().Set(headerAssertion, assertion)
+	w.WriteHeader(http.StatusOK)
+}
+
+// jwks publishes the public keys used to verify identity assertions.
+func (s *RequestHandler) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(s.assertionService.KeySet()); err != nil {
+		writeError(w, r, err)
+		return
+	}
+}
+
+func (s *RequestHandler) sessions(w http.ResponseWriter, r *http.Request) {
+	cookie, err := s.extractSession(r)
+	if err != nil || cookie.Value == "" {
+		writeError(w, r, &model.UnauthorizedError{Message: "missing session cookie"})
 		return
 	}
 
@@ -247,12 +497,12 @@ func (s *RequestHandler) sessions(w http.ResponseWriter, r *http.Request) {
 	sessionIDs, err := s.sessionService.FetchAll(r.Context(), cookie.Value)
 	if err != nil {
 		log.Printf("invalid session: %s", err)
-		w.WriteHeader(http.StatusUnauthorized)
+		writeError(w, r, &model.UnauthorizedError{Message: "invalid session"})
 		return
 	}
 
 	if err := json.NewEncoder(w).Encode(sessionIDs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -261,20 +511,18 @@ func (s *RequestHandler) sessions(w http.ResponseWriter, r *http.Request) {
 
 // get user info/id
 // when active sessions changes, send updated list to client
+// changes to the account made elsewhere are pushed to the client as a model.Notification
 func (s *RequestHandler) websocket(w http.ResponseWriter, r *http.Request) {
 	// verify session valid
 	cookie, err := s.extractSession(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if cookie.Value == "" {
-		http.Error(w, "missing session cookie", http.StatusBadRequest)
+	if err != nil || cookie.Value == "" {
+		writeError(w, r, s.missingSession())
 		return
 	}
-	if _, err := s.sessionService.Fetch(r.Context(), cookie.Value); err != nil {
+	userID, err := s.sessionService.Fetch(r.Context(), cookie.Value)
+	if err != nil {
 		log.Printf("invalid session: %s", err)
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, &model.UnauthorizedError{Message: "invalid session"})
 		return
 	}
 
@@ -290,6 +538,16 @@ func (s *RequestHandler) websocket(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	// subscribe to notifications of changes to the account
+	notifications, unsubscribe := s.notificationService.Subscribe(r.Context(), userID)
+	defer func() {
+		if err := unsubscribe(); err != nil {
+			log.Printf("failed to unsubscribe from notifications: %s", err)
+		}
+	}()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
 	// initialize a variable to store last delivered payload
 	var lastSessionVersion string
 
@@ -330,46 +588,75 @@ func (s *RequestHandler) websocket(w http.ResponseWriter, r *http.Request) {
 			lastSessionVersion = string(jsonData)
 		}
 
-		time.Sleep(5 * time.Second)
+		// wait for the next poll, or a notification to forward to the client
+		select {
+		case <-ticker.C:
+		case notification, ok := <-notifications:
+			if !ok {
+				return
+			}
+			if err := c.WriteMessage(websocket.TextMessage, []byte(notification)); err != nil {
+				log.Printf("failed to write message: %s", err)
+			}
+		}
 	}
 }
 
 func parseRequestBody(r *http.Request, v interface{}) error {
-	return json.NewDecoder(r.Body).Decode(v)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return &model.ValidationError{Field: "body", Message: "request body must be valid JSON"}
+	}
+	return nil
 }
 
 func (s *RequestHandler) extractSession(r *http.Request) (*http.Cookie, error) {
 	return r.Cookie(s.sessionConfig.Name)
 }
 
+// missingSession is returned by endpoints which require, but were not sent, a session cookie.
+func (s *RequestHandler) missingSession() error {
+	return &model.ValidationError{Field: s.sessionConfig.Name, Message: "missing session cookie"}
+}
+
 func (s *RequestHandler) logoutUser(ctx context.Context, cookie *http.Cookie) error {
 	// fetch session from cache
 	userID, err := s.sessionService.Fetch(ctx, cookie.Value)
 	if err != nil {
-		return err
+		log.Printf("invalid session: %s", err)
+		return &model.UnauthorizedError{Message: "invalid session"}
 	}
 	// fetch user from database
 	user := &model.User{ID: userID}
 	if err := s.authService.Fetch(ctx, user); err != nil {
 		return err
 	}
-	// generate logout event
-	return s.authService.Logout(ctx, user) // TODO include sessionID in event body
+	// generate logout event, recording the session signed out of
+	return s.authService.Logout(model.WithSession(ctx, cookie.Value), user)
 }
 
 func (s *RequestHandler) logoutUsers(ctx context.Context, cookie *http.Cookie) error {
 	// fetch session from cache
 	userID, err := s.sessionService.Fetch(ctx, cookie.Value)
 	if err != nil {
-		return err
+		log.Printf("invalid session: %s", err)
+		return &model.UnauthorizedError{Message: "invalid session"}
 	}
 	// fetch user from database
 	user := &model.User{ID: userID}
 	if err := s.authService.Fetch(ctx, user); err != nil {
 		return err
 	}
+	// record the sessions signed out of, while they still exist
+	sessions, err := s.sessionService.UserSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	sessionIDs := make([]string, len(sessions))
+	for i, session := range sessions {
+		sessionIDs[i] = session.ID
+	}
 	// generate logout event
-	return s.authService.LogoutAll(ctx, user) // TODO include sessionIDs in event body
+	return s.authService.LogoutAll(model.WithSession(ctx, cookie.Value), user, sessionIDs)
 }
 
 func (s *RequestHandler) invalidateSessions(ctx context.Context, w http.ResponseWriter, cookie *http.Cookie) error {
@@ -395,31 +682,143 @@ func (s *RequestHandler) createSession(ctx context.Context, w http.ResponseWrite
 	if err != nil {
 		return err
 	}
+	// warm identity cache, sparing the first verification a database query
+	if identity, err := s.roleService.Identity(ctx, user); err != nil {
+		log.Printf("failed to load identity: %s", err)
+	} else if err := s.sessionService.CacheIdentity(ctx, identity); err != nil {
+		log.Printf("failed to cache identity: %s", err)
+	}
 	http.SetCookie(w, cookie)
 	return nil
 }
 
+// identity returns the identity of the user from cache,
+// falling back to the database when the identity is not cached.
+// The sessions of users whose status no longer allows signing in are removed.
+func (s *RequestHandler) identity(ctx context.Context, userID uuid.UUID) (*model.Identity, error) {
+	identity, err := s.sessionService.FetchIdentity(ctx, userID)
+	if err != nil {
+		user := &model.User{ID: userID}
+		if err := s.authService.Fetch(ctx, user); err != nil {
+			return nil, err
+		}
+		if identity, err = s.roleService.Identity(ctx, user); err != nil {
+			return nil, err
+		}
+		if err := s.sessionService.CacheIdentity(ctx, identity); err != nil {
+			log.Printf("failed to cache identity: %s", err)
+		}
+	}
+	if err := identity.Status.Err(); err != nil {
+		if err := s.sessionService.RemoveUserSessions(ctx, userID); err != nil {
+			log.Printf("failed to remove sessions: %s", err)
+		}
+		return nil, err
+	}
+	return identity, nil
+}
+
+// newSigner returns a signer for the configured private key. When no key is configured
+// a key is generated, which is only suitable when running a single instance of the server,
+// since assertions signed by one instance cannot be verified with the keys of another.
+func newSigner(config config.Assertion) (*jwt.Signer, error) {
+	if config.KeyFile == "" {
+		log.Println("ASSERTION_KEY_FILE not set, generating ephemeral signing key")
+		key, err := jwt.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		return jwt.NewSigner(key)
+	}
+	key, err := jwt.LoadKey(config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.NewSigner(key)
+}
+
 func (s *RequestHandler) close() model.Errors {
 	errors := make(model.Errors, 0)
+	if s.relay != nil {
+		errors = append(errors, s.relay.Stop())
+	}
+	for _, relay := range s.projectionRelays {
+		errors = append(errors, relay.Stop())
+	}
+	s.dispatcher.Stop()
+	if s.checkpointer != nil {
+		s.checkpointer.Stop()
+	}
+	if s.archiver != nil {
+		s.archiver.Stop()
+	}
+	errors = append(errors, s.webhookRelay.Stop())
 	errors = append(errors, s.userRepository.Close())
 	errors = append(errors, s.eventRepository.Close())
+	errors = append(errors, s.oauthRepository.Close())
+	errors = append(errors, s.identityRepository.Close())
+	errors = append(errors, s.roleRepository.Close())
+	errors = append(errors, s.webhookRepository.Close())
+	errors = append(errors, s.projectionRepository.Close())
+	errors = append(errors, s.auditRepository.Close())
+	errors = append(errors, s.archiveRepository.Close())
+	if s.locator != nil {
+		errors = append(errors, s.locator.Close())
+	}
+	if s.loginMonitor != nil {
+		s.loginMonitor.Stop()
+	}
+	if s.notifier != nil {
+		errors = append(errors, s.notifier.Close())
+	}
 	return errors
 }
 
-// TODO return model.Errors instead of error
-func validateUser(user *model.User) error {
+// validateUser returns model.Errors containing a model.ValidationError
+// for every invalid field of a user registering, or nil if user is valid.
+// The username must be normalized by model.NormalizeUsername and satisfy policy.
+func validateUser(user *model.User, policy *model.UsernamePolicy) error {
+	if user == nil {
+		return &model.ValidationError{Field: "body", Message: "request body cannot be empty"}
+	}
+	errs := make(model.Errors, 0)
+	if err := policy.Validate(user.Username); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validatePassword(user.Password); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateCredentials returns model.Errors containing a model.ValidationError
+// for every missing or invalid credential of a user signing in, or nil if they are valid.
+// The username is not held to the current policy, which may have changed since registration.
+func validateCredentials(user *model.User) error {
+	if user == nil {
+		return &model.ValidationError{Field: "body", Message: "request body cannot be empty"}
+	}
+	errs := make(model.Errors, 0)
 	if user.Username == "" {
-		return errors.New("username cannot be empty")
+		errs = append(errs, &model.ValidationError{Field: "username", Message: "username cannot be empty"})
 	}
-	// Strings are UTF-8 encoded, this means each charcter aka rune can be 1 to 4 bytes
-	if len(user.Username) > 50 {
-		return errors.New("username cannot exceed 50 characters")
+	if err := validatePassword(user.Password); err != nil {
+		errs = append(errs, err)
 	}
-	if len(user.Password) < 1 || len(user.Password) > 72 {
-		return errors.New("password must be between 1 and 72 characters")
+	if len(errs) > 0 {
+		return errs
 	}
-	if !regexp.MustCompile(`^[a-zA-Z0-9]*$`).MatchString(user.Username) {
-		return errors.New("username must be alphanumeric")
+	return nil
+}
+
+// validatePassword returns a model.ValidationError if password is invalid, or nil if it is valid.
+// bcrypt ignores everything past the first 72 bytes.
+func validatePassword(password string) *model.ValidationError {
+	if len(password) < 1 || len(password) > 72 {
+		return &model.ValidationError{Field: "password", Message: "password must be between 1 and 72 characters"}
 	}
 	return nil
 }
@@ -8,13 +8,18 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/dgyurics/auth/auth-server/cache"
 	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/identity"
+	"github.com/dgyurics/auth/auth-server/jwt"
 	"github.com/dgyurics/auth/auth-server/model"
 	repo "github.com/dgyurics/auth/auth-server/repository"
 	"github.com/dgyurics/auth/auth-server/service"
+	"github.com/go-chi/chi/middleware"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 )
 
@@ -27,6 +32,45 @@ func TestHandlerSuite(t *testing.T) {
 	t.Run("TestHealthCheck", suite.TestHealthCheck)
 	t.Run("TestLogin", suite.TestRegistration)
 	t.Run("TestLogin", suite.TestLogin)
+	t.Run("TestRegistrationInvalid", suite.TestRegistrationInvalid)
+	t.Run("TestRegistrationConflict", suite.TestRegistrationConflict)
+	t.Run("TestRegistrationUsernamePolicy", suite.TestRegistrationUsernamePolicy)
+	t.Run("TestLoginInvalidCredentials", suite.TestLoginInvalidCredentials)
+	t.Run("TestUserMissingSession", suite.TestUserMissingSession)
+	t.Run("TestVerify", suite.TestVerify)
+	t.Run("TestVerifyIdentityNotCached", suite.TestVerifyIdentityNotCached)
+	t.Run("TestVerifyInvalidSession", suite.TestVerifyInvalidSession)
+	t.Run("TestJWKS", suite.TestJWKS)
+	t.Run("TestAuthorizeLoginRequired", suite.TestAuthorizeLoginRequired)
+	t.Run("TestAuthorizeInvalidRedirect", suite.TestAuthorizeInvalidRedirect)
+	t.Run("TestAuthorizationCodeFlow", suite.TestAuthorizationCodeFlow)
+	t.Run("TestOpenIDConfiguration", suite.TestOpenIDConfiguration)
+	t.Run("TestAuthorizePrompt", suite.TestAuthorizePrompt)
+	t.Run("TestUserInfo", suite.TestUserInfo)
+	t.Run("TestSocialLoginDisabled", suite.TestSocialLoginDisabled)
+	t.Run("TestSocialLogin", suite.TestSocialLogin)
+	t.Run("TestReturnTo", suite.TestReturnTo)
+	t.Run("TestRequirePermission", suite.TestRequirePermission)
+	t.Run("TestAssignRole", suite.TestAssignRole)
+	t.Run("TestAdminRequiresRole", suite.TestAdminRequiresRole)
+	t.Run("TestAdminUsers", suite.TestAdminUsers)
+	t.Run("TestAdminLock", suite.TestAdminLock)
+	t.Run("TestAdminDisable", suite.TestAdminDisable)
+	t.Run("TestAdminVerify", suite.TestAdminVerify)
+	t.Run("TestVerifyInactiveUser", suite.TestVerifyInactiveUser)
+	t.Run("TestAdminLogout", suite.TestAdminLogout)
+	t.Run("TestAdminDelete", suite.TestAdminDelete)
+	t.Run("TestAdminActivity", suite.TestAdminActivity)
+	t.Run("TestExportUser", suite.TestExportUser)
+	t.Run("TestDeleteUser", suite.TestDeleteUser)
+	t.Run("TestDeleteUserReauthenticate", suite.TestDeleteUserReauthenticate)
+	t.Run("TestUpdateUser", suite.TestUpdateUser)
+	t.Run("TestLogoutAllEvent", suite.TestLogoutAllEvent)
+	t.Run("TestEvents", suite.TestEvents)
+	t.Run("TestAdminSearchEvents", suite.TestAdminSearchEvents)
+	t.Run("TestClientIP", suite.TestClientIP)
+	t.Run("TestWebhookEndpoints", suite.TestWebhookEndpoints)
+	t.Run("TestWebhookDeliveries", suite.TestWebhookDeliveries)
 	// TODO: Add tests for the following:
 	// t.Run("TestLogout", suite.TestLogout)
 }
@@ -38,6 +82,17 @@ type HandlerTestSuite struct {
 	sessionCache      cache.SessionCache
 	sessionRepository repo.SessionRepository
 	sessionService    service.SessionService
+	assertionService  service.AssertionService
+	oauthRepo         *repo.MockOAuthRepository
+	oauthService      service.OAuthService
+	roleRepo          *repo.MockRoleRepository
+	roleService       service.RoleService
+	projectionRepo    *repo.MockProjectionRepository
+	adminService      service.AdminService
+	accountService    service.AccountService
+	notifications     service.NotificationService
+	webhookRepo       *repo.MockWebhookRepository
+	webhookService    service.WebhookService
 	handler           RequestHandler
 }
 
@@ -48,7 +103,7 @@ func (suite *HandlerTestSuite) Setup() {
 	suite.eventRepo = &repo.MockEventRepository{
 		Events: []*model.Event{},
 	}
-	suite.authService = service.NewAuthService(suite.userRepo, suite.eventRepo)
+	suite.authService = service.NewAuthService(suite.userRepo, suite.eventRepo, nil, config.Registration{})
 	suite.sessionCache = &cache.MockSessionCache{
 		Sessions:    make(map[string]string),
 		SessionsSet: make(map[string]map[string]struct{}),
@@ -57,9 +112,43 @@ func (suite *HandlerTestSuite) Setup() {
 		Sessions: []*model.Session{},
 	}
 	suite.sessionService = service.NewSessionService(suite.sessionCache)
+	signer, err := newSigner(env.Assertion)
+	if err != nil {
+		panic(err)
+	}
+	suite.assertionService = service.NewAssertionService(signer, env.Assertion)
+	suite.oauthRepo = repo.NewMockOAuthRepository()
+	suite.oauthService = service.NewOAuthService(suite.oauthRepo, suite.userRepo, suite.eventRepo, suite.sessionCache, signer, env.OAuth)
+	suite.roleRepo = repo.NewMockRoleRepository(&model.Role{
+		Name:        "admin",
+		Permissions: []string{model.PermissionRolesRead, model.PermissionRolesWrite},
+	}, &model.Role{
+		Name:        "auditor",
+		Permissions: []string{model.PermissionRolesRead},
+	})
+	suite.roleService = service.NewRoleService(suite.roleRepo, suite.userRepo)
+	suite.projectionRepo = &repo.MockProjectionRepository{Activity: make(map[uuid.UUID]*model.UserActivity)}
+	suite.adminService = service.NewAdminService(suite.userRepo, suite.eventRepo, suite.roleRepo, suite.projectionRepo,
+		suite.sessionService)
+	suite.notifications = service.NewNotificationService(&cache.MockPubSub{})
+	suite.accountService = service.NewAccountService(suite.userRepo, suite.eventRepo, suite.roleRepo,
+		&repo.MockIdentityRepository{}, suite.sessionService, suite.notifications)
+	suite.webhookRepo = &repo.MockWebhookRepository{}
+	suite.webhookService = service.NewWebhookService(suite.webhookRepo)
 	suite.handler = RequestHandler{
-		authService:    suite.authService,
-		sessionService: suite.sessionService,
+		sessionConfig: env.Session,
+		oauthConfig:   env.OAuth,
+		usernamePolicy: model.NewUsernamePolicy(env.Username.MinLength, env.Username.MaxLength,
+			env.Username.Unicode, strings.Split(env.Username.Reserved, ",")),
+		authService:         suite.authService,
+		sessionService:      suite.sessionService,
+		assertionService:    suite.assertionService,
+		oauthService:        suite.oauthService,
+		roleService:         suite.roleService,
+		adminService:        suite.adminService,
+		accountService:      suite.accountService,
+		notificationService: suite.notifications,
+		webhookService:      suite.webhookService,
 	}
 }
 
@@ -142,6 +231,217 @@ func (suite *HandlerTestSuite) TestLogout(t *testing.T) {
 	verifycookie(t, cookie, true)
 }
 
+func (suite *HandlerTestSuite) TestLogoutAllEvent(t *testing.T) {
+	user, session := suite.userWithRoles(t)
+	rr := httptest.NewRecorder()
+	require.NoError(t, suite.handler.createSession(context.Background(), rr, user))
+	sessions, err := suite.sessionService.UserSessions(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+
+	req := httptest.NewRequest(http.MethodPost, "/logout-all", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	middleware.RequestID(requestMetadata(nil)(http.HandlerFunc(suite.handler.logoutAll))).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// the event records the sessions signed out of, and the request signing out
+	events := suite.eventRepo.(*repo.MockEventRepository).Events
+	event := events[len(events)-1]
+	require.Equal(t, model.LoggedOutAll, event.Type)
+	require.NotContains(t, string(event.Body), session.Value)
+	payload, err := event.Payload()
+	require.NoError(t, err)
+	logout := payload.(*model.LogoutAllPayload)
+	require.Equal(t, model.EventSchemas[model.LoggedOutAll].Version, logout.Version)
+	require.ElementsMatch(t, []string{sessions[0].ID, sessions[1].ID}, logout.SessionIDs)
+	require.Contains(t, logout.SessionIDs, event.Context.SessionID)
+	require.Equal(t, "192.0.2.1", event.Context.IP)
+	require.Equal(t, "test-agent", event.Context.UserAgent)
+	require.NotEmpty(t, event.Context.RequestID)
+}
+
+func (suite *HandlerTestSuite) TestRegistrationInvalid(t *testing.T) {
+	body := bytes.NewReader([]byte(`{"username": "not valid!", "password": ""}`))
+	req := httptest.NewRequest(http.MethodPost, "/register", body)
+	rr := httptest.NewRecorder()
+	suite.handler.registration(rr, req)
+
+	p := decodeProblem(t, rr, http.StatusBadRequest)
+	require.Equal(t, problemValidation, p.Type)
+	require.Len(t, p.Errors, 2)
+	require.Equal(t, "username", p.Errors[0].Field)
+	require.Equal(t, "password", p.Errors[1].Field)
+}
+
+func (suite *HandlerTestSuite) TestRegistrationConflict(t *testing.T) {
+	user, userIO := generateUniqueUser(t)
+	err := suite.authService.Create(context.Background(), &model.User{
+		Username: user.Username,
+		Password: user.Password,
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/register", userIO)
+	rr := httptest.NewRecorder()
+	suite.handler.registration(rr, req)
+
+	p := decodeProblem(t, rr, http.StatusConflict)
+	require.Equal(t, problemConflict, p.Type)
+	require.Equal(t, "username already exists", p.Detail)
+}
+
+func (suite *HandlerTestSuite) TestRegistrationUsernamePolicy(t *testing.T) {
+	register := func(username string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(&model.User{Username: username, Password: "password"})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		suite.handler.registration(rr, req)
+		return rr
+	}
+
+	// stored in NFKC, so the decomposed and fullwidth forms are stored composed and as ASCII
+	require.Equal(t, http.StatusCreated, register("Zoe\u0308Paypal").Code)
+	require.True(t, suite.userRepo.ExistsUser(context.Background(), "ZoëPaypal"))
+	require.Equal(t, http.StatusCreated, register("ｍａｒｉａ７").Code)
+	require.True(t, suite.userRepo.ExistsUser(context.Background(), "maria7"))
+	require.Equal(t, http.StatusCreated, register("Дмитрий").Code)
+	require.Equal(t, http.StatusCreated, register("山田たろう").Code)
+	require.Equal(t, http.StatusCreated, register("B0bby").Code)
+	require.Equal(t, http.StatusCreated, register("poppy").Code)
+
+	for _, username := range []string{
+		"zoëpaypal", // case
+		"ZOËPAYPAL", // case
+		"rnaria7",   // "rn" looks like "m"
+		"bobby",     // digit "0" looks like "o"
+		"рорру",     // Cyrillic, whole-script confusable with Latin
+	} {
+		p := decodeProblem(t, register(username), http.StatusConflict)
+		require.Equal(t, "username already exists", p.Detail, username)
+	}
+
+	for username, message := range map[string]string{
+		"Admin":   "username is reserved",
+		"r00t":    "username is reserved",
+		"pаypal":  "username cannot mix letters of different scripts", // Cyrillic "а"
+		"user!":   "username must consist of letters and digits",
+		"\u0301a": "username must consist of letters and digits",
+	} {
+		p := decodeProblem(t, register(username), http.StatusBadRequest)
+		require.Equal(t, message, p.Errors[0].Message, username)
+	}
+}
+
+func (suite *HandlerTestSuite) TestLoginInvalidCredentials(t *testing.T) {
+	_, userIO := generateUniqueUser(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/login", userIO)
+	rr := httptest.NewRecorder()
+	suite.handler.login(rr, req)
+
+	p := decodeProblem(t, rr, http.StatusUnauthorized)
+	require.Equal(t, problemUnauthorized, p.Type)
+	require.Equal(t, "invalid credentials", p.Detail)
+	require.Empty(t, rr.Header().Get("Set-Cookie"))
+}
+
+func (suite *HandlerTestSuite) TestUserMissingSession(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	rr := httptest.NewRecorder()
+	suite.handler.user(rr, req)
+
+	p := decodeProblem(t, rr, http.StatusUnauthorized)
+	require.Equal(t, "/user", p.Instance)
+}
+
+func (suite *HandlerTestSuite) TestVerify(t *testing.T) {
+	user, userIO := generateUniqueUser(t)
+
+	// Register user, creating a session
+	req := httptest.NewRequest(http.MethodPost, "/register", userIO)
+	rr := httptest.NewRecorder()
+	suite.handler.registration(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	session := rr.Result().Cookies()[0]
+
+	req = httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	suite.handler.verify(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, suite.authService.Fetch(context.Background(), user))
+	require.Equal(t, user.ID.String(), rr.Header().Get(headerUserID))
+	require.Equal(t, user.Username, rr.Header().Get(headerUsername))
+	verifycookie(t, rr.Header().Get("Set-Cookie"), false)
+
+	// Verify identity assertion
+	verifier := identity.NewVerifier(suite.assertionService.KeySet(), env.Assertion.Issuer, env.Assertion.Audience)
+	claims, err := verifier.Verify(context.Background(), rr.Header().Get(headerAssertion))
+	require.NoError(t, err)
+	require.Equal(t, user.ID.String(), claims.Subject)
+	require.Equal(t, user.Username, claims.Username)
+}
+
+func (suite *HandlerTestSuite) TestJWKS(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	rr := httptest.NewRecorder()
+	suite.handler.jwks(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var keys jwt.KeySet
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&keys))
+	require.Len(t, keys.Keys, 1)
+	require.NotEmpty(t, keys.Keys[0].Kid)
+}
+
+func (suite *HandlerTestSuite) TestVerifyIdentityNotCached(t *testing.T) {
+	user, _ := generateUniqueUser(t)
+	err := suite.authService.Create(context.Background(), user)
+	require.NoError(t, err)
+
+	// Create session without caching identity
+	session, err := suite.sessionService.Create(context.Background(), user.ID)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.AddCookie(session)
+	rr := httptest.NewRecorder()
+	suite.handler.verify(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, user.Username, rr.Header().Get(headerUsername))
+
+	// Identity should now be cached
+	identity, err := suite.sessionService.FetchIdentity(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, user.Username, identity.Username)
+}
+
+func (suite *HandlerTestSuite) TestVerifyInvalidSession(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.AddCookie(&http.Cookie{Name: env.Session.Name, Value: "invalid"})
+	rr := httptest.NewRecorder()
+	suite.handler.verify(rr, req)
+
+	decodeProblem(t, rr, http.StatusUnauthorized)
+	require.Empty(t, rr.Header().Get(headerUserID))
+}
+
+// decodeProblem verifies the response is a problem document with the expected status
+func decodeProblem(t *testing.T, rr *httptest.ResponseRecorder, status int) *problem {
+	require.Equal(t, status, rr.Code)
+	require.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+
+	var p problem
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&p))
+	require.Equal(t, status, p.Status)
+	return &p
+}
+
 func generateUniqueUser(t *testing.T) (*model.User, io.Reader) {
 	user := model.User{
 		Username: repo.GenerateUniqueUsername(),
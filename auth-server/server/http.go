@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"time"
 
@@ -50,6 +51,11 @@ func NewHTTPServer(addr string) *HTTPServer {
 
 	// middleware
 	r.Use(middleware.RequestID)
+	trustedProxies, err := parseTrustedProxies(cfg.ServerConfig.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.Use(requestMetadata(trustedProxies))
 	r.Use(middleware.Logger)
 	r.Use(cors)
 
@@ -58,12 +64,41 @@ func NewHTTPServer(addr string) *HTTPServer {
 	defaultGroup.Use(middleware.Timeout(time.Duration(cfg.RequestTimeout) * time.Second))
 
 	defaultGroup.Get("/health", h.healthCheck)
+	defaultGroup.Get("/.well-known/jwks.json", h.jwks)
 	defaultGroup.Get("/user", h.user)
+	defaultGroup.Patch("/user", h.updateUser)
+	defaultGroup.Delete("/user", h.deleteUser)
+	defaultGroup.Get("/user/export", h.exportUser)
+	defaultGroup.Get("/verify", h.verify)
 	defaultGroup.Get("/sessions", h.sessions)
+	defaultGroup.Get("/events", h.events)
 	defaultGroup.Post("/login", h.login)
 	defaultGroup.Post("/logout", h.logout)
 	defaultGroup.Post("/logout-all", h.logoutAll)
 	defaultGroup.Post("/register", h.registration)
+	defaultGroup.Get("/login/oidc", h.socialLogin)
+	defaultGroup.Get("/login/oidc/callback", h.socialCallback)
+
+	// roles
+	defaultGroup.With(h.requirePermission(model.PermissionRolesRead)).Get("/roles", h.roles)
+	defaultGroup.With(h.requirePermission(model.PermissionRolesRead)).Get("/users/{id}/roles", h.userRoles)
+	defaultGroup.With(h.requirePermission(model.PermissionRolesWrite)).Put("/users/{id}/roles/{role}", h.assignRole)
+	defaultGroup.With(h.requirePermission(model.PermissionRolesWrite)).Delete("/users/{id}/roles/{role}", h.revokeRole)
+
+	// admin
+	defaultGroup.Route("/admin", h.adminRoutes)
+
+	// oauth
+	defaultGroup.Get("/oauth/authorize", h.authorize)
+	defaultGroup.Post("/oauth/authorize", h.authorizeDecision)
+	defaultGroup.Post("/oauth/token", h.token)
+	defaultGroup.Post("/oauth/revoke", h.revoke)
+	defaultGroup.Post("/oauth/introspect", h.introspect)
+
+	// openid connect
+	defaultGroup.Get("/.well-known/openid-configuration", h.openIDConfiguration)
+	defaultGroup.Get("/userinfo", h.userInfo)
+	defaultGroup.Post("/userinfo", h.userInfo)
 
 	// websocket
 	wsGroup := r.Group(nil)
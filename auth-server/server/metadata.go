@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/go-chi/chi/middleware"
+)
+
+// parseTrustedProxies parses the comma separated addresses and CIDR ranges of trusted proxies.
+func parseTrustedProxies(proxies string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, proxy := range strings.Split(proxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// requestMetadata returns middleware recording the client address, user agent and ID of the
+// request in the model.EventContext of its context, which is attached to the events it causes.
+func requestMetadata(trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ec := &model.EventContext{
+				UserAgent: r.UserAgent(),
+				RequestID: middleware.GetReqID(r.Context()),
+			}
+			if ip := clientIP(r, trustedProxies); ip != nil {
+				ec.IP = ip.String()
+			}
+			next.ServeHTTP(w, r.WithContext(model.WithEventContext(r.Context(), ec)))
+		})
+	}
+}
+
+// clientIP returns the address of the client making the request, or nil when it is unknown.
+// X-Forwarded-For is read from right to left while the address it was received from is a
+// trusted proxy, so clients cannot spoof their address by sending the header themselves.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && trusted(ip, trustedProxies); i-- {
+		next := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if next == nil {
+			break
+		}
+		ip = next
+	}
+	return ip
+}
+
+// trusted reports whether ip belongs to one of the trusted proxies.
+func trusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *HandlerTestSuite) TestClientIP(t *testing.T) {
+	trustedProxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1, ::1")
+	require.NoError(t, err)
+	_, err = parseTrustedProxies("10.0.0.0/33")
+	require.Error(t, err)
+	_, err = parseTrustedProxies("gateway")
+	require.Error(t, err)
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{"direct", "203.0.113.1:1234", nil, "203.0.113.1"},
+		{"untrusted proxy", "203.0.113.1:1234", []string{"198.51.100.1"}, "203.0.113.1"},
+		{"trusted proxy", "192.0.2.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted IPv6 proxy", "[::1]:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "192.0.2.1:1234", []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"}, "198.51.100.1"},
+		{"spoofed by client", "192.0.2.1:1234", []string{"10.0.0.9, 198.51.100.1"}, "198.51.100.1"},
+		{"malformed", "192.0.2.1:1234", []string{"unknown"}, "192.0.2.1"},
+		{"without proxy", "192.0.2.1:1234", nil, "192.0.2.1"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		for _, forwarded := range tc.forwarded {
+			req.Header.Add("X-Forwarded-For", forwarded)
+		}
+		require.Equal(t, tc.expected, clientIP(req, trustedProxies).String(), tc.name)
+	}
+}
@@ -0,0 +1,449 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
+)
+
+// consentPage asks the user to authorize a client. The form is submitted to the
+// authorization endpoint along with the parameters of the authorization request.
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize {{.Client.Name}}</title></head>
+<body>
+  <h1>Authorize {{.Client.Name}}</h1>
+  <p>{{.Client.Name}} is requesting access to your account.</p>
+  {{if .Request.Scopes}}<ul>{{range .Request.Scopes}}<li>{{.}}</li>{{end}}</ul>{{end}}
+  <form method="post">
+    <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+    <input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+    <input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+    <input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+    <input type="hidden" name="scope" value="{{.Scope}}">
+    <input type="hidden" name="state" value="{{.Request.State}}">
+    <input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+    <input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+    <input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+    <input type="hidden" name="prompt" value="{{.Prompt}}">
+    <input type="hidden" name="max_age" value="{{.MaxAge}}">
+    <input type="hidden" name="login_request" value="{{.LoginRequest}}">
+    <button type="submit" name="decision" value="allow">Allow</button>
+    <button type="submit" name="decision" value="deny">Deny</button>
+  </form>
+</body>
+</html>
+`))
+
+// authorize is the OAuth 2.0 authorization endpoint. The user is identified by their session
+// cookie, and is sent to the login page when they have none, or when the client requires the
+// user to authenticate again. Users who have already consented to the requested scopes are
+// redirected back to the client with an authorization code, all others are asked for consent.
+func (s *RequestHandler) authorize(w http.ResponseWriter, r *http.Request) {
+	req, err := parseAuthorizationRequest(r.URL.Query())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	client, err := s.oauthService.ValidateAuthorization(r.Context(), req)
+	if err != nil {
+		s.authorizationError(w, r, req, err)
+		return
+	}
+
+	sessionID, userID, err := s.sessionUser(r)
+	if err != nil {
+		if req.Prompted("none") {
+			s.redirectWithError(w, r, req, &model.OAuthError{Code: model.LoginRequired})
+			return
+		}
+		s.redirectToLogin(w, r, "")
+		return
+	}
+	authTime, err := s.sessionService.AuthTime(r.Context(), sessionID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	consented, err := s.oauthService.Consented(r.Context(), userID, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	issue := consented && !req.Prompted("consent")
+	if reauthenticate(req, authTime) {
+		// prompt and max_age are kept, the request is satisfied by the login it asked for. The login is
+		// redeemed once a code is issued, by the consent form when the user is asked for consent
+		loggedIn, err := s.oauthService.LoggedIn(r.Context(), r.URL.Query().Get(loginRequestParam), sessionID, authTime, issue)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !loggedIn {
+			if req.Prompted("none") {
+				s.redirectWithError(w, r, req, &model.OAuthError{Code: model.LoginRequired})
+				return
+			}
+			s.redirectToLogin(w, r, sessionID)
+			return
+		}
+	}
+
+	if issue {
+		s.redirectWithCode(w, r, userID, authTime, req)
+		return
+	}
+	if req.Prompted("none") {
+		s.redirectWithError(w, r, req, &model.OAuthError{Code: model.ConsentRequired})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY") // prevent clickjacking of the consent form
+	w.Header().Set("Cache-Control", "no-store")
+	err = consentPage.Execute(w, map[string]interface{}{
+		"Client":       client,
+		"Request":      req,
+		"Scope":        strings.Join(req.Scopes, " "),
+		"Prompt":       strings.Join(req.Prompt, " "),
+		"MaxAge":       r.URL.Query().Get("max_age"),
+		"LoginRequest": r.URL.Query().Get(loginRequestParam),
+		"CSRFToken":    csrfToken(sessionID),
+	})
+	if err != nil {
+		log.Printf("failed to render consent page: %s", err)
+	}
+}
+
+// authorizeDecision receives the decision of the user submitted from the consent page. Access is only
+// granted once the user has logged in again when the request requires it, as checked by authorize.
+func (s *RequestHandler) authorizeDecision(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, r, &model.ValidationError{Field: "body", Message: "malformed form"})
+		return
+	}
+	req, err := parseAuthorizationRequest(r.PostForm)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, err := s.oauthService.ValidateAuthorization(r.Context(), req); err != nil {
+		s.authorizationError(w, r, req, err)
+		return
+	}
+
+	sessionID, userID, err := s.sessionUser(r)
+	if err != nil {
+		writeError(w, r, &model.UnauthorizedError{Message: "invalid session"})
+		return
+	}
+	expected := csrfToken(sessionID)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(r.PostForm.Get("csrf_token"))) != 1 {
+		writeError(w, r, &model.ValidationError{Field: "csrf_token", Message: "invalid csrf token"})
+		return
+	}
+
+	if r.PostForm.Get("decision") != "allow" {
+		s.redirectWithError(w, r, req, &model.OAuthError{Code: model.AccessDenied, Description: "user denied access"})
+		return
+	}
+	authTime, err := s.sessionService.AuthTime(r.Context(), sessionID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if reauthenticate(req, authTime) {
+		loggedIn, err := s.oauthService.LoggedIn(r.Context(), r.PostForm.Get(loginRequestParam), sessionID, authTime, true)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !loggedIn {
+			s.redirectWithError(w, r, req, &model.OAuthError{Code: model.LoginRequired})
+			return
+		}
+	}
+	if err := s.oauthService.Consent(model.WithSession(r.Context(), sessionID), userID, req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.redirectWithCode(w, r, userID, authTime, req)
+}
+
+// token is the OAuth 2.0 token endpoint, supporting the authorization_code and refresh_token grants.
+func (s *RequestHandler) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, r, &model.OAuthError{Code: model.InvalidRequest, Description: "malformed form"})
+		return
+	}
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+
+	var res *model.TokenResponse
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		res, err = s.oauthService.ExchangeCode(r.Context(), client,
+			r.PostForm.Get("code"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+	case "refresh_token":
+		res, err = s.oauthService.Refresh(r.Context(), client,
+			r.PostForm.Get("refresh_token"), strings.Fields(r.PostForm.Get("scope")))
+	default:
+		err = &model.OAuthError{Code: model.UnsupportedGrantType}
+	}
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+	writeTokenResponse(w, r, res)
+}
+
+// revoke is the token revocation endpoint described by RFC 7009.
+func (s *RequestHandler) revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, r, &model.OAuthError{Code: model.InvalidRequest, Description: "malformed form"})
+		return
+	}
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, r, &model.OAuthError{Code: model.InvalidRequest, Description: "token is required"})
+		return
+	}
+	if err := s.oauthService.Revoke(r.Context(), client, token); err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// introspect is the token introspection endpoint described by RFC 7662.
+// Only confidential clients, such as resource servers, may introspect tokens.
+func (s *RequestHandler) introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, r, &model.OAuthError{Code: model.InvalidRequest, Description: "malformed form"})
+		return
+	}
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+	if client.Public() {
+		writeOAuthError(w, r, &model.OAuthError{Code: model.UnauthorizedClient, Description: "public clients may not introspect tokens"})
+		return
+	}
+	introspection, err := s.oauthService.Introspect(r.Context(), r.PostForm.Get("token"))
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(introspection); err != nil {
+		log.Printf("failed to write introspection: %s", err)
+	}
+}
+
+// sessionUser returns the session ID and user ID of the session cookie sent with the request.
+func (s *RequestHandler) sessionUser(r *http.Request) (string, uuid.UUID, error) {
+	cookie, err := s.extractSession(r)
+	if err != nil || cookie.Value == "" {
+		return "", uuid.UUID{}, &model.UnauthorizedError{Message: "missing session cookie"}
+	}
+	userID, err := s.sessionService.Fetch(r.Context(), cookie.Value)
+	if err != nil {
+		return "", uuid.UUID{}, &model.UnauthorizedError{Message: "invalid session"}
+	}
+	return cookie.Value, userID, nil
+}
+
+// authenticateClient identifies the client from HTTP basic authentication,
+// or the client_id and client_secret form parameters.
+func (s *RequestHandler) authenticateClient(r *http.Request) (*model.OAuthClient, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		// credentials are form-urlencoded before being base64 encoded, see RFC 6749 section 2.3.1
+		var err error
+		if clientID, err = url.QueryUnescape(clientID); err != nil {
+			return nil, &model.OAuthError{Code: model.InvalidClient}
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, &model.OAuthError{Code: model.InvalidClient}
+		}
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	return s.oauthService.AuthenticateClient(r.Context(), clientID, secret)
+}
+
+// loginRequestParam is the query parameter of the authorization request carrying the marker
+// issued by OAuthService.RequestLogin when the user is sent to log in.
+const loginRequestParam = "login_request"
+
+// redirectToLogin sends the user to the login page, which is expected to return the user to
+// the authorization endpoint once they have logged in. The returned request carries a marker
+// recording that a login was requested, so that prompt=login and max_age are satisfied by the
+// login and not by whatever session the user returns with. sessionID is the current session of
+// the user, or empty when they have none.
+func (s *RequestHandler) redirectToLogin(w http.ResponseWriter, r *http.Request, sessionID string) {
+	if s.oauthConfig.LoginURL == "" {
+		writeError(w, r, &model.UnauthorizedError{Message: "login required"})
+		return
+	}
+	loginURL, err := url.Parse(s.oauthConfig.LoginURL)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	marker, err := s.oauthService.RequestLogin(r.Context(), sessionID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	returnQuery := r.URL.Query()
+	returnQuery.Set(loginRequestParam, marker)
+	returnTo := *r.URL
+	returnTo.RawQuery = returnQuery.Encode()
+	query := loginURL.Query()
+	query.Set("return_to", s.oauthConfig.Issuer+returnTo.RequestURI())
+	loginURL.RawQuery = query.Encode()
+	http.Redirect(w, r, loginURL.String(), http.StatusFound)
+}
+
+func (s *RequestHandler) redirectWithCode(w http.ResponseWriter, r *http.Request, userID uuid.UUID, authTime time.Time, req *model.AuthorizationRequest) {
+	code, err := s.oauthService.IssueCode(r.Context(), userID, authTime, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	redirect(w, r, req, url.Values{"code": {code}})
+}
+
+// authorizationError reports an invalid authorization request. Errors concerning the client or
+// redirect URI are shown to the user, all others are returned to the client via redirect.
+func (s *RequestHandler) authorizationError(w http.ResponseWriter, r *http.Request, req *model.AuthorizationRequest, err error) {
+	var oauthErr *model.OAuthError
+	if errors.As(err, &oauthErr) {
+		s.redirectWithError(w, r, req, oauthErr)
+		return
+	}
+	writeError(w, r, err)
+}
+
+func (s *RequestHandler) redirectWithError(w http.ResponseWriter, r *http.Request, req *model.AuthorizationRequest, err *model.OAuthError) {
+	params := url.Values{"error": {err.Code}}
+	if err.Description != "" {
+		params.Set("error_description", err.Description)
+	}
+	redirect(w, r, req, params)
+}
+
+// redirect returns the user to the redirect URI of the client, with params and the state of
+// the authorization request appended to its query.
+func redirect(w http.ResponseWriter, r *http.Request, req *model.AuthorizationRequest, params url.Values) {
+	redirectURI, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	query := redirectURI.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	redirectURI.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func parseAuthorizationRequest(values url.Values) (*model.AuthorizationRequest, error) {
+	req := &model.AuthorizationRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scopes:              strings.Fields(values.Get("scope")),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+		Nonce:               values.Get("nonce"),
+		Prompt:              strings.Fields(values.Get("prompt")),
+	}
+	if v := values.Get("max_age"); v != "" {
+		maxAge, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, &model.ValidationError{Field: "max_age", Message: "max_age must be an integer"}
+		}
+		req.MaxAge = &maxAge
+	}
+	return req, nil
+}
+
+// reauthenticate reports whether the user must log in again before the request is authorized,
+// either because the client asked for it or because the user authenticated too long ago.
+func reauthenticate(req *model.AuthorizationRequest, authTime time.Time) bool {
+	if req.Prompted("login") {
+		return true
+	}
+	if req.MaxAge == nil {
+		return false
+	}
+	// sessions without a recorded auth time cannot satisfy max_age
+	return authTime.IsZero() || time.Since(authTime) > time.Duration(*req.MaxAge)*time.Second
+}
+
+// csrfToken derives the token protecting the consent form from the session ID.
+// Only a holder of the session ID, which is kept in a HttpOnly cookie, can derive it.
+func csrfToken(sessionID string) string {
+	sum := sha256.Sum256([]byte("consent:" + sessionID))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func writeTokenResponse(w http.ResponseWriter, r *http.Request, res interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("request %s: failed to write token response: %s", r.URL.Path, err)
+	}
+}
+
+// writeOAuthError writes an error response as defined by RFC 6749 section 5.2.
+// Errors which are not a model.OAuthError are written as a problem document.
+func writeOAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	var oauthErr *model.OAuthError
+	if !errors.As(err, &oauthErr) {
+		writeError(w, r, err)
+		return
+	}
+	status := http.StatusBadRequest
+	if oauthErr.Code == model.InvalidClient {
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(oauthErr); err != nil {
+		log.Printf("failed to write error response: %s", err)
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/stretchr/testify/require"
+)
+
+const testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func (suite *HandlerTestSuite) TestAuthorizeLoginRequired(t *testing.T) {
+	suite.registerClient(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+authorizeQuery().Encode(), nil)
+	rr := httptest.NewRecorder()
+	suite.handler.authorize(rr, req)
+
+	require.Equal(t, http.StatusFound, rr.Code)
+	location, err := url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(location.String(), env.OAuth.LoginURL))
+	require.Contains(t, location.Query().Get("return_to"), env.OAuth.Issuer+"/oauth/authorize?")
+}
+
+func (suite *HandlerTestSuite) TestAuthorizeInvalidRedirect(t *testing.T) {
+	suite.registerClient(t)
+
+	query := authorizeQuery()
+	query.Set("redirect_uri", "https://attacker.example/callback")
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query.Encode(), nil)
+	rr := httptest.NewRecorder()
+	suite.handler.authorize(rr, req)
+
+	// never redirect to an unregistered URI
+	decodeProblem(t, rr, http.StatusBadRequest)
+	require.Empty(t, rr.Header().Get("Location"))
+}
+
+func (suite *HandlerTestSuite) TestAuthorizationCodeFlow(t *testing.T) {
+	suite.registerClient(t)
+	user, _ := generateUniqueUser(t)
+	require.NoError(t, suite.authService.Create(context.Background(), user))
+	session, err := suite.sessionService.Create(context.Background(), user.ID)
+	require.NoError(t, err)
+
+	// user is asked for consent
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+authorizeQuery().Encode(), nil)
+	req.AddCookie(session)
+	rr := httptest.NewRecorder()
+	suite.handler.authorize(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	csrf := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(rr.Body.String())
+	require.Len(t, csrf, 2)
+
+	// consent is rejected without the csrf token
+	form := authorizeQuery()
+	form.Set("decision", "allow")
+	rr = suite.postForm(t, suite.handler.authorizeDecision, "/oauth/authorize", form, session)
+	decodeProblem(t, rr, http.StatusBadRequest)
+
+	// user allows access, and is redirected back to the client with a code
+	form.Set("csrf_token", csrf[1])
+	rr = suite.postForm(t, suite.handler.authorizeDecision, "/oauth/authorize", form, session)
+	require.Equal(t, http.StatusFound, rr.Code)
+	location, err := url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, "xyz", location.Query().Get("state"))
+	code := location.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	// client exchanges code for tokens
+	rr = suite.postForm(t, suite.handler.token, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"spa"},
+		"code":          {code},
+		"redirect_uri":  {"https://spa.example/callback"},
+		"code_verifier": {testCodeVerifier},
+	}, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	var tokens model.TokenResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens))
+	require.NotEmpty(t, tokens.AccessToken)
+
+	// code cannot be exchanged twice
+	rr = suite.postForm(t, suite.handler.token, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"spa"},
+		"code":          {code},
+		"redirect_uri":  {"https://spa.example/callback"},
+		"code_verifier": {testCodeVerifier},
+	}, nil)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	var oauthErr model.OAuthError
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&oauthErr))
+	require.Equal(t, model.InvalidGrant, oauthErr.Code)
+
+	// consent is remembered
+	req = httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+authorizeQuery().Encode(), nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	suite.handler.authorize(rr, req)
+	require.Equal(t, http.StatusFound, rr.Code)
+	require.Contains(t, rr.Header().Get("Location"), "code=")
+
+	// client revokes the access token
+	rr = suite.postForm(t, suite.handler.revoke, "/oauth/revoke", url.Values{
+		"client_id": {"spa"},
+		"token":     {tokens.AccessToken},
+	}, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+}
+
+func (suite *HandlerTestSuite) registerClient(t *testing.T) {
+	if _, err := suite.oauthRepo.GetClient(context.Background(), "spa"); err == nil {
+		return
+	}
+	require.NoError(t, suite.oauthRepo.CreateClient(context.Background(), &model.OAuthClient{
+		ID:           "spa",
+		Name:         "Single Page App",
+		RedirectURIs: []string{"https://spa.example/callback"},
+		Scopes:       []string{"openid", "profile"},
+	}))
+}
+
+func (suite *HandlerTestSuite) postForm(t *testing.T, handler http.HandlerFunc, target string, form url.Values, session *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if session != nil {
+		req.AddCookie(session)
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func authorizeQuery() url.Values {
+	sum := sha256.Sum256([]byte(testCodeVerifier))
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"spa"},
+		"redirect_uri":          {"https://spa.example/callback"},
+		"scope":                 {"profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dgyurics/auth/auth-server/model"
+)
+
+// providerMetadata is the OpenID Provider configuration document,
+// see OpenID Connect Discovery 1.0 section 3.
+type providerMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	ResponseModesSupported            []string `json:"response_modes_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	PromptValuesSupported             []string `json:"prompt_values_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// openIDConfiguration serves the discovery document used by OpenID Connect clients
+// to locate the endpoints and capabilities of this provider.
+func (s *RequestHandler) openIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	issuer := s.oauthConfig.Issuer
+	var algs []string
+	for _, key := range s.assertionService.KeySet().Keys {
+		algs = append(algs, key.Alg)
+	}
+	metadata := &providerMetadata{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		ScopesSupported:                   []string{model.ScopeOpenID, model.ScopeProfile},
+		ResponseTypesSupported:            []string{"code"},
+		ResponseModesSupported:            []string{"query"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algs,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		PromptValuesSupported:             []string{"none", "login", "consent"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "preferred_username"},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if err := json.NewEncoder(w).Encode(metadata); err != nil {
+		log.Printf("failed to write openid configuration: %s", err)
+	}
+}
+
+// userInfo is the OpenID Connect userinfo endpoint, returning claims about the user
+// identified by the bearer access token.
+func (s *RequestHandler) userInfo(w http.ResponseWriter, r *http.Request) {
+	token, err := bearerToken(r)
+	if err != nil {
+		writeBearerError(w, r, err)
+		return
+	}
+	info, err := s.oauthService.UserInfo(r.Context(), token)
+	if err != nil {
+		writeBearerError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		log.Printf("failed to write userinfo: %s", err)
+	}
+}
+
+// bearerToken returns the access token sent in the Authorization header,
+// or in the access_token form parameter, see RFC 6750 section 2.
+func bearerToken(r *http.Request) (string, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", &model.OAuthError{Code: model.InvalidRequest, Description: "malformed authorization header"}
+		}
+		return token, nil
+	}
+	if r.Method == http.MethodPost {
+		if token := r.PostFormValue("access_token"); token != "" {
+			return token, nil
+		}
+	}
+	return "", &model.OAuthError{Code: model.InvalidToken, Description: "missing access token"}
+}
+
+// writeBearerError writes an error response as defined by RFC 6750 section 3.
+// Errors which are not a model.OAuthError are written as a problem document.
+func writeBearerError(w http.ResponseWriter, r *http.Request, err error) {
+	var oauthErr *model.OAuthError
+	if !errors.As(err, &oauthErr) {
+		writeError(w, r, err)
+		return
+	}
+	status := http.StatusUnauthorized
+	switch oauthErr.Code {
+	case model.InvalidRequest:
+		status = http.StatusBadRequest
+	case model.InsufficientScope:
+		status = http.StatusForbidden
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error=%q, error_description=%q`, oauthErr.Code, oauthErr.Description))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(oauthErr); err != nil {
+		log.Printf("failed to write error response: %s", err)
+	}
+}
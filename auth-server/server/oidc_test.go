@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *HandlerTestSuite) TestOpenIDConfiguration(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil)
+	rr := httptest.NewRecorder()
+	suite.handler.openIDConfiguration(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var metadata providerMetadata
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&metadata))
+	require.Equal(t, env.OAuth.Issuer, metadata.Issuer)
+	require.Equal(t, env.OAuth.Issuer+"/.well-known/jwks.json", metadata.JWKSURI)
+	require.Equal(t, []string{"S256"}, metadata.CodeChallengeMethodsSupported)
+	require.NotEmpty(t, metadata.IDTokenSigningAlgValuesSupported)
+}
+
+func (suite *HandlerTestSuite) TestAuthorizePrompt(t *testing.T) {
+	suite.registerClient(t)
+
+	// prompt=none without a session is reported to the client
+	query := authorizeQuery()
+	query.Set("prompt", "none")
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query.Encode(), nil)
+	rr := httptest.NewRecorder()
+	suite.handler.authorize(rr, req)
+	require.Equal(t, http.StatusFound, rr.Code)
+	location, err := url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, model.LoginRequired, location.Query().Get("error"))
+
+	// prompt=login sends a logged in user to the login page,
+	// returning with the prompt and a marker of the requested login
+	user, _ := generateUniqueUser(t)
+	require.NoError(t, suite.authService.Create(context.Background(), user))
+	session, err := suite.sessionService.Create(context.Background(), user.ID)
+	require.NoError(t, err)
+	query = authorizeQuery()
+	query.Set("prompt", "login")
+	returnTo := suite.authorizeLogin(t, query, session)
+	require.Equal(t, "/auth/oauth/authorize", returnTo.Path)
+	require.Equal(t, "login", returnTo.Query().Get("prompt"))
+	require.Equal(t, "spa", returnTo.Query().Get("client_id"))
+	require.NotEmpty(t, returnTo.Query().Get(loginRequestParam))
+
+	// returning with the session that was already open does not satisfy the prompt
+	returnTo = suite.authorizeLogin(t, returnTo.Query(), session)
+
+	// a new login does, once, the consent form carrying the marker
+	session, err = suite.sessionService.Create(context.Background(), user.ID)
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+returnTo.RawQuery, nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	suite.handler.authorize(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	form := url.Values{}
+	for _, field := range regexp.MustCompile(`type="hidden" name="([a-z_]+)" value="([^"]*)"`).FindAllStringSubmatch(rr.Body.String(), -1) {
+		form.Set(field[1], html.UnescapeString(field[2]))
+	}
+	form.Set("decision", "allow")
+	require.Equal(t, "login", form.Get("prompt"))
+	require.Equal(t, returnTo.Query().Get(loginRequestParam), form.Get(loginRequestParam))
+
+	// the decision is only accepted along with the login
+	withoutLogin := url.Values{}
+	for k, v := range form {
+		withoutLogin[k] = v
+	}
+	withoutLogin.Del(loginRequestParam)
+	rr = suite.postForm(t, suite.handler.authorizeDecision, "/oauth/authorize", withoutLogin, session)
+	require.Equal(t, http.StatusFound, rr.Code)
+	location, err = url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, model.LoginRequired, location.Query().Get("error"))
+
+	rr = suite.postForm(t, suite.handler.authorizeDecision, "/oauth/authorize", form, session)
+	require.Equal(t, http.StatusFound, rr.Code)
+	location, err = url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	require.NotEmpty(t, location.Query().Get("code"))
+
+	// which is redeemed by the code
+	rr = suite.postForm(t, suite.handler.authorizeDecision, "/oauth/authorize", form, session)
+	location, err = url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, model.LoginRequired, location.Query().Get("error"))
+	suite.authorizeLogin(t, returnTo.Query(), session)
+
+	// a forged marker is not accepted
+	query.Set(loginRequestParam, "forged")
+	suite.authorizeLogin(t, query, session)
+}
+
+// authorizeLogin requests authorization, expecting to be sent to the login page,
+// and returns the URL the login page is asked to return the user to.
+func (suite *HandlerTestSuite) authorizeLogin(t *testing.T, query url.Values, session *http.Cookie) *url.URL {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query.Encode(), nil)
+	req.AddCookie(session)
+	rr := httptest.NewRecorder()
+	suite.handler.authorize(rr, req)
+	require.Equal(t, http.StatusFound, rr.Code)
+	location, err := url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(location.String(), env.OAuth.LoginURL))
+	returnTo, err := url.Parse(location.Query().Get("return_to"))
+	require.NoError(t, err)
+	return returnTo
+}
+
+func (suite *HandlerTestSuite) TestUserInfo(t *testing.T) {
+	suite.registerClient(t)
+	user, _ := generateUniqueUser(t)
+	require.NoError(t, suite.authService.Create(context.Background(), user))
+
+	query := authorizeQuery()
+	query.Set("scope", "openid profile")
+	query.Set("nonce", "abc")
+	authReq, err := parseAuthorizationRequest(query)
+	require.NoError(t, err)
+	client, err := suite.oauthService.ValidateAuthorization(context.Background(), authReq)
+	require.NoError(t, err)
+	code, err := suite.oauthService.IssueCode(context.Background(), user.ID, time.Now(), authReq)
+	require.NoError(t, err)
+	tokens, err := suite.oauthService.ExchangeCode(context.Background(), client, code, authReq.RedirectURI, testCodeVerifier)
+	require.NoError(t, err)
+	require.NotEmpty(t, tokens.IDToken)
+
+	req := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	rr := httptest.NewRecorder()
+	suite.handler.userInfo(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var info model.UserInfo
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&info))
+	require.Equal(t, user.ID.String(), info.Subject)
+	require.Equal(t, user.Username, info.PreferredUsername)
+
+	// invalid tokens are rejected as described by RFC 6750
+	req = httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer invalid")
+	rr = httptest.NewRecorder()
+	suite.handler.userInfo(rr, req)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	require.Contains(t, rr.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+type identityContextKey struct{}
+
+// requirePermission returns middleware allowing only users with a session
+// whose roles grant permission. The identity of the user is stored in the request context.
+func (s *RequestHandler) requirePermission(permission string) func(http.Handler) http.Handler {
+	return s.requireIdentity(func(identity *model.Identity) bool {
+		return identity.HasPermission(permission)
+	}, "missing permission "+permission)
+}
+
+// requireRole returns middleware allowing only users with a session who are assigned role.
+// The identity of the user is stored in the request context.
+func (s *RequestHandler) requireRole(role string) func(http.Handler) http.Handler {
+	return s.requireIdentity(func(identity *model.Identity) bool {
+		return identity.HasRole(role)
+	}, "missing role "+role)
+}
+
+// requireIdentity returns middleware allowing only users with a session whose identity
+// is allowed, rejecting other users with a model.ForbiddenError carrying message.
+func (s *RequestHandler) requireIdentity(allowed func(*model.Identity) bool, message string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sessionID, userID, err := s.sessionUser(r)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			identity, err := s.identity(r.Context(), userID)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if !allowed(identity) {
+				writeError(w, r, &model.ForbiddenError{Message: message})
+				return
+			}
+			ctx := model.WithSession(context.WithValue(r.Context(), identityContextKey{}, identity), sessionID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requestIdentity returns the identity of the user making the request.
+// Only valid for requests which passed requirePermission or requireRole.
+func requestIdentity(r *http.Request) *model.Identity {
+	identity, _ := r.Context().Value(identityContextKey{}).(*model.Identity)
+	return identity
+}
+
+// roles lists every role and the permissions it grants.
+func (s *RequestHandler) roles(w http.ResponseWriter, r *http.Request) {
+	roles, err := s.roleService.Roles(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, roles)
+}
+
+// userRoles lists the roles assigned to the user identified by the id URL parameter.
+func (s *RequestHandler) userRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	roles, err := s.roleService.UserRoles(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, roles)
+}
+
+// assignRole assigns the role URL parameter to the user identified by the id URL parameter.
+func (s *RequestHandler) assignRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	actor := requestIdentity(r)
+	if err := s.roleService.Assign(r.Context(), userID, chi.URLParam(r, "role"), actor.UserID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.refreshIdentity(r.Context(), userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeRole revokes the role URL parameter from the user identified by the id URL parameter.
+func (s *RequestHandler) revokeRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	actor := requestIdentity(r)
+	if err := s.roleService.Revoke(r.Context(), userID, chi.URLParam(r, "role"), actor.UserID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.refreshIdentity(r.Context(), userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refreshIdentity removes the cached identity of the user, so a change to their roles
+// takes effect on their next request rather than when their sessions expire.
+func (s *RequestHandler) refreshIdentity(ctx context.Context, userID uuid.UUID) {
+	if err := s.sessionService.RemoveIdentity(ctx, userID); err != nil {
+		log.Printf("failed to remove cached identity: %s", err)
+	}
+}
+
+func userIDParam(r *http.Request) (uuid.UUID, error) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return uuid.UUID{}, &model.ValidationError{Field: "id", Message: "id must be a valid UUID"}
+	}
+	return userID, nil
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("request %s: failed to write response: %s", r.URL.Path, err)
+	}
+}
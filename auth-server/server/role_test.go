@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *HandlerTestSuite) TestRequirePermission(t *testing.T) {
+	router := suite.roleRouter()
+
+	// no session
+	req := httptest.NewRequest(http.MethodGet, "/roles", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	decodeProblem(t, rr, http.StatusUnauthorized)
+
+	// session without permission
+	_, session := suite.userWithRoles(t)
+	req = httptest.NewRequest(http.MethodGet, "/roles", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	problem := decodeProblem(t, rr, http.StatusForbidden)
+	require.Equal(t, problemForbidden, problem.Type)
+
+	// session with permission
+	_, session = suite.userWithRoles(t, "auditor")
+	req = httptest.NewRequest(http.MethodGet, "/roles", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var roles []*model.Role
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&roles))
+	require.Len(t, roles, 2)
+
+	// auditors may not assign roles
+	req = httptest.NewRequest(http.MethodPut, "/users/"+uuid.NewString()+"/roles/admin", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	decodeProblem(t, rr, http.StatusForbidden)
+}
+
+func (suite *HandlerTestSuite) TestAssignRole(t *testing.T) {
+	router := suite.roleRouter()
+	admin, adminSession := suite.userWithRoles(t, "admin")
+	user, userSession := suite.userWithRoles(t)
+
+	// identity of the user is cached without roles
+	req := httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.AddCookie(userSession)
+	rr := httptest.NewRecorder()
+	suite.handler.verify(rr, req)
+	require.Empty(t, rr.Header().Get(headerRoles))
+
+	req = httptest.NewRequest(http.MethodPut, "/users/"+user.ID.String()+"/roles/auditor", nil)
+	req.AddCookie(adminSession)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	require.Equal(t, model.RoleAssigned, suite.roleRepo.Events[len(suite.roleRepo.Events)-1].Type)
+
+	// role change takes effect without logging in again
+	req = httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.AddCookie(userSession)
+	rr = httptest.NewRecorder()
+	suite.handler.verify(rr, req)
+	require.Equal(t, "auditor", rr.Header().Get(headerRoles))
+
+	req = httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.AddCookie(userSession)
+	rr = httptest.NewRecorder()
+	suite.handler.user(rr, req)
+	var fetched model.User
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&fetched))
+	require.Equal(t, []string{"auditor"}, fetched.Roles)
+
+	// assigning twice, unknown roles and unknown users
+	for path, status := range map[string]int{
+		"/users/" + user.ID.String() + "/roles/auditor": http.StatusConflict,
+		"/users/" + user.ID.String() + "/roles/unknown": http.StatusNotFound,
+		"/users/" + uuid.NewString() + "/roles/auditor": http.StatusNotFound,
+		"/users/not-a-uuid/roles/auditor":               http.StatusBadRequest,
+	} {
+		req = httptest.NewRequest(http.MethodPut, path, nil)
+		req.AddCookie(adminSession)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		decodeProblem(t, rr, status)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/users/"+user.ID.String()+"/roles/auditor", nil)
+	req.AddCookie(adminSession)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	require.Equal(t, model.RoleRevoked, suite.roleRepo.Events[len(suite.roleRepo.Events)-1].Type)
+	require.Empty(t, suite.roleRepo.UserRoles[user.ID])
+	require.Contains(t, suite.roleRepo.UserRoles[admin.ID], "admin")
+}
+
+// roleRouter routes the role endpoints as registered by NewHTTPServer
+func (suite *HandlerTestSuite) roleRouter() http.Handler {
+	h := &suite.handler
+	r := chi.NewRouter()
+	r.With(h.requirePermission(model.PermissionRolesRead)).Get("/roles", h.roles)
+	r.With(h.requirePermission(model.PermissionRolesWrite)).Put("/users/{id}/roles/{role}", h.assignRole)
+	r.With(h.requirePermission(model.PermissionRolesWrite)).Delete("/users/{id}/roles/{role}", h.revokeRole)
+	return r
+}
+
+// userWithRoles creates a user with the given roles, returning the user and their session cookie
+func (suite *HandlerTestSuite) userWithRoles(t *testing.T, roles ...string) (*model.User, *http.Cookie) {
+	user, _ := generateUniqueUser(t)
+	require.NoError(t, suite.authService.Create(context.Background(), user))
+	for _, role := range roles {
+		require.NoError(t, suite.roleRepo.AssignRole(context.Background(), user.ID, role, uuid.Nil))
+	}
+	rr := httptest.NewRecorder()
+	require.NoError(t, suite.handler.createSession(context.Background(), rr, user))
+	return user, rr.Result().Cookies()[0]
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/service"
+	"github.com/google/uuid"
+)
+
+// socialStateCookie binds a login at the upstream provider to the browser which started it,
+// so a user cannot be made to complete a login started by someone else.
+const socialStateCookie = "X-Social-State"
+
+// socialLogin sends the user to the upstream provider to sign in. The optional return_to
+// query parameter is the page the user is sent to once signed in.
+func (s *RequestHandler) socialLogin(w http.ResponseWriter, r *http.Request) {
+	if s.socialConfig.Issuer == "" {
+		writeError(w, r, &model.NotFoundError{Resource: "social login"})
+		return
+	}
+	state, authURL, err := s.socialService.Begin(r.Context(), s.returnTo(r.URL.Query().Get("return_to")))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	http.SetCookie(w, s.stateCookie(state, int(service.SocialStateTTL.Seconds())))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// socialCallback is the redirect URL registered with the upstream provider. The user is signed in,
+// registering them when signing in for the first time, and sent to the page passed to socialLogin.
+// A user who already has a session links the upstream account to their existing user.
+func (s *RequestHandler) socialCallback(w http.ResponseWriter, r *http.Request) {
+	if s.socialConfig.Issuer == "" {
+		writeError(w, r, &model.NotFoundError{Resource: "social login"})
+		return
+	}
+	query := r.URL.Query()
+	if code := query.Get("error"); code != "" {
+		writeError(w, r, &model.UnauthorizedError{Message: "login at identity provider was not completed: " + code})
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(socialStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		writeError(w, r, &model.ValidationError{Field: "state", Message: "invalid or expired state"})
+		return
+	}
+	http.SetCookie(w, s.stateCookie("", -1))
+
+	var current uuid.UUID
+	if _, userID, err := s.sessionUser(r); err == nil {
+		current = userID
+	}
+	user, returnTo, err := s.socialService.Complete(r.Context(), state, query.Get("code"), current)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// users linking an account keep their existing session
+	if user.ID != current {
+		if err := s.createSession(r.Context(), w, user); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+func (s *RequestHandler) stateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     socialStateCookie,
+		Value:    value,
+		Domain:   s.sessionConfig.Domain,
+		Path:     s.sessionConfig.Path,
+		MaxAge:   maxAge,
+		Secure:   s.sessionConfig.Secure,
+		HttpOnly: true,
+		// sent with the top-level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// returnTo returns returnTo if it is a page users may be sent to after signing in,
+// either the configured return URL or the authorization endpoint, otherwise the configured
+// return URL. This prevents the login from being used as an open redirect.
+func (s *RequestHandler) returnTo(returnTo string) string {
+	if returnTo == "" {
+		return s.socialConfig.ReturnURL
+	}
+	target, err := url.Parse(returnTo)
+	if err != nil {
+		return s.socialConfig.ReturnURL
+	}
+	for _, allowed := range []string{s.socialConfig.ReturnURL, s.oauthConfig.Issuer + "/oauth/"} {
+		prefix, err := url.Parse(allowed)
+		if err != nil {
+			continue
+		}
+		if target.Scheme == prefix.Scheme && target.Host == prefix.Host && underPath(target.Path, prefix.Path) {
+			return returnTo
+		}
+	}
+	return s.socialConfig.ReturnURL
+}
+
+// underPath reports whether p is prefix, or a path below it. Matching is done on whole
+// segments, so /app does not allow /app-evil, and paths with dot segments are rejected.
+func underPath(p string, prefix string) bool {
+	clean := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	if clean != p {
+		return false
+	}
+	return p == prefix || strings.HasPrefix(p, strings.TrimSuffix(prefix, "/")+"/")
+}
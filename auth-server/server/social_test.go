@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/oidc"
+	repo "github.com/dgyurics/auth/auth-server/repository"
+	"github.com/dgyurics/auth/auth-server/service"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *HandlerTestSuite) TestSocialLoginDisabled(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/login/oidc", nil)
+	rr := httptest.NewRecorder()
+	suite.handler.socialLogin(rr, req)
+	decodeProblem(t, rr, http.StatusNotFound)
+}
+
+func (suite *HandlerTestSuite) TestSocialLogin(t *testing.T) {
+	provider, err := oidc.NewFakeProvider("auth-server", "secret")
+	require.NoError(t, err)
+	defer provider.Close()
+	provider.User = oidc.Claims{Claims: jwt.Claims{Subject: uuid.NewString()}, PreferredUsername: "social"}
+	handler := suite.socialHandler(provider)
+
+	// user is sent to the provider, unsafe return_to is ignored
+	req := httptest.NewRequest(http.MethodGet, "/login/oidc?return_to="+url.QueryEscape("https://attacker.example/"), nil)
+	rr := httptest.NewRecorder()
+	handler.socialLogin(rr, req)
+	require.Equal(t, http.StatusFound, rr.Code)
+	require.True(t, strings.HasPrefix(rr.Header().Get("Location"), provider.URL+"/authorize"))
+	stateCookie := rr.Result().Cookies()[0]
+	require.Equal(t, socialStateCookie, stateCookie.Name)
+
+	code, state, err := provider.Authorize(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, stateCookie.Value, state)
+	callback := "/login/oidc/callback?" + url.Values{"code": {code}, "state": {state}}.Encode()
+
+	// callback must come from the browser which started the login
+	req = httptest.NewRequest(http.MethodGet, callback, nil)
+	rr = httptest.NewRecorder()
+	handler.socialCallback(rr, req)
+	decodeProblem(t, rr, http.StatusBadRequest)
+
+	// user is signed in and returned to the configured page
+	req = httptest.NewRequest(http.MethodGet, callback, nil)
+	req.AddCookie(stateCookie)
+	rr = httptest.NewRecorder()
+	handler.socialCallback(rr, req)
+	require.Equal(t, http.StatusFound, rr.Code)
+	require.Equal(t, handler.socialConfig.ReturnURL, rr.Header().Get("Location"))
+	var session *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == env.Session.Name {
+			session = cookie
+		}
+	}
+	require.NotNil(t, session)
+	userID, err := suite.sessionService.Fetch(req.Context(), session.Value)
+	require.NoError(t, err)
+	identity, err := handler.identity(req.Context(), userID)
+	require.NoError(t, err)
+	require.Contains(t, identity.Username, "social")
+}
+
+// socialHandler returns a copy of the suite handler signing users in with provider
+func (suite *HandlerTestSuite) socialHandler(provider *oidc.FakeProvider) RequestHandler {
+	cfg := env.SocialLogin
+	cfg.Issuer = provider.Issuer()
+	cfg.ClientID = provider.ClientID
+	cfg.ClientSecret = provider.ClientSecret
+	rp := oidc.NewProvider(oidc.Config{
+		Issuer:       cfg.Issuer,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+	}, nil)
+
+	handler := suite.handler
+	handler.socialConfig = cfg
+	handler.socialService = service.NewSocialService(rp, suite.authService, suite.userRepo, &repo.MockIdentityRepository{},
+		suite.eventRepo, suite.sessionCache, suite.handler.usernamePolicy, cfg)
+	return handler
+}
+
+func (suite *HandlerTestSuite) TestReturnTo(t *testing.T) {
+	handler := suite.handler
+	handler.socialConfig.ReturnURL = "https://app.example/app"
+	handler.oauthConfig.Issuer = "https://auth.example/auth"
+
+	for returnTo, expected := range map[string]string{
+		"":                                       "https://app.example/app",
+		"https://app.example/app":                "https://app.example/app",
+		"https://app.example/app/settings?tab=1": "https://app.example/app/settings?tab=1",
+		"https://auth.example/auth/oauth/authorize": "https://auth.example/auth/oauth/authorize",
+		"https://app.example/app-evil":              "https://app.example/app",
+		"https://app.example/app/../evil":           "https://app.example/app",
+		"https://app.example/app//evil.example":     "https://app.example/app",
+		"https://auth.example/auth/oauth-evil":      "https://app.example/app",
+		"https://attacker.example/app":              "https://app.example/app",
+		"http://app.example/app":                    "https://app.example/app",
+	} {
+		require.Equal(t, expected, handler.returnTo(returnTo), returnTo)
+	}
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// deliveryPage is a page of the deliveries to a webhook endpoint, newest first.
+// NextBefore, when set, is the before query parameter requesting the next page.
+type deliveryPage struct {
+	Deliveries []*model.WebhookDelivery `json:"deliveries"`
+	NextBefore int64                    `json:"next_before,omitempty"`
+}
+
+// webhookRoutes registers the routes managing webhook endpoints, mounted under /admin/webhooks.
+func (s *RequestHandler) webhookRoutes(r chi.Router) {
+	r.Get("/", s.webhookEndpoints)
+	r.Post("/", s.createWebhookEndpoint)
+	r.Get("/{id}", s.webhookEndpoint)
+	r.Patch("/{id}", s.updateWebhookEndpoint)
+	r.Delete("/{id}", s.deleteWebhookEndpoint)
+	r.Get("/{id}/deliveries", s.webhookDeliveries)
+	r.Get("/{id}/deliveries/{delivery}", s.webhookDelivery)
+	r.Post("/{id}/deliveries/{delivery}/retry", s.retryWebhookDelivery)
+}
+
+// webhookEndpoints lists every webhook endpoint, without their secrets.
+func (s *RequestHandler) webhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := s.webhookService.Endpoints(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, endpoints)
+}
+
+// createWebhookEndpoint creates a webhook endpoint from the JSON request body, which is active
+// unless stated otherwise. The response includes the secret deliveries are signed with,
+// which is never returned again.
+func (s *RequestHandler) createWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	endpoint := &model.WebhookEndpoint{Active: true}
+	if err := parseRequestBody(r, endpoint); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.webhookService.Create(r.Context(), endpoint, requestIdentity(r).UserID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, endpoint)
+}
+
+// webhookEndpoint returns the webhook endpoint identified by the id URL parameter.
+func (s *RequestHandler) webhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookIDParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	endpoint, err := s.webhookService.Endpoint(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, endpoint)
+}
+
+// updateWebhookEndpoint changes the fields of the webhook endpoint identified by the id URL
+// parameter which are present in the JSON request body.
+func (s *RequestHandler) updateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookIDParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var update model.WebhookEndpointUpdate
+	if err := parseRequestBody(r, &update); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	endpoint, err := s.webhookService.Update(r.Context(), id, &update, requestIdentity(r).UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, endpoint)
+}
+
+// deleteWebhookEndpoint deletes the webhook endpoint identified by the id URL parameter, along with its deliveries.
+func (s *RequestHandler) deleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookIDParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.webhookService.Delete(r.Context(), id, requestIdentity(r).UserID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// webhookDeliveries lists the deliveries to the webhook endpoint identified by the id URL parameter,
+// newest first. The optional status query parameter restricts the list to deliveries with that
+// status, and before and limit page through the list.
+func (s *RequestHandler) webhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookIDParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	limit, err := intParam(r, "limit", defaultPageSize, 1, maxPageSize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, err := intParam(r, "before", 0, 0, -1)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	status := model.WebhookDeliveryStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", model.WebhookPending, model.WebhookSucceeded, model.WebhookDead:
+	default:
+		writeError(w, r, &model.ValidationError{Field: "status", Message: "status must be pending, succeeded or dead"})
+		return
+	}
+	deliveries, err := s.webhookService.Deliveries(r.Context(), &model.WebhookDeliveryFilter{
+		EndpointID: id,
+		Status:     status,
+		Before:     int64(before),
+		Limit:      limit,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	page := &deliveryPage{Deliveries: deliveries}
+	if len(deliveries) == limit {
+		page.NextBefore = deliveries[len(deliveries)-1].ID
+	}
+	writeJSON(w, r, http.StatusOK, page)
+}
+
+// webhookDelivery returns the delivery identified by the delivery URL parameter to the webhook
+// endpoint identified by the id URL parameter, along with the log of its attempts.
+func (s *RequestHandler) webhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, deliveryID, err := webhookDeliveryParams(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	delivery, err := s.webhookService.Delivery(r.Context(), id, deliveryID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, delivery)
+}
+
+// retryWebhookDelivery returns the dead delivery identified by the delivery URL parameter to
+// the webhook endpoint identified by the id URL parameter to pending, to be attempted again.
+func (s *RequestHandler) retryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, deliveryID, err := webhookDeliveryParams(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.webhookService.Retry(r.Context(), id, deliveryID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func webhookIDParam(r *http.Request) (uuid.UUID, error) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return uuid.UUID{}, &model.ValidationError{Field: "id", Message: "id must be a valid UUID"}
+	}
+	return id, nil
+}
+
+func webhookDeliveryParams(r *http.Request) (uuid.UUID, int64, error) {
+	id, err := webhookIDParam(r)
+	if err != nil {
+		return uuid.UUID{}, 0, err
+	}
+	deliveryID, err := strconv.ParseInt(chi.URLParam(r, "delivery"), 10, 64)
+	if err != nil || deliveryID < 1 {
+		return uuid.UUID{}, 0, &model.ValidationError{Field: "delivery", Message: "delivery must be a positive integer"}
+	}
+	return id, deliveryID, nil
+}
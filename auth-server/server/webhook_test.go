@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookRequest serves a request with a JSON body to router on behalf of the session
+func webhookRequest(router http.Handler, method string, target string, body string, session *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.AddCookie(session)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func (suite *HandlerTestSuite) TestWebhookEndpoints(t *testing.T) {
+	router := suite.adminRouter()
+	admin, session := suite.userWithRoles(t, "admin")
+
+	// only admins manage endpoints
+	_, userSession := suite.userWithRoles(t)
+	rr := suite.adminRequest(router, http.MethodGet, "/admin/webhooks", userSession)
+	decodeProblem(t, rr, http.StatusForbidden)
+
+	// the secret is only returned on creation, and endpoints are active by default
+	rr = webhookRequest(router, http.MethodPost, "/admin/webhooks",
+		`{"url":"https://example.com/hook","event_types":["logged_in","account_locked"],"description":"siem"}`, session)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var created model.WebhookEndpoint
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+	require.NotEmpty(t, created.Secret)
+	require.True(t, created.Active)
+	require.Equal(t, []model.EventType{model.LoggedIn, model.AccountLocked}, created.EventTypes)
+	target := "/admin/webhooks/" + created.ID.String()
+
+	rr = suite.adminRequest(router, http.MethodGet, target, session)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var fetched model.WebhookEndpoint
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&fetched))
+	require.Equal(t, created.URL, fetched.URL)
+	require.Empty(t, fetched.Secret)
+	require.NotContains(t, rr.Body.String(), created.Secret)
+
+	rr = suite.adminRequest(router, http.MethodGet, "/admin/webhooks", session)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NotContains(t, rr.Body.String(), created.Secret)
+
+	// the change is recorded, with the actor but without the secret
+	events := suite.webhookRepo.Events
+	require.Equal(t, model.WebhookCreated, events[len(events)-1].Type)
+	require.Equal(t, admin.ID, events[len(events)-1].UUID)
+	require.NotContains(t, string(events[len(events)-1].Body), created.Secret)
+
+	// only the fields present are updated
+	rr = webhookRequest(router, http.MethodPatch, target, `{"active":false}`, session)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var updated model.WebhookEndpoint
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&updated))
+	require.False(t, updated.Active)
+	require.Equal(t, created.URL, updated.URL)
+	require.Equal(t, created.EventTypes, updated.EventTypes)
+	events = suite.webhookRepo.Events
+	require.Equal(t, model.WebhookUpdated, events[len(events)-1].Type)
+
+	// invalid endpoints are rejected
+	for _, body := range []string{
+		`{"url":"ftp://example.com/hook"}`,
+		`{"url":"/hook"}`,
+		`{"url":"https://example.com/hook","event_types":["unknown"]}`,
+		`{"url":"https://example.com/hook","description":"` + strings.Repeat("a", 201) + `"}`,
+		`not json`,
+	} {
+		rr = webhookRequest(router, http.MethodPost, "/admin/webhooks", body, session)
+		decodeProblem(t, rr, http.StatusBadRequest)
+	}
+	rr = webhookRequest(router, http.MethodPatch, target, `{"url":"example.com"}`, session)
+	decodeProblem(t, rr, http.StatusBadRequest)
+
+	rr = suite.adminRequest(router, http.MethodDelete, target, session)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	events = suite.webhookRepo.Events
+	require.Equal(t, model.WebhookDeleted, events[len(events)-1].Type)
+	rr = suite.adminRequest(router, http.MethodGet, target, session)
+	decodeProblem(t, rr, http.StatusNotFound)
+	rr = suite.adminRequest(router, http.MethodDelete, target, session)
+	decodeProblem(t, rr, http.StatusNotFound)
+	rr = suite.adminRequest(router, http.MethodGet, "/admin/webhooks/invalid", session)
+	decodeProblem(t, rr, http.StatusBadRequest)
+}
+
+func (suite *HandlerTestSuite) TestWebhookDeliveries(t *testing.T) {
+	ctx := context.Background()
+	router := suite.adminRouter()
+	_, session := suite.userWithRoles(t, "admin")
+
+	rr := webhookRequest(router, http.MethodPost, "/admin/webhooks", `{"url":"https://example.com/deliveries"}`, session)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var endpoint model.WebhookEndpoint
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&endpoint))
+	target := "/admin/webhooks/" + endpoint.ID.String() + "/deliveries"
+
+	require.NoError(t, suite.webhookRepo.CreateDeliveries(ctx, []*model.Event{
+		{ID: 1001, Type: model.LoggedIn},
+		{ID: 1002, Type: model.AccountLocked},
+		{ID: 1003, Type: model.LoggedOut},
+	}))
+	deliveries, err := suite.webhookRepo.GetDeliveries(ctx, &model.WebhookDeliveryFilter{EndpointID: endpoint.ID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 3)
+	dead := deliveries[1]
+	dead.Status, dead.Attempts = model.WebhookDead, 10
+	require.NoError(t, suite.webhookRepo.RecordAttempt(ctx, dead, &model.WebhookAttempt{StatusCode: 500, Error: "endpoint responded with status 500"}))
+
+	// newest first, paged with next_before
+	rr = suite.adminRequest(router, http.MethodGet, target+"?limit=2", session)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var page deliveryPage
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+	require.Len(t, page.Deliveries, 2)
+	require.Equal(t, model.LoggedOut, page.Deliveries[0].EventType)
+	require.Equal(t, page.Deliveries[1].ID, page.NextBefore)
+
+	rr = suite.adminRequest(router, http.MethodGet, target+"?status=dead", session)
+	require.Equal(t, http.StatusOK, rr.Code)
+	page = deliveryPage{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+	require.Len(t, page.Deliveries, 1)
+	require.Equal(t, dead.ID, page.Deliveries[0].ID)
+
+	rr = suite.adminRequest(router, http.MethodGet, target+"?status=unknown", session)
+	decodeProblem(t, rr, http.StatusBadRequest)
+
+	// a single delivery includes the log of its attempts
+	deliveryTarget := target + "/" + strconv.FormatInt(dead.ID, 10)
+	rr = suite.adminRequest(router, http.MethodGet, deliveryTarget, session)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var delivery model.WebhookDelivery
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&delivery))
+	require.Equal(t, model.WebhookDead, delivery.Status)
+	require.Len(t, delivery.Log, 1)
+	require.Equal(t, 500, delivery.Log[0].StatusCode)
+
+	// only dead deliveries are retried
+	rr = suite.adminRequest(router, http.MethodPost, target+"/"+strconv.FormatInt(deliveries[0].ID, 10)+"/retry", session)
+	decodeProblem(t, rr, http.StatusConflict)
+	rr = suite.adminRequest(router, http.MethodPost, deliveryTarget+"/retry", session)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	retried, err := suite.webhookRepo.GetDelivery(ctx, endpoint.ID, dead.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.WebhookPending, retried.Status)
+	require.Zero(t, retried.Attempts)
+
+	rr = suite.adminRequest(router, http.MethodGet, target+"/999999", session)
+	decodeProblem(t, rr, http.StatusNotFound)
+	rr = suite.adminRequest(router, http.MethodGet, target+"/invalid", session)
+	decodeProblem(t, rr, http.StatusBadRequest)
+}
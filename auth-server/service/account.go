@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ReauthWindow is how recently a user must have signed in to delete their account
+// without entering their password, allowing users of social login to reauthenticate
+// by signing in with their provider.
+const ReauthWindow = 5 * time.Minute
+
+// exportPageSize is the number of events fetched at once when exporting an account.
+const exportPageSize = 500
+
+// AccountService is an interface for users managing their own account.
+type AccountService interface {
+	Events(ctx context.Context, filter *model.EventFilter) ([]*model.Event, error)
+	Export(ctx context.Context, userID uuid.UUID) (*model.AccountExport, error)
+	Update(ctx context.Context, userID uuid.UUID, update *model.UserUpdate) (*model.User, error)
+	Delete(ctx context.Context, userID uuid.UUID, password string, authTime time.Time) error
+}
+
+type accountService struct {
+	userRepository      repository.UserRepository
+	eventRepository     repository.EventRepository
+	roleRepository      repository.RoleRepository
+	identityRepository  repository.IdentityRepository
+	sessionService      SessionService
+	notificationService NotificationService
+}
+
+// NewAccountService creates a new AccountService with the given user, event, role + identity repositories.
+// Changes to an account are pushed to the user's other devices through notificationService.
+func NewAccountService(
+	userRepository repository.UserRepository,
+	eventRepository repository.EventRepository,
+	roleRepository repository.RoleRepository,
+	identityRepository repository.IdentityRepository,
+	sessionService SessionService,
+	notificationService NotificationService,
+) AccountService {
+	return &accountService{
+		userRepository,
+		eventRepository,
+		roleRepository,
+		identityRepository,
+		sessionService,
+		notificationService,
+	}
+}
+
+// Events returns the events of the user selected by filter, their security history.
+func (s *accountService) Events(ctx context.Context, filter *model.EventFilter) ([]*model.Event, error) {
+	return s.eventRepository.GetEvents(ctx, filter)
+}
+
+// Export returns the data stored about the user: their profile and roles, active sessions,
+// linked identities and every event recorded about them.
+func (s *accountService) Export(ctx context.Context, userID uuid.UUID) (*model.AccountExport, error) {
+	user := &model.User{ID: userID}
+	if err := s.userRepository.GetUser(ctx, user); err != nil {
+		return nil, err
+	}
+	roles, err := s.roleRepository.GetUserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		user.Roles = append(user.Roles, role.Name)
+	}
+
+	export := &model.AccountExport{
+		User:       model.OmitPassword(user),
+		ExportedAt: time.Now().UTC(),
+	}
+	if export.Sessions, err = s.sessionService.UserSessions(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.Identities, err = s.identityRepository.GetUserIdentities(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	filter := &model.EventFilter{UUID: userID, Limit: exportPageSize}
+	export.Events = make([]*model.Event, 0)
+	for {
+		events, err := s.eventRepository.GetEvents(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		export.Events = append(export.Events, events...)
+		if len(events) < filter.Limit {
+			return export, nil
+		}
+		filter.Before = events[len(events)-1].ID
+	}
+}
+
+// Update applies update to the profile of the user, returning the updated user.
+// The fields of update must have been validated by the caller.
+func (s *accountService) Update(ctx context.Context, userID uuid.UUID, update *model.UserUpdate) (*model.User, error) {
+	user := &model.User{ID: userID}
+	if err := s.userRepository.GetUser(ctx, user); err != nil {
+		return nil, err
+	}
+	if update.Username != nil && *update.Username != user.Username {
+		if err := s.changeUsername(ctx, user, *update.Username); err != nil {
+			return nil, err
+		}
+	}
+	return model.OmitPassword(user), nil
+}
+
+// changeUsername changes the username of user, recording the old and new username as an event,
+// and notifies the user's websocket connections of the change.
+func (s *accountService) changeUsername(ctx context.Context, user *model.User, username string) error {
+	event, err := model.NewEvent(ctx, user.ID, model.UsernameChanged, &model.UsernameChangedPayload{
+		ID:          user.ID,
+		OldUsername: user.Username,
+		NewUsername: username,
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.userRepository.UpdateUsername(ctx, user.ID, username, event); err != nil {
+		return err
+	}
+	user.Username = username
+
+	// the cached identity carries the old username
+	if err := s.sessionService.RemoveIdentity(ctx, user.ID); err != nil {
+		log.Printf("failed to remove cached identity: %s", err)
+	}
+	if err := s.notificationService.Notify(ctx, user.ID, &model.Notification{
+		Type:     model.UsernameChanged,
+		Username: username,
+	}); err != nil {
+		log.Printf("failed to notify user %s: %s", user.ID, err)
+	}
+	return nil
+}
+
+// Delete erases the account of the user, signing them out of every session.
+// The user must reauthenticate, either with their password, or by having signed in
+// within ReauthWindow of authTime when password is empty.
+func (s *accountService) Delete(ctx context.Context, userID uuid.UUID, password string, authTime time.Time) error {
+	user := &model.User{ID: userID}
+	if err := s.userRepository.GetUser(ctx, user); err != nil {
+		return err
+	}
+	if password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+			return &model.UnauthorizedError{Message: "invalid credentials"}
+		}
+	} else if time.Since(authTime) > ReauthWindow {
+		return &model.UnauthorizedError{Message: "password required, or sign in again to delete your account"}
+	}
+
+	// the event of a self-service deletion omits the username, which is personal data
+	event, err := model.NewEvent(ctx, userID, model.AccountDeleted, &model.AccountActionPayload{
+		ID:      userID,
+		ActorID: userID,
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.userRepository.EraseUser(ctx, userID, event); err != nil {
+		return err
+	}
+	if err := s.sessionService.RemoveUserSessions(ctx, userID); err != nil {
+		return err
+	}
+	return s.sessionService.RemoveIdentity(ctx, userID)
+}
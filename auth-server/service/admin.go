@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+)
+
+// AdminService is an interface for operators managing the accounts of other users.
+// Every change is recorded as an event carrying the ID of the acting admin.
+type AdminService interface {
+	Users(ctx context.Context, search string, limit int, offset int) ([]*model.User, int, error)
+	User(ctx context.Context, userID uuid.UUID) (*model.User, error)
+	Sessions(ctx context.Context, userID uuid.UUID) ([]*model.Session, error)
+	Events(ctx context.Context, filter *model.EventFilter) ([]*model.Event, error)
+	Activity(ctx context.Context, userID uuid.UUID, logins int) (*model.UserActivity, error)
+	Logout(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error
+	Lock(ctx context.Context, userID uuid.UUID, actorID uuid.UUID, reason string) error
+	Unlock(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error
+	Disable(ctx context.Context, userID uuid.UUID, actorID uuid.UUID, reason string) error
+	Enable(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error
+	Verify(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error
+	Delete(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error
+}
+
+type adminService struct {
+	userRepository       repository.UserRepository
+	eventRepository      repository.EventRepository
+	roleRepository       repository.RoleRepository
+	projectionRepository repository.ProjectionRepository
+	sessionService       SessionService
+}
+
+// NewAdminService creates a new AdminService with the given user, event, role + projection repositories.
+// Sessions of users who are signed out, locked, disabled or deleted are removed through sessionService.
+func NewAdminService(
+	userRepository repository.UserRepository,
+	eventRepository repository.EventRepository,
+	roleRepository repository.RoleRepository,
+	projectionRepository repository.ProjectionRepository,
+	sessionService SessionService,
+) AdminService {
+	return &adminService{
+		userRepository,
+		eventRepository,
+		roleRepository,
+		projectionRepository,
+		sessionService,
+	}
+}
+
+// Users returns a page of users whose username contains search, and the total number of matches.
+func (s *adminService) Users(ctx context.Context, search string, limit int, offset int) ([]*model.User, int, error) {
+	return s.userRepository.ListUsers(ctx, search, limit, offset)
+}
+
+// User returns the user, including their status and roles.
+func (s *adminService) User(ctx context.Context, userID uuid.UUID) (*model.User, error) {
+	user := &model.User{ID: userID}
+	if err := s.userRepository.GetUser(ctx, user); err != nil {
+		return nil, err
+	}
+	roles, err := s.roleRepository.GetUserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	user.Roles = make([]string, 0, len(roles))
+	for _, role := range roles {
+		user.Roles = append(user.Roles, role.Name)
+	}
+	return model.OmitPassword(user), nil
+}
+
+// Sessions returns the active sessions of the user.
+func (s *adminService) Sessions(ctx context.Context, userID uuid.UUID) ([]*model.Session, error) {
+	if err := s.userRepository.GetUser(ctx, &model.User{ID: userID}); err != nil {
+		return nil, err
+	}
+	return s.sessionService.UserSessions(ctx, userID)
+}
+
+// Events returns the events selected by filter, of every user when its UUID is uuid.Nil.
+func (s *adminService) Events(ctx context.Context, filter *model.EventFilter) ([]*model.Event, error) {
+	return s.eventRepository.GetEvents(ctx, filter)
+}
+
+// Activity returns the activity of the user projected from their events, along with their
+// most recent logins.
+func (s *adminService) Activity(ctx context.Context, userID uuid.UUID, logins int) (*model.UserActivity, error) {
+	if err := s.userRepository.GetUser(ctx, &model.User{ID: userID}); err != nil {
+		return nil, err
+	}
+	return s.projectionRepository.GetUserActivity(ctx, userID, logins)
+}
+
+// Logout signs the user out of every session.
+func (s *adminService) Logout(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error {
+	user := &model.User{ID: userID}
+	if err := s.userRepository.GetUser(ctx, user); err != nil {
+		return err
+	}
+	// record the sessions signed out of, while they still exist
+	sessions, err := s.sessionService.UserSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	sessionIDs := make([]string, len(sessions))
+	for i, session := range sessions {
+		sessionIDs[i] = session.ID
+	}
+	if err := s.sessionService.RemoveUserSessions(ctx, userID); err != nil {
+		return err
+	}
+	event, err := model.NewEvent(ctx, userID, model.LoggedOutAll, &model.LogoutAllPayload{
+		ID:         userID,
+		Username:   user.Username,
+		SessionIDs: sessionIDs,
+		ActorID:    &actorID,
+	})
+	if err != nil {
+		return err
+	}
+	return s.eventRepository.CreateEvent(ctx, event)
+}
+
+// Lock temporarily prevents an active or unverified user from signing in,
+// and signs them out of every session.
+func (s *adminService) Lock(ctx context.Context, userID uuid.UUID, actorID uuid.UUID, reason string) error {
+	return s.deactivate(ctx, userID, actorID, &statusChange{
+		from:      []model.UserStatus{model.UserActive, model.UserPendingVerification},
+		to:        model.UserLocked,
+		eventType: model.AccountLocked,
+		reason:    reason,
+	})
+}
+
+// Unlock allows a locked user to sign in again.
+func (s *adminService) Unlock(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error {
+	return s.setStatus(ctx, userID, actorID, &statusChange{
+		from:      []model.UserStatus{model.UserLocked},
+		to:        model.UserActive,
+		eventType: model.AccountUnlocked,
+	})
+}
+
+// Disable indefinitely prevents the user from signing in, and signs them out of every session.
+func (s *adminService) Disable(ctx context.Context, userID uuid.UUID, actorID uuid.UUID, reason string) error {
+	return s.deactivate(ctx, userID, actorID, &statusChange{
+		from:      []model.UserStatus{model.UserActive, model.UserLocked, model.UserPendingVerification},
+		to:        model.UserDisabled,
+		eventType: model.AccountDisabled,
+		reason:    reason,
+	})
+}
+
+// Enable allows a disabled user to sign in again.
+func (s *adminService) Enable(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error {
+	return s.setStatus(ctx, userID, actorID, &statusChange{
+		from:      []model.UserStatus{model.UserDisabled},
+		to:        model.UserActive,
+		eventType: model.AccountEnabled,
+	})
+}
+
+// Verify allows a user pending verification to sign in.
+func (s *adminService) Verify(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error {
+	return s.setStatus(ctx, userID, actorID, &statusChange{
+		from:      []model.UserStatus{model.UserPendingVerification},
+		to:        model.UserActive,
+		eventType: model.AccountVerified,
+	})
+}
+
+// Delete deletes the user and signs them out of every session. Their events are kept.
+func (s *adminService) Delete(ctx context.Context, userID uuid.UUID, actorID uuid.UUID) error {
+	if userID == actorID {
+		return &model.ConflictError{Message: "cannot delete your own account"}
+	}
+	user := &model.User{ID: userID}
+	if err := s.userRepository.GetUser(ctx, user); err != nil {
+		return err
+	}
+	event, err := adminEvent(ctx, model.AccountDeleted, user, actorID, "")
+	if err != nil {
+		return err
+	}
+	if err := s.userRepository.DeleteUser(ctx, userID, event); err != nil {
+		return err
+	}
+	return s.removeSessions(ctx, userID)
+}
+
+// statusChange describes a transition of the status of a user, recorded as an event of eventType.
+type statusChange struct {
+	from      []model.UserStatus
+	to        model.UserStatus
+	eventType model.EventType
+	reason    string
+}
+
+// deactivate applies change, which must prevent the user from signing in,
+// and signs the user out of every session.
+func (s *adminService) deactivate(ctx context.Context, userID uuid.UUID, actorID uuid.UUID, change *statusChange) error {
+	if userID == actorID {
+		return &model.ConflictError{Message: "cannot deactivate your own account"}
+	}
+	if err := s.setStatus(ctx, userID, actorID, change); err != nil {
+		return err
+	}
+	return s.removeSessions(ctx, userID)
+}
+
+// setStatus applies change, returning a model.ConflictError when the current status
+// of the user is not one change may be applied to.
+func (s *adminService) setStatus(ctx context.Context, userID uuid.UUID, actorID uuid.UUID, change *statusChange) error {
+	user := &model.User{ID: userID}
+	if err := s.userRepository.GetUser(ctx, user); err != nil {
+		return err
+	}
+	allowed := false
+	for _, status := range change.from {
+		allowed = allowed || user.Status == status
+	}
+	if !allowed {
+		return &model.ConflictError{Message: "account is " + string(user.Status)}
+	}
+	event, err := adminEvent(ctx, change.eventType, user, actorID, change.reason)
+	if err != nil {
+		return err
+	}
+	return s.userRepository.UpdateStatus(ctx, userID, change.to, event)
+}
+
+// removeSessions signs the user out of every session and drops their cached identity.
+func (s *adminService) removeSessions(ctx context.Context, userID uuid.UUID) error {
+	if err := s.sessionService.RemoveUserSessions(ctx, userID); err != nil {
+		return err
+	}
+	return s.sessionService.RemoveIdentity(ctx, userID)
+}
+
+// adminEvent returns an event of eventType recording an admin action against user.
+// reason, the explanation given by the admin, is omitted when empty.
+func adminEvent(ctx context.Context, eventType model.EventType, user *model.User, actorID uuid.UUID, reason string) (*model.Event, error) {
+	return model.NewEvent(ctx, user.ID, eventType, &model.AccountActionPayload{
+		ID:       user.ID,
+		Username: user.Username,
+		ActorID:  actorID,
+		Reason:   reason,
+	})
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/identity"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
+)
+
+// AssertionService is an interface for minting identity assertions,
+// short-lived signed tokens identifying the user to services behind the api-gateway.
+type AssertionService interface {
+	Issue(identity *model.Identity) (string, error)
+	KeySet() *jwt.KeySet
+}
+
+type assertionService struct {
+	signer *jwt.Signer
+	config config.Assertion
+}
+
+// NewAssertionService creates a new AssertionService signing assertions with the given signer.
+func NewAssertionService(signer *jwt.Signer, config config.Assertion) AssertionService {
+	return &assertionService{
+		signer,
+		config,
+	}
+}
+
+// Issue returns a signed assertion of the identity, valid for the configured TTL.
+func (s *assertionService) Issue(ident *model.Identity) (string, error) {
+	now := time.Now()
+	return s.signer.Sign(&identity.Claims{
+		Claims: jwt.Claims{
+			Issuer:    s.config.Issuer,
+			Subject:   ident.UserID.String(),
+			Audience:  jwt.Audience{s.config.Audience},
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Duration(s.config.TTL) * time.Second).Unix(),
+			ID:        uuid.NewString(),
+		},
+		Username: ident.Username,
+		Roles:    ident.Roles,
+	})
+}
+
+// KeySet returns the public keys used to verify assertions.
+func (s *assertionService) KeySet() *jwt.KeySet {
+	return s.signer.KeySet()
+}
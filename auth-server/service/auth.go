@@ -2,8 +2,9 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"log"
 
+	"github.com/dgyurics/auth/auth-server/config"
 	"github.com/dgyurics/auth/auth-server/model"
 	"github.com/dgyurics/auth/auth-server/repository"
 	"github.com/google/uuid"
@@ -15,7 +16,7 @@ type AuthService interface {
 	Authenticate(ctx context.Context, user *model.User) error
 	Create(ctx context.Context, user *model.User) error
 	Logout(ctx context.Context, user *model.User) error
-	LogoutAll(ctx context.Context, user *model.User) error
+	LogoutAll(ctx context.Context, user *model.User, sessionIDs []string) error
 	Exists(ctx context.Context, user *model.User) bool // FIXME remove and combine into single transaction with Create
 	Fetch(ctx context.Context, user *model.User) error
 }
@@ -23,16 +24,23 @@ type AuthService interface {
 type authService struct {
 	userRepository  repository.UserRepository
 	eventRepository repository.EventRepository
+	loginMonitor    LoginMonitor // nil when suspicious logins are not detected
+	config          config.Registration
 }
 
 // NewAuthService creates a new AuthService with the given user + event repositories.
+// Logins are checked for suspicious activity by loginMonitor, which may be nil.
 func NewAuthService(
 	userRepository repository.UserRepository,
 	eventRepository repository.EventRepository,
+	loginMonitor LoginMonitor,
+	config config.Registration,
 ) AuthService {
 	return &authService{
 		userRepository,
 		eventRepository,
+		loginMonitor,
+		config,
 	}
 }
 
@@ -42,7 +50,8 @@ func (s *authService) Exists(ctx context.Context, user *model.User) bool {
 
 // Create creates a new user with a unique UUID and a bcrypt-hashed password.
 // The new user is stored in the underlying user repository, and the user's ID and password
-// are updated with the new values.
+// are updated with the new values. The user is pending_verification when registrations
+// must be verified, and active otherwise.
 //
 // Returns an error if there is an issue generating the password hash or creating the user in the repository.
 func (s *authService) Create(ctx context.Context, user *model.User) error {
@@ -54,6 +63,10 @@ func (s *authService) Create(ctx context.Context, user *model.User) error {
 	}
 	user.ID = uuid.New()
 	user.Password = string(hashedPass)
+	user.Status = model.UserActive
+	if s.config.Verification {
+		user.Status = model.UserPendingVerification
+	}
 	return s.userRepository.CreateUser(ctx, user)
 }
 
@@ -61,7 +74,9 @@ func (s *authService) Create(ctx context.Context, user *model.User) error {
 // to the provided password hash using the bcrypt algorithm. If the hashes match, the user's ID is set and
 // the LoginSuccess method is called on the underlying user repository.
 //
-// Returns an error if the user cannot be retrieved or the password hashes do not match.
+// Returns an error if the user cannot be retrieved or the password hashes do not match,
+// and a model.ForbiddenError if the password matches but the status of the user does not allow signing in.
+// A suspicious login is alerted to the user, but still succeeds.
 func (s *authService) Authenticate(ctx context.Context, user *model.User) error {
 	userCpy := *user
 	if err := s.userRepository.GetUser(ctx, &userCpy); err != nil {
@@ -70,44 +85,50 @@ func (s *authService) Authenticate(ctx context.Context, user *model.User) error
 	if err := bcrypt.CompareHashAndPassword([]byte(userCpy.Password), []byte(user.Password)); err != nil {
 		return err
 	}
+	if err := userCpy.Status.Err(); err != nil {
+		return err
+	}
 	user.ID = userCpy.ID
+	user.Status = userCpy.Status
+	if s.loginMonitor != nil {
+		if _, err := s.loginMonitor.Check(ctx, user); err != nil {
+			log.Printf("failed to check login of user %s: %s", user.ID, err)
+		}
+	}
 
-	// stringify user for event body
-	userEncoded, err := json.Marshal(model.OmitPassword(user))
+	event, err := model.NewEvent(ctx, user.ID, model.LoggedIn, &model.LoginPayload{
+		ID:       user.ID,
+		Username: user.Username,
+	})
 	if err != nil {
 		return err
 	}
-	return s.eventRepository.CreateEvent(ctx, &model.Event{
-		UUID: user.ID,
-		Type: model.LoggedIn,
-		Body: userEncoded,
-	})
+	return s.eventRepository.CreateEvent(ctx, event)
 }
 
+// Logout records the user signing out of the session carried by the EventContext of ctx.
 func (s *authService) Logout(ctx context.Context, user *model.User) error {
-	// stringify user for event body
-	userEncoded, err := json.Marshal(model.OmitPassword(user))
+	event, err := model.NewEvent(ctx, user.ID, model.LoggedOut, &model.UserPayload{
+		ID:       user.ID,
+		Username: user.Username,
+	})
 	if err != nil {
 		return err
 	}
-	return s.eventRepository.CreateEvent(ctx, &model.Event{
-		UUID: user.ID,
-		Type: model.LoggedOut,
-		Body: userEncoded,
-	})
+	return s.eventRepository.CreateEvent(ctx, event)
 }
 
-func (s *authService) LogoutAll(ctx context.Context, user *model.User) error {
-	// stringify user for event body
-	userEncoded, err := json.Marshal(model.OmitPassword(user))
+// LogoutAll records the user signing out of every session, whose hashes are sessionIDs.
+func (s *authService) LogoutAll(ctx context.Context, user *model.User, sessionIDs []string) error {
+	event, err := model.NewEvent(ctx, user.ID, model.LoggedOutAll, &model.LogoutAllPayload{
+		ID:         user.ID,
+		Username:   user.Username,
+		SessionIDs: sessionIDs,
+	})
 	if err != nil {
 		return err
 	}
-	return s.eventRepository.CreateEvent(ctx, &model.Event{
-		UUID: user.ID,
-		Type: model.LoggedOutAll,
-		Body: userEncoded,
-	})
+	return s.eventRepository.CreateEvent(ctx, event)
 }
 
 func (s *authService) Fetch(ctx context.Context, user *model.User) error {
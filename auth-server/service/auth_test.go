@@ -2,8 +2,10 @@ package service
 
 import (
 	"context"
+	"errors"
 	"testing"
 
+	"github.com/dgyurics/auth/auth-server/config"
 	"github.com/dgyurics/auth/auth-server/model"
 	repo "github.com/dgyurics/auth/auth-server/repository"
 	"github.com/stretchr/testify/require"
@@ -15,8 +17,10 @@ func TestAuthServiceSuite(t *testing.T) {
 
 	t.Run("TestCreate", suite.TestCreate)
 	t.Run("TestCreateUserAlreadyExists", suite.TestCreateUserAlreadyExists)
+	t.Run("TestCreatePendingVerification", suite.TestCreatePendingVerification)
 	t.Run("Login", suite.TestLogin)
 	t.Run("LoginUserNotExist", suite.TestLoginUserNotExist)
+	t.Run("LoginInactiveUser", suite.TestLoginInactiveUser)
 	t.Run("Logout", suite.TestLogout)
 }
 
@@ -33,7 +37,7 @@ func (suite *AuthServiceTestSuite) Setup() {
 	suite.eventRepo = &repo.MockEventRepository{
 		Events: []*model.Event{},
 	}
-	suite.service = NewAuthService(suite.userRepo, suite.eventRepo)
+	suite.service = NewAuthService(suite.userRepo, suite.eventRepo, nil, config.Registration{})
 }
 
 func (suite *AuthServiceTestSuite) TestCreate(t *testing.T) {
@@ -71,6 +75,31 @@ func (suite *AuthServiceTestSuite) TestCreateUserAlreadyExists(t *testing.T) {
 	require.Error(t, err)
 }
 
+func (suite *AuthServiceTestSuite) TestCreatePendingVerification(t *testing.T) {
+	user := model.User{
+		Username: repo.GenerateUniqueUsername(),
+		Password: "test",
+	}
+	require.NoError(t, suite.service.Create(context.Background(), &user))
+	require.Equal(t, model.UserActive, user.Status)
+
+	// new users cannot sign in until verified when registrations must be verified
+	service := NewAuthService(suite.userRepo, suite.eventRepo, nil, config.Registration{Verification: true})
+	pending := model.User{
+		Username: repo.GenerateUniqueUsername(),
+		Password: "test",
+	}
+	require.NoError(t, service.Create(context.Background(), &pending))
+	require.Equal(t, model.UserPendingVerification, pending.Status)
+
+	err := service.Authenticate(context.Background(), &model.User{
+		Username: pending.Username,
+		Password: "test",
+	})
+	var forbidden *model.ForbiddenError
+	require.ErrorAs(t, err, &forbidden)
+}
+
 func (suite *AuthServiceTestSuite) TestLogin(t *testing.T) {
 	username := repo.GenerateUniqueUsername()
 	password := "pw1234"
@@ -97,6 +126,33 @@ func (suite *AuthServiceTestSuite) TestLoginUserNotExist(t *testing.T) {
 	require.Error(t, err)
 }
 
+func (suite *AuthServiceTestSuite) TestLoginInactiveUser(t *testing.T) {
+	user := model.User{
+		Username: repo.GenerateUniqueUsername(),
+		Password: "test",
+	}
+	require.NoError(t, suite.service.Create(context.Background(), &user))
+
+	for _, status := range []model.UserStatus{model.UserLocked, model.UserDisabled, model.UserPendingVerification} {
+		require.NoError(t, suite.userRepo.UpdateStatus(context.Background(), user.ID, status, &model.Event{}))
+		err := suite.service.Authenticate(context.Background(), &model.User{
+			Username: user.Username,
+			Password: "test",
+		})
+		var forbidden *model.ForbiddenError
+		require.ErrorAs(t, err, &forbidden)
+	}
+
+	// the status is only revealed to users who know the password
+	err := suite.service.Authenticate(context.Background(), &model.User{
+		Username: user.Username,
+		Password: "wrong",
+	})
+	var forbidden *model.ForbiddenError
+	require.Error(t, err)
+	require.False(t, errors.As(err, &forbidden))
+}
+
 func (suite *AuthServiceTestSuite) TestLogout(t *testing.T) {
 	user := model.User{
 		Username: repo.GenerateUniqueUsername(),
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/alert"
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/geo"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+)
+
+// Prefix lengths of the networks logins are compared by, so the addresses assigned to
+// a household or mobile device over time belong to the same network.
+const (
+	ipv4NetworkBits = 24
+	ipv6NetworkBits = 48
+)
+
+// minTravelDistance is the distance in kilometers below which logins are never impossible
+// travel, as the accuracy of geolocation is too low to tell.
+const minTravelDistance = 100
+
+// alertTimeout bounds the time spent alerting a user to a suspicious login.
+const alertTimeout = 30 * time.Second
+
+// LoginMonitor is an interface for detecting suspicious logins.
+type LoginMonitor interface {
+	// Check compares the login of user, described by the EventContext of ctx, against their
+	// previous logins. A suspicious login is recorded as an event, and the user is alerted
+	// in the background, so the login is not held up by the notifier.
+	// Returns the alert, or nil when the login is not suspicious.
+	Check(ctx context.Context, user *model.User) (*model.LoginAlert, error)
+	// Stop waits for the alerts in progress to be sent.
+	Stop()
+}
+
+type loginMonitor struct {
+	eventRepository     repository.EventRepository
+	notificationService NotificationService
+	notifier            alert.Notifier // nil when users are only alerted through their websocket connections
+	locator             geo.Locator    // nil when impossible travel is not detected
+	config              config.SuspiciousLogin
+	alerts              sync.WaitGroup
+}
+
+// NewLoginMonitor creates a new LoginMonitor comparing logins against the events recorded in
+// eventRepository, alerting users through notificationService and notifier. The locations of
+// logins are compared with locator. notifier and locator may be nil.
+func NewLoginMonitor(
+	eventRepository repository.EventRepository,
+	notificationService NotificationService,
+	notifier alert.Notifier,
+	locator geo.Locator,
+	config config.SuspiciousLogin,
+) LoginMonitor {
+	return &loginMonitor{
+		eventRepository:     eventRepository,
+		notificationService: notificationService,
+		notifier:            notifier,
+		locator:             locator,
+		config:              config,
+	}
+}
+
+// Check flags the login as a new device when no previous login had the same user agent, as a new
+// network when none came from the same network, and as impossible travel when the previous login
+// was further away than could have been travelled since. The first login of a user, and logins
+// not made through a request, are never suspicious.
+func (m *loginMonitor) Check(ctx context.Context, user *model.User) (*model.LoginAlert, error) {
+	login := model.EventContextFrom(ctx)
+	if login == nil || net.ParseIP(login.IP) == nil {
+		return nil, nil
+	}
+	events, err := m.eventRepository.GetEvents(ctx, &model.EventFilter{
+		UUID:  user.ID,
+		Types: []model.EventType{model.LoggedIn},
+		Limit: m.config.History,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// logins recorded before their context was, have nothing to compare against
+	history := make([]*model.Event, 0, len(events))
+	for _, event := range events {
+		if event.Context != nil && net.ParseIP(event.Context.IP) != nil {
+			history = append(history, event)
+		}
+	}
+	if len(history) == 0 {
+		return nil, nil
+	}
+
+	alert := &model.LoginAlert{IP: login.IP, UserAgent: login.UserAgent}
+	if !seenDevice(history, login.UserAgent) {
+		alert.Risks = append(alert.Risks, model.NewDevice)
+	}
+	if !seenNetwork(history, net.ParseIP(login.IP)) {
+		alert.Risks = append(alert.Risks, model.NewNetwork)
+	}
+	if m.locator != nil {
+		if err := m.checkTravel(alert, history[0]); err != nil {
+			log.Printf("failed to locate login: %s", err)
+		}
+	}
+	if len(alert.Risks) == 0 {
+		return nil, nil
+	}
+
+	event, err := model.NewEvent(ctx, user.ID, model.SuspiciousLogin, &model.SuspiciousLoginPayload{
+		ID:         user.ID,
+		Username:   user.Username,
+		LoginAlert: *alert,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := m.eventRepository.CreateEvent(ctx, event); err != nil {
+		return nil, err
+	}
+	// the alert outlives the request, which is cancelled once the login is answered
+	m.alerts.Add(1)
+	go func() {
+		defer m.alerts.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+		defer cancel()
+		m.alert(ctx, user, alert)
+	}()
+	return alert, nil
+}
+
+func (m *loginMonitor) Stop() {
+	m.alerts.Wait()
+}
+
+// checkTravel locates the login of alert and the previous login, flagging the login as
+// impossible travel when the speed required to travel between them exceeds the maximum.
+func (m *loginMonitor) checkTravel(alert *model.LoginAlert, previous *model.Event) error {
+	location, err := m.locator.Locate(net.ParseIP(alert.IP))
+	if err != nil || location == nil {
+		return err
+	}
+	alert.Location = location
+	previousLocation, err := m.locator.Locate(net.ParseIP(previous.Context.IP))
+	if err != nil || previousLocation == nil {
+		return err
+	}
+	alert.PreviousLocation = previousLocation
+
+	distance := geo.Distance(previousLocation, location)
+	// logins within a minute of each other are compared as a minute apart
+	hours := time.Since(previous.CreatedAt).Hours()
+	if hours < 1.0/60 {
+		hours = 1.0 / 60
+	}
+	if distance > minTravelDistance && distance/hours > float64(m.config.MaxSpeed) {
+		alert.Risks = append(alert.Risks, model.ImpossibleTravel)
+	}
+	return nil
+}
+
+// alert alerts the user to the suspicious login. Failures are logged, as the login is recorded regardless.
+func (m *loginMonitor) alert(ctx context.Context, user *model.User, alert *model.LoginAlert) {
+	if err := m.notificationService.Notify(ctx, user.ID, &model.Notification{
+		Type:  model.SuspiciousLogin,
+		Login: alert,
+	}); err != nil {
+		log.Printf("failed to notify user %s: %s", user.ID, err)
+	}
+	if m.notifier == nil {
+		return
+	}
+	if err := m.notifier.Notify(ctx, user, alert); err != nil {
+		log.Printf("failed to alert user %s: %s", user.ID, err)
+	}
+}
+
+// seenDevice reports whether one of the logins was made by userAgent.
+func seenDevice(logins []*model.Event, userAgent string) bool {
+	for _, login := range logins {
+		if login.Context.UserAgent == userAgent {
+			return true
+		}
+	}
+	return false
+}
+
+// seenNetwork reports whether one of the logins came from the network of ip.
+func seenNetwork(logins []*model.Event, ip net.IP) bool {
+	network := networkOf(ip)
+	for _, login := range logins {
+		if network.Contains(net.ParseIP(login.Context.IP)) {
+			return true
+		}
+	}
+	return false
+}
+
+// networkOf returns the network logins from ip are compared by.
+func networkOf(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(ipv4NetworkBits, 8*net.IPv4len)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+	mask := net.CIDRMask(ipv6NetworkBits, 8*net.IPv6len)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/cache"
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/model"
+	repo "github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginMonitorSuite(t *testing.T) {
+	suite := &LoginMonitorTestSuite{}
+
+	t.Run("FirstLogin", suite.TestFirstLogin)
+	t.Run("KnownLogin", suite.TestKnownLogin)
+	t.Run("NewDevice", suite.TestNewDevice)
+	t.Run("NewNetwork", suite.TestNewNetwork)
+	t.Run("ImpossibleTravel", suite.TestImpossibleTravel)
+	t.Run("NoContext", suite.TestNoContext)
+	t.Run("AlertInBackground", suite.TestAlertInBackground)
+	t.Run("Authenticate", suite.TestAuthenticate)
+}
+
+type LoginMonitorTestSuite struct {
+	eventRepo     *repo.MockEventRepository
+	notifications NotificationService
+	notifier      *mockNotifier
+	locator       mockLocator
+	monitor       LoginMonitor
+	user          *model.User
+}
+
+// mockLocator locates the addresses it maps, and no others.
+type mockLocator map[string]*model.Location
+
+func (l mockLocator) Locate(ip net.IP) (*model.Location, error) {
+	return l[ip.String()], nil
+}
+
+func (l mockLocator) Close() error {
+	return nil
+}
+
+// mockNotifier records the alerts it is notified of. When release is set,
+// alerts are held until it is closed, and the context is checked once released.
+type mockNotifier struct {
+	alerts  []*model.LoginAlert
+	release chan struct{}
+}
+
+func (n *mockNotifier) Notify(ctx context.Context, _ *model.User, alert *model.LoginAlert) error {
+	if n.release != nil {
+		<-n.release
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func (n *mockNotifier) Close() error {
+	return nil
+}
+
+// Setup resets the suite, with the user having signed in from 192.0.2.10 in Amsterdam by Firefox an hour ago.
+func (suite *LoginMonitorTestSuite) Setup() {
+	suite.eventRepo = &repo.MockEventRepository{Events: []*model.Event{}}
+	suite.notifications = NewNotificationService(&cache.MockPubSub{})
+	suite.notifier = &mockNotifier{}
+	suite.locator = mockLocator{
+		"192.0.2.10":   {Country: "NL", City: "Amsterdam", Latitude: 52.37, Longitude: 4.89},
+		"192.0.2.20":   {Country: "NL", City: "Amsterdam", Latitude: 52.37, Longitude: 4.89},
+		"198.51.100.1": {Country: "NL", City: "Rotterdam", Latitude: 51.92, Longitude: 4.48},
+		"203.0.113.1":  {Country: "JP", City: "Tokyo", Latitude: 35.68, Longitude: 139.69},
+	}
+	suite.monitor = NewLoginMonitor(suite.eventRepo, suite.notifications, suite.notifier, suite.locator, config.SuspiciousLogin{
+		Enabled:  true,
+		History:  100,
+		MaxSpeed: 1000,
+	})
+	suite.user = &model.User{ID: uuid.New(), Username: repo.GenerateUniqueUsername()}
+	suite.login("192.0.2.10", "Firefox", time.Now().Add(-time.Hour))
+}
+
+// login records a login of the user from ip by userAgent at createdAt.
+func (suite *LoginMonitorTestSuite) login(ip, userAgent string, createdAt time.Time) {
+	event, _ := model.NewEvent(suite.context(ip, userAgent), suite.user.ID, model.LoggedIn, &model.LoginPayload{
+		ID:       suite.user.ID,
+		Username: suite.user.Username,
+	})
+	_ = suite.eventRepo.CreateEvent(context.Background(), event)
+	event.CreatedAt = createdAt
+}
+
+func (suite *LoginMonitorTestSuite) context(ip, userAgent string) context.Context {
+	return model.WithEventContext(context.Background(), &model.EventContext{IP: ip, UserAgent: userAgent})
+}
+
+// suspiciousLogins returns the suspicious_login events recorded.
+func (suite *LoginMonitorTestSuite) suspiciousLogins() []*model.Event {
+	events, _ := suite.eventRepo.GetEvents(context.Background(), &model.EventFilter{
+		UUID:  suite.user.ID,
+		Types: []model.EventType{model.SuspiciousLogin},
+		Limit: 10,
+	})
+	return events
+}
+
+func (suite *LoginMonitorTestSuite) TestFirstLogin(t *testing.T) {
+	suite.Setup()
+	suite.eventRepo.Events = []*model.Event{}
+
+	alert, err := suite.monitor.Check(suite.context("203.0.113.1", "Chrome"), suite.user)
+	require.NoError(t, err)
+	require.Nil(t, alert)
+	require.Empty(t, suite.suspiciousLogins())
+}
+
+func (suite *LoginMonitorTestSuite) TestKnownLogin(t *testing.T) {
+	suite.Setup()
+
+	// another address of the same network, in the same city
+	alert, err := suite.monitor.Check(suite.context("192.0.2.20", "Firefox"), suite.user)
+	require.NoError(t, err)
+	require.Nil(t, alert)
+	require.Empty(t, suite.suspiciousLogins())
+	require.Empty(t, suite.notifier.alerts)
+}
+
+func (suite *LoginMonitorTestSuite) TestNewDevice(t *testing.T) {
+	suite.Setup()
+	notifications, unsubscribe := suite.notifications.Subscribe(context.Background(), suite.user.ID)
+	defer unsubscribe() // nolint:errcheck
+
+	alert, err := suite.monitor.Check(suite.context("192.0.2.20", "Chrome"), suite.user)
+	require.NoError(t, err)
+	require.Equal(t, []model.LoginRisk{model.NewDevice}, alert.Risks)
+	require.Equal(t, "Chrome", alert.UserAgent)
+
+	// the login is recorded
+	events := suite.suspiciousLogins()
+	require.Len(t, events, 1)
+	payload, err := events[0].Payload()
+	require.NoError(t, err)
+	require.Equal(t, []model.LoginRisk{model.NewDevice}, payload.(*model.SuspiciousLoginPayload).Risks)
+	require.Equal(t, "192.0.2.20", events[0].Context.IP)
+
+	// and the user alerted through their websocket and the notifier
+	var notification model.Notification
+	require.NoError(t, json.Unmarshal([]byte(<-notifications), &notification))
+	require.Equal(t, model.SuspiciousLogin, notification.Type)
+	require.Equal(t, alert.Risks, notification.Login.Risks)
+	suite.monitor.Stop()
+	require.Len(t, suite.notifier.alerts, 1)
+}
+
+func (suite *LoginMonitorTestSuite) TestNewNetwork(t *testing.T) {
+	suite.Setup()
+
+	// too close to the previous login to be impossible travel
+	alert, err := suite.monitor.Check(suite.context("198.51.100.1", "Firefox"), suite.user)
+	require.NoError(t, err)
+	require.Equal(t, []model.LoginRisk{model.NewNetwork}, alert.Risks)
+	require.Equal(t, "Rotterdam", alert.Location.City)
+	require.Equal(t, "Amsterdam", alert.PreviousLocation.City)
+}
+
+func (suite *LoginMonitorTestSuite) TestImpossibleTravel(t *testing.T) {
+	suite.Setup()
+
+	alert, err := suite.monitor.Check(suite.context("203.0.113.1", "Firefox"), suite.user)
+	require.NoError(t, err)
+	require.Equal(t, []model.LoginRisk{model.NewNetwork, model.ImpossibleTravel}, alert.Risks)
+	require.Equal(t, "Tokyo", alert.Location.City)
+
+	// a day is long enough to fly from Amsterdam to Tokyo
+	suite.Setup()
+	suite.eventRepo.Events[0].CreatedAt = time.Now().Add(-24 * time.Hour)
+	alert, err = suite.monitor.Check(suite.context("203.0.113.1", "Firefox"), suite.user)
+	require.NoError(t, err)
+	require.Equal(t, []model.LoginRisk{model.NewNetwork}, alert.Risks)
+}
+
+func (suite *LoginMonitorTestSuite) TestNoContext(t *testing.T) {
+	suite.Setup()
+
+	alert, err := suite.monitor.Check(context.Background(), suite.user)
+	require.NoError(t, err)
+	require.Nil(t, alert)
+
+	// logins recorded without a context are not compared against
+	suite.eventRepo.Events[0].Context = nil
+	alert, err = suite.monitor.Check(suite.context("203.0.113.1", "Chrome"), suite.user)
+	require.NoError(t, err)
+	require.Nil(t, alert)
+}
+
+func (suite *LoginMonitorTestSuite) TestAlertInBackground(t *testing.T) {
+	suite.Setup()
+	suite.notifier.release = make(chan struct{})
+
+	// the login is answered while the notifier is still sending the alert,
+	// which is not cancelled along with the request
+	ctx, cancel := context.WithCancel(suite.context("192.0.2.20", "Chrome"))
+	alert, err := suite.monitor.Check(ctx, suite.user)
+	require.NoError(t, err)
+	require.NotNil(t, alert)
+	cancel()
+	require.Empty(t, suite.notifier.alerts)
+
+	close(suite.notifier.release)
+	suite.monitor.Stop()
+	require.Len(t, suite.notifier.alerts, 1)
+}
+
+func (suite *LoginMonitorTestSuite) TestAuthenticate(t *testing.T) {
+	suite.Setup()
+	userRepo := &repo.MockUserRepository{Users: []*model.User{}}
+	authService := NewAuthService(userRepo, suite.eventRepo, suite.monitor, config.Registration{})
+	user := &model.User{Username: suite.user.Username, Password: "pw1234"}
+	require.NoError(t, authService.Create(context.Background(), user))
+	suite.user = user
+	suite.login("192.0.2.10", "Firefox", time.Now().Add(-time.Hour))
+
+	// a suspicious login still succeeds
+	user = &model.User{Username: suite.user.Username, Password: "pw1234"}
+	require.NoError(t, authService.Authenticate(suite.context("192.0.2.10", "Chrome"), user))
+	require.Len(t, suite.suspiciousLogins(), 1)
+	events, err := suite.eventRepo.GetEvents(context.Background(), &model.EventFilter{UUID: user.ID, Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, model.LoggedIn, events[0].Type)
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/dgyurics/auth/auth-server/cache"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/google/uuid"
+)
+
+// NotificationService is an interface for pushing notifications to the websocket connections
+// of a user, whichever instance of the server they are connected to.
+type NotificationService interface {
+	Notify(ctx context.Context, userID uuid.UUID, notification *model.Notification) error
+	Subscribe(ctx context.Context, userID uuid.UUID) (<-chan string, func() error)
+}
+
+type notificationService struct {
+	pubSub cache.PubSub
+}
+
+// NewNotificationService creates a new NotificationService delivering notifications through pubSub.
+func NewNotificationService(pubSub cache.PubSub) NotificationService {
+	return &notificationService{
+		pubSub,
+	}
+}
+
+// Notify publishes the notification to the subscribers of the user.
+func (s *notificationService) Notify(ctx context.Context, userID uuid.UUID, notification *model.Notification) error {
+	message, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	return s.pubSub.Publish(ctx, notificationChannel(userID), string(message))
+}
+
+// Subscribe returns the JSON encoded notifications of the user, and a function which unsubscribes.
+func (s *notificationService) Subscribe(ctx context.Context, userID uuid.UUID) (<-chan string, func() error) {
+	return s.pubSub.Subscribe(ctx, notificationChannel(userID))
+}
+
+func notificationChannel(userID uuid.UUID) string {
+	return "notifications:" + userID.String()
+}
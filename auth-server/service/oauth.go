@@ -0,0 +1,589 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/cache"
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// OAuthService is an interface for OAuth 2.0 authorization server operations,
+// implementing the authorization code grant with PKCE (RFC 6749, RFC 7636),
+// token revocation (RFC 7009), token introspection (RFC 7662) and OpenID Connect.
+type OAuthService interface {
+	ValidateAuthorization(ctx context.Context, req *model.AuthorizationRequest) (*model.OAuthClient, error)
+	Consented(ctx context.Context, userID uuid.UUID, req *model.AuthorizationRequest) (bool, error)
+	Consent(ctx context.Context, userID uuid.UUID, req *model.AuthorizationRequest) error
+	RequestLogin(ctx context.Context, sessionID string) (string, error)
+	LoggedIn(ctx context.Context, marker string, sessionID string, authTime time.Time, redeem bool) (bool, error)
+	IssueCode(ctx context.Context, userID uuid.UUID, authTime time.Time, req *model.AuthorizationRequest) (string, error)
+	AuthenticateClient(ctx context.Context, clientID string, secret string) (*model.OAuthClient, error)
+	ExchangeCode(ctx context.Context, client *model.OAuthClient, code string, redirectURI string, codeVerifier string) (*model.TokenResponse, error)
+	Refresh(ctx context.Context, client *model.OAuthClient, refreshToken string, scopes []string) (*model.TokenResponse, error)
+	Revoke(ctx context.Context, client *model.OAuthClient, token string) error
+	Introspect(ctx context.Context, token string) (*model.Introspection, error)
+	UserInfo(ctx context.Context, accessToken string) (*model.UserInfo, error)
+}
+
+// IDTokenClaims are the claims of an OpenID Connect ID token.
+type IDTokenClaims struct {
+	jwt.Claims
+	AuthTime          int64  `json:"auth_time,omitempty"`
+	Nonce             string `json:"nonce,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+type oauthService struct {
+	oauthRepository repository.OAuthRepository
+	userRepository  repository.UserRepository
+	eventRepository repository.EventRepository
+	cache           cache.SessionCache
+	signer          *jwt.Signer
+	config          config.OAuth
+}
+
+// NewOAuthService creates a new OAuthService. Clients, consents and refresh tokens are stored
+// in the database, while short-lived authorization codes and access tokens are stored in cache.
+// ID tokens are signed with signer.
+func NewOAuthService(
+	oauthRepository repository.OAuthRepository,
+	userRepository repository.UserRepository,
+	eventRepository repository.EventRepository,
+	cache cache.SessionCache,
+	signer *jwt.Signer,
+	config config.OAuth,
+) OAuthService {
+	return &oauthService{
+		oauthRepository,
+		userRepository,
+		eventRepository,
+		cache,
+		signer,
+		config,
+	}
+}
+
+// ValidateAuthorization validates an authorization request and returns the requesting client.
+// A model.ValidationError is returned when the client or redirect URI is invalid, in which case
+// the user must not be redirected. Other errors are returned as a model.OAuthError, which is
+// reported to the client by redirecting the user.
+func (s *oauthService) ValidateAuthorization(ctx context.Context, req *model.AuthorizationRequest) (*model.OAuthClient, error) {
+	if req.ClientID == "" {
+		return nil, &model.ValidationError{Field: "client_id", Message: "client_id is required"}
+	}
+	client, err := s.oauthRepository.GetClient(ctx, req.ClientID)
+	var notFound *model.NotFoundError
+	if errors.As(err, &notFound) {
+		return nil, &model.ValidationError{Field: "client_id", Message: "unknown client"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// redirect_uri may be omitted when the client registered a single redirect URI
+	if req.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		req.RedirectURI = client.RedirectURIs[0]
+	}
+	if !client.AllowsRedirect(req.RedirectURI) {
+		return nil, &model.ValidationError{Field: "redirect_uri", Message: "redirect_uri is not registered for client"}
+	}
+
+	if req.ResponseType != "code" {
+		return nil, &model.OAuthError{Code: model.UnsupportedResponseType, Description: "response_type must be code"}
+	}
+	if !client.AllowsScopes(req.Scopes) {
+		return nil, &model.OAuthError{Code: model.InvalidScope, Description: "scope exceeds scopes registered for client"}
+	}
+	// PKCE is required of every client, and only the S256 method is accepted
+	if !validCodeVerifier(req.CodeChallenge) {
+		return nil, &model.OAuthError{Code: model.InvalidRequest, Description: "code_challenge is required"}
+	}
+	if req.CodeChallengeMethod != "S256" {
+		return nil, &model.OAuthError{Code: model.InvalidRequest, Description: "code_challenge_method must be S256"}
+	}
+	if req.Prompted("none") && len(req.Prompt) > 1 {
+		return nil, &model.OAuthError{Code: model.InvalidRequest, Description: "prompt none cannot be combined with other values"}
+	}
+	if req.MaxAge != nil && *req.MaxAge < 0 {
+		return nil, &model.OAuthError{Code: model.InvalidRequest, Description: "max_age must not be negative"}
+	}
+	return client, nil
+}
+
+// Consented reports whether the user has previously granted the requested scopes to the client.
+func (s *oauthService) Consented(ctx context.Context, userID uuid.UUID, req *model.AuthorizationRequest) (bool, error) {
+	granted, err := s.oauthRepository.GetConsent(ctx, userID, req.ClientID)
+	if err != nil {
+		return false, err
+	}
+	return granted != nil && model.ContainsScopes(granted, req.Scopes), nil
+}
+
+// Consent records the user granting the requested scopes to the client.
+func (s *oauthService) Consent(ctx context.Context, userID uuid.UUID, req *model.AuthorizationRequest) error {
+	granted, err := s.oauthRepository.GetConsent(ctx, userID, req.ClientID)
+	if err != nil {
+		return err
+	}
+	scopes := append([]string{}, granted...)
+	for _, scope := range req.Scopes {
+		if !model.ContainsScopes(scopes, []string{scope}) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if err := s.oauthRepository.SaveConsent(ctx, userID, req.ClientID, scopes); err != nil {
+		return err
+	}
+
+	event, err := model.NewEvent(ctx, userID, model.ConsentGranted, &model.ConsentPayload{
+		ClientID: req.ClientID,
+		Scopes:   req.Scopes,
+	})
+	if err != nil {
+		return err
+	}
+	return s.eventRepository.CreateEvent(ctx, event)
+}
+
+// loginRequestTTL bounds the time a user may take to log in when asked to authenticate again.
+const loginRequestTTL = 15 * time.Minute
+
+// RequestLogin records that the user, identified by sessionID when they have a session, is sent
+// to log in before the authorization request may continue. The returned marker travels with the
+// request through the login page, and is redeemed by LoggedIn once the user returns.
+func (s *oauthService) RequestLogin(ctx context.Context, sessionID string) (string, error) {
+	marker := generateToken()
+	if marker == "" {
+		return "", errors.New("failed to generate login request")
+	}
+	req := &model.LoginRequest{IssuedAt: time.Now()}
+	if sessionID != "" {
+		req.Session = hashToken(sessionID)
+	}
+	return marker, s.store(ctx, loginRequestKey(marker), req, loginRequestTTL)
+}
+
+// LoggedIn reports whether the user logged in after the login identified by marker was requested.
+// The session in use when it was requested does not qualify, however recent its auth time.
+// Markers satisfy a single authorization: the marker is redeemed when redeem is set, as when a
+// code is about to be issued, and is otherwise kept, as while the user is asked for consent.
+func (s *oauthService) LoggedIn(ctx context.Context, marker string, sessionID string, authTime time.Time, redeem bool) (bool, error) {
+	if marker == "" || sessionID == "" {
+		return false, nil
+	}
+	var (
+		req   model.LoginRequest
+		found bool
+		err   error
+	)
+	if redeem {
+		found, err = s.take(ctx, loginRequestKey(marker), &req)
+	} else {
+		found, err = s.load(ctx, loginRequestKey(marker), &req)
+	}
+	if err != nil || !found {
+		return false, err
+	}
+	if req.Session == hashToken(sessionID) {
+		return false, nil
+	}
+	// auth times are recorded with second precision
+	return authTime.Unix() >= req.IssuedAt.Unix(), nil
+}
+
+// IssueCode issues a single-use authorization code for the validated request.
+// authTime is the time the user authenticated, reported to the client in the ID token.
+func (s *oauthService) IssueCode(ctx context.Context, userID uuid.UUID, authTime time.Time, req *model.AuthorizationRequest) (string, error) {
+	code := generateToken()
+	if code == "" {
+		return "", errors.New("failed to generate authorization code")
+	}
+	ttl := time.Duration(s.config.CodeTTL) * time.Second
+	err := s.store(ctx, codeKey(code), &model.AuthorizationCode{
+		ClientID:      req.ClientID,
+		UserID:        userID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        req.Scopes,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      authTime,
+		ExpiresAt:     time.Now().Add(ttl),
+	}, ttl)
+	return code, err
+}
+
+// AuthenticateClient identifies the client making a request to the token, revocation or
+// introspection endpoint. Confidential clients must authenticate with their secret.
+func (s *oauthService) AuthenticateClient(ctx context.Context, clientID string, secret string) (*model.OAuthClient, error) {
+	invalidClient := &model.OAuthError{Code: model.InvalidClient, Description: "client authentication failed"}
+	if clientID == "" {
+		return nil, invalidClient
+	}
+	client, err := s.oauthRepository.GetClient(ctx, clientID)
+	var notFound *model.NotFoundError
+	if errors.As(err, &notFound) {
+		return nil, invalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	if client.Public() {
+		if secret != "" {
+			return nil, invalidClient
+		}
+		return client, nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(client.Secret), []byte(secret)); err != nil {
+		return nil, invalidClient
+	}
+	return client, nil
+}
+
+// ExchangeCode redeems an authorization code for an access and refresh token.
+func (s *oauthService) ExchangeCode(ctx context.Context, client *model.OAuthClient, code string, redirectURI string, codeVerifier string) (*model.TokenResponse, error) {
+	invalidGrant := &model.OAuthError{Code: model.InvalidGrant, Description: "invalid authorization code"}
+	if code == "" {
+		return nil, invalidGrant
+	}
+
+	// codes are single-use, remove before validating. Only the caller whose
+	// GETDEL removed the code may redeem it, concurrent requests find nothing.
+	var grant model.AuthorizationCode
+	found, err := s.take(ctx, codeKey(code), &grant)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, invalidGrant
+	}
+
+	if grant.ClientID != client.ID || grant.RedirectURI != redirectURI || time.Now().After(grant.ExpiresAt) {
+		return nil, invalidGrant
+	}
+	if !validCodeVerifier(codeVerifier) || !verifyCodeChallenge(grant.CodeChallenge, codeVerifier) {
+		return nil, &model.OAuthError{Code: model.InvalidGrant, Description: "code_verifier does not match code_challenge"}
+	}
+	res, err := s.issueTokens(ctx, client.ID, grant.UserID, grant.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	if model.ContainsScopes(grant.Scopes, []string{model.ScopeOpenID}) {
+		if res.IDToken, err = s.issueIDToken(ctx, client.ID, &grant); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Refresh redeems a refresh token for a new access and refresh token.
+// Refresh tokens are rotated, the redeemed token is revoked, and only the request revoking it is answered.
+func (s *oauthService) Refresh(ctx context.Context, client *model.OAuthClient, refreshToken string, scopes []string) (*model.TokenResponse, error) {
+	invalidGrant := &model.OAuthError{Code: model.InvalidGrant, Description: "invalid refresh token"}
+	token, err := s.oauthRepository.GetRefreshToken(ctx, hashToken(refreshToken))
+	var notFound *model.NotFoundError
+	if errors.As(err, &notFound) {
+		return nil, invalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.ClientID != client.ID || !token.Active(time.Now()) {
+		return nil, invalidGrant
+	}
+
+	// scope may be narrowed, but never broadened
+	if len(scopes) == 0 {
+		scopes = token.Scopes
+	}
+	if !model.ContainsScopes(token.Scopes, scopes) {
+		return nil, &model.OAuthError{Code: model.InvalidScope, Description: "scope exceeds scope originally granted"}
+	}
+
+	// users who may no longer sign in cannot refresh tokens issued before their status changed
+	user := &model.User{ID: token.UserID}
+	if err := s.userRepository.GetUser(ctx, user); err != nil {
+		if errors.As(err, &notFound) {
+			return nil, invalidGrant
+		}
+		return nil, err
+	}
+	if user.Status.Err() != nil {
+		return nil, invalidGrant
+	}
+
+	// a concurrent request redeeming the same token may have revoked it since it was read
+	if err := s.oauthRepository.RevokeRefreshToken(ctx, token.Hash); err != nil {
+		if errors.As(err, &notFound) {
+			return nil, invalidGrant
+		}
+		return nil, err
+	}
+	return s.issueTokens(ctx, client.ID, token.UserID, scopes)
+}
+
+// Revoke revokes an access or refresh token issued to the client.
+// Unknown tokens, and tokens issued to other clients, are ignored as required by RFC 7009.
+func (s *oauthService) Revoke(ctx context.Context, client *model.OAuthClient, token string) error {
+	var access model.AccessToken
+	found, err := s.load(ctx, accessTokenKey(token), &access)
+	if err != nil {
+		return err
+	}
+	if found {
+		if access.ClientID != client.ID {
+			return nil
+		}
+		return s.cache.Del(ctx, accessTokenKey(token))
+	}
+
+	refresh, err := s.oauthRepository.GetRefreshToken(ctx, hashToken(token))
+	var notFound *model.NotFoundError
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if refresh.ClientID != client.ID {
+		return nil
+	}
+	if err := s.oauthRepository.RevokeRefreshToken(ctx, refresh.Hash); err != nil && !errors.As(err, &notFound) {
+		return err
+	}
+	return nil
+}
+
+// Introspect returns the state of an access or refresh token.
+func (s *oauthService) Introspect(ctx context.Context, token string) (*model.Introspection, error) {
+	var access model.AccessToken
+	found, err := s.load(ctx, accessTokenKey(token), &access)
+	if err != nil {
+		return nil, err
+	}
+	if found && time.Now().Before(access.ExpiresAt) {
+		return s.introspection(ctx, "access_token", access.ClientID, access.UserID, access.Scopes, access.IssuedAt, access.ExpiresAt)
+	}
+
+	refresh, err := s.oauthRepository.GetRefreshToken(ctx, hashToken(token))
+	var notFound *model.NotFoundError
+	if errors.As(err, &notFound) {
+		return &model.Introspection{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !refresh.Active(time.Now()) {
+		return &model.Introspection{Active: false}, nil
+	}
+	return s.introspection(ctx, "refresh_token", refresh.ClientID, refresh.UserID, refresh.Scopes, refresh.IssuedAt, refresh.ExpiresAt)
+}
+
+// UserInfo returns the claims about the user the access token was issued for.
+// The access token must have been granted the openid scope.
+func (s *oauthService) UserInfo(ctx context.Context, accessToken string) (*model.UserInfo, error) {
+	var access model.AccessToken
+	found, err := s.load(ctx, accessTokenKey(accessToken), &access)
+	if err != nil {
+		return nil, err
+	}
+	if !found || !time.Now().Before(access.ExpiresAt) {
+		return nil, &model.OAuthError{Code: model.InvalidToken, Description: "access token is invalid or expired"}
+	}
+	if !model.ContainsScopes(access.Scopes, []string{model.ScopeOpenID}) {
+		return nil, &model.OAuthError{Code: model.InsufficientScope, Description: "access token was not granted the openid scope"}
+	}
+
+	user := &model.User{ID: access.UserID}
+	if err := s.userRepository.GetUser(ctx, user); err != nil {
+		var notFound *model.NotFoundError
+		if errors.As(err, &notFound) {
+			return nil, &model.OAuthError{Code: model.InvalidToken, Description: "user no longer exists"}
+		}
+		return nil, err
+	}
+	if user.Status.Err() != nil {
+		return nil, &model.OAuthError{Code: model.InvalidToken, Description: "user may no longer sign in"}
+	}
+	info := &model.UserInfo{Subject: user.ID.String()}
+	if model.ContainsScopes(access.Scopes, []string{model.ScopeProfile}) {
+		info.PreferredUsername = user.Username
+	}
+	return info, nil
+}
+
+// issueIDToken signs an ID token asserting the authentication of the user the grant was issued for.
+// ID tokens share the lifetime of access tokens.
+func (s *oauthService) issueIDToken(ctx context.Context, clientID string, grant *model.AuthorizationCode) (string, error) {
+	user := &model.User{ID: grant.UserID}
+	if err := s.userRepository.GetUser(ctx, user); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := &IDTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    s.config.Issuer,
+			Subject:   user.ID.String(),
+			Audience:  jwt.Audience{clientID},
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Duration(s.config.AccessTokenTTL) * time.Second).Unix(),
+		},
+		Nonce: grant.Nonce,
+	}
+	if !grant.AuthTime.IsZero() {
+		claims.AuthTime = grant.AuthTime.Unix()
+	}
+	if model.ContainsScopes(grant.Scopes, []string{model.ScopeProfile}) {
+		claims.PreferredUsername = user.Username
+	}
+	return s.signer.Sign(claims)
+}
+
+func (s *oauthService) introspection(ctx context.Context, tokenType string, clientID string, userID uuid.UUID, scopes []string, issuedAt, expiresAt time.Time) (*model.Introspection, error) {
+	user := &model.User{ID: userID}
+	if err := s.userRepository.GetUser(ctx, user); err != nil {
+		var notFound *model.NotFoundError
+		if errors.As(err, &notFound) {
+			return &model.Introspection{Active: false}, nil
+		}
+		return nil, err
+	}
+	if user.Status.Err() != nil {
+		return &model.Introspection{Active: false}, nil
+	}
+	return &model.Introspection{
+		Active:    true,
+		Scope:     strings.Join(scopes, " "),
+		ClientID:  clientID,
+		Username:  user.Username,
+		TokenType: tokenType,
+		ExpiresAt: expiresAt.Unix(),
+		IssuedAt:  issuedAt.Unix(),
+		Subject:   userID.String(),
+	}, nil
+}
+
+func (s *oauthService) issueTokens(ctx context.Context, clientID string, userID uuid.UUID, scopes []string) (*model.TokenResponse, error) {
+	accessToken, refreshToken := generateToken(), generateToken()
+	if accessToken == "" || refreshToken == "" {
+		return nil, errors.New("failed to generate token")
+	}
+	now := time.Now()
+	accessTTL := time.Duration(s.config.AccessTokenTTL) * time.Second
+	refreshTTL := time.Duration(s.config.RefreshTokenTTL) * time.Second
+
+	err := s.store(ctx, accessTokenKey(accessToken), &model.AccessToken{
+		ClientID:  clientID,
+		UserID:    userID,
+		Scopes:    scopes,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(accessTTL),
+	}, accessTTL)
+	if err != nil {
+		return nil, err
+	}
+	err = s.oauthRepository.CreateRefreshToken(ctx, &model.RefreshToken{
+		Hash:      hashToken(refreshToken),
+		ClientID:  clientID,
+		UserID:    userID,
+		Scopes:    scopes,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(refreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    s.config.AccessTokenTTL,
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+// store encodes v as JSON and stores it in cache.
+func (s *oauthService) store(ctx context.Context, key string, v interface{}, expiration time.Duration) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.cache.Set(ctx, key, string(value), expiration)
+}
+
+// load decodes the JSON stored in cache into v, reporting whether the key was found.
+func (s *oauthService) load(ctx context.Context, key string, v interface{}) (bool, error) {
+	value, err := s.cache.Get(ctx, key)
+	if errors.Is(err, redis.Nil) || (err == nil && value == "") {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal([]byte(value), v)
+}
+
+// take is load, but atomically removes the key from cache.
+func (s *oauthService) take(ctx context.Context, key string, v interface{}) (bool, error) {
+	value, err := s.cache.GetDel(ctx, key)
+	if errors.Is(err, redis.Nil) || (err == nil && value == "") {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal([]byte(value), v)
+}
+
+// Tokens are stored under the hash of their value, so a leaked cache does not leak usable tokens.
+func codeKey(code string) string {
+	return "oauth:code:" + hashToken(code)
+}
+
+func loginRequestKey(marker string) string {
+	return "oauth:login:" + hashToken(marker)
+}
+
+func accessTokenKey(token string) string {
+	return "oauth:access:" + hashToken(token)
+}
+
+// hashToken returns the hex encoded SHA-256 hash of token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// validCodeVerifier reports whether v has the length and characters required of a
+// code verifier, and of a S256 code challenge, by RFC 7636 section 4.1.
+func validCodeVerifier(v string) bool {
+	if len(v) < 43 || len(v) > 128 {
+		return false
+	}
+	for _, c := range v {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("-._~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyCodeChallenge reports whether the S256 transformation of verifier matches challenge.
+func verifyCodeChallenge(challenge string, verifier string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
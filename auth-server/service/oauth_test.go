@@ -0,0 +1,343 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/cache"
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/model"
+	repo "github.com/dgyurics/auth/auth-server/repository"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func TestOAuthServiceSuite(t *testing.T) {
+	suite := &OAuthServiceTestSuite{}
+	suite.Setup(t)
+
+	t.Run("TestValidateAuthorization", suite.TestValidateAuthorization)
+	t.Run("TestExchangeCode", suite.TestExchangeCode)
+	t.Run("TestExchangeCodeInvalidVerifier", suite.TestExchangeCodeInvalidVerifier)
+	t.Run("TestRefresh", suite.TestRefresh)
+	t.Run("TestRefreshConcurrently", suite.TestRefreshConcurrently)
+	t.Run("TestRevoke", suite.TestRevoke)
+	t.Run("TestAuthenticateClient", suite.TestAuthenticateClient)
+	t.Run("TestIDToken", suite.TestIDToken)
+	t.Run("TestUserInfo", suite.TestUserInfo)
+	t.Run("TestValidatePrompt", suite.TestValidatePrompt)
+}
+
+type OAuthServiceTestSuite struct {
+	oauthRepo *repo.MockOAuthRepository
+	userRepo  repo.UserRepository
+	signer    *jwt.Signer
+	config    config.OAuth
+	service   OAuthService
+	user      *model.User
+}
+
+func (suite *OAuthServiceTestSuite) Setup(t *testing.T) {
+	suite.oauthRepo = repo.NewMockOAuthRepository()
+	suite.userRepo = &repo.MockUserRepository{
+		Users: []*model.User{},
+	}
+	eventRepo := &repo.MockEventRepository{
+		Events: []*model.Event{},
+	}
+	sessionCache := &cache.MockSessionCache{
+		Sessions:    make(map[string]string),
+		SessionsSet: make(map[string]map[string]struct{}),
+	}
+	key, err := jwt.GenerateKey()
+	require.NoError(t, err)
+	suite.signer, err = jwt.NewSigner(key)
+	require.NoError(t, err)
+	suite.config = config.New().OAuth
+	suite.service = NewOAuthService(suite.oauthRepo, suite.userRepo, eventRepo, sessionCache, suite.signer, suite.config)
+
+	secret, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, suite.oauthRepo.CreateClient(context.Background(), &model.OAuthClient{
+		ID:           "confidential",
+		Secret:       string(secret),
+		Name:         "Confidential",
+		RedirectURIs: []string{"https://client.example/callback"},
+		Scopes:       []string{"profile", "email"},
+	}))
+	require.NoError(t, suite.oauthRepo.CreateClient(context.Background(), &model.OAuthClient{
+		ID:           "public",
+		Name:         "Public",
+		RedirectURIs: []string{"https://spa.example/callback"},
+		Scopes:       []string{"openid", "profile"},
+	}))
+
+	suite.user = &model.User{Username: repo.GenerateUniqueUsername(), Password: "test"}
+	require.NoError(t, NewAuthService(suite.userRepo, eventRepo, nil, config.Registration{}).Create(context.Background(), suite.user))
+}
+
+func (suite *OAuthServiceTestSuite) TestValidateAuthorization(t *testing.T) {
+	ctx := context.Background()
+
+	// redirect_uri defaults to the only registered redirect URI
+	req := authorizationRequest("public")
+	req.RedirectURI = ""
+	_, err := suite.service.ValidateAuthorization(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, "https://spa.example/callback", req.RedirectURI)
+
+	// unknown client and redirect URI must not be redirected to
+	_, err = suite.service.ValidateAuthorization(ctx, authorizationRequest("unknown"))
+	require.IsType(t, &model.ValidationError{}, err)
+	req = authorizationRequest("public")
+	req.RedirectURI = "https://attacker.example/callback"
+	_, err = suite.service.ValidateAuthorization(ctx, req)
+	require.IsType(t, &model.ValidationError{}, err)
+
+	// PKCE is required
+	req = authorizationRequest("public")
+	req.CodeChallenge = ""
+	_, err = suite.service.ValidateAuthorization(ctx, req)
+	require.Equal(t, model.InvalidRequest, err.(*model.OAuthError).Code)
+	req = authorizationRequest("public")
+	req.CodeChallengeMethod = "plain"
+	_, err = suite.service.ValidateAuthorization(ctx, req)
+	require.Equal(t, model.InvalidRequest, err.(*model.OAuthError).Code)
+
+	// scope is limited to the scopes registered for the client
+	req = authorizationRequest("public")
+	req.Scopes = []string{"email"}
+	_, err = suite.service.ValidateAuthorization(ctx, req)
+	require.Equal(t, model.InvalidScope, err.(*model.OAuthError).Code)
+}
+
+func (suite *OAuthServiceTestSuite) TestExchangeCode(t *testing.T) {
+	ctx := context.Background()
+	req := authorizationRequest("public")
+	client, err := suite.service.ValidateAuthorization(ctx, req)
+	require.NoError(t, err)
+
+	// consent is remembered
+	consented, err := suite.service.Consented(ctx, suite.user.ID, req)
+	require.NoError(t, err)
+	require.False(t, consented)
+	require.NoError(t, suite.service.Consent(ctx, suite.user.ID, req))
+	consented, err = suite.service.Consented(ctx, suite.user.ID, req)
+	require.NoError(t, err)
+	require.True(t, consented)
+
+	code, err := suite.service.IssueCode(ctx, suite.user.ID, time.Now(), req)
+	require.NoError(t, err)
+	res, err := suite.service.ExchangeCode(ctx, client, code, req.RedirectURI, testCodeVerifier)
+	require.NoError(t, err)
+	require.NotEmpty(t, res.AccessToken)
+	require.NotEmpty(t, res.RefreshToken)
+	require.Equal(t, "Bearer", res.TokenType)
+	require.Equal(t, "profile", res.Scope)
+	require.Empty(t, res.IDToken, "ID token issued without openid scope")
+
+	// codes are single-use
+	_, err = suite.service.ExchangeCode(ctx, client, code, req.RedirectURI, testCodeVerifier)
+	require.Equal(t, model.InvalidGrant, err.(*model.OAuthError).Code)
+
+	introspection, err := suite.service.Introspect(ctx, res.AccessToken)
+	require.NoError(t, err)
+	require.True(t, introspection.Active)
+	require.Equal(t, suite.user.ID.String(), introspection.Subject)
+	require.Equal(t, suite.user.Username, introspection.Username)
+	require.Equal(t, "public", introspection.ClientID)
+}
+
+func (suite *OAuthServiceTestSuite) TestExchangeCodeInvalidVerifier(t *testing.T) {
+	ctx := context.Background()
+	req := authorizationRequest("public")
+	client, err := suite.service.ValidateAuthorization(ctx, req)
+	require.NoError(t, err)
+
+	code, err := suite.service.IssueCode(ctx, suite.user.ID, time.Now(), req)
+	require.NoError(t, err)
+	_, err = suite.service.ExchangeCode(ctx, client, code, req.RedirectURI, "wrong-verifier-wrong-verifier-wrong-verifier")
+	require.Equal(t, model.InvalidGrant, err.(*model.OAuthError).Code)
+
+	// a failed attempt consumes the code
+	_, err = suite.service.ExchangeCode(ctx, client, code, req.RedirectURI, testCodeVerifier)
+	require.Equal(t, model.InvalidGrant, err.(*model.OAuthError).Code)
+}
+
+func (suite *OAuthServiceTestSuite) TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	client, res := suite.tokens(t, "public")
+
+	refreshed, err := suite.service.Refresh(ctx, client, res.RefreshToken, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, res.RefreshToken, refreshed.RefreshToken)
+
+	// refresh tokens are rotated
+	_, err = suite.service.Refresh(ctx, client, res.RefreshToken, nil)
+	require.Equal(t, model.InvalidGrant, err.(*model.OAuthError).Code)
+
+	// scope cannot be broadened
+	_, err = suite.service.Refresh(ctx, client, refreshed.RefreshToken, []string{"profile", "email"})
+	require.Equal(t, model.InvalidScope, err.(*model.OAuthError).Code)
+}
+
+// racingOAuthRepository reads refresh tokens as they were before being revoked,
+// as by a request reading a token while another request redeems it.
+type racingOAuthRepository struct {
+	*repo.MockOAuthRepository
+}
+
+func (r *racingOAuthRepository) GetRefreshToken(ctx context.Context, hash string) (*model.RefreshToken, error) {
+	token, err := r.MockOAuthRepository.GetRefreshToken(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	token.RevokedAt = nil
+	return token, nil
+}
+
+func (suite *OAuthServiceTestSuite) TestRefreshConcurrently(t *testing.T) {
+	ctx := context.Background()
+	client, res := suite.tokens(t, "public")
+	service := NewOAuthService(&racingOAuthRepository{suite.oauthRepo}, suite.userRepo,
+		&repo.MockEventRepository{Events: []*model.Event{}}, &cache.MockSessionCache{
+			Sessions:    make(map[string]string),
+			SessionsSet: make(map[string]map[string]struct{}),
+		}, suite.signer, suite.config)
+
+	// both requests find the token active, but only the first to revoke it is answered
+	_, err := service.Refresh(ctx, client, res.RefreshToken, nil)
+	require.NoError(t, err)
+	_, err = service.Refresh(ctx, client, res.RefreshToken, nil)
+	require.Equal(t, model.InvalidGrant, err.(*model.OAuthError).Code)
+}
+
+func (suite *OAuthServiceTestSuite) TestRevoke(t *testing.T) {
+	ctx := context.Background()
+	client, res := suite.tokens(t, "public")
+
+	require.NoError(t, suite.service.Revoke(ctx, client, res.AccessToken))
+	introspection, err := suite.service.Introspect(ctx, res.AccessToken)
+	require.NoError(t, err)
+	require.False(t, introspection.Active)
+
+	require.NoError(t, suite.service.Revoke(ctx, client, res.RefreshToken))
+	introspection, err = suite.service.Introspect(ctx, res.RefreshToken)
+	require.NoError(t, err)
+	require.False(t, introspection.Active)
+
+	// unknown tokens are ignored
+	require.NoError(t, suite.service.Revoke(ctx, client, "unknown"))
+}
+
+func (suite *OAuthServiceTestSuite) TestAuthenticateClient(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := suite.service.AuthenticateClient(ctx, "confidential", "secret")
+	require.NoError(t, err)
+	_, err = suite.service.AuthenticateClient(ctx, "confidential", "wrong")
+	require.Equal(t, model.InvalidClient, err.(*model.OAuthError).Code)
+	_, err = suite.service.AuthenticateClient(ctx, "confidential", "")
+	require.Equal(t, model.InvalidClient, err.(*model.OAuthError).Code)
+	_, err = suite.service.AuthenticateClient(ctx, "public", "")
+	require.NoError(t, err)
+	_, err = suite.service.AuthenticateClient(ctx, "unknown", "")
+	require.Equal(t, model.InvalidClient, err.(*model.OAuthError).Code)
+}
+
+func (suite *OAuthServiceTestSuite) TestIDToken(t *testing.T) {
+	ctx := context.Background()
+	req := authorizationRequest("public")
+	req.Scopes = []string{"openid", "profile"}
+	req.Nonce = "n-0S6_WzA2Mj"
+	client, err := suite.service.ValidateAuthorization(ctx, req)
+	require.NoError(t, err)
+
+	authTime := time.Now().Add(-time.Minute)
+	code, err := suite.service.IssueCode(ctx, suite.user.ID, authTime, req)
+	require.NoError(t, err)
+	res, err := suite.service.ExchangeCode(ctx, client, code, req.RedirectURI, testCodeVerifier)
+	require.NoError(t, err)
+	require.NotEmpty(t, res.IDToken)
+
+	var claims IDTokenClaims
+	require.NoError(t, jwt.Parse(ctx, res.IDToken, suite.signer.KeySet(), &claims))
+	require.NoError(t, claims.Validate(suite.config.Issuer, "public", time.Now()))
+	require.Equal(t, suite.user.ID.String(), claims.Subject)
+	require.Equal(t, req.Nonce, claims.Nonce)
+	require.Equal(t, authTime.Unix(), claims.AuthTime)
+	require.Equal(t, suite.user.Username, claims.PreferredUsername)
+}
+
+func (suite *OAuthServiceTestSuite) TestUserInfo(t *testing.T) {
+	ctx := context.Background()
+	req := authorizationRequest("public")
+	req.Scopes = []string{"openid"}
+	client, err := suite.service.ValidateAuthorization(ctx, req)
+	require.NoError(t, err)
+	code, err := suite.service.IssueCode(ctx, suite.user.ID, time.Now(), req)
+	require.NoError(t, err)
+	res, err := suite.service.ExchangeCode(ctx, client, code, req.RedirectURI, testCodeVerifier)
+	require.NoError(t, err)
+
+	// username requires the profile scope
+	info, err := suite.service.UserInfo(ctx, res.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, suite.user.ID.String(), info.Subject)
+	require.Empty(t, info.PreferredUsername)
+
+	_, err = suite.service.UserInfo(ctx, "unknown")
+	require.Equal(t, model.InvalidToken, err.(*model.OAuthError).Code)
+
+	// access tokens without the openid scope cannot be used
+	_, res = suite.tokens(t, "public")
+	_, err = suite.service.UserInfo(ctx, res.AccessToken)
+	require.Equal(t, model.InsufficientScope, err.(*model.OAuthError).Code)
+}
+
+func (suite *OAuthServiceTestSuite) TestValidatePrompt(t *testing.T) {
+	ctx := context.Background()
+
+	req := authorizationRequest("public")
+	req.Prompt = []string{"none", "login"}
+	_, err := suite.service.ValidateAuthorization(ctx, req)
+	require.Equal(t, model.InvalidRequest, err.(*model.OAuthError).Code)
+
+	maxAge := -1
+	req = authorizationRequest("public")
+	req.MaxAge = &maxAge
+	_, err = suite.service.ValidateAuthorization(ctx, req)
+	require.Equal(t, model.InvalidRequest, err.(*model.OAuthError).Code)
+}
+
+// tokens runs the authorization code flow for the client, returning the issued tokens
+func (suite *OAuthServiceTestSuite) tokens(t *testing.T, clientID string) (*model.OAuthClient, *model.TokenResponse) {
+	ctx := context.Background()
+	req := authorizationRequest(clientID)
+	client, err := suite.service.ValidateAuthorization(ctx, req)
+	require.NoError(t, err)
+	code, err := suite.service.IssueCode(ctx, suite.user.ID, time.Now(), req)
+	require.NoError(t, err)
+	res, err := suite.service.ExchangeCode(ctx, client, code, req.RedirectURI, testCodeVerifier)
+	require.NoError(t, err)
+	return client, res
+}
+
+func authorizationRequest(clientID string) *model.AuthorizationRequest {
+	sum := sha256.Sum256([]byte(testCodeVerifier))
+	return &model.AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            clientID,
+		RedirectURI:         "https://spa.example/callback",
+		Scopes:              []string{"profile"},
+		State:               "state",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+)
+
+// RoleService is an interface for role based access control operations.
+type RoleService interface {
+	Roles(ctx context.Context) ([]*model.Role, error)
+	UserRoles(ctx context.Context, userID uuid.UUID) ([]*model.Role, error)
+	Identity(ctx context.Context, user *model.User) (*model.Identity, error)
+	Assign(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) error
+	Revoke(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) error
+}
+
+type roleService struct {
+	roleRepository repository.RoleRepository
+	userRepository repository.UserRepository
+}
+
+// NewRoleService creates a new RoleService with the given role + user repositories.
+func NewRoleService(
+	roleRepository repository.RoleRepository,
+	userRepository repository.UserRepository,
+) RoleService {
+	return &roleService{
+		roleRepository,
+		userRepository,
+	}
+}
+
+// Roles returns every role and the permissions it grants.
+func (s *roleService) Roles(ctx context.Context) ([]*model.Role, error) {
+	return s.roleRepository.GetRoles(ctx)
+}
+
+// UserRoles returns the roles assigned to the user.
+func (s *roleService) UserRoles(ctx context.Context, userID uuid.UUID) ([]*model.Role, error) {
+	if err := s.userRepository.GetUser(ctx, &model.User{ID: userID}); err != nil {
+		return nil, err
+	}
+	return s.roleRepository.GetUserRoles(ctx, userID)
+}
+
+// Identity returns the identity of the user, including their roles
+// and the union of the permissions granted by them.
+func (s *roleService) Identity(ctx context.Context, user *model.User) (*model.Identity, error) {
+	roles, err := s.roleRepository.GetUserRoles(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	identity := &model.Identity{UserID: user.ID, Username: user.Username, Status: user.Status}
+	granted := make(map[string]bool)
+	for _, role := range roles {
+		identity.Roles = append(identity.Roles, role.Name)
+		for _, permission := range role.Permissions {
+			if !granted[permission] {
+				granted[permission] = true
+				identity.Permissions = append(identity.Permissions, permission)
+			}
+		}
+	}
+	return identity, nil
+}
+
+// Assign assigns the role to the user on behalf of the actor.
+func (s *roleService) Assign(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) error {
+	if err := s.userRepository.GetUser(ctx, &model.User{ID: userID}); err != nil {
+		return err
+	}
+	return s.roleRepository.AssignRole(ctx, userID, role, actorID)
+}
+
+// Revoke revokes the role from the user on behalf of the actor.
+func (s *roleService) Revoke(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) error {
+	return s.roleRepository.RevokeRole(ctx, userID, role, actorID)
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	repo "github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleIdentity(t *testing.T) {
+	ctx := context.Background()
+	roleRepo := repo.NewMockRoleRepository(&model.Role{
+		Name:        "admin",
+		Permissions: []string{model.PermissionRolesRead, model.PermissionRolesWrite},
+	}, &model.Role{
+		Name:        "auditor",
+		Permissions: []string{model.PermissionRolesRead},
+	})
+	userRepo := &repo.MockUserRepository{Users: []*model.User{}}
+	service := NewRoleService(roleRepo, userRepo)
+
+	user := &model.User{Username: repo.GenerateUniqueUsername(), Password: "password"}
+	require.NoError(t, userRepo.CreateUser(ctx, user))
+
+	identity, err := service.Identity(ctx, user)
+	require.NoError(t, err)
+	require.Empty(t, identity.Roles)
+	require.False(t, identity.HasPermission(model.PermissionRolesRead))
+
+	require.NoError(t, service.Assign(ctx, user.ID, "auditor", uuid.Nil))
+	require.NoError(t, service.Assign(ctx, user.ID, "admin", uuid.Nil))
+	identity, err = service.Identity(ctx, user)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"admin", "auditor"}, identity.Roles)
+	require.ElementsMatch(t, []string{model.PermissionRolesRead, model.PermissionRolesWrite}, identity.Permissions)
+
+	require.NoError(t, service.Revoke(ctx, user.ID, "admin", uuid.Nil))
+	identity, err = service.Identity(ctx, user)
+	require.NoError(t, err)
+	require.True(t, identity.HasPermission(model.PermissionRolesRead))
+	require.False(t, identity.HasPermission(model.PermissionRolesWrite))
+
+	// unknown users cannot be assigned roles
+	var notFound *model.NotFoundError
+	require.ErrorAs(t, service.Assign(ctx, uuid.New(), "admin", uuid.Nil), &notFound)
+}
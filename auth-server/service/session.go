@@ -4,13 +4,18 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/dgyurics/auth/auth-server/cache"
 	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 )
 
@@ -22,6 +27,12 @@ type SessionService interface {
 	FetchAll(ctx context.Context, sessionID string) ([]string, error)
 	Remove(ctx context.Context, cookie *http.Cookie) (*http.Cookie, error)
 	RemoveAll(ctx context.Context, cookie *http.Cookie) (*http.Cookie, error)
+	UserSessions(ctx context.Context, userID uuid.UUID) ([]*model.Session, error)
+	RemoveUserSessions(ctx context.Context, userID uuid.UUID) error
+	FetchIdentity(ctx context.Context, userID uuid.UUID) (*model.Identity, error)
+	CacheIdentity(ctx context.Context, identity *model.Identity) error
+	RemoveIdentity(ctx context.Context, userID uuid.UUID) error
+	AuthTime(ctx context.Context, sessionID string) (time.Time, error)
 }
 
 type sessionService struct {
@@ -56,6 +67,10 @@ func (s *sessionService) Remove(ctx context.Context, cookie *http.Cookie) (*http
 		return nil, err
 	}
 
+	if err := s.sessionCache.Del(ctx, authTimeKey(cookie.Value)); err != nil {
+		return nil, err
+	}
+
 	if err := s.sessionCache.SRem(ctx, userID, cookie.Value); err != nil {
 		return nil, err
 	}
@@ -68,26 +83,64 @@ func (s *sessionService) RemoveAll(ctx context.Context, cookie *http.Cookie) (*h
 	cookie.MaxAge = 0
 	cookie.Expires = time.Now() // workaround since MaxAge 0 not being respected by some tools/browsers
 
-	userID, err := s.sessionCache.Get(ctx, cookie.Value)
+	userID, err := s.Fetch(ctx, cookie.Value)
 	if err != nil {
 		return nil, err
 	}
 
-	sessions, err := s.sessionCache.SMembers(ctx, userID)
-	if err != nil {
+	if err := s.RemoveUserSessions(ctx, userID); err != nil {
 		return nil, err
 	}
 
+	return cookie, nil
+}
+
+// RemoveUserSessions removes all sessions for the user from shared cache,
+// signing the user out everywhere without requiring one of their session cookies.
+func (s *sessionService) RemoveUserSessions(ctx context.Context, userID uuid.UUID) error {
+	sessions, err := s.sessionCache.SMembers(ctx, userID.String())
+	if err != nil {
+		return err
+	}
+
 	for _, session := range sessions {
 		if err := s.sessionCache.Del(ctx, session); err != nil {
-			return nil, err
+			return err
 		}
-		if err := s.sessionCache.SRem(ctx, userID, session); err != nil {
-			return nil, err
+		if err := s.sessionCache.Del(ctx, authTimeKey(session)); err != nil {
+			return err
+		}
+		if err := s.sessionCache.SRem(ctx, userID.String(), session); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return cookie, nil
+// UserSessions returns the active sessions of the user. Session IDs are secret,
+// so the ID of each session is replaced by its hash, and CreatedAt is the auth time.
+func (s *sessionService) UserSessions(ctx context.Context, userID uuid.UUID) ([]*model.Session, error) {
+	sessionIDs, err := s.sessionCache.SMembers(ctx, userID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*model.Session, 0, len(sessionIDs))
+	for _, id := range sessionIDs {
+		if value, err := s.sessionCache.Get(ctx, id); err != nil || value == "" {
+			continue // expired
+		}
+		authTime, err := s.AuthTime(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &model.Session{
+			ID:        hashToken(id),
+			UserID:    userID,
+			CreatedAt: authTime,
+		})
+	}
+	return sessions, nil
 }
 
 func (s *sessionService) Fetch(ctx context.Context, sessionID string) (uuid.UUID, error) {
@@ -145,13 +198,83 @@ func (s *sessionService) Create(ctx context.Context, userID uuid.UUID) (*http.Co
 		return nil, err
 	}
 
+	authTime := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := s.sessionCache.Set(ctx, authTimeKey(sessionID), authTime, expiration); err != nil {
+		return nil, err
+	}
+
 	return s.newCookie(sessionID), nil
 }
 
 // Extend updates the expiration of the session in the session cache and
 func (s *sessionService) Extend(ctx context.Context, userID string, cookie *http.Cookie) (*http.Cookie, error) {
 	s.modifyCookie(cookie)
-	return cookie, s.sessionCache.Set(ctx, cookie.Value, userID, maxAgeToExpiration(s.sessionConfig.MaxAge))
+	expiration := maxAgeToExpiration(s.sessionConfig.MaxAge)
+	if err := s.sessionCache.Set(ctx, cookie.Value, userID, expiration); err != nil {
+		return cookie, err
+	}
+	return cookie, s.sessionCache.Expire(ctx, authTimeKey(cookie.Value), expiration)
+}
+
+// AuthTime returns the time the user authenticated to create the session.
+// The zero time is returned for sessions created before auth times were recorded.
+func (s *sessionService) AuthTime(ctx context.Context, sessionID string) (time.Time, error) {
+	value, err := s.sessionCache.Get(ctx, authTimeKey(sessionID))
+	if errors.Is(err, redis.Nil) || (err == nil && value == "") {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// FetchIdentity returns the cached identity of the user.
+// An error is returned if the identity is not cached, in which case the caller
+// is expected to load the user from the database and call CacheIdentity.
+func (s *sessionService) FetchIdentity(ctx context.Context, userID uuid.UUID) (*model.Identity, error) {
+	value, err := s.sessionCache.Get(ctx, identityKey(userID))
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, errors.New("identity not cached")
+	}
+	var identity model.Identity
+	if err := json.Unmarshal([]byte(value), &identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// CacheIdentity stores the identity of the user for the lifetime of a session.
+func (s *sessionService) CacheIdentity(ctx context.Context, identity *model.Identity) error {
+	value, err := json.Marshal(identity)
+	if err != nil {
+		return err
+	}
+	return s.sessionCache.Set(ctx, identityKey(identity.UserID), string(value), maxAgeToExpiration(s.sessionConfig.MaxAge))
+}
+
+// RemoveIdentity removes the cached identity of the user, forcing it to be reloaded
+// on the next verification. Called when the roles of the user change.
+func (s *sessionService) RemoveIdentity(ctx context.Context, userID uuid.UUID) error {
+	return s.sessionCache.Del(ctx, identityKey(userID))
+}
+
+// identityKey returns the cache key of the user's identity.
+// The prefix prevents collisions with session IDs and the user's set of sessions.
+func identityKey(userID uuid.UUID) string {
+	return "identity:" + userID.String()
+}
+
+// authTimeKey returns the cache key of the time the session was created.
+func authTimeKey(sessionID string) string {
+	return "auth_time:" + sessionID
 }
 
 // base64 encoded 32 byte random string
@@ -161,6 +284,11 @@ func (s *sessionService) Extend(ctx context.Context, userID string, cookie *http
 // to ensure that the encoded output contains a multiple of 4 characters. Thus the length of the encoded
 // string will be 44 characters.
 func generateSessionID() string {
+	return generateToken()
+}
+
+// generateToken returns a base64 encoded 32 byte random string, see generateSessionID.
+func generateToken() string {
 	b := make([]byte, 32)
 	if _, err := io.ReadFull(rand.Reader, b); err != nil {
 		return ""
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/dgyurics/auth/auth-server/cache"
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/oidc"
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// SocialStateTTL is how long a user has to complete login at the upstream provider.
+const SocialStateTTL = 10 * time.Minute
+
+// SocialService is an interface for signing users in with an upstream OpenID Connect provider.
+type SocialService interface {
+	Begin(ctx context.Context, returnTo string) (state string, authURL string, err error)
+	Complete(ctx context.Context, state string, code string, current uuid.UUID) (*model.User, string, error)
+}
+
+// socialState is the state of a login in progress at the upstream provider.
+type socialState struct {
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	ReturnTo     string `json:"return_to"`
+}
+
+type socialService struct {
+	provider           *oidc.Provider
+	authService        AuthService
+	userRepository     repository.UserRepository
+	identityRepository repository.IdentityRepository
+	eventRepository    repository.EventRepository
+	cache              cache.SessionCache
+	usernamePolicy     *model.UsernamePolicy
+	config             config.SocialLogin
+}
+
+// NewSocialService creates a new SocialService signing users in with provider.
+// Users signing in for the first time are registered, and their identity at the provider
+// is linked to the new user.
+func NewSocialService(
+	provider *oidc.Provider,
+	authService AuthService,
+	userRepository repository.UserRepository,
+	identityRepository repository.IdentityRepository,
+	eventRepository repository.EventRepository,
+	cache cache.SessionCache,
+	usernamePolicy *model.UsernamePolicy,
+	config config.SocialLogin,
+) SocialService {
+	return &socialService{
+		provider,
+		authService,
+		userRepository,
+		identityRepository,
+		eventRepository,
+		cache,
+		usernamePolicy,
+		config,
+	}
+}
+
+// Begin starts a login at the upstream provider, returning the state identifying the login
+// and the URL the user is sent to. The user is sent to returnTo once the login is complete.
+func (s *socialService) Begin(ctx context.Context, returnTo string) (string, string, error) {
+	state, nonce, verifier := generateToken(), generateToken(), generateToken()
+	if state == "" || nonce == "" || verifier == "" {
+		return "", "", errors.New("failed to generate state")
+	}
+	// tokens are base64 encoded with padding, which is not allowed in a code verifier
+	verifier = strings.TrimRight(verifier, "=")
+
+	value, err := json.Marshal(&socialState{
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ReturnTo:     returnTo,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.cache.Set(ctx, socialStateKey(state), string(value), SocialStateTTL); err != nil {
+		return "", "", err
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	authURL, err := s.provider.AuthCodeURL(ctx, state, nonce, base64.RawURLEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		return "", "", err
+	}
+	return state, authURL, nil
+}
+
+// Complete finishes the login identified by state, redeeming the code returned by the provider.
+// It returns the user signed in, and the URL passed to Begin. When current is not the zero UUID,
+// an identity not yet linked to any user is linked to the current user rather than registering
+// a new user.
+func (s *socialService) Complete(ctx context.Context, state string, code string, current uuid.UUID) (*model.User, string, error) {
+	invalidState := &model.ValidationError{Field: "state", Message: "invalid or expired state"}
+	if state == "" {
+		return nil, "", invalidState
+	}
+	// state is single-use, taken atomically before redeeming the code so concurrent callbacks cannot both complete
+	value, err := s.cache.GetDel(ctx, socialStateKey(state))
+	if errors.Is(err, redis.Nil) || (err == nil && value == "") {
+		return nil, "", invalidState
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var login socialState
+	if err := json.Unmarshal([]byte(value), &login); err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.provider.Exchange(ctx, code, login.CodeVerifier)
+	if err != nil {
+		log.Printf("social login: %s", err)
+		return nil, "", &model.UnauthorizedError{Message: "login at identity provider failed"}
+	}
+	claims, err := s.provider.Verify(ctx, token.IDToken, login.Nonce)
+	if err != nil {
+		log.Printf("social login: %s", err)
+		return nil, "", &model.UnauthorizedError{Message: "login at identity provider failed"}
+	}
+
+	user, err := s.user(ctx, claims, current)
+	if err != nil {
+		return nil, "", err
+	}
+
+	event, err := model.NewEvent(ctx, user.ID, model.LoggedIn, &model.LoginPayload{
+		ID:       user.ID,
+		Username: user.Username,
+		Provider: s.config.Provider,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	err = s.eventRepository.CreateEvent(ctx, event)
+	return user, login.ReturnTo, err
+}
+
+// user returns the user linked to the identity described by claims,
+// linking the identity to the current or a newly registered user when necessary.
+func (s *socialService) user(ctx context.Context, claims *oidc.Claims, current uuid.UUID) (*model.User, error) {
+	identity, err := s.identityRepository.GetIdentity(ctx, s.config.Provider, claims.Subject)
+	var notFound *model.NotFoundError
+	if err != nil && !errors.As(err, &notFound) {
+		return nil, err
+	}
+	if identity != nil {
+		if current != uuid.Nil && current != identity.UserID {
+			return nil, &model.ConflictError{Message: "identity is linked to another user"}
+		}
+		user := &model.User{ID: identity.UserID}
+		if err := s.userRepository.GetUser(ctx, user); err != nil {
+			return nil, err
+		}
+		if err := user.Status.Err(); err != nil {
+			return nil, err
+		}
+		return model.OmitPassword(user), nil
+	}
+
+	var user *model.User
+	if current != uuid.Nil {
+		user = &model.User{ID: current}
+		if err := s.userRepository.GetUser(ctx, user); err != nil {
+			return nil, err
+		}
+	} else if user, err = s.register(ctx, claims); err != nil {
+		return nil, err
+	}
+
+	identity = &model.ExternalIdentity{
+		Provider: s.config.Provider,
+		Subject:  claims.Subject,
+		UserID:   user.ID,
+	}
+	if claims.EmailVerified {
+		identity.Email = claims.Email
+	}
+	if err := s.identityRepository.CreateIdentity(ctx, identity); err != nil {
+		return nil, err
+	}
+	event, err := model.NewEvent(ctx, user.ID, model.IdentityLinked, &model.IdentityPayload{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		UserID:   identity.UserID,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.eventRepository.CreateEvent(ctx, event); err != nil {
+		return nil, err
+	}
+	// a registered user may be pending verification, the identity signs in once verified
+	if err := user.Status.Err(); err != nil {
+		return nil, err
+	}
+	return model.OmitPassword(user), nil
+}
+
+// register creates a user for an identity signing in for the first time. The username is derived
+// from the claims, and the password is random, so the user can only sign in through the provider.
+func (s *socialService) register(ctx context.Context, claims *oidc.Claims) (*model.User, error) {
+	base := usernameFromClaims(claims, s.usernamePolicy)
+	username := base
+	for attempt := 0; attempt < 10; attempt++ {
+		// reserved and too short usernames are usually accepted with a numeric suffix
+		if err := s.usernamePolicy.Validate(username); err != nil {
+			username = fmt.Sprintf("%s%d", base, rand.Intn(100000)) // nolint:gosec
+			continue
+		}
+		password := generateToken()
+		if password == "" {
+			return nil, errors.New("failed to generate password")
+		}
+		user := &model.User{Username: username, Password: password}
+		err := s.authService.Create(ctx, user)
+		var conflict *model.ConflictError
+		if errors.As(err, &conflict) {
+			username = fmt.Sprintf("%s%d", base, rand.Intn(100000)) // nolint:gosec
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	return nil, &model.ConflictError{Message: "unable to find an available username"}
+}
+
+// usernameFromClaims derives a username satisfying policy from the first claim of an ID token yielding one,
+// normalized by model.NormalizeUsername and stripped of characters other than letters, digits and marks.
+// It leaves room within the maximum length of policy for a numeric suffix.
+func usernameFromClaims(claims *oidc.Claims, policy *model.UsernamePolicy) string {
+	limit := policy.MaxLength - 5
+	if limit < policy.MinLength {
+		limit = policy.MaxLength
+	}
+	for _, candidate := range []string{claims.PreferredUsername, strings.Split(claims.Email, "@")[0], claims.Name} {
+		var b strings.Builder
+		for _, r := range model.NormalizeUsername(candidate) {
+			mark := unicode.In(r, unicode.Mn, unicode.Mc)
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || (mark && b.Len() > 0) {
+				b.WriteRune(r)
+			}
+		}
+		username := []rune(model.NormalizeUsername(b.String()))
+		if len(username) > limit {
+			username = username[:limit]
+		}
+		if policy.Validate(string(username)) == nil {
+			return string(username)
+		}
+	}
+	return "user"
+}
+
+func socialStateKey(state string) string {
+	return "social:state:" + hashToken(state)
+}
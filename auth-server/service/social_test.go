@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dgyurics/auth/auth-server/cache"
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/oidc"
+	repo "github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocialServiceSuite(t *testing.T) {
+	suite := &SocialServiceTestSuite{}
+	suite.Setup(t)
+	defer suite.provider.Close()
+
+	t.Run("TestRegister", suite.TestRegister)
+	t.Run("TestLinkCurrentUser", suite.TestLinkCurrentUser)
+	t.Run("TestInvalidState", suite.TestInvalidState)
+	t.Run("TestUsernameFromClaims", suite.TestUsernameFromClaims)
+}
+
+type SocialServiceTestSuite struct {
+	provider     *oidc.FakeProvider
+	userRepo     *repo.MockUserRepository
+	identityRepo *repo.MockIdentityRepository
+	authService  AuthService
+	service      SocialService
+}
+
+func (suite *SocialServiceTestSuite) Setup(t *testing.T) {
+	var err error
+	suite.provider, err = oidc.NewFakeProvider("auth-server", "secret")
+	require.NoError(t, err)
+
+	cfg := config.New().SocialLogin
+	cfg.Issuer = suite.provider.Issuer()
+	cfg.ClientID = "auth-server"
+	cfg.ClientSecret = "secret"
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:       cfg.Issuer,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+	}, nil)
+
+	suite.userRepo = &repo.MockUserRepository{
+		Users: []*model.User{},
+	}
+	suite.identityRepo = &repo.MockIdentityRepository{}
+	eventRepo := &repo.MockEventRepository{
+		Events: []*model.Event{},
+	}
+	sessionCache := &cache.MockSessionCache{
+		Sessions:    make(map[string]string),
+		SessionsSet: make(map[string]map[string]struct{}),
+	}
+	suite.authService = NewAuthService(suite.userRepo, eventRepo, nil, config.Registration{})
+	suite.service = NewSocialService(provider, suite.authService, suite.userRepo, suite.identityRepo, eventRepo, sessionCache,
+		model.NewUsernamePolicy(1, 50, true, []string{"admin"}), cfg)
+}
+
+func (suite *SocialServiceTestSuite) TestRegister(t *testing.T) {
+	ctx := context.Background()
+	suite.provider.User = oidc.Claims{
+		Claims:            jwt.Claims{Subject: uuid.NewString()},
+		PreferredUsername: "jane.doe",
+		Email:             "jane@example.com",
+		EmailVerified:     true,
+	}
+
+	// first login registers a user
+	user, returnTo := suite.login(t, uuid.Nil)
+	require.Equal(t, "http://localhost:3000/app", returnTo)
+	require.Contains(t, user.Username, "janedoe")
+	require.Empty(t, user.Password)
+	identity, err := suite.identityRepo.GetIdentity(ctx, "oidc", suite.provider.User.Subject)
+	require.NoError(t, err)
+	require.Equal(t, user.ID, identity.UserID)
+	require.Equal(t, "jane@example.com", identity.Email)
+
+	// later logins sign in the linked user
+	again, _ := suite.login(t, uuid.Nil)
+	require.Equal(t, user.ID, again.ID)
+
+	// a different upstream account with the same username registers a new user
+	suite.provider.User.Subject = uuid.NewString()
+	other, _ := suite.login(t, uuid.Nil)
+	require.NotEqual(t, user.ID, other.ID)
+	require.NotEqual(t, user.Username, other.Username)
+}
+
+func (suite *SocialServiceTestSuite) TestLinkCurrentUser(t *testing.T) {
+	ctx := context.Background()
+	current := &model.User{Username: repo.GenerateUniqueUsername(), Password: "test"}
+	require.NoError(t, suite.authService.Create(ctx, current))
+	suite.provider.User = oidc.Claims{Claims: jwt.Claims{Subject: uuid.NewString()}}
+
+	user, _ := suite.login(t, current.ID)
+	require.Equal(t, current.ID, user.ID)
+
+	// an identity cannot be linked to a second user
+	another := &model.User{Username: repo.GenerateUniqueUsername(), Password: "test"}
+	require.NoError(t, suite.authService.Create(ctx, another))
+	state, authURL, err := suite.service.Begin(ctx, "")
+	require.NoError(t, err)
+	code, _, err := suite.provider.Authorize(authURL)
+	require.NoError(t, err)
+	_, _, err = suite.service.Complete(ctx, state, code, another.ID)
+	require.IsType(t, &model.ConflictError{}, err)
+}
+
+func (suite *SocialServiceTestSuite) TestInvalidState(t *testing.T) {
+	ctx := context.Background()
+	suite.provider.User = oidc.Claims{Claims: jwt.Claims{Subject: uuid.NewString()}}
+
+	state, authURL, err := suite.service.Begin(ctx, "")
+	require.NoError(t, err)
+	code, _, err := suite.provider.Authorize(authURL)
+	require.NoError(t, err)
+
+	_, _, err = suite.service.Complete(ctx, "unknown", code, uuid.Nil)
+	require.IsType(t, &model.ValidationError{}, err)
+
+	// state is single-use
+	_, _, err = suite.service.Complete(ctx, state, code, uuid.Nil)
+	require.NoError(t, err)
+	_, _, err = suite.service.Complete(ctx, state, code, uuid.Nil)
+	require.IsType(t, &model.ValidationError{}, err)
+
+	// and taken by the first callback, even when it fails
+	state, authURL, err = suite.service.Begin(ctx, "")
+	require.NoError(t, err)
+	code, _, err = suite.provider.Authorize(authURL)
+	require.NoError(t, err)
+	_, _, err = suite.service.Complete(ctx, state, "invalid", uuid.Nil)
+	require.IsType(t, &model.UnauthorizedError{}, err)
+	_, _, err = suite.service.Complete(ctx, state, code, uuid.Nil)
+	require.IsType(t, &model.ValidationError{}, err)
+}
+
+func (suite *SocialServiceTestSuite) TestUsernameFromClaims(t *testing.T) {
+	policy := model.NewUsernamePolicy(3, 50, true, []string{"admin"})
+	require.Equal(t, "janedoe", usernameFromClaims(&oidc.Claims{PreferredUsername: "jane.doe"}, policy))
+	require.Equal(t, "jane", usernameFromClaims(&oidc.Claims{Email: "jane@example.com"}, policy))
+	require.Equal(t, "JaneDoe", usernameFromClaims(&oidc.Claims{Name: "Jane Doe"}, policy))
+	require.Equal(t, "張偉強", usernameFromClaims(&oidc.Claims{Name: "張 偉強"}, policy))
+	require.Equal(t, "jane", usernameFromClaims(&oidc.Claims{PreferredUsername: "ｊａｎｅ"}, policy))
+	require.Len(t, []rune(usernameFromClaims(&oidc.Claims{Name: strings.Repeat("張", 60)}, policy)), 45)
+
+	// claims yielding no username satisfying the policy are skipped
+	claims := &oidc.Claims{PreferredUsername: "Admin", Email: "jo@example.com", Name: "Jane Doe"}
+	require.Equal(t, "JaneDoe", usernameFromClaims(claims, policy))
+	claims = &oidc.Claims{PreferredUsername: "pаypal", Email: "jane@example.com"} // Cyrillic "а"
+	require.Equal(t, "jane", usernameFromClaims(claims, policy))
+	claims = &oidc.Claims{PreferredUsername: "張偉強", Email: "zhang@example.com"}
+	require.Equal(t, "zhang", usernameFromClaims(claims, model.NewUsernamePolicy(3, 50, false, nil)))
+	require.Equal(t, "user", usernameFromClaims(&oidc.Claims{Name: "張偉強"}, model.NewUsernamePolicy(3, 50, false, nil)))
+}
+
+// login runs the login flow against the fake provider, returning the signed in user
+func (suite *SocialServiceTestSuite) login(t *testing.T, current uuid.UUID) (*model.User, string) {
+	ctx := context.Background()
+	state, authURL, err := suite.service.Begin(ctx, "http://localhost:3000/app")
+	require.NoError(t, err)
+	code, returnedState, err := suite.provider.Authorize(authURL)
+	require.NoError(t, err)
+	require.Equal(t, state, returnedState)
+	user, returnTo, err := suite.service.Complete(ctx, state, code, current)
+	require.NoError(t, err)
+	return user, returnTo
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+)
+
+// WebhookService is an interface for admins managing the endpoints events are delivered to.
+// Every change to an endpoint is recorded as an event carrying the ID of the acting admin.
+type WebhookService interface {
+	Endpoints(ctx context.Context) ([]*model.WebhookEndpoint, error)
+	Endpoint(ctx context.Context, id uuid.UUID) (*model.WebhookEndpoint, error)
+	Create(ctx context.Context, endpoint *model.WebhookEndpoint, actorID uuid.UUID) error
+	Update(ctx context.Context, id uuid.UUID, update *model.WebhookEndpointUpdate, actorID uuid.UUID) (*model.WebhookEndpoint, error)
+	Delete(ctx context.Context, id uuid.UUID, actorID uuid.UUID) error
+	Deliveries(ctx context.Context, filter *model.WebhookDeliveryFilter) ([]*model.WebhookDelivery, error)
+	Delivery(ctx context.Context, endpointID uuid.UUID, id int64) (*model.WebhookDelivery, error)
+	Retry(ctx context.Context, endpointID uuid.UUID, id int64) error
+}
+
+type webhookService struct {
+	webhookRepository repository.WebhookRepository
+}
+
+// NewWebhookService creates a new WebhookService with the given webhook repository.
+func NewWebhookService(webhookRepository repository.WebhookRepository) WebhookService {
+	return &webhookService{
+		webhookRepository,
+	}
+}
+
+// Endpoints returns every endpoint, without their secrets.
+func (s *webhookService) Endpoints(ctx context.Context) ([]*model.WebhookEndpoint, error) {
+	return s.webhookRepository.GetEndpoints(ctx)
+}
+
+// Endpoint returns the endpoint, without its secret.
+func (s *webhookService) Endpoint(ctx context.Context, id uuid.UUID) (*model.WebhookEndpoint, error) {
+	return s.webhookRepository.GetEndpoint(ctx, id)
+}
+
+// Create validates and creates the endpoint on behalf of the actor, generating its ID and
+// the secret deliveries are signed with. The secret is only returned here.
+func (s *webhookService) Create(ctx context.Context, endpoint *model.WebhookEndpoint, actorID uuid.UUID) error {
+	if endpoint.EventTypes == nil {
+		endpoint.EventTypes = []model.EventType{}
+	}
+	if err := endpoint.Validate(); err != nil {
+		return err
+	}
+	endpoint.ID = uuid.New()
+	endpoint.Secret = generateToken()
+	event, err := webhookEvent(ctx, model.WebhookCreated, endpoint, actorID)
+	if err != nil {
+		return err
+	}
+	return s.webhookRepository.CreateEndpoint(ctx, endpoint, event)
+}
+
+// Update changes the fields of the endpoint set in update on behalf of the actor,
+// returning the updated endpoint. The secret cannot be changed.
+func (s *webhookService) Update(ctx context.Context, id uuid.UUID, update *model.WebhookEndpointUpdate, actorID uuid.UUID) (*model.WebhookEndpoint, error) {
+	endpoint, err := s.webhookRepository.GetEndpoint(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if update.URL != nil {
+		endpoint.URL = *update.URL
+	}
+	if update.EventTypes != nil {
+		endpoint.EventTypes = *update.EventTypes
+		if endpoint.EventTypes == nil {
+			endpoint.EventTypes = []model.EventType{}
+		}
+	}
+	if update.Description != nil {
+		endpoint.Description = *update.Description
+	}
+	if update.Active != nil {
+		endpoint.Active = *update.Active
+	}
+	if err := endpoint.Validate(); err != nil {
+		return nil, err
+	}
+	event, err := webhookEvent(ctx, model.WebhookUpdated, endpoint, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.webhookRepository.UpdateEndpoint(ctx, endpoint, event); err != nil {
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+// Delete deletes the endpoint, along with its deliveries, on behalf of the actor.
+func (s *webhookService) Delete(ctx context.Context, id uuid.UUID, actorID uuid.UUID) error {
+	endpoint, err := s.webhookRepository.GetEndpoint(ctx, id)
+	if err != nil {
+		return err
+	}
+	event, err := webhookEvent(ctx, model.WebhookDeleted, endpoint, actorID)
+	if err != nil {
+		return err
+	}
+	return s.webhookRepository.DeleteEndpoint(ctx, id, event)
+}
+
+// Deliveries returns the deliveries to the endpoint selected by filter.
+func (s *webhookService) Deliveries(ctx context.Context, filter *model.WebhookDeliveryFilter) ([]*model.WebhookDelivery, error) {
+	if _, err := s.webhookRepository.GetEndpoint(ctx, filter.EndpointID); err != nil {
+		return nil, err
+	}
+	return s.webhookRepository.GetDeliveries(ctx, filter)
+}
+
+// Delivery returns the delivery to the endpoint, along with the log of its attempts.
+func (s *webhookService) Delivery(ctx context.Context, endpointID uuid.UUID, id int64) (*model.WebhookDelivery, error) {
+	return s.webhookRepository.GetDelivery(ctx, endpointID, id)
+}
+
+// Retry returns a dead delivery to pending, to be attempted again as soon as possible.
+func (s *webhookService) Retry(ctx context.Context, endpointID uuid.UUID, id int64) error {
+	delivery, err := s.webhookRepository.GetDelivery(ctx, endpointID, id)
+	if err != nil {
+		return err
+	}
+	if delivery.Status != model.WebhookDead {
+		return &model.ConflictError{Message: "only dead deliveries can be retried"}
+	}
+	return s.webhookRepository.RetryDelivery(ctx, endpointID, id)
+}
+
+// webhookEvent returns an event of the actor changing the endpoint. The secret is never recorded.
+func webhookEvent(ctx context.Context, eventType model.EventType, endpoint *model.WebhookEndpoint, actorID uuid.UUID) (*model.Event, error) {
+	return model.NewEvent(ctx, actorID, eventType, &model.WebhookPayload{
+		ID:         endpoint.ID,
+		URL:        endpoint.URL,
+		EventTypes: endpoint.EventTypes,
+		Active:     endpoint.Active,
+		ActorID:    actorID,
+	})
+}
@@ -0,0 +1,193 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+)
+
+// claimMargin is added to the timeout of a request when claiming a delivery,
+// leaving time to record the attempt before the delivery can be claimed again.
+const claimMargin = time.Minute
+
+// maxErrorLength caps the length of the error logged for an attempt.
+const maxErrorLength = 512
+
+// Dispatcher attempts the deliveries which are due.
+type Dispatcher struct {
+	repository   repository.WebhookRepository
+	client       *http.Client
+	maxAttempts  int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	timeout      time.Duration
+	batchSize    int
+	pollInterval time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewDispatcher returns a Dispatcher posting deliveries with client. The number of attempts,
+// backoff, request timeout, batch size and poll interval are read from config.
+func NewDispatcher(repository repository.WebhookRepository, client *http.Client, config config.Webhook) *Dispatcher {
+	return &Dispatcher{
+		repository:   repository,
+		client:       client,
+		maxAttempts:  config.MaxAttempts,
+		backoff:      time.Duration(config.Backoff) * time.Second,
+		maxBackoff:   time.Duration(config.MaxBackoff) * time.Second,
+		timeout:      time.Duration(config.Timeout) * time.Second,
+		batchSize:    config.BatchSize,
+		pollInterval: time.Duration(config.PollInterval) * time.Millisecond,
+	}
+}
+
+// Start starts attempting deliveries in the background, until Stop is called.
+func (d *Dispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	d.done = make(chan struct{})
+	go d.run(ctx)
+}
+
+// Stop stops attempting deliveries, waiting for the attempts in progress.
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	<-d.done
+	d.client.CloseIdleConnections()
+}
+
+// run attempts batches of deliveries until ctx is done,
+// polling for deliveries once none are due.
+func (d *Dispatcher) run(ctx context.Context) {
+	defer close(d.done)
+	for {
+		attempted, err := d.dispatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("webhooks: %s", err)
+		}
+		if attempted == d.batchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.pollInterval):
+		}
+	}
+}
+
+// dispatch attempts the next batch of deliveries concurrently, returning the number attempted.
+func (d *Dispatcher) dispatch(ctx context.Context) (int, error) {
+	jobs, err := d.repository.ClaimDeliveries(ctx, d.batchSize, d.timeout+claimMargin)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim deliveries: %w", err)
+	}
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job *model.WebhookJob) {
+			defer wg.Done()
+			if err := d.attempt(ctx, job); err != nil {
+				log.Printf("webhooks: failed to record attempt of delivery %d: %s", job.Delivery.ID, err)
+			}
+		}(job)
+	}
+	wg.Wait()
+	return len(jobs), nil
+}
+
+// attempt posts the event of job to its endpoint and records the attempt,
+// scheduling the next attempt when it fails.
+func (d *Dispatcher) attempt(ctx context.Context, job *model.WebhookJob) error {
+	delivery := job.Delivery
+	started := time.Now()
+	statusCode, err := d.post(ctx, job, started)
+	if ctx.Err() != nil {
+		// stopping interrupted the attempt, so the delivery is attempted again once its claim expires
+		return nil
+	}
+	attempt := &model.WebhookAttempt{
+		AttemptedAt: started.UTC(),
+		StatusCode:  statusCode,
+		Duration:    time.Since(started).Milliseconds(),
+	}
+
+	delivery.Attempts++
+	switch {
+	case err == nil:
+		now := time.Now().UTC()
+		delivery.Status = model.WebhookSucceeded
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= d.maxAttempts:
+		attempt.Error = truncate(err.Error(), maxErrorLength)
+		delivery.Status = model.WebhookDead
+	default:
+		attempt.Error = truncate(err.Error(), maxErrorLength)
+		delivery.NextAttemptAt = time.Now().UTC().Add(d.retryDelay(delivery.Attempts))
+	}
+	return d.repository.RecordAttempt(ctx, delivery, attempt)
+}
+
+// post posts the event of job to its endpoint, returning the status code of the response,
+// if any, and an error unless the endpoint responded with a 2xx status.
+func (d *Dispatcher) post(ctx context.Context, job *model.WebhookJob, now time.Time) (int, error) {
+	body, err := json.Marshal(job.Event)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.Endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, strconv.FormatInt(job.Delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(job.Endpoint.Secret, now, body))
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("endpoint responded with status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// retryDelay returns the delay before the attempt following the given number of failed
+// attempts: the backoff, doubled with every further failure, up to the max backoff.
+func (d *Dispatcher) retryDelay(failures int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < failures && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		return d.maxBackoff
+	}
+	return delay
+}
+
+// truncate returns s cut to at most n bytes, dropping a partial UTF-8 sequence at the end.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return strings.ToValidUTF8(s[:n], "")
+	}
+	return s
+}
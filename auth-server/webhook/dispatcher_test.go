@@ -0,0 +1,230 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/config"
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var webhookConfig = config.Webhook{
+	MaxAttempts:  3,
+	Backoff:      30,
+	MaxBackoff:   90,
+	Timeout:      1,
+	BatchSize:    10,
+	PollInterval: 10,
+}
+
+// receiver is a webhook endpoint responding with status, recording the requests received
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+func newEndpoint(url string, eventTypes ...model.EventType) *model.WebhookEndpoint {
+	return &model.WebhookEndpoint{
+		ID:         uuid.New(),
+		URL:        url,
+		Secret:     "secret",
+		EventTypes: eventTypes,
+		Active:     true,
+	}
+}
+
+func newEvent(id int64, eventType model.EventType) *model.Event {
+	return &model.Event{ID: id, UUID: uuid.New(), Type: eventType, Body: json.RawMessage(`{}`), CreatedAt: time.Now().UTC()}
+}
+
+func TestFanout(t *testing.T) {
+	ctx := context.Background()
+	subscribed := newEndpoint("http://subscribed", model.LoggedIn)
+	every := newEndpoint("http://every")
+	inactive := newEndpoint("http://inactive")
+	inactive.Active = false
+	repo := &repository.MockWebhookRepository{Endpoints: []*model.WebhookEndpoint{subscribed, every, inactive}}
+	sink := NewFanout(repo)
+
+	events := []*model.Event{newEvent(1, model.LoggedIn), newEvent(2, model.LoggedOut)}
+	require.NoError(t, sink.Publish(ctx, events))
+	// publishing again does not duplicate deliveries
+	require.NoError(t, sink.Publish(ctx, events))
+
+	count := func(endpointID uuid.UUID) int {
+		deliveries, err := repo.GetDeliveries(ctx, &model.WebhookDeliveryFilter{EndpointID: endpointID, Limit: 10})
+		require.NoError(t, err)
+		return len(deliveries)
+	}
+	require.Equal(t, 1, count(subscribed.ID))
+	require.Equal(t, 2, count(every.ID))
+	require.Equal(t, 0, count(inactive.ID))
+}
+
+func TestDispatchSigned(t *testing.T) {
+	ctx := context.Background()
+	rc := &receiver{status: http.StatusNoContent}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	endpoint := newEndpoint(server.URL)
+	repo := &repository.MockWebhookRepository{Endpoints: []*model.WebhookEndpoint{endpoint}}
+	event := newEvent(1, model.LoggedIn)
+	require.NoError(t, repo.CreateDeliveries(ctx, []*model.Event{event}))
+
+	dispatcher := NewDispatcher(repo, server.Client(), webhookConfig)
+	attempted, err := dispatcher.dispatch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, attempted)
+
+	require.Len(t, rc.requests, 1)
+	req, body := rc.requests[0], rc.bodies[0]
+	require.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	require.NoError(t, Verify("secret", req.Header, body, time.Minute, time.Now()))
+
+	var received model.Event
+	require.NoError(t, json.Unmarshal(body, &received))
+	require.Equal(t, event.ID, received.ID)
+	require.Equal(t, event.Type, received.Type)
+
+	delivery, err := repo.GetDelivery(ctx, endpoint.ID, 1)
+	require.NoError(t, err)
+	require.Equal(t, strconv.FormatInt(delivery.ID, 10), req.Header.Get(HeaderID))
+	require.Equal(t, model.WebhookSucceeded, delivery.Status)
+	require.Equal(t, 1, delivery.Attempts)
+	require.NotNil(t, delivery.DeliveredAt)
+	require.Len(t, delivery.Log, 1)
+	require.Equal(t, http.StatusNoContent, delivery.Log[0].StatusCode)
+	require.Empty(t, delivery.Log[0].Error)
+
+	// a delivery which succeeded is not attempted again
+	attempted, err = dispatcher.dispatch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, attempted)
+}
+
+func TestDispatchFailure(t *testing.T) {
+	ctx := context.Background()
+	rc := &receiver{status: http.StatusInternalServerError}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	endpoint := newEndpoint(server.URL)
+	repo := &repository.MockWebhookRepository{Endpoints: []*model.WebhookEndpoint{endpoint}}
+	require.NoError(t, repo.CreateDeliveries(ctx, []*model.Event{newEvent(1, model.LoggedIn)}))
+	dispatcher := NewDispatcher(repo, server.Client(), webhookConfig)
+
+	// every failed attempt but the last schedules another after the backoff
+	for attempts := 1; attempts <= webhookConfig.MaxAttempts; attempts++ {
+		before := time.Now()
+		attempted, err := dispatcher.dispatch(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, attempted)
+
+		delivery, err := repo.GetDelivery(ctx, endpoint.ID, 1)
+		require.NoError(t, err)
+		require.Equal(t, attempts, delivery.Attempts)
+		require.Len(t, delivery.Log, attempts)
+		require.Equal(t, http.StatusInternalServerError, delivery.Log[attempts-1].StatusCode)
+		require.Contains(t, delivery.Log[attempts-1].Error, "500")
+		if attempts < webhookConfig.MaxAttempts {
+			require.Equal(t, model.WebhookPending, delivery.Status)
+			require.WithinDuration(t, before.Add(dispatcher.retryDelay(attempts)), delivery.NextAttemptAt, time.Second)
+		} else {
+			require.Equal(t, model.WebhookDead, delivery.Status)
+		}
+
+		// not attempted again before the backoff
+		attempted, err = dispatcher.dispatch(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 0, attempted)
+
+		// skip the backoff
+		repo.Deliveries[0].NextAttemptAt = time.Now().UTC()
+	}
+
+	// a dead delivery is not attempted until retried
+	attempted, err := dispatcher.dispatch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, attempted)
+
+	rc.status = http.StatusOK
+	require.NoError(t, repo.RetryDelivery(ctx, endpoint.ID, 1))
+	attempted, err = dispatcher.dispatch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, attempted)
+	delivery, err := repo.GetDelivery(ctx, endpoint.ID, 1)
+	require.NoError(t, err)
+	require.Equal(t, model.WebhookSucceeded, delivery.Status)
+	require.Len(t, delivery.Log, webhookConfig.MaxAttempts+1)
+}
+
+func TestDispatchUnreachable(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	endpoint := newEndpoint(url)
+	repo := &repository.MockWebhookRepository{Endpoints: []*model.WebhookEndpoint{endpoint}}
+	require.NoError(t, repo.CreateDeliveries(ctx, []*model.Event{newEvent(1, model.LoggedIn)}))
+
+	_, err := NewDispatcher(repo, &http.Client{}, webhookConfig).dispatch(ctx)
+	require.NoError(t, err)
+	delivery, err := repo.GetDelivery(ctx, endpoint.ID, 1)
+	require.NoError(t, err)
+	require.Equal(t, model.WebhookPending, delivery.Status)
+	require.Len(t, delivery.Log, 1)
+	require.Zero(t, delivery.Log[0].StatusCode)
+	require.NotEmpty(t, delivery.Log[0].Error)
+}
+
+func TestRetryDelay(t *testing.T) {
+	dispatcher := NewDispatcher(nil, nil, webhookConfig)
+	require.Equal(t, 30*time.Second, dispatcher.retryDelay(1))
+	require.Equal(t, 60*time.Second, dispatcher.retryDelay(2))
+	require.Equal(t, 90*time.Second, dispatcher.retryDelay(3))
+	require.Equal(t, 90*time.Second, dispatcher.retryDelay(20))
+}
+
+func TestDispatcherStartStop(t *testing.T) {
+	ctx := context.Background()
+	rc := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	endpoint := newEndpoint(server.URL)
+	repo := &repository.MockWebhookRepository{Endpoints: []*model.WebhookEndpoint{endpoint}}
+	require.NoError(t, repo.CreateDeliveries(ctx, []*model.Event{newEvent(1, model.LoggedIn), newEvent(2, model.LoggedOut)}))
+
+	dispatcher := NewDispatcher(repo, server.Client(), webhookConfig)
+	dispatcher.Start()
+	require.Eventually(t, func() bool {
+		deliveries, err := repo.GetDeliveries(ctx, &model.WebhookDeliveryFilter{
+			EndpointID: endpoint.ID, Status: model.WebhookSucceeded, Limit: 10,
+		})
+		return err == nil && len(deliveries) == 2
+	}, time.Second, 10*time.Millisecond)
+	dispatcher.Stop()
+}
@@ -0,0 +1,11 @@
+// Package webhook delivers events to the webhook endpoints subscribed to them.
+//
+// A Relay of the outbox package publishes events to the Fanout sink, which records a pending
+// delivery of each event to every endpoint subscribed to its type. The Dispatcher attempts
+// deliveries which are due, posting the event as JSON signed with the secret of the endpoint,
+// see Sign. A failed delivery is attempted again with exponential backoff, and is marked dead
+// once every attempt has failed, until an admin retries it. Every attempt is logged.
+//
+// Deliveries are claimed with row locks, so every replica runs a dispatcher. Delivery is
+// at-least-once: receivers should deduplicate events by the X-Webhook-Id header.
+package webhook
@@ -0,0 +1,27 @@
+package webhook
+
+import (
+	"context"
+
+	"github.com/dgyurics/auth/auth-server/model"
+	"github.com/dgyurics/auth/auth-server/outbox"
+	"github.com/dgyurics/auth/auth-server/repository"
+)
+
+type fanout struct {
+	repository repository.WebhookRepository
+}
+
+// NewFanout returns an outbox.Sink recording a pending delivery of each event to every
+// active endpoint subscribed to its type, to be attempted by a Dispatcher.
+func NewFanout(repository repository.WebhookRepository) outbox.Sink {
+	return &fanout{repository}
+}
+
+func (s *fanout) Publish(ctx context.Context, events []*model.Event) error {
+	return s.repository.CreateDeliveries(ctx, events)
+}
+
+func (s *fanout) Close() error {
+	return nil
+}
@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers set on every delivery.
+const (
+	HeaderID        = "X-Webhook-Id"        // ID of the delivery, the same for every attempt
+	HeaderTimestamp = "X-Webhook-Timestamp" // Unix time of the attempt
+	HeaderSignature = "X-Webhook-Signature" // see Sign
+)
+
+// signatureVersion prefixes the signature, so the scheme can change without breaking receivers.
+const signatureVersion = "v1"
+
+// ErrInvalidSignature is returned by Verify when a delivery was not signed with the secret,
+// or was signed outside of the tolerance.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns the signature of a delivery of body attempted at timestamp: "v1=" followed by the
+// hex encoded HMAC-SHA256, keyed by secret, of the Unix timestamp, a period, and body. Signing
+// the timestamp lets receivers reject deliveries which are replayed later.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns ErrInvalidSignature unless the headers of a delivery of body carry its signature
+// by secret, and its timestamp is within tolerance of now. It is provided for receivers in Go.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	unix, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	timestamp := time.Unix(unix, 0)
+	if now.Sub(timestamp) > tolerance || timestamp.Sub(now) > tolerance {
+		return ErrInvalidSignature
+	}
+	expected := Sign(secret, timestamp, body)
+	for _, signature := range strings.Split(header.Get(HeaderSignature), ",") {
+		if hmac.Equal([]byte(strings.TrimSpace(signature)), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
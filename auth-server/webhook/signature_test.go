@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func signedHeader(secret string, timestamp time.Time, body []byte) http.Header {
+	header := http.Header{}
+	header.Set(HeaderTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+	header.Set(HeaderSignature, Sign(secret, timestamp, body))
+	return header
+}
+
+func TestSign(t *testing.T) {
+	timestamp := time.Unix(1700000000, 0)
+	// echo -n '1700000000.{"id":1}' | openssl dgst -sha256 -hmac secret
+	require.Equal(t, "v1=3dd1b9aef568d75f6790a84bd2e5dfa1f44409eef3cbdbd3f10b837376100c11",
+		Sign("secret", timestamp, []byte(`{"id":1}`)))
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":1,"type":"logged_in"}`)
+	now := time.Now()
+
+	require.NoError(t, Verify("secret", signedHeader("secret", now, body), body, 5*time.Minute, now))
+
+	tests := map[string]http.Header{
+		"wrong secret": signedHeader("other", now, body),
+		"stale":        signedHeader("secret", now.Add(-10*time.Minute), body),
+		"future":       signedHeader("secret", now.Add(10*time.Minute), body),
+		"missing":      {},
+	}
+	for name, header := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.ErrorIs(t, Verify("secret", header, body, 5*time.Minute, now), ErrInvalidSignature)
+		})
+	}
+
+	t.Run("tampered body", func(t *testing.T) {
+		header := signedHeader("secret", now, body)
+		require.ErrorIs(t, Verify("secret", header, []byte(`{"id":2,"type":"logged_in"}`), 5*time.Minute, now), ErrInvalidSignature)
+	})
+
+	t.Run("tampered timestamp", func(t *testing.T) {
+		header := signedHeader("secret", now, body)
+		header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix()-1, 10))
+		require.ErrorIs(t, Verify("secret", header, body, 5*time.Minute, now), ErrInvalidSignature)
+	})
+}
@@ -3,24 +3,37 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
-	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
-)
-
-const (
-	Port = ":8080"
+	"github.com/dgyurics/auth/auth-server/identity"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/dgyurics/auth/secure-server/server"
 )
 
 func main() {
-	r := chi.NewRouter()
-	r.Use(middleware.Logger)
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("ok"))
-	})
-	r.Get("/echo", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("echo"))
-	})
-	log.Println("Secure service listening on port " + Port)
-	http.ListenAndServe(Port, r)
+	port := getEnv("PORT", "8080")
+
+	// Verify identity assertions when the key set of auth-server is configured,
+	// otherwise trust the identity headers set by the api-gateway
+	var verifier *identity.Verifier
+	if jwksURL := getEnv("IDENTITY_JWKS_URL", ""); jwksURL != "" {
+		keys := jwt.NewRemoteKeySet(jwksURL, nil)
+		verifier = identity.NewVerifier(keys,
+			getEnv("IDENTITY_ISSUER", "auth-server"),
+			getEnv("IDENTITY_AUDIENCE", "secure-server"))
+	} else {
+		log.Println("IDENTITY_JWKS_URL not set, trusting identity headers from api-gateway")
+	}
+
+	log.Println("Secure service listening on port " + port)
+	if err := http.ListenAndServe(":"+port, server.NewRouter(verifier)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func getEnv(key string, defaultVal string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultVal
 }
@@ -2,4 +2,18 @@ module github.com/dgyurics/auth/secure-server
 
 go 1.20
 
-require github.com/go-chi/chi v1.5.4
+require (
+	github.com/dgyurics/auth/auth-server v0.0.0-00010101000000-000000000000
+	github.com/go-chi/chi v1.5.4
+	github.com/google/uuid v1.3.0
+	github.com/stretchr/testify v1.8.4
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/dgyurics/auth/auth-server => ../auth-server
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dgyurics/auth/auth-server/identity"
+	"github.com/dgyurics/auth/auth-server/jwt"
+)
+
+// Headers set by the api-gateway identifying the user, see api-gateway/nginx.conf
+const (
+	headerUserID   = "X-User-ID"
+	headerUsername = "X-Username"
+	headerRoles    = "X-User-Roles"
+)
+
+// authenticate returns middleware rejecting requests which do not identify the user.
+// When a verifier is configured the signed identity assertion is required, otherwise
+// the identity headers set by the api-gateway are trusted as is.
+func authenticate(verifier *identity.Verifier) func(http.Handler) http.Handler {
+	if verifier != nil {
+		return identity.Middleware(verifier)
+	}
+	return trustHeaders
+}
+
+// trustHeaders reads the identity of the user from the headers set by the api-gateway.
+// It must only be used when the service is unreachable except through the gateway.
+func trustHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get(headerUserID)
+		if userID == "" {
+			http.Error(w, "missing user identity", http.StatusUnauthorized)
+			return
+		}
+		claims := &identity.Claims{
+			Claims:   jwt.Claims{Subject: userID},
+			Username: r.Header.Get(headerUsername),
+		}
+		if roles := r.Header.Get(headerRoles); roles != "" {
+			claims.Roles = strings.Split(roles, ",")
+		}
+		next.ServeHTTP(w, r.WithContext(identity.NewContext(r.Context(), claims)))
+	})
+}
+
+// user returns the identity of the user making the request.
+// Only valid for requests which passed authenticate.
+func user(r *http.Request) *identity.Claims {
+	claims, _ := identity.FromContext(r.Context())
+	return claims
+}
@@ -0,0 +1,3 @@
+// Package server provides the HTTP server of secure-server, a service reachable only through
+// the api-gateway by authenticated users.
+package server
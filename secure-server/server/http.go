@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/dgyurics/auth/auth-server/identity"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// NewRouter returns the request multiplexer of secure-server.
+// verifier may be nil, in which case the identity headers set by the api-gateway are trusted.
+func NewRouter(verifier *identity.Verifier) http.Handler {
+	h := &handler{notes: newNoteStore()}
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(middleware.Logger)
+
+	r.Get("/health", h.health)
+
+	// routes requiring an authenticated user
+	r.Group(func(r chi.Router) {
+		r.Use(authenticate(verifier))
+		r.Get("/echo", h.echo)
+		r.Get("/whoami", h.whoami)
+		r.Get("/notes", h.listNotes)
+		r.Post("/notes", h.createNote)
+		r.Delete("/notes/{id}", h.deleteNote)
+	})
+
+	return r
+}
+
+type handler struct {
+	notes *noteStore
+}
+
+func (h *handler) health(w http.ResponseWriter, _ *http.Request) {
+	_, _ = w.Write([]byte("ok"))
+}
+
+func (h *handler) echo(w http.ResponseWriter, _ *http.Request) {
+	_, _ = w.Write([]byte("echo"))
+}
+
+// whoami returns the identity of the user as forwarded by the api-gateway.
+func (h *handler) whoami(w http.ResponseWriter, r *http.Request) {
+	claims := user(r)
+	writeJSON(w, http.StatusOK, struct {
+		ID       string   `json:"id"`
+		Username string   `json:"username"`
+		Roles    []string `json:"roles,omitempty"`
+	}{claims.Subject, claims.Username, claims.Roles})
+}
+
+func (h *handler) listNotes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.notes.list(user(r).Subject))
+}
+
+func (h *handler) createNote(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Text == "" {
+		http.Error(w, "note text cannot be empty", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, h.notes.add(user(r).Subject, body.Text))
+}
+
+func (h *handler) deleteNote(w http.ResponseWriter, r *http.Request) {
+	if !h.notes.remove(user(r).Subject, chi.URLParam(r, "id")) {
+		http.Error(w, "note not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %s", err)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Note is an example of a resource owned by a user.
+type Note struct {
+	ID        string    `json:"id"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// noteStore is an in-memory store of notes partitioned by user ID.
+// Safe for concurrent use by multiple goroutines.
+type noteStore struct {
+	mu    sync.RWMutex
+	notes map[string][]*Note
+}
+
+func newNoteStore() *noteStore {
+	return &noteStore{
+		notes: make(map[string][]*Note),
+	}
+}
+
+// list returns the notes of the user, oldest first.
+func (s *noteStore) list(userID string) []*Note {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	notes := make([]*Note, len(s.notes[userID]))
+	copy(notes, s.notes[userID])
+	return notes
+}
+
+// add creates a note owned by the user.
+func (s *noteStore) add(userID string, text string) *Note {
+	note := &Note{
+		ID:        uuid.NewString(),
+		Text:      text,
+		CreatedAt: time.Now().UTC(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notes[userID] = append(s.notes[userID], note)
+	return note
+}
+
+// remove deletes the note if owned by the user, reporting whether it was found.
+func (s *noteStore) remove(userID string, noteID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notes := s.notes[userID]
+	for i, note := range notes {
+		if note.ID == noteID {
+			s.notes[userID] = append(notes[:i], notes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgyurics/auth/auth-server/identity"
+	"github.com/dgyurics/auth/auth-server/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	rr := serve(t, NewRouter(nil), http.MethodGet, "/health", nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestWhoamiAssertion(t *testing.T) {
+	signer := newSigner(t)
+	router := NewRouter(identity.NewVerifier(signer.KeySet(), "auth-server", "secure-server"))
+
+	// valid assertion
+	assertion := sign(t, signer, "user-one", "alice")
+	rr := serve(t, router, http.MethodGet, "/whoami", map[string]string{identity.Header: assertion}, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var body map[string]string
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+	require.Equal(t, "user-one", body["id"])
+	require.Equal(t, "alice", body["username"])
+
+	// identity headers are ignored when assertions are required
+	rr = serve(t, router, http.MethodGet, "/whoami", map[string]string{headerUserID: "user-one"}, nil)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// assertion signed by an unknown key
+	forged := sign(t, newSigner(t), "user-one", "alice")
+	rr = serve(t, router, http.MethodGet, "/whoami", map[string]string{identity.Header: forged}, nil)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestWhoamiHeaders(t *testing.T) {
+	router := NewRouter(nil)
+
+	rr := serve(t, router, http.MethodGet, "/whoami", map[string]string{
+		headerUserID:   "user-one",
+		headerUsername: "alice",
+		headerRoles:    "admin,auditor",
+	}, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var body struct {
+		Username string   `json:"username"`
+		Roles    []string `json:"roles"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+	require.Equal(t, "alice", body.Username)
+	require.Equal(t, []string{"admin", "auditor"}, body.Roles)
+
+	rr = serve(t, router, http.MethodGet, "/whoami", nil, nil)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestNotesPerUser(t *testing.T) {
+	router := NewRouter(nil)
+	alice := map[string]string{headerUserID: "user-one"}
+	bob := map[string]string{headerUserID: "user-two"}
+
+	rr := serve(t, router, http.MethodPost, "/notes", alice, strings.NewReader(`{"text": "secret"}`))
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var note Note
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&note))
+
+	// note is only visible to its owner
+	rr = serve(t, router, http.MethodGet, "/notes", alice, nil)
+	require.Contains(t, rr.Body.String(), "secret")
+	rr = serve(t, router, http.MethodGet, "/notes", bob, nil)
+	require.NotContains(t, rr.Body.String(), "secret")
+
+	// and can only be deleted by its owner
+	rr = serve(t, router, http.MethodDelete, "/notes/"+note.ID, bob, nil)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	rr = serve(t, router, http.MethodDelete, "/notes/"+note.ID, alice, nil)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = serve(t, router, http.MethodPost, "/notes", alice, strings.NewReader(`{}`))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func serve(t *testing.T, h http.Handler, method, target string, headers map[string]string, body *strings.Reader) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	if body != nil {
+		req = httptest.NewRequest(method, target, body)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func newSigner(t *testing.T) *jwt.Signer {
+	key, err := jwt.GenerateKey()
+	require.NoError(t, err)
+	signer, err := jwt.NewSigner(key)
+	require.NoError(t, err)
+	return signer
+}
+
+func sign(t *testing.T, signer *jwt.Signer, userID, username string) string {
+	assertion, err := signer.Sign(&identity.Claims{
+		Claims: jwt.Claims{
+			Issuer:    "auth-server",
+			Subject:   userID,
+			Audience:  jwt.Audience{"secure-server"},
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+		Username: username,
+	})
+	require.NoError(t, err)
+	return assertion
+}